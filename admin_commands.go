@@ -0,0 +1,431 @@
+package main
+
+import (
+	"arcio/attendance-system/model"
+	"arcio/attendance-system/security"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+/*
+ * Commands for setting up and, fixing a deployment. Users, modules and, groups can be given by
+ * their id or, their external id (groups by their name), roles by their id or, their name.
+ */
+
+const (
+	CREATE_USER_USAGE   = "create-user -external-id <id> -firstname <name> -surname <name> -email <email>, adds a user"
+	CREATE_ROLE_USAGE   = "create-role -name <name> <PERMS_FLAG>..., adds a role with the flags"
+	GRANT_ROLE_USAGE    = "grant-role -role <role> -layer <layer> [-module <module>] [-group <group>] <user>, assigns a role at a layer"
+	CREATE_MODULE_USAGE = "create-module -external-id <id> -name <name>, adds a module"
+	CREATE_GROUP_USAGE  = "create-group -module <module> -name <name>, adds a module group"
+	ENROL_USAGE         = "enrol -module <module> [-group <group>] <external id>..., adds users to a module and, a group"
+	SPAWN_LESSONS_USAGE = "spawn-lessons, spawns lessons from repeating lessons once"
+	MINT_TOKEN_USAGE    = "mint-token -key <private key> [-ttl <duration>] <user>, prints an access token for testing"
+	PERMISSIONS_USAGE   = "permissions -layer <layer> [-module <module>] [-group <group>] <user>, prints a user's effective permissions"
+)
+
+func adminStore() model.Repositories {
+	return model.NewPostgresRepositories(DatabasePool)
+}
+
+// Finds a user by their id or, their external id
+func findUser(ctx context.Context, store model.Repositories, ref string) (model.User, error) {
+	if _, err := uuid.Parse(ref); err == nil {
+		user, err := store.Users.GetUser(ctx, ref)
+		if err != model.ErrUserNotFound {
+			return user, err
+		}
+	}
+
+	return store.Users.GetUserByExternalId(ctx, ref)
+}
+
+// Finds a module by its id or, its external id
+func findModule(ctx context.Context, store model.Repositories, ref string) (model.Module, error) {
+	if _, err := uuid.Parse(ref); err == nil {
+		module, err := store.Modules.GetModule(ctx, ref)
+		if err != model.ErrModuleNotFound {
+			return module, err
+		}
+	}
+
+	modules, err := store.Modules.GetModules(ctx)
+	if err != nil {
+		return model.Module{}, err
+	}
+
+	for _, module := range modules {
+		if module.ExternalId == ref {
+			return module, nil
+		}
+	}
+
+	return model.Module{}, model.ErrModuleNotFound
+}
+
+// Finds a group in a module by its id or, its name
+func findGroup(ctx context.Context, store model.Repositories, moduleId string, ref string) (model.ModuleGroup, error) {
+	groups, err := store.ModuleGroups.GetGroups(ctx, moduleId)
+	if err != nil {
+		return model.ModuleGroup{}, err
+	}
+
+	for _, group := range groups {
+		if group.Id == ref || group.Name == ref {
+			return group, nil
+		}
+	}
+
+	return model.ModuleGroup{}, model.ErrModuleGroupNotFound
+}
+
+// Finds a role by its id or, its name
+func findRole(ctx context.Context, ref string) (model.Role, error) {
+	roles, err := model.GetRoles(ctx, DatabasePool)
+	if err != nil {
+		return model.Role{}, err
+	}
+
+	for _, role := range roles {
+		if role.Id == ref || role.Name == ref {
+			return role, nil
+		}
+	}
+
+	return model.Role{}, model.ErrRoleNotFound
+}
+
+// Finds the module and, group ids for the -module and, -group flags, they are blank if not given
+func findScope(ctx context.Context, store model.Repositories, moduleRef string, groupRef string) (string, string, error) {
+	if moduleRef == "" {
+		if groupRef != "" {
+			return "", "", errors.New("-group needs -module")
+		}
+		return "", "", nil
+	}
+
+	module, err := findModule(ctx, store, moduleRef)
+	if err != nil {
+		return "", "", err
+	}
+
+	if groupRef == "" {
+		return module.Id, "", nil
+	}
+
+	group, err := findGroup(ctx, store, module.Id, groupRef)
+	if err != nil {
+		return "", "", err
+	}
+
+	return module.Id, group.Id, nil
+}
+
+func createUserCommand(args []string) error {
+	flags := flag.NewFlagSet("create-user", flag.ContinueOnError)
+	externalId := flags.String("external-id", "", "the id from the SIS, i.e: the student number")
+	firstname := flags.String("firstname", "", "the first name")
+	surname := flags.String("surname", "", "the surname")
+	email := flags.String("email", "", "the email address")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 0 {
+		return errors.New("usage: " + CREATE_USER_USAGE)
+	}
+
+	user := model.User{ExternalId: *externalId, Fname: *firstname, Sname: *surname, Email: *email}
+	err = adminStore().Users.CreateUser(context.Background(), &user)
+	if err != nil {
+		return err
+	}
+
+	printJson(user)
+	return nil
+}
+
+func createRoleCommand(args []string) error {
+	flags := flag.NewFlagSet("create-role", flag.ContinueOnError)
+	name := flags.String("name", "", "the name of the role")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	overrides, err := security.ParseFlags(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	role := model.Role{Name: *name, Overrides: overrides}
+	err = adminStore().Permissions.CreateRole(context.Background(), &role)
+	if err != nil {
+		return err
+	}
+
+	printJson(role)
+	return nil
+}
+
+func grantRoleCommand(args []string) error {
+	flags := flag.NewFlagSet("grant-role", flag.ContinueOnError)
+	roleRef := flags.String("role", "", "the role's id or, name")
+	layerName := flags.String("layer", "", "global, module, module-group or, attendance")
+	moduleRef := flags.String("module", "", "the module for the module and, module-group layers")
+	groupRef := flags.String("group", "", "the group for the module-group layer")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("usage: " + GRANT_ROLE_USAGE)
+	}
+
+	l, err := security.ParseLayer(*layerName)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	store := adminStore()
+	user, err := findUser(ctx, store, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	role, err := findRole(ctx, *roleRef)
+	if err != nil {
+		return err
+	}
+
+	moduleId, groupId, err := findScope(ctx, store, *moduleRef, *groupRef)
+	if err != nil {
+		return err
+	}
+
+	err = store.Permissions.AssignRole(ctx, role.Id, user.InternalId, moduleId, groupId, l)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Granted %s to %s at the %s layer\n", role.Name, user.ExternalId, l)
+	return nil
+}
+
+func createModuleCommand(args []string) error {
+	flags := flag.NewFlagSet("create-module", flag.ContinueOnError)
+	externalId := flags.String("external-id", "", "the module code from the SIS")
+	name := flags.String("name", "", "the name of the module")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 0 {
+		return errors.New("usage: " + CREATE_MODULE_USAGE)
+	}
+
+	module := model.Module{ExternalId: *externalId, Name: *name}
+	err = adminStore().Modules.CreateModule(context.Background(), &module)
+	if err != nil {
+		return err
+	}
+
+	printJson(module)
+	return nil
+}
+
+func createGroupCommand(args []string) error {
+	flags := flag.NewFlagSet("create-group", flag.ContinueOnError)
+	moduleRef := flags.String("module", "", "the module's id or, external id")
+	name := flags.String("name", "", "the name of the group")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 0 || *moduleRef == "" {
+		return errors.New("usage: " + CREATE_GROUP_USAGE)
+	}
+
+	ctx := context.Background()
+	store := adminStore()
+	module, err := findModule(ctx, store, *moduleRef)
+	if err != nil {
+		return err
+	}
+
+	group := model.ModuleGroup{ModuleId: module.Id, Name: *name}
+	err = store.ModuleGroups.CreateGroup(ctx, &group)
+	if err != nil {
+		return err
+	}
+
+	printJson(group)
+	return nil
+}
+
+/*
+ * Adds users to a module and, optionally one of its groups. Every user is tried, users that are
+ * already enrolled are reported as errors.
+ */
+func enrolCommand(args []string) error {
+	flags := flag.NewFlagSet("enrol", flag.ContinueOnError)
+	moduleRef := flags.String("module", "", "the module's id or, external id")
+	groupRef := flags.String("group", "", "the group's id or, name")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 || *moduleRef == "" {
+		return errors.New("usage: " + ENROL_USAGE)
+	}
+
+	ctx := context.Background()
+	store := adminStore()
+	moduleId, groupId, err := findScope(ctx, store, *moduleRef, *groupRef)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, externalId := range flags.Args() {
+		err := enrolUser(ctx, store, externalId, moduleId, groupId)
+		if err != nil {
+			fmt.Printf("%s - %s\n", externalId, err)
+			failed++
+			continue
+		}
+
+		fmt.Printf("%s - enrolled\n", externalId)
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%d users were not enrolled", failed)
+	}
+	return nil
+}
+
+func enrolUser(ctx context.Context, store model.Repositories, externalId string, moduleId string, groupId string) error {
+	user, err := store.Users.GetUserByExternalId(ctx, externalId)
+	if err != nil {
+		return err
+	}
+
+	err = store.Modules.AddUser(ctx, user.InternalId, moduleId)
+	if err != nil {
+		return err
+	}
+
+	if groupId == "" {
+		return nil
+	}
+
+	return store.ModuleGroups.AddUser(ctx, user.InternalId, groupId)
+}
+
+func spawnLessonsCommand(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: " + SPAWN_LESSONS_USAGE)
+	}
+
+	spawned, err := model.SpawnRepeatingLessons(context.Background(), time.Now(), DatabasePool)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Spawned %d lessons\n", spawned)
+	return nil
+}
+
+/*
+ * Signs an access token with the auth system's private key, the server only checks tokens with the
+ * public key so this is for testing against a deployment.
+ */
+func mintTokenCommand(args []string) error {
+	flags := flag.NewFlagSet("mint-token", flag.ContinueOnError)
+	keyFile := flags.String("key", "", "the PEM encoded EC private key")
+	ttl := flags.Duration("ttl", time.Hour, "how long the token is valid for")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 || *keyFile == "" {
+		return errors.New("usage: " + MINT_TOKEN_USAGE)
+	}
+
+	raw, err := os.ReadFile(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	key, err := jwt.ParseECPrivateKeyFromPEM(raw)
+	if err != nil {
+		return err
+	}
+
+	user, err := findUser(context.Background(), adminStore(), flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	token, err := security.SignAccessToken(key, user.InternalId, user.Fname, user.Sname, *ttl)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(token)
+	return nil
+}
+
+func permissionsCommand(args []string) error {
+	flags := flag.NewFlagSet("permissions", flag.ContinueOnError)
+	layerName := flags.String("layer", "global", "global, module, module-group or, attendance")
+	moduleRef := flags.String("module", "", "the module's id or, external id")
+	groupRef := flags.String("group", "", "the group's id or, name")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("usage: " + PERMISSIONS_USAGE)
+	}
+
+	l, err := security.ParseLayer(*layerName)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	store := adminStore()
+	user, err := findUser(ctx, store, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	moduleId, groupId, err := findScope(ctx, store, *moduleRef, *groupRef)
+	if err != nil {
+		return err
+	}
+
+	explanation, err := model.ExplainPermissions(ctx, user.InternalId, moduleId, groupId, l, DatabasePool)
+	if err != nil {
+		return err
+	}
+
+	printJson(explanation)
+	return nil
+}
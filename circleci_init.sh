@@ -5,6 +5,13 @@ echo "Init .env and, keys" &&
 cp .env_circleci .env &&
 bash init_tests.sh &&
 
+# The route tests use the same database, see harness.NewPostgres. CircleCI runs $BASH_ENV before
+# each later step so that they have it too
+echo "Export TEST_DATABASE_URL" &&
+set -a && source .env && set +a &&
+export TEST_DATABASE_URL="postgres://$DB_USERNAME:$DB_PASSWORD@$DB_URL:$DB_PORT/$DB_NAME?sslmode=$SSL_MODE" &&
+echo "export TEST_DATABASE_URL=\"$TEST_DATABASE_URL\"" >> "${BASH_ENV:-/dev/null}" &&
+
 # Start database
 cd arcio-db &&
 echo "Starting image"
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"arcio/attendance-system/migrations"
+	"arcio/attendance-system/model"
+	"arcio/attendance-system/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+/*
+ * Subcommands are ran instead of the server when the first argument is a command name,
+ * i.e: `./attendance-system import-roster -dry-run roster.csv`
+ */
+
+type command struct {
+	Usage string
+	Run   func(args []string) error
+}
+
+const IMPORT_ROSTER_USAGE = "import-roster [-dry-run] <roster.csv>, imports modules, groups and, memberships keyed by external ids"
+const MIGRATE_USAGE = "migrate up [-to <version>] | down [-to <version>] | status | baseline <version>, changes the schema version"
+
+var commands = map[string]command{
+	"import-roster": {Usage: IMPORT_ROSTER_USAGE, Run: importRosterCommand},
+	"migrate":       {Usage: MIGRATE_USAGE, Run: migrateCommand},
+
+	// See ./admin_commands.go
+	"create-user":   {Usage: CREATE_USER_USAGE, Run: createUserCommand},
+	"create-role":   {Usage: CREATE_ROLE_USAGE, Run: createRoleCommand},
+	"grant-role":    {Usage: GRANT_ROLE_USAGE, Run: grantRoleCommand},
+	"create-module": {Usage: CREATE_MODULE_USAGE, Run: createModuleCommand},
+	"create-group":  {Usage: CREATE_GROUP_USAGE, Run: createGroupCommand},
+	"enrol":         {Usage: ENROL_USAGE, Run: enrolCommand},
+	"spawn-lessons": {Usage: SPAWN_LESSONS_USAGE, Run: spawnLessonsCommand},
+	"mint-token":    {Usage: MINT_TOKEN_USAGE, Run: mintTokenCommand},
+	"permissions":   {Usage: PERMISSIONS_USAGE, Run: permissionsCommand},
+}
+
+func printCommandHelp() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println("Commands:")
+	for _, name := range names {
+		fmt.Printf(" -> %s\n", commands[name].Usage)
+	}
+}
+
+/*
+ * Runs a subcommand, the process exits with a non-zero status if it fails.
+ */
+func runCommand(args []string) {
+	cmd, found := commands[args[0]]
+	if !found {
+		fmt.Printf("Unknown command \"%s\"\n", args[0])
+		printCommandHelp()
+		os.Exit(2)
+	}
+
+	err := cmd.Run(args[1:])
+	if err != nil {
+		fmt.Printf("%s failed - %s\n", args[0], err)
+		os.Exit(1)
+	}
+}
+
+func printJson(v interface{}) {
+	out, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(out))
+}
+
+func importRosterCommand(args []string) error {
+	flags := flag.NewFlagSet("import-roster", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only print the changes")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("usage: " + IMPORT_ROSTER_USAGE)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	result, err := model.ImportRosterCsv(context.Background(), file, *dryRun, DatabasePool)
+	if err != nil {
+		return err
+	}
+
+	printJson(result)
+	if len(result.Errors) != 0 {
+		return fmt.Errorf("%d rows have errors, nothing was imported", len(result.Errors))
+	}
+
+	return nil
+}
+
+/*
+ * Moves the schema between versions of the migrations in ./migrations, down reverts the latest
+ * migration unless a version is given.
+ */
+func migrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: " + MIGRATE_USAGE)
+	}
+
+	all, err := utils.LoadMigrations(migrations.Files)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "status":
+		statuses, err := utils.GetMigrationStatus(ctx, DatabasePool, all)
+		if err != nil {
+			return err
+		}
+
+		printJson(statuses)
+		return nil
+	case "baseline":
+		if len(args) != 2 {
+			return errors.New("usage: " + MIGRATE_USAGE)
+		}
+
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+
+		return utils.BaselineMigrations(ctx, DatabasePool, all, version)
+	case "up", "down":
+	default:
+		return errors.New("usage: " + MIGRATE_USAGE)
+	}
+
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	target := flags.Int("to", -1, "the version to migrate to")
+	err = flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+
+	var ran []utils.Migration
+	if args[0] == "up" {
+		if *target < 0 {
+			*target = 0
+		}
+		ran, err = utils.MigrateUp(ctx, DatabasePool, all, *target)
+	} else {
+		if *target < 0 {
+			*target, err = previousVersion(ctx, all)
+			if err != nil {
+				return err
+			}
+		}
+		ran, err = utils.MigrateDown(ctx, DatabasePool, all, *target)
+	}
+
+	for _, migration := range ran {
+		fmt.Printf("%s %d_%s\n", args[0], migration.Version, migration.Name)
+	}
+	return err
+}
+
+// The version before the latest applied migration
+func previousVersion(ctx context.Context, all []utils.Migration) (int, error) {
+	statuses, err := utils.GetMigrationStatus(ctx, DatabasePool, all)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := make([]int, 0)
+	for _, status := range statuses {
+		if status.Applied {
+			applied = append(applied, status.Version)
+		}
+	}
+
+	if len(applied) < 2 {
+		return 0, nil
+	}
+	return applied[len(applied)-2], nil
+}
@@ -7,8 +7,39 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"time"
 )
 
+// Seconds that a check-in code is valid for when CHECKIN_CODE_PERIOD is not set
+const DEFAULT_CHECKIN_CODE_PERIOD = 30
+
+// How long after the start of a lesson a mark is stored as late when LATE_GRACE_PERIOD is not set
+const DEFAULT_LATE_GRACE_PERIOD = 10 * time.Minute
+
+// Percentage attendance that students are at risk below when AT_RISK_THRESHOLD is not set
+const DEFAULT_AT_RISK_THRESHOLD = 80
+
+// Lessons missed in a row that a student is at risk after when AT_RISK_CONSECUTIVE_ABSENCES is not set
+const DEFAULT_AT_RISK_CONSECUTIVE_ABSENCES = 3
+
+// Lessons that a student must have had before the threshold applies when AT_RISK_MINIMUM_SESSIONS is not set
+const DEFAULT_AT_RISK_MINIMUM_SESSIONS = 5
+
+// Seconds between at-risk checks when AT_RISK_CHECK_PERIOD is not set
+const DEFAULT_AT_RISK_CHECK_PERIOD = 60 * 60
+
+// Time zone that new repeating lessons are in when TIMEZONE is not set
+const DEFAULT_TIMEZONE = "Europe/London"
+
+// Lowest level of log lines that are written when LOG_LEVEL is not set
+const DEFAULT_LOG_LEVEL = "info"
+
+// Format of log lines when LOG_FORMAT is not set, json or, logfmt
+const DEFAULT_LOG_FORMAT = "json"
+
+// Seconds that a request can take before its queries are cancelled when REQUEST_TIMEOUT is not set
+const DEFAULT_REQUEST_TIMEOUT = 30
+
 type Config struct {
 	DbUrl            string
 	DbPort           int
@@ -24,11 +55,25 @@ type Config struct {
 	SslMode          string
 	NonceToggle      bool
 	XForward         bool
+	CheckinSecret    string
+	CheckinPeriod    int
+	LateGracePeriod  int
+
+	AtRiskThreshold           int
+	AtRiskConsecutiveAbsences int
+	AtRiskMinimumSessions     int
+	AtRiskCheckPeriod         int
+
+	Timezone       string
+	RequestTimeout int
+
+	LogLevel  string
+	LogFormat string
 }
 
 func PrintConfHelp() {
 	fmt.Println("Create a .env file in the working directory which has the following defined:")
-	fmt.Println("DB_URL, DB_PORT, DB_USERNAME, DB_PASSWORD, BIND_ADDR, BIND_PORT, JWT_SECRET_FILE, DB_MAX_CONNS")
+	fmt.Println("DB_URL, DB_PORT, DB_USERNAME, DB_PASSWORD, BIND_ADDR, BIND_PORT, JWT_SECRET_FILE, DB_MAX_CONNS, CHECKIN_SECRET")
 	fmt.Println("See ../README.md for more help.")
 }
 
@@ -64,6 +109,22 @@ func getEnvVarBool(EnvVar string) bool {
 	return ret
 }
 
+func getEnvVarDefault(EnvVar string, Default string) string {
+	if os.Getenv(EnvVar) == "" {
+		return Default
+	}
+
+	return os.Getenv(EnvVar)
+}
+
+func getEnvVarIntDefault(EnvVar string, Default int) int {
+	if os.Getenv(EnvVar) == "" {
+		return Default
+	}
+
+	return getEnvVarInt(EnvVar)
+}
+
 func LoadConfig() (Config, error) {
 	err := godotenv.Load()
 	if err != nil {
@@ -83,7 +144,21 @@ func LoadConfig() (Config, error) {
 		JwtSecretFile:    getEnvVar("JWT_SECRET_FILE"),
 		SslMode:          getEnvVar("SSL_MODE"),
 		NonceToggle:      getEnvVarBool("NONCE_TOGGLE"),
-		XForward:         getEnvVarBool("XFORWARD")}
+		XForward:         getEnvVarBool("XFORWARD"),
+		CheckinSecret:    getEnvVar("CHECKIN_SECRET"),
+		CheckinPeriod:    getEnvVarIntDefault("CHECKIN_CODE_PERIOD", DEFAULT_CHECKIN_CODE_PERIOD),
+		LateGracePeriod:  getEnvVarIntDefault("LATE_GRACE_PERIOD", int(DEFAULT_LATE_GRACE_PERIOD/time.Second)),
+
+		AtRiskThreshold:           getEnvVarIntDefault("AT_RISK_THRESHOLD", DEFAULT_AT_RISK_THRESHOLD),
+		AtRiskConsecutiveAbsences: getEnvVarIntDefault("AT_RISK_CONSECUTIVE_ABSENCES", DEFAULT_AT_RISK_CONSECUTIVE_ABSENCES),
+		AtRiskMinimumSessions:     getEnvVarIntDefault("AT_RISK_MINIMUM_SESSIONS", DEFAULT_AT_RISK_MINIMUM_SESSIONS),
+		AtRiskCheckPeriod:         getEnvVarIntDefault("AT_RISK_CHECK_PERIOD", DEFAULT_AT_RISK_CHECK_PERIOD),
+
+		Timezone:       getEnvVarDefault("TIMEZONE", DEFAULT_TIMEZONE),
+		RequestTimeout: getEnvVarIntDefault("REQUEST_TIMEOUT", DEFAULT_REQUEST_TIMEOUT),
+
+		LogLevel:  getEnvVarDefault("LOG_LEVEL", DEFAULT_LOG_LEVEL),
+		LogFormat: getEnvVarDefault("LOG_FORMAT", DEFAULT_LOG_FORMAT)}
 	log.Println("Loaded .env file")
 	log.Printf("Loading public key from %s\n", ret.JwtSecretFile)
 
@@ -0,0 +1,39 @@
+package harness
+
+import (
+	"sync"
+	"time"
+)
+
+// The time that in-memory harnesses start at
+var DEFAULT_START_TIME = time.Date(2022, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+/*
+ * A clock that only moves when it is told to, so that tests are deterministic.
+ */
+type Clock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+func (c *Clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *Clock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *Clock) Set(t time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = t
+}
@@ -0,0 +1,313 @@
+package harness
+
+import (
+	"arcio/attendance-system/model"
+	"arcio/attendance-system/security"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+/*
+ * Fixtures are versioned JSON files that are seeded through the repositories, so they work with
+ * the in-memory store and, Postgres. Everything has a key that tests use to find its id, lesson
+ * times are durations from the harness's clock, i.e: "-15m".
+ */
+
+//go:embed fixtures/*.json
+var embeddedFixtures embed.FS
+
+type Fixture struct {
+	Users   []FixtureUser   `json:"users"`
+	Modules []FixtureModule `json:"modules"`
+	Roles   []FixtureRole   `json:"roles"`
+}
+
+type FixtureUser struct {
+	Key        string `json:"key"`
+	ExternalId string `json:"external-id"`
+	Fname      string `json:"firstname"`
+	Sname      string `json:"surname"`
+	Email      string `json:"email"`
+}
+
+type FixtureModule struct {
+	Key        string         `json:"key"`
+	Name       string         `json:"name"`
+	ExternalId string         `json:"external-id"`
+	Users      []string       `json:"users"` // User keys
+	Groups     []FixtureGroup `json:"groups"`
+}
+
+type FixtureGroup struct {
+	Key     string          `json:"key"`
+	Name    string          `json:"name"`
+	Users   []string        `json:"users"` // User keys, they must be in the module
+	Lessons []FixtureLesson `json:"lessons"`
+}
+
+// Each lesson gets its own group lesson
+type FixtureLesson struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	Summary     string `json:"summary"`
+	Description string `json:"description"`
+	Location    string `json:"location"`
+	Start       string `json:"start"` // Duration from the clock
+	End         string `json:"end"`   // Duration from the clock
+}
+
+type FixtureRole struct {
+	Key         string              `json:"key"`
+	Name        string              `json:"name"`
+	Flags       []string            `json:"flags"` // See security.ParseFlags
+	Assignments []FixtureAssignment `json:"assignments"`
+}
+
+type FixtureAssignment struct {
+	User   string         `json:"user"`
+	Layer  security.Layer `json:"layer"`
+	Module string         `json:"module,omitempty"`
+	Group  string         `json:"group,omitempty"`
+}
+
+// The ids of seeded fixtures by key
+type Seeded struct {
+	Users   map[string]model.User
+	Modules map[string]model.Module
+	Groups  map[string]model.ModuleGroup
+	Lessons map[string]model.ActualLesson
+	Roles   map[string]model.Role
+}
+
+func newSeeded() *Seeded {
+	return &Seeded{Users: make(map[string]model.User),
+		Modules: make(map[string]model.Module),
+		Groups:  make(map[string]model.ModuleGroup),
+		Lessons: make(map[string]model.ActualLesson),
+		Roles:   make(map[string]model.Role)}
+}
+
+/*
+ * Reads the fixture files in a directory in the order of their names, i.e: 0001_base.json then
+ * 0002_more.json.
+ */
+func ReadFixtures(fsys fs.FS, dir string) ([]Fixture, error) {
+	names, err := fs.Glob(fsys, dir+"/*.json")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	ret := make([]Fixture, 0, len(names))
+	for _, name := range names {
+		raw, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		var fixture Fixture
+		err = json.Unmarshal(raw, &fixture)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read fixture %s - %s", name, err)
+		}
+
+		ret = append(ret, fixture)
+	}
+
+	return ret, nil
+}
+
+// Reads the fixtures that are built into the harness, see ./fixtures
+func DefaultFixtures() ([]Fixture, error) {
+	return ReadFixtures(embeddedFixtures, "fixtures")
+}
+
+// Gets the id for a key, a blank key is a blank id
+func lookup(ids map[string]string, kind string, key string) (string, error) {
+	if key == "" {
+		return "", nil
+	}
+
+	id, found := ids[key]
+	if !found {
+		return "", fmt.Errorf("Cannot find %s %s", kind, key)
+	}
+
+	return id, nil
+}
+
+/*
+ * Creates everything in the fixtures, later fixtures can use keys from earlier ones.
+ */
+func (s *Seeded) seed(ctx context.Context, store model.Repositories, now time.Time, fixtures []Fixture) error {
+	userIds := make(map[string]string)
+	for key, user := range s.Users {
+		userIds[key] = user.InternalId
+	}
+
+	for _, fixture := range fixtures {
+		for _, fixtureUser := range fixture.Users {
+			user := model.User{ExternalId: fixtureUser.ExternalId,
+				Fname: fixtureUser.Fname,
+				Sname: fixtureUser.Sname,
+				Email: fixtureUser.Email}
+			err := store.Users.CreateUser(ctx, &user)
+			if err != nil {
+				return fmt.Errorf("Cannot create user %s - %s", fixtureUser.Key, err)
+			}
+
+			s.Users[fixtureUser.Key] = user
+			userIds[fixtureUser.Key] = user.InternalId
+		}
+
+		for _, fixtureModule := range fixture.Modules {
+			err := s.seedModule(ctx, store, now, userIds, fixtureModule)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, fixtureRole := range fixture.Roles {
+			err := s.seedRole(ctx, store, userIds, fixtureRole)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Seeded) seedModule(ctx context.Context, store model.Repositories, now time.Time, userIds map[string]string, fixtureModule FixtureModule) error {
+	module := model.Module{Name: fixtureModule.Name, ExternalId: fixtureModule.ExternalId}
+	err := store.Modules.CreateModule(ctx, &module)
+	if err != nil {
+		return fmt.Errorf("Cannot create module %s - %s", fixtureModule.Key, err)
+	}
+	s.Modules[fixtureModule.Key] = module
+
+	for _, userKey := range fixtureModule.Users {
+		userId, err := lookup(userIds, "user", userKey)
+		if err != nil {
+			return err
+		}
+
+		err = store.Modules.AddUser(ctx, userId, module.Id)
+		if err != nil {
+			return fmt.Errorf("Cannot add user %s to module %s - %s", userKey, fixtureModule.Key, err)
+		}
+	}
+
+	for _, fixtureGroup := range fixtureModule.Groups {
+		group := model.ModuleGroup{ModuleId: module.Id, Name: fixtureGroup.Name}
+		err = store.ModuleGroups.CreateGroup(ctx, &group)
+		if err != nil {
+			return fmt.Errorf("Cannot create module group %s - %s", fixtureGroup.Key, err)
+		}
+		s.Groups[fixtureGroup.Key] = group
+
+		for _, userKey := range fixtureGroup.Users {
+			userId, err := lookup(userIds, "user", userKey)
+			if err != nil {
+				return err
+			}
+
+			err = store.ModuleGroups.AddUser(ctx, userId, group.Id)
+			if err != nil {
+				return fmt.Errorf("Cannot add user %s to module group %s - %s", userKey, fixtureGroup.Key, err)
+			}
+		}
+
+		for _, fixtureLesson := range fixtureGroup.Lessons {
+			err = s.seedLesson(ctx, store, now, group, fixtureLesson)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Seeded) seedLesson(ctx context.Context, store model.Repositories, now time.Time, group model.ModuleGroup, fixtureLesson FixtureLesson) error {
+	start, err := time.ParseDuration(fixtureLesson.Start)
+	if err != nil {
+		return fmt.Errorf("Invalid start for lesson %s - %s", fixtureLesson.Key, err)
+	}
+
+	end, err := time.ParseDuration(fixtureLesson.End)
+	if err != nil {
+		return fmt.Errorf("Invalid end for lesson %s - %s", fixtureLesson.Key, err)
+	}
+
+	groupLesson := model.GroupLesson{ModuleGroupId: group.Id,
+		Name:               fixtureLesson.Name,
+		Summary:            fixtureLesson.Summary,
+		Description:        fixtureLesson.Description,
+		Location:           fixtureLesson.Location,
+		AttendanceRequired: true}
+	err = store.Lessons.CreateGroupLesson(ctx, &groupLesson)
+	if err != nil {
+		return fmt.Errorf("Cannot create group lesson for %s - %s", fixtureLesson.Key, err)
+	}
+
+	lesson := model.ActualLesson{GroupLessonId: groupLesson.Id, StartTime: now.Add(start), EndTime: now.Add(end)}
+	err = store.Lessons.CreateLesson(ctx, &lesson)
+	if err != nil {
+		return fmt.Errorf("Cannot create lesson %s - %s", fixtureLesson.Key, err)
+	}
+
+	s.Lessons[fixtureLesson.Key] = lesson
+	return nil
+}
+
+func (s *Seeded) seedRole(ctx context.Context, store model.Repositories, userIds map[string]string, fixtureRole FixtureRole) error {
+	overrides, err := security.ParseFlags(fixtureRole.Flags)
+	if err != nil {
+		return fmt.Errorf("Invalid flags for role %s - %s", fixtureRole.Key, err)
+	}
+
+	role := model.Role{Name: fixtureRole.Name, Overrides: overrides}
+	err = store.Permissions.CreateRole(ctx, &role)
+	if err != nil {
+		return fmt.Errorf("Cannot create role %s - %s", fixtureRole.Key, err)
+	}
+	s.Roles[fixtureRole.Key] = role
+
+	for _, assignment := range fixtureRole.Assignments {
+		userId, err := lookup(userIds, "user", assignment.User)
+		if err != nil {
+			return err
+		}
+
+		moduleId := ""
+		if assignment.Module != "" {
+			module, found := s.Modules[assignment.Module]
+			if !found {
+				return fmt.Errorf("Cannot find module %s", assignment.Module)
+			}
+			moduleId = module.Id
+		}
+
+		groupId := ""
+		if assignment.Group != "" {
+			group, found := s.Groups[assignment.Group]
+			if !found {
+				return fmt.Errorf("Cannot find module group %s", assignment.Group)
+			}
+			groupId = group.Id
+		}
+
+		err = store.Permissions.AssignRole(ctx, role.Id, userId, moduleId, groupId, assignment.Layer)
+		if err != nil {
+			return fmt.Errorf("Cannot assign role %s to %s - %s", fixtureRole.Key, assignment.User, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,287 @@
+/*
+ * harness.go sets up the router against a fixture-driven store for tests that make HTTP requests.
+ */
+
+package harness
+
+import (
+	"arcio/attendance-system/config"
+	"arcio/attendance-system/middleware"
+	"arcio/attendance-system/migrations"
+	"arcio/attendance-system/model"
+	"arcio/attendance-system/routes"
+	"arcio/attendance-system/security"
+	"arcio/attendance-system/utils"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "github.com/lib/pq"
+)
+
+const (
+	// A Postgres url to create test schemas in, Postgres harnesses are skipped without it
+	DATABASE_URL_ENV = "TEST_DATABASE_URL"
+	// How long tokens from Harness.Token are valid for
+	TOKEN_TTL = time.Hour
+)
+
+// The router uses globals so, only one harness can be used at a time
+var harnessLock sync.Mutex
+var nonceManager security.NonceManager
+var nonceOnce sync.Once
+
+type Harness struct {
+	Config config.Config
+	Store  model.Repositories
+	Router *gin.Engine
+	Clock  *Clock
+	Pool   *utils.DatabasePool // nil for in-memory harnesses
+	Seeded *Seeded
+	key    *ecdsa.PrivateKey
+}
+
+/*
+ * Creates a harness with the in-memory store and, the default fixtures. The clock starts at
+ * DEFAULT_START_TIME and, only handlers that use routes.Store work without a database.
+ */
+func NewMemory(t testing.TB) *Harness {
+	clock := NewClock(DEFAULT_START_TIME)
+	return newHarness(t, model.NewMemoryRepositories(clock.Now), nil, clock)
+}
+
+/*
+ * Creates a harness against Postgres in a new schema that is dropped after the test, the schema
+ * is built from the migrations then the default fixtures are seeded. The database sets
+ * its own times so, the clock starts at the current time.
+ */
+func NewPostgres(t testing.TB) *Harness {
+	databaseUrl := os.Getenv(DATABASE_URL_ENV)
+	if databaseUrl == "" {
+		t.Skip(DATABASE_URL_ENV + " is not set")
+	}
+
+	schema := fmt.Sprintf("harness_%d_%d", time.Now().Unix(), rand.Int63())
+	admin, err := sql.Open("postgres", databaseUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	_, err = admin.Exec("CREATE SCHEMA " + schema + ";")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		cleanup, err := sql.Open("postgres", databaseUrl)
+		if err != nil {
+			t.Log(err)
+			return
+		}
+		defer cleanup.Close()
+
+		_, err = cleanup.Exec("DROP SCHEMA " + schema + " CASCADE;")
+		if err != nil {
+			t.Log(err)
+		}
+	})
+
+	schemaUrl, err := withSearchPath(databaseUrl, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("postgres", schemaUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One connection so, the search path is the same for every query
+	db.SetMaxOpenConns(1)
+
+	pool := &utils.DatabasePool{Database: db, PendingWrites: make([]utils.DatabaseRunnable, 0)}
+	err = loadSchema(pool)
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		utils.FlushPendingWrites(pool)
+		db.Close()
+	})
+
+	return newHarness(t, model.NewPostgresRepositories(pool), pool, NewClock(time.Now()))
+}
+
+func newHarness(t testing.TB, store model.Repositories, pool *utils.DatabasePool, clock *Clock) *Harness {
+	key, publicKey, err := NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixtures, err := DefaultFixtures()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Harness{Config: config.Config{JwtPublicKey: publicKey,
+		NonceToggle:     false,
+		CheckinPeriod:   config.DEFAULT_CHECKIN_CODE_PERIOD,
+		LateGracePeriod: int(config.DEFAULT_LATE_GRACE_PERIOD / time.Second),
+		Timezone:        config.DEFAULT_TIMEZONE,
+		RequestTimeout:  config.DEFAULT_REQUEST_TIMEOUT},
+		Store:  store,
+		Clock:  clock,
+		Pool:   pool,
+		Seeded: newSeeded(),
+		key:    key}
+
+	err = h.Seed(fixtures...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	nonceOnce.Do(nonceManager.InitNonceManager)
+
+	harnessLock.Lock()
+	oldStore, oldPool := routes.Store, routes.DatabasePool
+	oldRoutesConfig, oldMiddlewareConfig, oldNonces := routes.GlobalConfig, middleware.GlobalConfig, routes.NonceManager
+	t.Cleanup(func() {
+		routes.Store, routes.DatabasePool = oldStore, oldPool
+		routes.GlobalConfig, middleware.GlobalConfig, routes.NonceManager = oldRoutesConfig, oldMiddlewareConfig, oldNonces
+		harnessLock.Unlock()
+	})
+
+	routes.Store = store
+	routes.DatabasePool = pool
+	routes.GlobalConfig = &h.Config
+	middleware.GlobalConfig = &h.Config
+	routes.NonceManager = &nonceManager
+	h.Router = routes.InitRouter()
+
+	return h
+}
+
+/*
+ * Seeds more fixtures, these can use the keys of the default fixtures.
+ */
+func (h *Harness) Seed(fixtures ...Fixture) error {
+	return h.Seeded.seed(context.Background(), h.Store, h.Clock.Now(), fixtures)
+}
+
+/*
+ * Gets an access token for a user by their fixture key.
+ */
+func (h *Harness) Token(userKey string) (string, error) {
+	user, found := h.Seeded.Users[userKey]
+	if !found {
+		return "", fmt.Errorf("Cannot find user %s", userKey)
+	}
+
+	return SignAccessToken(h.key, user.InternalId, user.Fname, user.Sname, TOKEN_TTL)
+}
+
+/*
+ * Makes a request as a user, a blank user key makes the request without a token. The body is
+ * encoded as JSON unless it is already a string or, []byte.
+ */
+func (h *Harness) Request(method string, target string, userKey string, body interface{}) (*httptest.ResponseRecorder, error) {
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(b)
+	case []byte:
+		reader = bytes.NewBuffer(b)
+	default:
+		raw, err := json.Marshal(b)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewBuffer(raw)
+	}
+
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if userKey != "" {
+		token, err := h.Token(userKey)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(middleware.AUTH_HEADER, "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	h.Router.ServeHTTP(w, req)
+
+	if h.Pool != nil {
+		utils.FlushPendingWrites(h.Pool)
+	}
+	return w, nil
+}
+
+// Ids of seeded fixtures, these are blank for unknown keys
+func (h *Harness) UserId(key string) string {
+	return h.Seeded.Users[key].InternalId
+}
+
+func (h *Harness) ModuleId(key string) string {
+	return h.Seeded.Modules[key].Id
+}
+
+func (h *Harness) GroupId(key string) string {
+	return h.Seeded.Groups[key].Id
+}
+
+func (h *Harness) LessonId(key string) string {
+	return h.Seeded.Lessons[key].Id
+}
+
+// Adds the search path to a Postgres url or, connection string
+func withSearchPath(databaseUrl string, schema string) (string, error) {
+	if !strings.HasPrefix(databaseUrl, "postgres://") && !strings.HasPrefix(databaseUrl, "postgresql://") {
+		return databaseUrl + " search_path=" + schema + ",public", nil
+	}
+
+	parsed, err := url.Parse(databaseUrl)
+	if err != nil {
+		return "", err
+	}
+
+	query := parsed.Query()
+	query.Set("search_path", schema+",public")
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
+
+/*
+ * Builds the schema from the embedded migrations, migration 0 is the base schema.
+ */
+func loadSchema(pool *utils.DatabasePool) error {
+	all, err := utils.LoadMigrations(migrations.Files)
+	if err != nil {
+		return err
+	}
+
+	_, err = utils.MigrateUp(context.Background(), pool, all, 0)
+	return err
+}
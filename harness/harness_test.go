@@ -0,0 +1,161 @@
+package harness
+
+import (
+	"arcio/attendance-system/model"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMemoryHarness(t *testing.T) {
+	h := NewMemory(t)
+
+	w, err := h.Request("GET", "/module/get", "", nil)
+	if err != nil || w.Code != http.StatusBadRequest {
+		t.Log("Expected requests without a token to be refused", w.Code, err)
+		t.Fail()
+	}
+
+	w, err = h.Request("GET", "/module/get", "other-student", nil)
+	if err != nil || w.Code != http.StatusUnauthorized {
+		t.Log("Expected users without roles to be refused all modules", w.Code, err)
+		t.Fail()
+	}
+
+	w, err = h.Request("GET", "/module/get", "admin", nil)
+	if err != nil || w.Code != http.StatusOK {
+		t.Log("Bad response for all modules", w.Code, w.Body.String(), err)
+		t.FailNow()
+	}
+
+	var modules []model.Module
+	if err := json.Unmarshal(w.Body.Bytes(), &modules); err != nil || len(modules) != 1 || modules[0].Id != h.ModuleId("testing") {
+		t.Log("Bad modules", w.Body.String(), err)
+		t.Fail()
+	}
+
+	body := map[string]string{"user-id": h.UserId("student"), "lesson-id": h.LessonId("current")}
+	w, err = h.Request("POST", "/attendance/lecturer/mark", "student", body)
+	if err != nil || w.Code != http.StatusUnauthorized {
+		t.Log("Expected students to be refused marking others", w.Code, err)
+		t.Fail()
+	}
+
+	w, err = h.Request("POST", "/attendance/lecturer/mark", "lecturer", body)
+	if err != nil || w.Code != http.StatusCreated {
+		t.Log("Bad response for marking attendance", w.Code, w.Body.String(), err)
+		t.FailNow()
+	}
+
+	marks, err := h.Store.Attendance.GetLessonMarks(context.Background(), h.LessonId("current"))
+	if err != nil || len(marks) != 1 || marks[0].UserId != h.UserId("student") {
+		t.Log("Bad marks", marks, err)
+		t.Fail()
+	}
+
+	// The lesson has ended after the clock moves on
+	h.Clock.Advance(time.Hour)
+	body["user-id"] = h.UserId("other-student")
+	w, err = h.Request("POST", "/attendance/lecturer/mark", "lecturer", body)
+	if err != nil || w.Code != http.StatusBadRequest {
+		t.Log("Expected marks after the lesson to be refused", w.Code, err)
+		t.Fail()
+	}
+}
+
+func TestReadFixtures(t *testing.T) {
+	fixtures, err := DefaultFixtures()
+	if err != nil || len(fixtures) == 0 {
+		t.Log("Cannot read the default fixtures", err)
+		t.FailNow()
+	}
+
+	if len(fixtures[0].Users) == 0 || len(fixtures[0].Modules) == 0 || len(fixtures[0].Roles) == 0 {
+		t.Log("Expected users, modules and, roles in the first fixture")
+		t.Fail()
+	}
+}
+
+func TestPostgresHarness(t *testing.T) {
+	h := NewPostgres(t)
+
+	w, err := h.Request("GET", "/user/get-modules", "student", nil)
+	if err != nil || w.Code != http.StatusOK {
+		t.Log("Bad response for the user's modules", w.Code, w.Body.String(), err)
+		t.Fail()
+	}
+}
+
+// A lecturer's module permissions must not apply to groups of other modules
+func TestModuleGroupOfAnotherModule(t *testing.T) {
+	h := NewMemory(t)
+
+	err := h.Seed(Fixture{Modules: []FixtureModule{{Key: "other",
+		Name:       "Other Module",
+		ExternalId: "CS2002",
+		Groups:     []FixtureGroup{{Key: "other-lab", Name: "Other Lab"}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := map[string]string{"module-id": h.ModuleId("testing"), "module-group-id": h.GroupId("other-lab"), "name": "Renamed"}
+	w, err := h.Request("PUT", "/module/group/update", "lecturer", body)
+	if err != nil || w.Code != http.StatusUnauthorized {
+		t.Log("Expected a group of another module to be refused", w.Code, w.Body.String(), err)
+		t.Fail()
+	}
+
+	w, err = h.Request("GET", "/module/group/lesson/get?moduleId="+h.ModuleId("testing")+"&moduleGroupId="+h.GroupId("other-lab"), "lecturer", nil)
+	if err != nil || w.Code != http.StatusUnauthorized {
+		t.Log("Expected lessons of another module's group to be refused", w.Code, w.Body.String(), err)
+		t.Fail()
+	}
+}
+
+// Creating group lessons, lessons and, roles only uses the store
+func TestMemoryCreateLessons(t *testing.T) {
+	h := NewMemory(t)
+
+	body := map[string]interface{}{"module-id": h.ModuleId("testing"), "module-group-id": h.GroupId("lab-a"),
+		"name": "Revision", "summary": "Revision", "location": "Room 102", "attendance-required": true}
+	w, err := h.Request("POST", "/module/group/lesson/add", "lecturer", body)
+	if err != nil || w.Code != http.StatusCreated {
+		t.Log("Cannot create a group lesson", w.Code, w.Body.String(), err)
+		t.FailNow()
+	}
+
+	var groupLesson model.GroupLesson
+	if err := json.Unmarshal(w.Body.Bytes(), &groupLesson); err != nil || groupLesson.Id == "" {
+		t.Log("Bad group lesson", w.Body.String(), err)
+		t.FailNow()
+	}
+
+	start := h.Clock.Now().Add(24 * time.Hour)
+	body = map[string]interface{}{"module-id": h.ModuleId("testing"), "module-group-id": h.GroupId("lab-a"),
+		"group-lesson-id": groupLesson.Id,
+		"start-time":      start.Format(time.RFC3339),
+		"end-time":        start.Add(time.Hour).Format(time.RFC3339)}
+	w, err = h.Request("POST", "/lesson/create-one-off", "lecturer", body)
+	if err != nil || w.Code != http.StatusCreated {
+		t.Log("Cannot create a lesson", w.Code, w.Body.String(), err)
+		t.FailNow()
+	}
+
+	lessons, err := h.Store.Lessons.GetUserLessons(context.Background(), h.UserId("student"))
+	found := false
+	for _, lesson := range lessons {
+		found = found || lesson.GroupLessonId == groupLesson.Id && lesson.Summary == "Revision"
+	}
+	if err != nil || !found {
+		t.Log("Expected the student to have the new lesson", lessons, err)
+		t.Fail()
+	}
+
+	w, err = h.Request("POST", "/roles/create", "admin", map[string]interface{}{"name": "Helper", "overrides": 2})
+	if err != nil || w.Code != http.StatusCreated {
+		t.Log("Cannot create a role", w.Code, w.Body.String(), err)
+		t.Fail()
+	}
+}
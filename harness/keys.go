@@ -0,0 +1,38 @@
+package harness
+
+import (
+	"arcio/attendance-system/security"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"time"
+)
+
+/*
+ * Creates an EC key pair for signing access tokens, this is the curve used by the auth system.
+ *
+ * @return the private key and, the PEM encoded public key for config.Config.JwtPublicKey
+ */
+func NewKeyPair() (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+/*
+ * Signs an access token for a user. The claims are checked against the wall clock, see
+ * security.Claims.Valid, so they are not issued with the harness's clock.
+ */
+func SignAccessToken(key *ecdsa.PrivateKey, userId string, firstname string, surname string, ttl time.Duration) (string, error) {
+	return security.SignAccessToken(key, userId, firstname, surname, ttl)
+}
@@ -0,0 +1,324 @@
+/*
+ * logging.go is a levelled logger that writes one JSON or, logfmt object per line. Loggers carry
+ * fields, i.e: the request id, and are passed down with the request's context so that errors from
+ * the model can be matched with the request that caused them.
+ */
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type Level int
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+)
+
+type Format int
+
+const (
+	JSON Format = iota
+	LOGFMT
+)
+
+const TIME_FORMAT = "2006-01-02T15:04:05.000000Z07:00"
+
+// Field names that every line has
+const (
+	TIME_FIELD   = "time"
+	LEVEL_FIELD  = "level"
+	MSG_FIELD    = "msg"
+	CALLER_FIELD = "caller"
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DEBUG || l > ERROR {
+		return "unknown"
+	}
+	return levelNames[l]
+}
+
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return INFO, fmt.Errorf("%s is not a log level, use debug, info, warn or, error", name)
+}
+
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "json":
+		return JSON, nil
+	case "logfmt":
+		return LOGFMT, nil
+	}
+	return JSON, fmt.Errorf("%s is not a log format, use json or, logfmt", name)
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Loggers made with With share their output so lines are not interleaved
+type output struct {
+	lock   sync.Mutex
+	writer io.Writer
+	level  Level
+	format Format
+	now    func() time.Time
+}
+
+type Logger struct {
+	out    *output
+	fields []field
+}
+
+func New(writer io.Writer, level Level, format Format) *Logger {
+	return &Logger{out: &output{writer: writer, level: level, format: format, now: time.Now}}
+}
+
+var defaultLogger = New(os.Stdout, INFO, JSON)
+
+// The logger for code that is not handling a request, i.e: the daemons
+func Default() *Logger {
+	return defaultLogger
+}
+
+func SetDefault(l *Logger) {
+	defaultLogger = l
+}
+
+type contextKey struct{}
+
+// Gets a context that carries a logger, see FromContext
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// Gets the logger for the context, this is the default logger if the context does not have one
+func FromContext(ctx context.Context) *Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+			return l
+		}
+	}
+	return defaultLogger
+}
+
+/*
+ * Gets a logger that adds a field to every line, later fields with the same key replace earlier
+ * ones.
+ */
+func (l *Logger) With(key string, value interface{}) *Logger {
+	fields := make([]field, 0, len(l.fields)+1)
+	for _, f := range l.fields {
+		if f.key != key {
+			fields = append(fields, f)
+		}
+	}
+	return &Logger{out: l.out, fields: append(fields, field{key: key, value: value})}
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.out.level
+}
+
+// The arguments are formatted like log.Println
+func (l *Logger) Debug(v ...interface{}) {
+	l.log(DEBUG, sprint(v...), true)
+}
+
+func (l *Logger) Info(v ...interface{}) {
+	l.log(INFO, sprint(v...), true)
+}
+
+func (l *Logger) Warn(v ...interface{}) {
+	l.log(WARN, sprint(v...), true)
+}
+
+func (l *Logger) Error(v ...interface{}) {
+	l.log(ERROR, sprint(v...), true)
+}
+
+// The arguments are formatted like log.Printf
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.log(DEBUG, fmt.Sprintf(format, v...), true)
+}
+
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.log(INFO, fmt.Sprintf(format, v...), true)
+}
+
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.log(WARN, fmt.Sprintf(format, v...), true)
+}
+
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.log(ERROR, fmt.Sprintf(format, v...), true)
+}
+
+// Logs at the error level then exits, this is for errors that the server cannot start with
+func (l *Logger) Fatal(v ...interface{}) {
+	l.log(ERROR, sprint(v...), true)
+	os.Exit(1)
+}
+
+func (l *Logger) Fatalf(format string, v ...interface{}) {
+	l.log(ERROR, fmt.Sprintf(format, v...), true)
+	os.Exit(1)
+}
+
+type lineWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w.logger.log(w.level, string(p), false)
+	return len(p), nil
+}
+
+/*
+ * Gets a writer that logs each write as a line, this is for the standard logger and, gin so
+ * that their lines are structured and, redacted too.
+ */
+func (l *Logger) Writer(level Level) io.Writer {
+	return lineWriter{logger: l, level: level}
+}
+
+func sprint(v ...interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+}
+
+// Gets the file and, line of the code that called the logger, i.e: model/attendance.go:164
+func caller() string {
+	// log, the exported method then, the caller
+	_, file, line, ok := runtime.Caller(3)
+	if !ok {
+		return ""
+	}
+	return filepath.Base(filepath.Dir(file)) + "/" + filepath.Base(file) + ":" + strconv.Itoa(line)
+}
+
+func (l *Logger) log(level Level, msg string, withCaller bool) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := make([]field, 0, len(l.fields)+4)
+	fields = append(fields, field{TIME_FIELD, l.out.now().UTC().Format(TIME_FORMAT)},
+		field{LEVEL_FIELD, level.String()},
+		field{MSG_FIELD, Redact(strings.TrimRight(msg, "\n"))})
+	if withCaller {
+		if c := caller(); c != "" {
+			fields = append(fields, field{CALLER_FIELD, c})
+		}
+	}
+
+	for _, f := range l.fields {
+		fields = append(fields, field{f.key, RedactField(f.key, f.value)})
+	}
+
+	var line bytes.Buffer
+	if l.out.format == LOGFMT {
+		writeLogfmt(&line, fields)
+	} else {
+		writeJson(&line, fields)
+	}
+	line.WriteByte('\n')
+
+	l.out.lock.Lock()
+	defer l.out.lock.Unlock()
+	l.out.writer.Write(line.Bytes())
+}
+
+// Errors and, Stringers are logged as their text, other values are logged as they are
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+func writeJson(buf *bytes.Buffer, fields []field) {
+	buf.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(f.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		value, err := json.Marshal(fieldValue(f.value))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(f.value))
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+}
+
+func writeLogfmt(buf *bytes.Buffer, fields []field) {
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+
+		buf.WriteString(f.key)
+		buf.WriteByte('=')
+
+		value := fieldValue(f.value)
+		text := ""
+		if value != nil {
+			text = fmt.Sprint(value)
+		}
+
+		if needsQuotes(text) {
+			buf.WriteString(strconv.Quote(text))
+		} else {
+			buf.WriteString(text)
+		}
+	}
+}
+
+func needsQuotes(text string) bool {
+	if text == "" || !utf8.ValidString(text) {
+		return true
+	}
+
+	for _, r := range text {
+		if r <= ' ' || r == '=' || r == '"' || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
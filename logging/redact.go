@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"regexp"
+	"strings"
+)
+
+/*
+ * Tokens and, nonces let anyone that reads the logs make requests as the user so, they are
+ * removed from every line. JWTs are found by their shape, nonces and, other secrets are found
+ * by the name they are given, i.e: "Nonce: 123" or, "?ical-auth=...".
+ */
+
+const REDACTED = "**REDACTED**"
+
+// Field and, parameter names that are always redacted
+var sensitiveNames = []string{"authorization", "nonce", "ical-auth", "token", "jwt", "password", "secret"}
+
+var jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`)
+var bearerPattern = regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`)
+var namedPattern = regexp.MustCompile(`(?i)([a-z_-]*(?:` + strings.Join(sensitiveNames, "|") + `)[a-z_-]*)("?\s*[:=]\s*"?)([^\s&",;]+)`)
+
+// Removes JWTs and, named secrets from text
+func Redact(text string) string {
+	text = jwtPattern.ReplaceAllString(text, REDACTED)
+	text = bearerPattern.ReplaceAllString(text, "Bearer "+REDACTED)
+	return namedPattern.ReplaceAllString(text, "${1}${2}"+REDACTED)
+}
+
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range sensitiveNames {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacts a field's value, the whole value is removed if the field's name is sensitive
+func RedactField(key string, value interface{}) interface{} {
+	if isSensitive(key) {
+		return REDACTED
+	}
+
+	switch v := fieldValue(value).(type) {
+	case string:
+		return Redact(v)
+	default:
+		return v
+	}
+}
@@ -2,13 +2,26 @@ package main
 
 import (
 	"arcio/attendance-system/config"
+	"arcio/attendance-system/logging"
 	"arcio/attendance-system/middleware"
+	"arcio/attendance-system/migrations"
+	"arcio/attendance-system/model"
 	"arcio/attendance-system/routes"
 	"arcio/attendance-system/security"
 	"arcio/attendance-system/utils"
+	"context"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"runtime"
+	"sync"
+	"syscall"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
 )
 
 var GlobalConfig config.Config
@@ -17,6 +30,9 @@ var NonceManager security.NonceManager
 
 const VERSION_INFO = "Verticle Slice 1"
 
+// How long requests have to finish when shutting down
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+
 func main() {
 	fmt.Println("Arcio Attendance System Backend, send stdout to a log file for all errors to be logged.")
 	fmt.Println(" -> See ./README.md for setup help and the \"arcio-db\" repo for database schemas.")
@@ -24,24 +40,38 @@ func main() {
 	fmt.Printf(" -> Version: \"%s\"\n", VERSION_INFO)
 	fmt.Printf(" -> Environment information: \"%s\"\n", runtime.Version())
 	fmt.Println("Please send above data in any bug reports or support queries.")
-	log.SetFlags(log.Llongfile | log.Ldate | log.Ltime | log.Lmicroseconds)
-	log.Println("Starting the attendance server")
+	// The standard logger and, gin write through the structured logger
+	// See logging/logging.go
+	redirectLogs()
+	logging.Default().Info("Starting the attendance server")
 
 	// Read configuration and connect to the database
 	conf, err := config.LoadConfig()
 	if err != nil {
-		log.Fatal("Cannot load config")
+		logging.Default().Fatal("Cannot load config")
 	}
 	GlobalConfig = conf
 
+	level, err := logging.ParseLevel(conf.LogLevel)
+	if err != nil {
+		logging.Default().Fatalf("LOG_LEVEL is invalid - %s", err)
+	}
+
+	format, err := logging.ParseFormat(conf.LogFormat)
+	if err != nil {
+		logging.Default().Fatalf("LOG_FORMAT is invalid - %s", err)
+	}
+	logging.SetDefault(logging.New(os.Stdout, level, format))
+	redirectLogs()
+
 	database, err := utils.InitDatabasePool(conf)
 	if err != nil {
-		log.Fatalf("Cannot initialise connection to the database - %s\n", err)
+		logging.Default().Fatalf("Cannot initialise connection to the database - %s", err)
 	}
 	DatabasePool = database
 
 	// Setup the nonce manager
-	log.Println("Starting nonce manager")
+	logging.Default().Info("Starting nonce manager")
 	NonceManager.InitNonceManager()
 
 	// Creates a reference to the global nonce manager.
@@ -51,17 +81,123 @@ func main() {
 	// Creates a reference to the global Database Pol.
 	// See routes/router.go
 	routes.DatabasePool = database
+	routes.Store = model.NewPostgresRepositories(database)
 
 	// Create a reference to the global config
 	// See routes/router.go
 	routes.GlobalConfig = &GlobalConfig
 	middleware.GlobalConfig = &GlobalConfig
 
+	// Marks after the grace period are stored as late
+	// See model/attendance.go
+	model.LateGracePeriod = time.Duration(conf.LateGracePeriod) * time.Second
+
+	// New repeating lessons are in this time zone
+	// See model/recurrence.go
+	if _, err := time.LoadLocation(conf.Timezone); err != nil {
+		logging.Default().Fatalf("TIMEZONE %s is not a valid time zone - %s", conf.Timezone, err)
+	}
+	model.DefaultTimezone = conf.Timezone
+
+	// The server and, the subcommands other than `migrate` do not run until the schema has every
+	// migration, see `migrate up`. See utils/migrate.go
+	if len(os.Args) < 2 || os.Args[1] != "migrate" {
+		allMigrations, err := utils.LoadMigrations(migrations.Files)
+		if err != nil {
+			logging.Default().Fatalf("Cannot read migrations - %s", err)
+		}
+
+		err = utils.CheckSchemaVersion(context.Background(), DatabasePool, allMigrations)
+		if err != nil {
+			logging.Default().Fatalf("Cannot start with this schema - %s, run `migrate up`", err)
+		}
+	}
+
+	// Run a subcommand instead of the server
+	// See ./commands.go
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+
+	// Background jobs run until the server is stopped, they are leader elected so that only
+	// one replica runs each job. See utils/leader.go
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var daemons sync.WaitGroup
+
+	// Spawn lessons from repeating lessons
+	// See model/repeating_lesson_daemon.go
+	daemons.Add(1)
+	go func() {
+		defer daemons.Done()
+		model.StartLessonSpawnDaemon(ctx, DatabasePool)
+	}()
+
+	// Check for students at risk due to low attendance
+	// See model/at_risk.go
+	atRiskRules := model.AtRiskRules{Threshold: float64(conf.AtRiskThreshold),
+		ConsecutiveAbsences: conf.AtRiskConsecutiveAbsences,
+		MinimumSessions:     conf.AtRiskMinimumSessions}
+	daemons.Add(1)
+	go func() {
+		defer daemons.Done()
+		model.StartAtRiskDaemon(ctx, atRiskRules, time.Duration(conf.AtRiskCheckPeriod)*time.Second, DatabasePool)
+	}()
+
+	// Send webhook deliveries
+	// See model/webhook_daemon.go
+	daemons.Add(1)
+	go func() {
+		defer daemons.Done()
+		model.StartWebhookDaemon(ctx, DatabasePool)
+	}()
+
+	// Pass register updates to register streams, every replica listens
+	// See model/register_stream.go
+	daemons.Add(1)
+	go func() {
+		defer daemons.Done()
+		model.StartRegisterListener(ctx, utils.DatabaseUrl(conf), DatabasePool)
+	}()
+
 	bindAddr := fmt.Sprintf("%s:%d", conf.BindAddr, conf.BindPort)
-	log.Printf("Started the attendance server on http://%s\n", bindAddr)
+	logging.Default().Infof("Started the attendance server on http://%s", bindAddr)
 
 	globalRouter := routes.InitRouter()
-	log.Println("Server started")
-	globalRouter.Run(bindAddr)
-	log.Fatal("Exited for no reason :(")
+	server := &http.Server{Addr: bindAddr, Handler: globalRouter}
+	// Streams would hold the shutdown open until they time out
+	server.RegisterOnShutdown(model.RegisterStream.Close)
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logging.Default().Fatalf("Cannot start the server - %s", err)
+		}
+	}()
+	logging.Default().Info("Server started")
+
+	// Shutdown cleanly on SIGINT or, SIGTERM
+	<-ctx.Done()
+	stop()
+	logging.Default().Info("Shutting down the attendance server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		logging.Default().Error(err)
+	}
+
+	daemons.Wait()
+	utils.FlushPendingWrites(DatabasePool)
+	DatabasePool.Database.Close()
+	logging.Default().Info("Stopped the attendance server")
+}
+
+// Sends the standard logger's and, gin's lines to the default logger
+func redirectLogs() {
+	log.SetFlags(0)
+	log.SetOutput(logging.Default().Writer(logging.INFO))
+	gin.DefaultWriter = logging.Default().Writer(logging.DEBUG)
+	gin.DefaultErrorWriter = logging.Default().Writer(logging.ERROR)
 }
@@ -0,0 +1,39 @@
+/*
+ * deadline.go contains middleware that cancels a request's queries when it takes too long.
+ */
+
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+ * Sets a deadline on the request's context, the model functions use it for their queries so they
+ * are cancelled when the deadline passes or, the client goes away.
+ *
+ * @param fallback the deadline for routes that are not in routes, 0 or less is no deadline
+ * @param routes deadlines by the route's full path, i.e: "/report/module"
+ */
+func Deadline(fallback time.Duration, routes map[string]time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout, found := routes[c.FullPath()]
+		if !found {
+			timeout = fallback
+		}
+
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -10,10 +10,11 @@ import (
 	"arcio/attendance-system/config"
 	"arcio/attendance-system/model"
 	"arcio/attendance-system/security"
-	"arcio/attendance-system/utils"
+	"bytes"
+	"encoding/json"
 	"errors"
 	"github.com/gin-gonic/gin"
-	"log"
+	"io"
 	"net/http"
 	"strconv"
 )
@@ -105,11 +106,16 @@ func CheckAuth(NonceManager *security.NonceManager) gin.HandlerFunc {
 			return
 		}
 		c.Set(CLAIMS_KEY, claims)
+		setRequestLog(c, RequestLog(c).With("user", claims.Uuid))
 		c.Next()
 	}
 }
 
-func CheckPermissions(layer security.Layer, database *utils.DatabasePool, required ...security.Overrides) gin.HandlerFunc {
+/*
+ * Checks that the user has the required permissions at a layer, the module and, module group ids
+ * are read from the query for GETs and, from the body otherwise.
+ */
+func CheckPermissions(layer security.Layer, permissions model.PermissionRepository, required ...security.Overrides) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get target ids
 		moduleid := ""
@@ -130,10 +136,16 @@ func CheckPermissions(layer security.Layer, database *utils.DatabasePool, requir
 			// Otherwise read body
 			var ids struct {
 				ModuleId      string `json:"module-id,omitempty"`
-				ModuleGroupId string `json:"modu:le-group-id,omitempty"`
+				ModuleGroupId string `json:"module-group-id,omitempty"`
+			}
+
+			// The body is put back so that the handler can bind it too
+			body, err := io.ReadAll(c.Request.Body)
+			if err == nil {
+				c.Request.Body = io.NopCloser(bytes.NewReader(body))
+				err = json.Unmarshal(body, &ids)
 			}
 
-			err := c.ShouldBindJSON(&ids)
 			if err != nil {
 				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
 					"error": "Bad request govna",
@@ -156,9 +168,15 @@ func CheckPermissions(layer security.Layer, database *utils.DatabasePool, requir
 		id := claims.Uuid
 
 		// Get user perms
-		perms, err := model.GetPermissions(id, moduleid, groupid, layer, database)
-		if err != nil {
-			log.Println(err)
+		perms, err := model.GetRepositoryPermissions(c.Request.Context(), permissions, id, moduleid, groupid, layer)
+		if err == model.ErrModuleGroupNotInModule {
+			// The module's permissions do not apply to another module's groups
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": UNAUTHORISED_ERROR_MSG,
+			})
+			return
+		} else if err != nil {
+			RequestLog(c).Error(err)
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
 				"error": INTERNAL_SERVER_ERROR_MSG,
 			})
@@ -209,6 +227,7 @@ func CheckIcalAuth(config *config.Config) gin.HandlerFunc {
 		c.Writer.Header().Set("Content-Type", "application/Calender")
 		c.Writer.Header().Set("Content-Disposition", "attatchment; filename=\"timetable.ics\"")
 		c.Set("claims", claims)
+		setRequestLog(c, RequestLog(c).With("user", claims.Uuid))
 		c.Next()
 	}
 }
@@ -2,8 +2,12 @@ package middleware
 
 import (
 	"arcio/attendance-system/config"
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/model"
 	"arcio/attendance-system/security"
 	"arcio/attendance-system/utils"
+	"bytes"
+	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"io"
 	"log"
@@ -12,6 +16,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 var router *gin.Engine
@@ -43,7 +48,7 @@ func init() {
 	}
 
 	permsRouter = gin.New()
-	permsRouter.Use(CheckPermissions(security.Global, db, security.PERMS_NONE))
+	permsRouter.Use(CheckPermissions(security.Global, model.NewPostgresRepositories(db).Permissions, security.PERMS_NONE))
 	permsRouter.GET("/get", func(c *gin.Context) {
 		c.Status(http.StatusOK)
 	})
@@ -163,3 +168,80 @@ func TestPermsMiddleWarePollutedJsonPOST(t *testing.T) {
 		t.Error("Expected Status 500, but got", w.Result().StatusCode)
 	}
 }
+
+// The deadline should be set for listed routes only
+func TestDeadline(t *testing.T) {
+	deadlineRouter := gin.New()
+	deadlineRouter.Use(Deadline(time.Minute, map[string]time.Duration{"/stream": 0}))
+	handler := func(c *gin.Context) {
+		if _, found := c.Request.Context().Deadline(); found {
+			c.Status(http.StatusOK)
+		} else {
+			c.Status(http.StatusNoContent)
+		}
+	}
+	deadlineRouter.GET("/report", handler)
+	deadlineRouter.GET("/stream", handler)
+
+	req, _ := http.NewRequest("GET", "/report", nil)
+	w := httptest.NewRecorder()
+	deadlineRouter.ServeHTTP(w, req)
+	if w.Result().StatusCode != http.StatusOK {
+		t.Error("Expected a deadline for /report, but got", w.Result().StatusCode)
+	}
+
+	req, _ = http.NewRequest("GET", "/stream", nil)
+	w = httptest.NewRecorder()
+	deadlineRouter.ServeHTTP(w, req)
+	if w.Result().StatusCode != http.StatusNoContent {
+		t.Error("Expected no deadline for /stream, but got", w.Result().StatusCode)
+	}
+}
+
+// Requests should get an id in the header and, one log line with it
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logRouter := gin.New()
+	logRouter.Use(RequestLogger(logging.New(&buf, logging.DEBUG, logging.JSON)))
+	logRouter.GET("/timetable/ical", func(c *gin.Context) {
+		RequestLog(c).Info("Handling")
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/timetable/ical?ical-auth=secret", nil)
+	w := httptest.NewRecorder()
+	logRouter.ServeHTTP(w, req)
+
+	requestId := w.Header().Get(REQUEST_ID_HEADER)
+	if requestId == "" {
+		t.Fatal("Expected a request id in the response")
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected the handler's line and, the request's line, got %s", buf.String())
+	}
+
+	var line map[string]interface{}
+	err := json.Unmarshal([]byte(lines[1]), &line)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if line["request-id"] != requestId || line["route"] != "/timetable/ical" || line["status"] != float64(http.StatusOK) {
+		t.Error("Expected the request id, route and, status, but got", lines[1])
+	}
+
+	if !strings.Contains(lines[0], requestId) || strings.Contains(buf.String(), "secret") {
+		t.Error("Expected the handler's line to have the request id and, the token to be redacted, but got", buf.String())
+	}
+
+	// Valid ids from a proxy are kept
+	req, _ = http.NewRequest("GET", "/timetable/ical", nil)
+	req.Header.Set(REQUEST_ID_HEADER, "proxy-123")
+	w = httptest.NewRecorder()
+	logRouter.ServeHTTP(w, req)
+	if w.Header().Get(REQUEST_ID_HEADER) != "proxy-123" {
+		t.Error("Expected the proxy's request id, but got", w.Header().Get(REQUEST_ID_HEADER))
+	}
+}
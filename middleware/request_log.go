@@ -0,0 +1,75 @@
+/*
+ * request_log.go contains middleware that gives each request an id and, logs it once it is done.
+ */
+
+package middleware
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/security"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const REQUEST_ID_HEADER = "X-Request-Id"
+
+// Request ids from a proxy are kept if they look like this so that they cannot forge log lines
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Gets the logger for a request, it has the request id and, the user once they are authorised
+func RequestLog(c *gin.Context) *logging.Logger {
+	return logging.FromContext(c.Request.Context())
+}
+
+func setRequestLog(c *gin.Context, l *logging.Logger) {
+	c.Request = c.Request.WithContext(logging.NewContext(c.Request.Context(), l))
+}
+
+/*
+ * Gives the request an id which is returned in the X-Request-Id header, then logs the route,
+ * status, latency and, user once the request is done. Model functions log through the request's
+ * context so their lines have the same id.
+ */
+func RequestLogger(base *logging.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestId := c.GetHeader(REQUEST_ID_HEADER)
+		if !requestIdPattern.MatchString(requestId) {
+			requestId = uuid.New().String()
+		}
+		c.Writer.Header().Set(REQUEST_ID_HEADER, requestId)
+		setRequestLog(c, base.With("request-id", requestId))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		l := RequestLog(c).With("method", c.Request.Method).
+			With("route", c.FullPath()).
+			With("path", c.Request.URL.RequestURI()).
+			With("status", status).
+			With("latency-ms", float64(time.Since(start).Microseconds())/1000).
+			With("ip", c.ClientIP())
+
+		if claims, found := c.Get(CLAIMS_KEY); found {
+			l = l.With("user", claims.(security.Claims).Uuid)
+		}
+
+		if len(c.Errors) != 0 {
+			l = l.With("errors", c.Errors.String())
+		}
+
+		switch {
+		case status >= http.StatusInternalServerError:
+			l.Error("Request failed")
+		case status >= http.StatusBadRequest:
+			l.Warn("Request rejected")
+		default:
+			l.Info("Request done")
+		}
+	}
+}
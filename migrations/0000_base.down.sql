@@ -0,0 +1,17 @@
+-- Removes every table of the base schema and, the data in them. migrate down never reverts the
+-- base schema, this is here for completeness.
+drop table if exists endpoint_audit_logs;
+drop table if exists attendance_user_roles;
+drop table if exists module_user_group_roles;
+drop table if exists module_user_roles;
+drop table if exists role_users;
+drop table if exists roles;
+drop table if exists attendance;
+drop table if exists actual_lessons;
+drop table if exists repeating_lessons;
+drop table if exists group_lessons;
+drop table if exists module_user_groups;
+drop table if exists module_users;
+drop table if exists module_groups;
+drop table if exists modules;
+drop table if exists users;
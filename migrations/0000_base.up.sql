@@ -0,0 +1,131 @@
+-- The base schema from before the migrations, it was kept in the arcio-db repo.
+-- Databases made from arcio-db already have these tables so nothing here replaces them, see
+-- the Database section of the README.
+create table if not exists users (
+	id uuid primary key,
+	external_id text not null default '',
+	firstname text not null,
+	surname text not null,
+	email text not null,
+	password text not null default '',
+	salt text not null default '',
+	creation_time timestamp not null default CURRENT_TIMESTAMP,
+	edit_time timestamp not null default CURRENT_TIMESTAMP
+);
+
+create table if not exists modules (
+	id uuid primary key,
+	name text not null,
+	external_id text not null default '',
+	creation_time timestamp not null default CURRENT_TIMESTAMP,
+	edit_time timestamp not null default CURRENT_TIMESTAMP
+);
+
+create table if not exists module_groups (
+	id uuid primary key,
+	module_id uuid not null references modules(id) on delete cascade,
+	name text not null,
+	creation_time timestamp not null default CURRENT_TIMESTAMP,
+	edit_time timestamp not null default CURRENT_TIMESTAMP
+);
+
+create table if not exists module_users (
+	id uuid primary key,
+	user_id uuid not null references users(id) on delete cascade,
+	module_id uuid not null references modules(id) on delete cascade,
+	creation_time timestamp not null default CURRENT_TIMESTAMP,
+	edit_time timestamp not null default CURRENT_TIMESTAMP
+);
+
+create table if not exists module_user_groups (
+	id uuid primary key,
+	module_user_id uuid not null references module_users(id) on delete cascade,
+	module_group_id uuid not null references module_groups(id) on delete cascade
+);
+
+create table if not exists group_lessons (
+	id uuid primary key,
+	module_group_id uuid not null references module_groups(id) on delete cascade,
+	name text not null,
+	attendance_required boolean not null default true,
+	summary text not null default '',
+	description text not null default '',
+	location text not null default '',
+	creation_time timestamp not null default CURRENT_TIMESTAMP,
+	edit_time timestamp not null default CURRENT_TIMESTAMP
+);
+
+-- start_time and, end_time are the time of day, the series runs between the repeating dates
+create table if not exists repeating_lessons (
+	id uuid primary key,
+	group_lesson_id uuid not null references group_lessons(id) on delete cascade,
+	start_repeating date not null,
+	stop_repeating date not null,
+	start_time time not null,
+	end_time time not null,
+	repeat_every interval not null,
+	last_spawned_time timestamp,
+	creation_time timestamp not null default CURRENT_TIMESTAMP,
+	edit_time timestamp not null default CURRENT_TIMESTAMP
+);
+
+create table if not exists actual_lessons (
+	id uuid primary key,
+	group_lesson_id uuid not null references group_lessons(id) on delete cascade,
+	start_time timestamp not null,
+	end_time timestamp not null,
+	summary text not null default '',
+	description text not null default '',
+	location text not null default '',
+	creation_time timestamp not null default CURRENT_TIMESTAMP,
+	edit_time timestamp not null default CURRENT_TIMESTAMP
+);
+
+create table if not exists attendance (
+	id uuid primary key,
+	lesson_id uuid not null references actual_lessons(id) on delete cascade,
+	user_id uuid not null references users(id) on delete cascade,
+	register_time timestamp not null default CURRENT_TIMESTAMP
+);
+
+-- overrides is a security.Overrides bit set
+create table if not exists roles (
+	id uuid primary key,
+	overrides bigint not null default 0
+);
+
+-- A role at each permission layer, see model/perms.go
+create table if not exists role_users (
+	id uuid primary key,
+	role_id uuid not null references roles(id) on delete cascade,
+	user_id uuid not null references users(id) on delete cascade
+);
+
+create table if not exists module_user_roles (
+	id uuid primary key,
+	role_id uuid not null references roles(id) on delete cascade,
+	module_user_id uuid not null references module_users(id) on delete cascade
+);
+
+create table if not exists module_user_group_roles (
+	id uuid primary key,
+	role_id uuid not null references roles(id) on delete cascade,
+	module_user_group_id uuid not null references module_user_groups(id) on delete cascade
+);
+
+create table if not exists attendance_user_roles (
+	id uuid primary key,
+	role_id uuid not null references roles(id) on delete cascade,
+	user_id uuid not null references users(id) on delete cascade
+);
+
+-- See utils/endpoint_audit_logger.go
+create table if not exists endpoint_audit_logs (
+	id uuid primary key,
+	user_id uuid,
+	method text not null,
+	endpoint text not null,
+	body bytea not null,
+	ip_address text not null,
+	creation_time timestamp not null default CURRENT_TIMESTAMP
+);
@@ -0,0 +1,11 @@
+-- Rotating code check-in sessions for lessons
+create table checkin_sessions (
+	id uuid primary key,
+	lesson_id uuid not null references actual_lessons(id) on delete cascade,
+	opened_by uuid not null references users(id),
+	code_period integer not null, -- seconds
+	opened_time timestamp not null,
+	expiry_time timestamp not null -- set to now when closed early
+);
+
+create index checkin_sessions_lesson_id_idx on checkin_sessions(lesson_id);
@@ -0,0 +1,2 @@
+alter table attendance drop constraint if exists attendance_status_check;
+alter table attendance drop column if exists reason, drop column if exists status;
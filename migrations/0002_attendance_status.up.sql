@@ -0,0 +1,7 @@
+-- Attendance statuses, see model/attendance.go
+alter table attendance
+	add column status varchar(32) not null default 'present',
+	add column reason text not null default '';
+
+alter table attendance add constraint attendance_status_check
+	check (status in ('present', 'late', 'excused', 'authorised-absence', 'unauthorised-absence'));
@@ -0,0 +1,3 @@
+drop table if exists repeating_lesson_exceptions;
+
+alter table actual_lessons drop column if exists cancelled;
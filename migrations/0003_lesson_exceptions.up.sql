@@ -0,0 +1,10 @@
+-- Cancelled lessons and, repeating lesson exceptions, see model/lesson_editing.go
+alter table actual_lessons add column cancelled boolean not null default false;
+
+create table repeating_lesson_exceptions (
+	id uuid primary key,
+	repeating_lesson_id uuid not null references repeating_lessons(id) on delete cascade,
+	exception_date date not null,
+	creation_time timestamp not null,
+	unique (repeating_lesson_id, exception_date)
+);
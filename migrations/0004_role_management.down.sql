@@ -0,0 +1,12 @@
+-- Columns are left in place as they may be part of the base schema
+drop index if exists attendance_user_roles_role_user_idx;
+alter table attendance_user_roles alter column id drop default;
+
+drop index if exists module_user_group_roles_role_user_idx;
+alter table module_user_group_roles alter column id drop default;
+
+drop index if exists module_user_roles_role_user_idx;
+alter table module_user_roles alter column id drop default;
+
+drop index if exists role_users_role_user_idx;
+alter table role_users alter column id drop default;
@@ -0,0 +1,26 @@
+-- Role management, see model/role_management.go
+-- The roles tables come from the base schema, these make sure that roles can be named
+-- and, that role assignments can be inserted without choosing an id.
+-- gen_random_uuid is built into Postgres 13 and, later, it comes from pgcrypto before that.
+create extension if not exists pgcrypto;
+
+alter table roles
+	add column if not exists name varchar(255) not null default '',
+	add column if not exists creation_time timestamp not null default CURRENT_TIMESTAMP,
+	add column if not exists edit_time timestamp not null default CURRENT_TIMESTAMP;
+
+alter table role_users add column if not exists id uuid;
+alter table role_users alter column id set default gen_random_uuid();
+create unique index if not exists role_users_role_user_idx on role_users (role_id, user_id);
+
+alter table module_user_roles add column if not exists id uuid;
+alter table module_user_roles alter column id set default gen_random_uuid();
+create unique index if not exists module_user_roles_role_user_idx on module_user_roles (role_id, module_user_id);
+
+alter table module_user_group_roles add column if not exists id uuid;
+alter table module_user_group_roles alter column id set default gen_random_uuid();
+create unique index if not exists module_user_group_roles_role_user_idx on module_user_group_roles (role_id, module_user_group_id);
+
+alter table attendance_user_roles add column if not exists id uuid;
+alter table attendance_user_roles alter column id set default gen_random_uuid();
+create unique index if not exists attendance_user_roles_role_user_idx on attendance_user_roles (role_id, user_id);
@@ -0,0 +1,3 @@
+drop index if exists attendance_alerts_module_idx;
+drop table if exists attendance_alerts;
+drop table if exists at_risk_students;
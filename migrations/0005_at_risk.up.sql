@@ -0,0 +1,27 @@
+-- At-risk students and, low attendance alerts, see model/at_risk.go
+create table if not exists at_risk_students (
+	id uuid primary key default gen_random_uuid(),
+	user_id uuid not null references users(id) on delete cascade,
+	module_id uuid not null references modules(id) on delete cascade,
+	percentage real not null,
+	consecutive_absences integer not null,
+	reasons text[] not null,
+	since timestamp not null default CURRENT_TIMESTAMP,
+	update_time timestamp not null default CURRENT_TIMESTAMP,
+	unique (user_id, module_id)
+);
+
+create table if not exists attendance_alerts (
+	id uuid primary key default gen_random_uuid(),
+	user_id uuid not null references users(id) on delete cascade,
+	module_id uuid not null references modules(id) on delete cascade,
+	reason text not null,
+	percentage real not null,
+	consecutive_absences integer not null,
+	creation_time timestamp not null default CURRENT_TIMESTAMP,
+	acknowledged boolean not null default false,
+	acknowledged_by uuid references users(id) on delete set null,
+	acknowledged_time timestamp
+);
+
+create index if not exists attendance_alerts_module_idx on attendance_alerts (module_id, creation_time);
@@ -0,0 +1,2 @@
+drop index if exists actual_lessons_occurrence_idx;
+alter table actual_lessons drop column occurrence_date, drop column repeating_lesson_id;
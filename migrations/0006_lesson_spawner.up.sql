@@ -0,0 +1,7 @@
+-- Lessons spawned from a repeating lesson, see model/repeating_lesson_daemon.go
+-- Each occurrence is spawned at most once, even with several replicas running the spawner.
+alter table actual_lessons
+	add column repeating_lesson_id uuid references repeating_lessons(id) on delete set null,
+	add column occurrence_date date;
+
+create unique index actual_lessons_occurrence_idx on actual_lessons (repeating_lesson_id, occurrence_date);
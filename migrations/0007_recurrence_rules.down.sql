@@ -0,0 +1 @@
+alter table repeating_lessons drop column timezone, drop column rdate, drop column rrule;
@@ -0,0 +1,6 @@
+-- RFC 5545 recurrence rules for repeating lessons, see model/recurrence.go
+-- Series without an rrule repeat every repeat_every, series without a timezone are in UTC.
+alter table repeating_lessons
+	add column rrule text not null default '',
+	add column rdate text not null default '',
+	add column timezone text not null default '';
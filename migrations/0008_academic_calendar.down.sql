@@ -0,0 +1,3 @@
+drop table if exists closures;
+drop table if exists terms;
+drop table if exists academic_years;
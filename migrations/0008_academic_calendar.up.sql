@@ -0,0 +1,36 @@
+-- Academic years, terms and, closure days, see model/academic_calendar.go
+create table if not exists academic_years (
+	id uuid primary key default gen_random_uuid(),
+	name text not null unique,
+	start_date date not null,
+	end_date date not null,
+	creation_time timestamp not null default CURRENT_TIMESTAMP,
+	edit_time timestamp not null default CURRENT_TIMESTAMP,
+	check (start_date <= end_date)
+);
+
+-- Teaching weeks are numbered from first_week, they run Monday to Sunday
+create table if not exists terms (
+	id uuid primary key default gen_random_uuid(),
+	academic_year_id uuid not null references academic_years(id) on delete cascade,
+	name text not null,
+	start_date date not null,
+	end_date date not null,
+	first_week integer not null,
+	creation_time timestamp not null default CURRENT_TIMESTAMP,
+	edit_time timestamp not null default CURRENT_TIMESTAMP,
+	unique (academic_year_id, name),
+	check (start_date <= end_date)
+);
+
+-- Days that no lessons happen on, both dates are inclusive
+create table if not exists closures (
+	id uuid primary key default gen_random_uuid(),
+	start_date date not null,
+	end_date date not null,
+	reason text not null default '',
+	creation_time timestamp not null default CURRENT_TIMESTAMP,
+	check (start_date <= end_date)
+);
+
+create index if not exists closures_date_idx on closures (start_date, end_date);
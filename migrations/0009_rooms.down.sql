@@ -0,0 +1,4 @@
+drop index if exists actual_lessons_room_idx;
+alter table actual_lessons drop column if exists room_id;
+alter table group_lessons drop column if exists room_id;
+drop table if exists rooms;
@@ -0,0 +1,15 @@
+-- Rooms that lessons are booked into, see model/rooms.go
+create table if not exists rooms (
+	id uuid primary key default gen_random_uuid(),
+	name text not null unique,
+	capacity integer not null check (capacity >= 0),
+	features text[] not null default '{}',
+	creation_time timestamp not null default CURRENT_TIMESTAMP,
+	edit_time timestamp not null default CURRENT_TIMESTAMP
+);
+
+-- A lesson's room overrides its group lesson's room
+alter table group_lessons add column if not exists room_id uuid references rooms(id) on delete set null;
+alter table actual_lessons add column if not exists room_id uuid references rooms(id) on delete set null;
+
+create index if not exists actual_lessons_room_idx on actual_lessons (room_id, start_time);
@@ -0,0 +1,2 @@
+drop index if exists attendance_lesson_user_idx;
+alter table attendance drop column if exists marked_by;
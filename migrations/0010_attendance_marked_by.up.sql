@@ -0,0 +1,4 @@
+-- Who registered each mark, see model/register.go. Older marks are left blank
+alter table attendance add column if not exists marked_by uuid references users(id) on delete set null;
+
+create index if not exists attendance_lesson_user_idx on attendance (lesson_id, user_id);
@@ -0,0 +1,5 @@
+drop trigger if exists attendance_corrections_immutable on attendance_corrections;
+drop function if exists attendance_corrections_immutable();
+drop index if exists attendance_corrections_user_idx;
+drop index if exists attendance_corrections_lesson_idx;
+drop table if exists attendance_corrections;
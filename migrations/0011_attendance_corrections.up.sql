@@ -0,0 +1,29 @@
+-- Changes to attendance marks, see model/corrections.go. Corrections are never changed or,
+-- removed so the ids are kept without foreign keys, the history outlives the lesson and, users
+create table if not exists attendance_corrections (
+	id uuid primary key default gen_random_uuid(),
+	attendance_id uuid,
+	lesson_id uuid not null,
+	user_id uuid not null,
+	action text not null check (action in ('amend', 'retract')),
+	old_status text not null default '',
+	new_status text not null default '',
+	old_reason text not null default '',
+	new_reason text not null default '',
+	actor uuid not null,
+	reason text not null,
+	creation_time timestamp not null default CURRENT_TIMESTAMP
+);
+
+create index if not exists attendance_corrections_lesson_idx on attendance_corrections (lesson_id, creation_time);
+create index if not exists attendance_corrections_user_idx on attendance_corrections (user_id, creation_time);
+
+create or replace function attendance_corrections_immutable() returns trigger as $$
+begin
+	raise exception 'attendance corrections cannot be changed';
+end;
+$$ language plpgsql;
+
+drop trigger if exists attendance_corrections_immutable on attendance_corrections;
+create trigger attendance_corrections_immutable before update or delete on attendance_corrections
+	for each row execute function attendance_corrections_immutable();
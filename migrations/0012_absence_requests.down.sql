@@ -0,0 +1,4 @@
+drop index if exists absence_requests_status_idx;
+drop index if exists absence_requests_user_idx;
+drop table if exists absence_request_lessons;
+drop table if exists absence_requests;
@@ -0,0 +1,28 @@
+-- Absence requests, see model/absence_requests.go. A request covers a time range or, a list of
+-- lessons
+create table if not exists absence_requests (
+	id uuid primary key default gen_random_uuid(),
+	user_id uuid not null references users(id) on delete cascade,
+	reason text not null,
+	start_time timestamp,
+	end_time timestamp,
+	attachment_name text,
+	attachment_type text,
+	attachment_size bigint,
+	attachment_url text,
+	status text not null default 'submitted' check (status in ('submitted', 'approved', 'rejected')),
+	decided_by uuid references users(id) on delete set null,
+	decision_reason text not null default '',
+	creation_time timestamp not null default CURRENT_TIMESTAMP,
+	decision_time timestamp,
+	check ((start_time is null) = (end_time is null) and (start_time is null or start_time < end_time))
+);
+
+create table if not exists absence_request_lessons (
+	request_id uuid not null references absence_requests(id) on delete cascade,
+	lesson_id uuid not null references actual_lessons(id) on delete cascade,
+	primary key (request_id, lesson_id)
+);
+
+create index if not exists absence_requests_user_idx on absence_requests (user_id, creation_time);
+create index if not exists absence_requests_status_idx on absence_requests (status, creation_time);
@@ -0,0 +1,7 @@
+drop table if exists webhook_dead_letters;
+drop index if exists webhook_delivery_log_delivery_idx;
+drop table if exists webhook_delivery_log;
+drop index if exists webhook_deliveries_subscription_idx;
+drop index if exists webhook_deliveries_due_idx;
+drop table if exists webhook_deliveries;
+drop table if exists webhook_subscriptions;
@@ -0,0 +1,54 @@
+-- Webhook subscriptions and, their deliveries, see model/webhooks.go
+create table if not exists webhook_subscriptions (
+	id uuid primary key default gen_random_uuid(),
+	url text not null,
+	secret text not null,
+	events text[] not null,
+	description text not null default '',
+	active boolean not null default true,
+	created_by uuid references users(id) on delete set null,
+	creation_time timestamp not null default CURRENT_TIMESTAMP,
+	edit_time timestamp not null default CURRENT_TIMESTAMP
+);
+
+-- One row per event per subscription, these are sent by the webhook daemon
+create table if not exists webhook_deliveries (
+	id uuid primary key default gen_random_uuid(),
+	subscription_id uuid not null references webhook_subscriptions(id) on delete cascade,
+	event_id uuid not null,
+	event text not null,
+	payload text not null,
+	status text not null default 'pending' check (status in ('pending', 'delivered', 'dead')),
+	attempts integer not null default 0,
+	next_attempt_time timestamp not null default CURRENT_TIMESTAMP,
+	creation_time timestamp not null default CURRENT_TIMESTAMP,
+	delivered_time timestamp
+);
+
+create index if not exists webhook_deliveries_due_idx on webhook_deliveries (next_attempt_time) where status = 'pending';
+create index if not exists webhook_deliveries_subscription_idx on webhook_deliveries (subscription_id, creation_time);
+
+-- Every attempt to send a delivery
+create table if not exists webhook_delivery_log (
+	id uuid primary key default gen_random_uuid(),
+	delivery_id uuid not null references webhook_deliveries(id) on delete cascade,
+	attempt integer not null,
+	status_code integer not null default 0,
+	error text not null default '',
+	duration_ms integer not null default 0,
+	attempt_time timestamp not null default CURRENT_TIMESTAMP
+);
+
+create index if not exists webhook_delivery_log_delivery_idx on webhook_delivery_log (delivery_id, attempt);
+
+-- Deliveries that ran out of attempts
+create table if not exists webhook_dead_letters (
+	id uuid primary key default gen_random_uuid(),
+	delivery_id uuid not null unique references webhook_deliveries(id) on delete cascade,
+	subscription_id uuid not null references webhook_subscriptions(id) on delete cascade,
+	event text not null,
+	payload text not null,
+	attempts integer not null,
+	last_error text not null default '',
+	creation_time timestamp not null default CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+-- This fails if a series has spawned more than one lesson on a day
+drop index if exists actual_lessons_occurrence_start_idx;
+create unique index actual_lessons_occurrence_idx on actual_lessons (repeating_lesson_id, occurrence_date);
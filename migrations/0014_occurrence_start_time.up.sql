@@ -0,0 +1,5 @@
+-- Spawned lessons are unique on their start instead of their date, see model/repeating_lesson_daemon.go
+-- Series that repeat more than once a day, or that have an rdate on the same day as another
+-- occurrence, have several occurrences with the same date.
+drop index if exists actual_lessons_occurrence_idx;
+create unique index actual_lessons_occurrence_start_idx on actual_lessons (repeating_lesson_id, start_time);
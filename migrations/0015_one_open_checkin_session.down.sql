@@ -0,0 +1,2 @@
+drop index if exists checkin_sessions_open_idx;
+alter table checkin_sessions drop column if exists closed_time;
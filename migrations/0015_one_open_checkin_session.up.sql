@@ -0,0 +1,13 @@
+-- A lesson can only have one open check-in session, see model/checkin.go
+-- Sessions are closed when they are closed early or, when they have expired and another is opened.
+alter table checkin_sessions add column closed_time timestamp;
+
+update checkin_sessions set closed_time = expiry_time where expiry_time <= CURRENT_TIMESTAMP;
+
+-- Only the latest of the sessions that are open at once stays open
+update checkin_sessions set closed_time = CURRENT_TIMESTAMP, expiry_time = CURRENT_TIMESTAMP
+where closed_time is null and exists (select 1 from checkin_sessions later
+	where later.lesson_id = checkin_sessions.lesson_id and later.closed_time is null and
+	(later.opened_time, later.id) > (checkin_sessions.opened_time, checkin_sessions.id));
+
+create unique index checkin_sessions_open_idx on checkin_sessions(lesson_id) where closed_time is null;
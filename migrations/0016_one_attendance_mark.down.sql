@@ -0,0 +1,2 @@
+drop index if exists attendance_lesson_user_idx;
+create index if not exists attendance_lesson_user_idx on attendance (lesson_id, user_id);
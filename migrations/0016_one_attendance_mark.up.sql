@@ -0,0 +1,8 @@
+-- A student has one mark for each lesson, marking them again changes it, see model/attendance.go
+-- Only the latest of the marks that were registered more than once is kept
+delete from attendance where exists (select 1 from attendance later
+	where later.lesson_id = attendance.lesson_id and later.user_id = attendance.user_id and
+	(later.register_time, later.id) > (attendance.register_time, attendance.id));
+
+drop index if exists attendance_lesson_user_idx;
+create unique index attendance_lesson_user_idx on attendance (lesson_id, user_id);
@@ -0,0 +1,24 @@
+alter table actual_lessons add column occurrence_date date;
+update actual_lessons set occurrence_date =
+	(actual_lessons.occurrence_start at time zone 'UTC'
+	at time zone coalesce(nullif(repeating_lessons.timezone, ''), 'UTC'))::date
+	from repeating_lessons where repeating_lessons.id = actual_lessons.repeating_lesson_id;
+
+alter table actual_lessons drop column occurrence_start;
+
+alter table repeating_lesson_exceptions add column exception_date date;
+update repeating_lesson_exceptions set exception_date =
+	(repeating_lesson_exceptions.exception_time at time zone 'UTC'
+	at time zone coalesce(nullif(repeating_lessons.timezone, ''), 'UTC'))::date
+	from repeating_lessons where repeating_lessons.id = repeating_lesson_exceptions.repeating_lesson_id;
+
+-- Exceptions for occurrences on the same date become one
+delete from repeating_lesson_exceptions where exists (select 1 from repeating_lesson_exceptions earlier
+	where earlier.repeating_lesson_id = repeating_lesson_exceptions.repeating_lesson_id and
+	earlier.exception_date = repeating_lesson_exceptions.exception_date and
+	earlier.exception_time < repeating_lesson_exceptions.exception_time);
+
+alter table repeating_lesson_exceptions
+	alter column exception_date set not null,
+	drop column exception_time,
+	add unique (repeating_lesson_id, exception_date);
@@ -0,0 +1,22 @@
+-- Exceptions and, spawned lessons are matched on the start of their occurrence instead of its date,
+-- see model/lesson_editing.go. A series can have more than one occurrence on a date, for example an
+-- rdate on the same day as another occurrence. The starts are in UTC like the other timestamps.
+-- The existing dates become the series' start time on that date.
+alter table repeating_lesson_exceptions add column exception_time timestamp;
+update repeating_lesson_exceptions set exception_time =
+	(repeating_lesson_exceptions.exception_date + repeating_lessons.start_time)
+	at time zone coalesce(nullif(repeating_lessons.timezone, ''), 'UTC') at time zone 'UTC'
+	from repeating_lessons where repeating_lessons.id = repeating_lesson_exceptions.repeating_lesson_id;
+
+alter table repeating_lesson_exceptions
+	alter column exception_time set not null,
+	drop column exception_date,
+	add unique (repeating_lesson_id, exception_time);
+
+alter table actual_lessons add column occurrence_start timestamp;
+update actual_lessons set occurrence_start =
+	(actual_lessons.occurrence_date + repeating_lessons.start_time)
+	at time zone coalesce(nullif(repeating_lessons.timezone, ''), 'UTC') at time zone 'UTC'
+	from repeating_lessons where repeating_lessons.id = actual_lessons.repeating_lesson_id;
+
+alter table actual_lessons drop column occurrence_date;
@@ -0,0 +1,11 @@
+/*
+ * The schema, version 0 is the base schema and, the others change it.
+ * Files are named <version>_<name>.up.sql and, <version>_<name>.down.sql. See utils/migrate.go
+ */
+
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var Files embed.FS
@@ -0,0 +1,489 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+/*
+ * This file handles absence requests, students tell us in advance that they will miss lessons
+ * and, the request is approved or, rejected. Approved requests mark the lessons that they cover
+ * as authorised absences so that every attendance calculation counts them, see
+ * applyAbsenceRequests.
+ */
+
+// Absence request statuses
+const (
+	ABSENCE_SUBMITTED = "submitted"
+	ABSENCE_APPROVED  = "approved"
+	ABSENCE_REJECTED  = "rejected"
+)
+
+// The reason given when an approved request changes a mark, see corrections.go
+const ABSENCE_CORRECTION_REASON = "Absence request approved"
+
+var ErrAbsenceRequestNotFound = errors.New("Cannot find absence request with matching id")
+
+/*
+ * Checks an absence request, it must have a reason and, cover a time range or, some lessons.
+ */
+func validateAbsenceRequest(request *AbsenceRequest) error {
+	request.Reason = strings.TrimSpace(request.Reason)
+	if request.Reason == "" {
+		return errors.New("A reason must be given for the absence")
+	}
+
+	if request.StartTime.IsZero() != request.EndTime.IsZero() {
+		return errors.New("start-time and, end-time must both be set")
+	}
+
+	if !request.StartTime.IsZero() && !request.StartTime.Before(request.EndTime) {
+		return errors.New("start-time must be before end-time")
+	}
+
+	seen := make(map[string]bool)
+	lessonIds := make([]string, 0, len(request.LessonIds))
+	for _, lessonId := range request.LessonIds {
+		if _, err := uuid.Parse(lessonId); err != nil {
+			return errors.New("Invalid lesson id " + lessonId)
+		}
+
+		if !seen[lessonId] {
+			seen[lessonId] = true
+			lessonIds = append(lessonIds, lessonId)
+		}
+	}
+	request.LessonIds = lessonIds
+
+	if request.StartTime.IsZero() && len(request.LessonIds) == 0 {
+		return errors.New("The absence must cover a time range or, some lessons")
+	}
+
+	if request.Attachment != nil {
+		request.Attachment.Name = strings.TrimSpace(request.Attachment.Name)
+		if request.Attachment.Name == "" {
+			return errors.New("The attachment must have a name")
+		}
+
+		if request.Attachment.Size < 0 {
+			return errors.New("The attachment size cannot be negative")
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Submits an absence request for request.UserId, the lessons must be ones that they are in.
+ */
+func SubmitAbsenceRequest(ctx context.Context, request *AbsenceRequest, pool *utils.DatabasePool) error {
+	err := validateAbsenceRequest(request)
+	if err != nil {
+		return err
+	}
+
+	if len(request.LessonIds) != 0 {
+		var count int
+		err = pool.Database.QueryRowContext(ctx, "select count(distinct actual_lessons.id) from actual_lessons "+
+			"inner join group_lessons on group_lessons.id = actual_lessons.group_lesson_id "+
+			"inner join module_user_groups on module_user_groups.module_group_id = group_lessons.module_group_id "+
+			"inner join module_users on module_users.id = module_user_groups.module_user_id "+
+			"where module_users.user_id = $1 and actual_lessons.id = any($2);",
+			request.UserId, pq.Array(request.LessonIds)).Scan(&count)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return err
+		}
+
+		if count != len(request.LessonIds) {
+			return errors.New("The absence can only cover lessons that you are in")
+		}
+	}
+
+	request.Id = uuid.New().String()
+	request.Status = ABSENCE_SUBMITTED
+	request.CreationTime = time.Now()
+
+	var startTime, endTime interface{}
+	if !request.StartTime.IsZero() {
+		startTime = request.StartTime.UTC()
+		endTime = request.EndTime.UTC()
+	}
+
+	var attachment AbsenceAttachment
+	if request.Attachment != nil {
+		attachment = *request.Attachment
+	}
+
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, "insert into absence_requests (id, user_id, reason, start_time, end_time, "+
+		"attachment_name, attachment_type, attachment_size, attachment_url, status, creation_time) "+
+		"values ($1, $2, $3, $4, $5, nullif($6, ''), $7, $8, $9, $10, $11);",
+		request.Id, request.UserId, request.Reason, startTime, endTime,
+		attachment.Name, attachment.ContentType, attachment.Size, attachment.Url, request.Status, request.CreationTime)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	for _, lessonId := range request.LessonIds {
+		_, err = tx.ExecContext(ctx, "insert into absence_request_lessons (request_id, lesson_id) values ($1, $2);",
+			request.Id, lessonId)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return err
+		}
+	}
+
+	success = true
+	return nil
+}
+
+// Conditions for getAbsenceRequests
+const (
+	ABSENCE_REQUEST_BY_ID     = "absence_requests.id = $1"
+	ABSENCE_REQUEST_BY_USER   = "absence_requests.user_id = $1"
+	ABSENCE_REQUEST_BY_STATUS = "absence_requests.status = $1"
+)
+
+func getAbsenceRequests(ctx context.Context, cond string, arg string, pool *utils.DatabasePool) ([]AbsenceRequest, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select id, user_id, reason, start_time, end_time, "+
+		"array(select lesson_id::text from absence_request_lessons where request_id = absence_requests.id), "+
+		"coalesce(attachment_name, ''), coalesce(attachment_type, ''), coalesce(attachment_size, 0), coalesce(attachment_url, ''), "+
+		"status, coalesce(decided_by::text, ''), decision_reason, creation_time, decision_time "+
+		"from absence_requests where "+cond+" order by creation_time asc;", arg)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]AbsenceRequest, 0)
+	for rows.Next() {
+		var request AbsenceRequest
+		var attachment AbsenceAttachment
+		var startTime, endTime, decisionTime sql.NullTime
+		err = rows.Scan(&request.Id, &request.UserId, &request.Reason, &startTime, &endTime, pq.Array(&request.LessonIds),
+			&attachment.Name, &attachment.ContentType, &attachment.Size, &attachment.Url,
+			&request.Status, &request.DecidedBy, &request.DecisionReason, &request.CreationTime, &decisionTime)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		request.StartTime = startTime.Time
+		request.EndTime = endTime.Time
+		request.DecisionTime = decisionTime.Time
+		if attachment.Name != "" {
+			request.Attachment = &attachment
+		}
+
+		ret = append(ret, request)
+	}
+
+	return ret, nil
+}
+
+func GetAbsenceRequest(ctx context.Context, requestId string, pool *utils.DatabasePool) (AbsenceRequest, error) {
+	if _, err := uuid.Parse(requestId); err != nil {
+		return AbsenceRequest{}, ErrAbsenceRequestNotFound
+	}
+
+	requests, err := getAbsenceRequests(ctx, ABSENCE_REQUEST_BY_ID, requestId, pool)
+	if err != nil {
+		return AbsenceRequest{}, err
+	} else if len(requests) == 0 {
+		return AbsenceRequest{}, ErrAbsenceRequestNotFound
+	}
+
+	return requests[0], nil
+}
+
+/*
+ * Gets a user's absence requests, oldest first.
+ */
+func GetUserAbsenceRequests(ctx context.Context, userId string, pool *utils.DatabasePool) ([]AbsenceRequest, error) {
+	if _, err := uuid.Parse(userId); err != nil {
+		return make([]AbsenceRequest, 0), nil
+	}
+
+	return getAbsenceRequests(ctx, ABSENCE_REQUEST_BY_USER, userId, pool)
+}
+
+/*
+ * Gets the absence requests with a status, oldest first.
+ */
+func GetAbsenceRequestsByStatus(ctx context.Context, status string, pool *utils.DatabasePool) ([]AbsenceRequest, error) {
+	return getAbsenceRequests(ctx, ABSENCE_REQUEST_BY_STATUS, status, pool)
+}
+
+// Conditions for applyAbsenceRequests
+const (
+	ABSENCE_LESSONS_BY_REQUEST = "absence_requests.id = $1"
+	ABSENCE_LESSONS_UPCOMING   = "actual_lessons.end_time >= $1"
+)
+
+/*
+ * Marks the lessons covered by approved absence requests as authorised absences. Lessons that
+ * the student has not been marked for get a new mark and, unauthorised absences are amended.
+ * Marks that have been corrected before are left alone so that a lecturer's correction is not
+ * undone, see corrections.go.
+ *
+ * @return the marks that were made or, changed, the callers publish them in tx so that webhooks and,
+ *         register streams only get them once it commits, see publishAttendanceMarked
+ */
+func applyAbsenceRequests(ctx context.Context, tx *sql.Tx, cond string, arg interface{}) ([]AttendanceMarkedEvent, error) {
+	rows, err := tx.QueryContext(ctx, "select distinct on (absence_requests.user_id, actual_lessons.id) "+
+		"absence_requests.user_id, absence_requests.reason, "+
+		"coalesce(absence_requests.decided_by, absence_requests.user_id), actual_lessons.id, "+
+		"coalesce(attendance.id::text, ''), coalesce(attendance.status, ''), coalesce(attendance.reason, '') "+
+		"from absence_requests "+
+		"inner join actual_lessons on (actual_lessons.start_time < absence_requests.end_time and "+
+		"actual_lessons.end_time > absence_requests.start_time) or actual_lessons.id in "+
+		"(select lesson_id from absence_request_lessons where request_id = absence_requests.id) "+
+		"inner join group_lessons on group_lessons.id = actual_lessons.group_lesson_id "+
+		"inner join module_user_groups on module_user_groups.module_group_id = group_lessons.module_group_id "+
+		"inner join module_users on module_users.id = module_user_groups.module_user_id "+
+		"left join attendance on attendance.lesson_id = actual_lessons.id and attendance.user_id = absence_requests.user_id "+
+		"where absence_requests.status = 'approved' and module_users.user_id = absence_requests.user_id and "+
+		"not actual_lessons.cancelled and "+cond+" and not exists "+
+		"(select 1 from attendance_corrections where attendance_corrections.lesson_id = actual_lessons.id and "+
+		"attendance_corrections.user_id = absence_requests.user_id) "+
+		"order by absence_requests.user_id, actual_lessons.id;", arg)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+
+	// The rows are read first as the transaction cannot run other queries until they are closed
+	type absenceMark struct {
+		AttendanceId string
+		Reason       string
+		Actor        string
+		Old          LessonAttendance
+	}
+
+	marks := make([]absenceMark, 0)
+	for rows.Next() {
+		var mark absenceMark
+		err = rows.Scan(&mark.Old.UserId, &mark.Reason, &mark.Actor, &mark.Old.LessonId,
+			&mark.AttendanceId, &mark.Old.Status, &mark.Old.Reason)
+		if err != nil {
+			rows.Close()
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		marks = append(marks, mark)
+	}
+	rows.Close()
+
+	ret := make([]AttendanceMarkedEvent, 0)
+	now := time.Now()
+	for _, mark := range marks {
+		event := AttendanceMarkedEvent{LessonId: mark.Old.LessonId,
+			UserId:       mark.Old.UserId,
+			Status:       ATTENDANCE_AUTHORISED_ABSENCE,
+			Reason:       mark.Reason,
+			MarkedBy:     mark.Actor,
+			RegisterTime: now}
+
+		if mark.AttendanceId == "" {
+			// Students that check in meanwhile keep their mark, see attendance_lesson_user_idx
+			res, err := tx.ExecContext(ctx, "insert into attendance (id, lesson_id, user_id, register_time, status, reason, marked_by) "+
+				"values ($1, $2, $3, $4, $5, $6, $7) on conflict (lesson_id, user_id) do nothing;",
+				uuid.New().String(), mark.Old.LessonId, mark.Old.UserId, now, ATTENDANCE_AUTHORISED_ABSENCE, mark.Reason, mark.Actor)
+			if err != nil {
+				logging.FromContext(ctx).Error(err)
+				return nil, err
+			}
+
+			count, err := res.RowsAffected()
+			if err != nil {
+				logging.FromContext(ctx).Error(err)
+				return nil, err
+			}
+
+			if count != 0 {
+				ret = append(ret, event)
+			}
+			continue
+		}
+
+		if mark.Old.Status != ATTENDANCE_UNAUTHORISED_ABSENCE {
+			continue
+		}
+
+		correction, err := newCorrection(CORRECTION_AMEND, mark.Old, ATTENDANCE_AUTHORISED_ABSENCE, mark.Reason, mark.Actor, ABSENCE_CORRECTION_REASON)
+		if err != nil {
+			return nil, err
+		}
+		correction.AttendanceId = mark.AttendanceId
+
+		_, err = tx.ExecContext(ctx, "update attendance set status = $2, reason = $3, marked_by = $4 where id = $1;",
+			mark.AttendanceId, ATTENDANCE_AUTHORISED_ABSENCE, mark.Reason, mark.Actor)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		err = insertCorrection(ctx, tx, correction)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, event)
+	}
+
+	return ret, nil
+}
+
+/*
+ * Approves or, rejects a submitted absence request. Approving it marks the lessons that it
+ * covers as authorised absences, including lessons that have already happened.
+ *
+ * @param decidedBy the user deciding the request
+ * @return the decided request
+ */
+func DecideAbsenceRequest(ctx context.Context, requestId string, approve bool, decidedBy string, decisionReason string, pool *utils.DatabasePool) (AbsenceRequest, error) {
+	if _, err := uuid.Parse(requestId); err != nil {
+		return AbsenceRequest{}, ErrAbsenceRequestNotFound
+	}
+
+	err := decideAbsenceRequest(ctx, requestId, approve, decidedBy, decisionReason, pool)
+	if err != nil {
+		return AbsenceRequest{}, err
+	}
+
+	return GetAbsenceRequest(ctx, requestId, pool)
+}
+
+func decideAbsenceRequest(ctx context.Context, requestId string, approve bool, decidedBy string, decisionReason string, pool *utils.DatabasePool) error {
+	status := ABSENCE_REJECTED
+	if approve {
+		status = ABSENCE_APPROVED
+	}
+
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer func() {
+		if !success {
+			tx.Rollback()
+		}
+	}()
+
+	var current string
+	err = tx.QueryRowContext(ctx, "select status from absence_requests where id = $1 for update;", requestId).Scan(&current)
+	if err == sql.ErrNoRows {
+		return ErrAbsenceRequestNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	if current != ABSENCE_SUBMITTED {
+		return errors.New("The absence request has already been " + current)
+	}
+
+	_, err = tx.ExecContext(ctx, "update absence_requests set status = $2, decided_by = $3, decision_reason = $4, "+
+		"decision_time = $5 where id = $1;",
+		requestId, status, decidedBy, strings.TrimSpace(decisionReason), time.Now())
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	if approve {
+		events, err := applyAbsenceRequests(ctx, tx, ABSENCE_LESSONS_BY_REQUEST, requestId)
+		if err != nil {
+			return err
+		}
+
+		logging.FromContext(ctx).Infof("Approved absence request %s, %d lessons marked", requestId, len(events))
+		for _, event := range events {
+			err = publishAttendanceMarked(ctx, tx, event)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	success = true
+	err = tx.Commit()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Marks the upcoming lessons covered by approved absence requests, this picks up lessons that
+ * were created or, students that joined a module group after the request was approved. It is
+ * run by the lesson spawner, see repeating_lesson_daemon.go
+ *
+ * @return the number of marks that were made or, changed
+ */
+func ApplyAbsenceRequests(ctx context.Context, now time.Time, pool *utils.DatabasePool) (int, error) {
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return 0, err
+	}
+	defer func() {
+		if !success {
+			tx.Rollback()
+		}
+	}()
+
+	events, err := applyAbsenceRequests(ctx, tx, ABSENCE_LESSONS_UPCOMING, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	for _, event := range events {
+		err = publishAttendanceMarked(ctx, tx, event)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	success = true
+	err = tx.Commit()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return 0, err
+	}
+
+	return len(events), nil
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateAbsenceRequest(t *testing.T) {
+	start := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	lesson := "7a0d4a3e-5b1c-4a9e-8f7e-2d6c1b0a9f11"
+
+	request := AbsenceRequest{Reason: " Ill ", StartTime: start, EndTime: start.Add(48 * time.Hour),
+		LessonIds:  []string{lesson, lesson},
+		Attachment: &AbsenceAttachment{Name: " note.pdf ", Size: 1024}}
+	if err := validateAbsenceRequest(&request); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if request.Reason != "Ill" || len(request.LessonIds) != 1 || request.Attachment.Name != "note.pdf" {
+		t.Log("Expected the request to be normalised", request)
+		t.Fail()
+	}
+
+	if err := validateAbsenceRequest(&AbsenceRequest{Reason: "Placement", LessonIds: []string{lesson}}); err != nil {
+		t.Log("Expected requests for lessons without a time range to be valid", err)
+		t.Fail()
+	}
+
+	invalid := map[string]AbsenceRequest{
+		"no reason":           {Reason: " ", LessonIds: []string{lesson}},
+		"nothing covered":     {Reason: "Ill"},
+		"half a range":        {Reason: "Ill", StartTime: start},
+		"backwards range":     {Reason: "Ill", StartTime: start, EndTime: start.Add(-time.Hour)},
+		"invalid lesson":      {Reason: "Ill", LessonIds: []string{"lesson"}},
+		"unnamed attachment":  {Reason: "Ill", LessonIds: []string{lesson}, Attachment: &AbsenceAttachment{}},
+		"negative attachment": {Reason: "Ill", LessonIds: []string{lesson}, Attachment: &AbsenceAttachment{Name: "a", Size: -1}},
+	}
+
+	for name, request := range invalid {
+		request := request
+		if err := validateAbsenceRequest(&request); err == nil {
+			t.Log("Expected the request to be invalid:", name)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+ * The academic calendar is made of academic years which are split into terms. Teaching weeks
+ * run from Monday to Sunday and, are numbered through the year from each term's first week, so
+ * the weeks of a term that starts on a Wednesday start on that Wednesday. Closures are days that
+ * no lessons happen on, such as bank holidays, repeating lessons are not spawned or, shown on
+ * them.
+ */
+
+var ErrAcademicYearNotFound = errors.New("Cannot find academic year")
+var ErrTermNotFound = errors.New("Cannot find term")
+var ErrTeachingWeekNotFound = errors.New("Cannot find teaching week")
+var ErrClosureNotFound = errors.New("Cannot find closure with matching id")
+
+// The Monday at the start of the date's week
+func mondayOf(date time.Time) time.Time {
+	date = toDate(date)
+	return date.AddDate(0, 0, -weekdayOffset(date.Weekday(), time.Monday))
+}
+
+/*
+ * Gets the teaching weeks of a term, the first and, last weeks are cut to the term's dates.
+ */
+func (t Term) TeachingWeeks() []TeachingWeek {
+	ret := make([]TeachingWeek, 0)
+	end := toDate(t.EndDate)
+	for monday := mondayOf(t.StartDate); !monday.After(end); monday = monday.AddDate(0, 0, 7) {
+		week := TeachingWeek{Number: t.FirstWeek + len(ret),
+			TermId:    t.Id,
+			StartDate: monday,
+			EndDate:   monday.AddDate(0, 0, 6)}
+
+		if week.StartDate.Before(toDate(t.StartDate)) {
+			week.StartDate = toDate(t.StartDate)
+		}
+		if week.EndDate.After(end) {
+			week.EndDate = end
+		}
+
+		ret = append(ret, week)
+	}
+
+	return ret
+}
+
+/*
+ * Gets the teaching weeks of every term in the year in order.
+ */
+func (y AcademicYear) TeachingWeeks() []TeachingWeek {
+	ret := make([]TeachingWeek, 0)
+	for _, term := range y.Terms {
+		ret = append(ret, term.TeachingWeeks()...)
+	}
+
+	sort.SliceStable(ret, func(i int, j int) bool {
+		return ret[i].StartDate.Before(ret[j].StartDate)
+	})
+	return ret
+}
+
+/*
+ * Gets a teaching week by its number.
+ */
+func (y AcademicYear) TeachingWeek(number int) (TeachingWeek, error) {
+	for _, week := range y.TeachingWeeks() {
+		if week.Number == number {
+			return week, nil
+		}
+	}
+
+	return TeachingWeek{}, ErrTeachingWeekNotFound
+}
+
+/*
+ * Finds a term in the year by its id or, its name which is not case sensitive.
+ */
+func (y AcademicYear) FindTerm(term string) (Term, error) {
+	for _, t := range y.Terms {
+		if t.Id == term || strings.EqualFold(t.Name, term) {
+			return t, nil
+		}
+	}
+
+	return Term{}, ErrTermNotFound
+}
+
+/*
+ * Checks that a term fits in its year and, does not overlap the year's other terms.
+ */
+func validateTerm(year AcademicYear, term Term) error {
+	if strings.TrimSpace(term.Name) == "" {
+		return errors.New("The term name cannot be empty")
+	}
+
+	if toDate(term.StartDate).After(toDate(term.EndDate)) {
+		return errors.New("start-date must be earlier than end-date")
+	}
+
+	if toDate(term.StartDate).Before(toDate(year.StartDate)) || toDate(term.EndDate).After(toDate(year.EndDate)) {
+		return errors.New("The term must be inside of the academic year")
+	}
+
+	for _, other := range year.Terms {
+		if other.Id != term.Id && !toDate(term.StartDate).After(toDate(other.EndDate)) && !toDate(other.StartDate).After(toDate(term.EndDate)) {
+			return errors.New("The term overlaps " + other.Name)
+		}
+	}
+
+	return nil
+}
+
+// The first week of a new term carries on from the terms before it
+func nextFirstWeek(year AcademicYear, term Term) int {
+	ret := 1
+	for _, other := range year.Terms {
+		weeks := other.TeachingWeeks()
+		if other.StartDate.Before(term.StartDate) && len(weeks) != 0 && weeks[len(weeks)-1].Number >= ret {
+			ret = weeks[len(weeks)-1].Number + 1
+		}
+	}
+
+	return ret
+}
+
+/*
+ * Adds the closed days that a repeating lesson runs over to its closed dates, so that the
+ * spawner, timetables and, the iCal export skip every occurrence on them.
+ */
+func withClosures(lesson RepeatingLesson, closures []Closure) RepeatingLesson {
+	closed := append(make([]time.Time, 0, len(lesson.ClosedDates)), lesson.ClosedDates...)
+	for _, closure := range closures {
+		date := toDate(closure.StartDate)
+		if date.Before(toDate(lesson.StartRepeating)) {
+			date = toDate(lesson.StartRepeating)
+		}
+
+		for ; !date.After(toDate(closure.EndDate)) && !date.After(toDate(lesson.StopRepeating)); date = date.AddDate(0, 0, 1) {
+			closed = append(closed, date)
+		}
+	}
+
+	lesson.ClosedDates = closed
+	return lesson
+}
+
+func CreateAcademicYear(ctx context.Context, year *AcademicYear, pool *utils.DatabasePool) error {
+	if strings.TrimSpace(year.Name) == "" {
+		return errors.New("The academic year name cannot be empty")
+	}
+
+	if toDate(year.StartDate).After(toDate(year.EndDate)) {
+		return errors.New("start-date must be earlier than end-date")
+	}
+
+	year.Id = uuid.New().String()
+	year.StartDate = toDate(year.StartDate)
+	year.EndDate = toDate(year.EndDate)
+	year.CreationTime = time.Now()
+	year.EditTime = year.CreationTime
+	year.Terms = make([]Term, 0)
+
+	_, err := pool.Database.ExecContext(ctx, "insert into academic_years (id, name, start_date, end_date, creation_time, edit_time) "+
+		"values ($1, $2, $3, $4, $5, $6);",
+		year.Id, year.Name, year.StartDate, year.EndDate, year.CreationTime, year.EditTime)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return errors.New("Cannot create the academic year, the name must be unique")
+	}
+
+	return nil
+}
+
+func getTerms(ctx context.Context, yearId string, pool *utils.DatabasePool) ([]Term, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select id, academic_year_id, name, start_date, end_date, first_week, "+
+		"creation_time, edit_time from terms where academic_year_id = $1 order by start_date asc;", yearId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]Term, 0)
+	for rows.Next() {
+		var term Term
+		err = rows.Scan(&term.Id, &term.AcademicYearId, &term.Name, &term.StartDate, &term.EndDate, &term.FirstWeek,
+			&term.CreationTime, &term.EditTime)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret = append(ret, term)
+	}
+
+	return ret, nil
+}
+
+/*
+ * Gets every academic year with its terms, newest first.
+ */
+func GetAcademicYears(ctx context.Context, pool *utils.DatabasePool) ([]AcademicYear, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select id, name, start_date, end_date, creation_time, edit_time "+
+		"from academic_years order by start_date desc;")
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]AcademicYear, 0)
+	for rows.Next() {
+		var year AcademicYear
+		err = rows.Scan(&year.Id, &year.Name, &year.StartDate, &year.EndDate, &year.CreationTime, &year.EditTime)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret = append(ret, year)
+	}
+	rows.Close()
+
+	for i := range ret {
+		ret[i].Terms, err = getTerms(ctx, ret[i].Id, pool)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+func GetAcademicYear(ctx context.Context, yearId string, pool *utils.DatabasePool) (AcademicYear, error) {
+	var year AcademicYear
+	err := pool.Database.QueryRowContext(ctx, "select id, name, start_date, end_date, creation_time, edit_time "+
+		"from academic_years where id::text = $1 or name = $1;", yearId).Scan(&year.Id,
+		&year.Name,
+		&year.StartDate,
+		&year.EndDate,
+		&year.CreationTime,
+		&year.EditTime)
+	if err == sql.ErrNoRows {
+		return AcademicYear{}, ErrAcademicYearNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AcademicYear{}, err
+	}
+
+	year.Terms, err = getTerms(ctx, year.Id, pool)
+	if err != nil {
+		return AcademicYear{}, err
+	}
+
+	return year, nil
+}
+
+/*
+ * Gets the academic year that a date is in or, the last one to start before it when the date
+ * is between years.
+ */
+func GetCurrentAcademicYear(ctx context.Context, date time.Time, pool *utils.DatabasePool) (AcademicYear, error) {
+	var yearId string
+	err := pool.Database.QueryRowContext(ctx, "select id from academic_years where start_date <= $1 "+
+		"order by start_date desc limit 1;", toDate(date)).Scan(&yearId)
+	if err == sql.ErrNoRows {
+		return AcademicYear{}, ErrAcademicYearNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AcademicYear{}, err
+	}
+
+	return GetAcademicYear(ctx, yearId, pool)
+}
+
+/*
+ * Deletes an academic year and, its terms.
+ */
+func DeleteAcademicYear(ctx context.Context, yearId string, pool *utils.DatabasePool) error {
+	res, err := pool.Database.ExecContext(ctx, "delete from academic_years where id = $1;", yearId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	} else if count == 0 {
+		return ErrAcademicYearNotFound
+	}
+
+	return nil
+}
+
+/*
+ * Adds a term to an academic year. If the first week is not set then the term's weeks are
+ * numbered on from the terms before it.
+ */
+func CreateTerm(ctx context.Context, term *Term, pool *utils.DatabasePool) error {
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	// Lock the year so that terms added at the same time cannot overlap
+	var yearId string
+	err = tx.QueryRowContext(ctx, "select id from academic_years where id = $1 for update;",
+		term.AcademicYearId).Scan(&yearId)
+	if err == sql.ErrNoRows {
+		return ErrAcademicYearNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	year, err := GetAcademicYear(ctx, yearId, pool)
+	if err != nil {
+		return err
+	}
+
+	term.Id = uuid.New().String()
+	term.StartDate = toDate(term.StartDate)
+	term.EndDate = toDate(term.EndDate)
+	err = validateTerm(year, *term)
+	if err != nil {
+		return err
+	}
+
+	if term.FirstWeek <= 0 {
+		term.FirstWeek = nextFirstWeek(year, *term)
+	}
+	term.CreationTime = time.Now()
+	term.EditTime = term.CreationTime
+
+	_, err = tx.ExecContext(ctx, "insert into terms (id, academic_year_id, name, start_date, end_date, first_week, "+
+		"creation_time, edit_time) values ($1, $2, $3, $4, $5, $6, $7, $8);",
+		term.Id, term.AcademicYearId, term.Name, term.StartDate, term.EndDate, term.FirstWeek,
+		term.CreationTime, term.EditTime)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return errors.New("Cannot create the term, the name must be unique in the academic year")
+	}
+
+	success = true
+	return nil
+}
+
+func DeleteTerm(ctx context.Context, termId string, pool *utils.DatabasePool) error {
+	res, err := pool.Database.ExecContext(ctx, "delete from terms where id = $1;", termId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	} else if count == 0 {
+		return ErrTermNotFound
+	}
+
+	return nil
+}
+
+func CreateClosure(ctx context.Context, closure *Closure, pool *utils.DatabasePool) error {
+	if toDate(closure.StartDate).After(toDate(closure.EndDate)) {
+		return errors.New("start-date must be earlier than end-date")
+	}
+
+	closure.Id = uuid.New().String()
+	closure.StartDate = toDate(closure.StartDate)
+	closure.EndDate = toDate(closure.EndDate)
+	closure.CreationTime = time.Now()
+
+	_, err := pool.Database.ExecContext(ctx, "insert into closures (id, start_date, end_date, reason, creation_time) "+
+		"values ($1, $2, $3, $4, $5);",
+		closure.Id, closure.StartDate, closure.EndDate, closure.Reason, closure.CreationTime)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	logging.FromContext(ctx).Infof("Closed %s to %s - %s", closure.StartDate.Format("2006-01-02"), closure.EndDate.Format("2006-01-02"), closure.Reason)
+	return nil
+}
+
+/*
+ * Gets the closures that overlap a range of dates, both dates are inclusive and, a zero
+ * date is not bounded.
+ */
+func GetClosures(ctx context.Context, from time.Time, to time.Time, pool *utils.DatabasePool) ([]Closure, error) {
+	var fromParam, toParam sql.NullTime
+	if !from.IsZero() {
+		fromParam = sql.NullTime{Time: toDate(from), Valid: true}
+	}
+	if !to.IsZero() {
+		toParam = sql.NullTime{Time: toDate(to), Valid: true}
+	}
+
+	rows, err := pool.Database.QueryContext(ctx, "select id, start_date, end_date, reason, creation_time from closures "+
+		"where ($1::date is null or end_date >= $1) and ($2::date is null or start_date <= $2) "+
+		"order by start_date asc;", fromParam, toParam)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]Closure, 0)
+	for rows.Next() {
+		var closure Closure
+		err = rows.Scan(&closure.Id, &closure.StartDate, &closure.EndDate, &closure.Reason, &closure.CreationTime)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret = append(ret, closure)
+	}
+
+	return ret, nil
+}
+
+func DeleteClosure(ctx context.Context, closureId string, pool *utils.DatabasePool) error {
+	res, err := pool.Database.ExecContext(ctx, "delete from closures where id = $1;", closureId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	} else if count == 0 {
+		return ErrClosureNotFound
+	}
+
+	return nil
+}
+
+/*
+ * Gets the dates of a term or, a teaching week. The academic year is the current one if it
+ * is not set.
+ *
+ * @param yearId the academic year's id or, name (optional)
+ * @param term   the term's id or, name, empty for a week
+ * @param week   the teaching week's number, 0 for a term
+ * @param now    the current time
+ * @param pool   the database pool
+ * @return the first date and, the day after the last date
+ */
+func GetCalendarRange(ctx context.Context, yearId string, term string, week int, now time.Time, pool *utils.DatabasePool) (time.Time, time.Time, error) {
+	var year AcademicYear
+	var err error
+	if yearId == "" {
+		year, err = GetCurrentAcademicYear(ctx, now, pool)
+	} else {
+		year, err = GetAcademicYear(ctx, yearId, pool)
+	}
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	if term != "" {
+		t, err := year.FindTerm(term)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+
+		return toDate(t.StartDate), toDate(t.EndDate).Add(DAY), nil
+	}
+
+	w, err := year.TeachingWeek(week)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	return w.StartDate, w.EndDate.Add(DAY), nil
+}
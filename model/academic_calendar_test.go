@@ -0,0 +1,117 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func testDate(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func testAcademicYear() AcademicYear {
+	// Autumn starts on a Wednesday, Spring on a Monday
+	return AcademicYear{Id: uuid.New().String(),
+		Name:      "2022/23",
+		StartDate: testDate(2022, 9, 1),
+		EndDate:   testDate(2023, 8, 31),
+		Terms: []Term{{Id: uuid.New().String(),
+			Name:      "Autumn",
+			StartDate: testDate(2022, 9, 28),
+			EndDate:   testDate(2022, 12, 9),
+			FirstWeek: 1},
+			{Id: uuid.New().String(),
+				Name:      "Spring",
+				StartDate: testDate(2023, 1, 9),
+				EndDate:   testDate(2023, 3, 24),
+				FirstWeek: 12}}}
+}
+
+func TestTeachingWeeks(t *testing.T) {
+	year := testAcademicYear()
+	weeks := year.TeachingWeeks()
+	if len(weeks) != 22 {
+		t.Log("Expected 11 weeks in each term", weeks)
+		t.Fail()
+	}
+
+	// The first week is cut to the start of the term
+	week, err := year.TeachingWeek(1)
+	if err != nil || !week.StartDate.Equal(testDate(2022, 9, 28)) || !week.EndDate.Equal(testDate(2022, 10, 2)) {
+		t.Log("Expected week 1 to be Wednesday to Sunday", week, err)
+		t.Fail()
+	}
+
+	week, err = year.TeachingWeek(12)
+	if err != nil || !week.StartDate.Equal(testDate(2023, 1, 9)) || week.TermId != year.Terms[1].Id {
+		t.Log("Expected week 12 to start the spring term", week, err)
+		t.Fail()
+	}
+
+	if _, err = year.TeachingWeek(23); err != ErrTeachingWeekNotFound {
+		t.Log("Expected no week 23")
+		t.Fail()
+	}
+
+	if term, err := year.FindTerm("spring"); err != nil || term.Id != year.Terms[1].Id {
+		t.Log("Expected to find the term by its name")
+		t.Fail()
+	}
+}
+
+func TestValidateTerm(t *testing.T) {
+	year := testAcademicYear()
+	summer := Term{Name: "Summer", StartDate: testDate(2023, 4, 17), EndDate: testDate(2023, 6, 9)}
+	if err := validateTerm(year, summer); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	if week := nextFirstWeek(year, summer); week != 23 {
+		t.Logf("Expected the summer term to start in week 23 not %d", week)
+		t.Fail()
+	}
+
+	overlapping := Term{Name: "Overlapping", StartDate: testDate(2022, 12, 1), EndDate: testDate(2023, 1, 20)}
+	if err := validateTerm(year, overlapping); err == nil {
+		t.Log("Expected overlapping terms to be invalid")
+		t.Fail()
+	}
+
+	outside := Term{Name: "Outside", StartDate: testDate(2023, 8, 1), EndDate: testDate(2023, 9, 20)}
+	if err := validateTerm(year, outside); err == nil {
+		t.Log("Expected terms outside of the year to be invalid")
+		t.Fail()
+	}
+}
+
+func TestClosures(t *testing.T) {
+	closures := []Closure{{StartDate: testDate(2022, 12, 24), EndDate: testDate(2023, 1, 2), Reason: "Christmas"}}
+
+	// Every day at 10, Christmas Day is skipped
+	lesson := RepeatingLesson{StartRepeating: testDate(2022, 12, 20),
+		StopRepeating: testDate(2022, 12, 31),
+		StartTime:     time.Date(0, 1, 1, 10, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(0, 1, 1, 11, 0, 0, 0, time.UTC),
+		RepeatEvery:   DAY}
+	lesson = withClosures(lesson, closures)
+	if len(lesson.ClosedDates) != 8 {
+		t.Log("Expected the closed days in the series to be closed dates", lesson.ClosedDates)
+		t.Fail()
+	}
+
+	christmas := time.Date(2022, 12, 25, 10, 0, 0, 0, time.UTC)
+	if _, err := occurrenceAt(lesson, christmas); err != nil || !isRepeatingLessonException(lesson, christmas) {
+		t.Log("Expected the occurrence on Christmas Day to be an exception")
+		t.Fail()
+	}
+
+	// Every occurrence on a closed date is skipped
+	lesson.RDates = []time.Time{time.Date(2022, 12, 25, 14, 0, 0, 0, time.UTC)}
+	if _, err := occurrenceAt(lesson, lesson.RDates[0]); err != nil || !isRepeatingLessonException(lesson, lesson.RDates[0]) {
+		t.Log("Expected the extra occurrence on Christmas Day to be an exception")
+		t.Fail()
+	}
+}
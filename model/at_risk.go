@@ -0,0 +1,448 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+/*
+ * This file finds students who are at risk due to low attendance.
+ * A background job runs the rules over each student's attendance in each of their modules, the
+ * students that break a rule are stored in at_risk_students. An alert is raised when a student
+ * starts breaking a rule, they will not be alerted again for that rule until they have stopped
+ * breaking it.
+ */
+
+type AtRiskReason string
+
+const (
+	AT_RISK_LOW_ATTENDANCE       AtRiskReason = "low-attendance"
+	AT_RISK_CONSECUTIVE_ABSENCES AtRiskReason = "consecutive-absences"
+)
+
+var ErrAlertNotFound = errors.New("Cannot find the alert")
+
+type AtRiskRules struct {
+	Threshold           float64 // Percentage attendance that a student is at risk below
+	ConsecutiveAbsences int     // Lessons missed in a row that a student is at risk after, 0 disables this
+	MinimumSessions     int     // Lessons that a student must have had before the threshold applies
+}
+
+type AtRiskStudent struct {
+	User                User           `json:"user"`
+	ModuleId            string         `json:"module-id"`
+	Percentage          float64        `json:"percentage"`
+	ConsecutiveAbsences int            `json:"consecutive-absences"`
+	Reasons             []AtRiskReason `json:"reasons"`
+	Since               time.Time      `json:"since"`
+	UpdateTime          time.Time      `json:"update-time"`
+}
+
+type AttendanceAlert struct {
+	Id                  string       `json:"id"`
+	User                User         `json:"user"`
+	ModuleId            string       `json:"module-id"`
+	Reason              AtRiskReason `json:"reason"`
+	Percentage          float64      `json:"percentage"`
+	ConsecutiveAbsences int          `json:"consecutive-absences"`
+	CreationTime        time.Time    `json:"creation-time"`
+	Acknowledged        bool         `json:"acknowledged"`
+	AcknowledgedBy      string       `json:"acknowledged-by,omitempty"`
+	AcknowledgedTime    time.Time    `json:"acknowledged-time,omitempty"`
+}
+
+/*
+ * Returns the rules that a student's attendance in a module breaks.
+ *
+ * @param record      the student's attendance in the module
+ * @param consecutive the lessons the student has missed in a row
+ */
+func evaluateAtRisk(record AttendanceRecord, consecutive int, rules AtRiskRules) []AtRiskReason {
+	ret := make([]AtRiskReason, 0)
+	if record.TotalSessions > 0 && record.TotalSessions >= rules.MinimumSessions && record.Percentage() < rules.Threshold {
+		ret = append(ret, AT_RISK_LOW_ATTENDANCE)
+	}
+
+	if rules.ConsecutiveAbsences > 0 && consecutive >= rules.ConsecutiveAbsences {
+		ret = append(ret, AT_RISK_CONSECUTIVE_ABSENCES)
+	}
+
+	return ret
+}
+
+/*
+ * Counts the lessons missed in a row from marks for a student's lessons, newest first.
+ * Lessons without a mark are missed, excused lessons are skipped.
+ */
+func countConsecutiveAbsences(marks []sql.NullString) int {
+	ret := 0
+	for _, mark := range marks {
+		status := AttendanceStatus(mark.String)
+		if mark.Valid && status.IsExcused() {
+			continue
+		}
+
+		if mark.Valid && status.IsAttended() {
+			break
+		}
+
+		ret++
+	}
+
+	return ret
+}
+
+/*
+ * Gets the lessons missed in a row by a student in each of their modules. Each lesson has at most
+ * one mark for the student, see attendance_lesson_user_idx, and lessons that start at the same
+ * time are ordered by id so that the count is the same every time.
+ */
+func getConsecutiveAbsences(ctx context.Context, userId string, pool *utils.DatabasePool) (map[string]int, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select module_groups.module_id, attendance.status "+
+		"from actual_lessons "+
+		"inner join group_lessons on group_lessons.id = actual_lessons.group_lesson_id "+
+		"inner join module_groups on module_groups.id = group_lessons.module_group_id "+
+		"inner join module_user_groups on module_user_groups.module_group_id = module_groups.id "+
+		"inner join module_users on module_users.id = module_user_groups.module_user_id "+
+		"left join attendance on attendance.lesson_id = actual_lessons.id and attendance.user_id = module_users.user_id "+
+		"where module_users.user_id = $1 and "+
+		"actual_lessons.end_time <= CURRENT_TIMESTAMP and "+
+		"not actual_lessons.cancelled "+
+		"order by actual_lessons.start_time desc, actual_lessons.id;", userId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	marks := make(map[string][]sql.NullString)
+	for rows.Next() {
+		var moduleId string
+		var status sql.NullString
+		err = rows.Scan(&moduleId, &status)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		marks[moduleId] = append(marks[moduleId], status)
+	}
+
+	ret := make(map[string]int)
+	for moduleId, moduleMarks := range marks {
+		ret[moduleId] = countConsecutiveAbsences(moduleMarks)
+	}
+
+	return ret, nil
+}
+
+/*
+ * Runs the rules over a student's attendance and, updates whether they are at risk in each
+ * of their modules.
+ *
+ * @return the alerts that were raised
+ */
+func UpdateStudentAtRisk(ctx context.Context, userId string, rules AtRiskRules, pool *utils.DatabasePool) ([]AttendanceAlert, error) {
+	attendance, err := GetStudentAttendancePercentages(ctx, userId, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	consecutive, err := getConsecutiveAbsences(ctx, userId, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer func() {
+		if !success {
+			tx.Rollback()
+		}
+	}()
+
+	// The rules that were already broken
+	rows, err := tx.QueryContext(ctx, "select module_id, reasons from at_risk_students where user_id = $1;", userId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+
+	previous := make(map[string]map[AtRiskReason]bool)
+	for rows.Next() {
+		var moduleId string
+		var reasons []string
+		err = rows.Scan(&moduleId, pq.Array(&reasons))
+		if err != nil {
+			rows.Close()
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		previous[moduleId] = make(map[AtRiskReason]bool)
+		for _, reason := range reasons {
+			previous[moduleId][AtRiskReason(reason)] = true
+		}
+	}
+	rows.Close()
+
+	alerts := make([]AttendanceAlert, 0)
+	now := time.Now()
+	for moduleId, record := range attendance.ModuleAttendance {
+		reasons := evaluateAtRisk(record, consecutive[moduleId], rules)
+		if len(reasons) == 0 {
+			continue
+		}
+
+		for _, reason := range reasons {
+			if previous[moduleId][reason] {
+				continue
+			}
+
+			alert := AttendanceAlert{Id: uuid.New().String(),
+				User:                User{InternalId: userId},
+				ModuleId:            moduleId,
+				Reason:              reason,
+				Percentage:          record.Percentage(),
+				ConsecutiveAbsences: consecutive[moduleId],
+				CreationTime:        now}
+
+			_, err = tx.ExecContext(ctx, "insert into attendance_alerts "+
+				"(id, user_id, module_id, reason, percentage, consecutive_absences, creation_time) "+
+				"values ($1, $2, $3, $4, $5, $6, $7);",
+				alert.Id, userId, moduleId, alert.Reason, alert.Percentage, alert.ConsecutiveAbsences, now)
+			if err != nil {
+				logging.FromContext(ctx).Error(err)
+				return nil, err
+			}
+
+			err = queueWebhookEventTx(ctx, tx, EVENT_STUDENT_AT_RISK, alert)
+			if err != nil {
+				return nil, err
+			}
+
+			alerts = append(alerts, alert)
+		}
+
+		_, err = tx.ExecContext(ctx, "insert into at_risk_students "+
+			"(user_id, module_id, percentage, consecutive_absences, reasons, since, update_time) "+
+			"values ($1, $2, $3, $4, $5, $6, $6) "+
+			"on conflict (user_id, module_id) do update set percentage = excluded.percentage, "+
+			"consecutive_absences = excluded.consecutive_absences, reasons = excluded.reasons, "+
+			"update_time = excluded.update_time;",
+			userId, moduleId, record.Percentage(), consecutive[moduleId], pq.Array(reasons), now)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		delete(previous, moduleId)
+	}
+
+	// Students who are no longer at risk
+	for moduleId := range previous {
+		_, err = tx.ExecContext(ctx, "delete from at_risk_students where user_id = $1 and module_id = $2;", userId, moduleId)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+	}
+
+	success = true
+	err = tx.Commit()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+
+	return alerts, nil
+}
+
+/*
+ * Runs the rules over every student that is in a module.
+ *
+ * @return the number of alerts that were raised
+ */
+func CheckAtRiskStudents(ctx context.Context, rules AtRiskRules, pool *utils.DatabasePool) (int, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select distinct user_id from module_users;")
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return 0, err
+	}
+	defer rows.Close()
+
+	users := make([]string, 0)
+	for rows.Next() {
+		var userId string
+		err = rows.Scan(&userId)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return 0, err
+		}
+
+		users = append(users, userId)
+	}
+
+	ret := 0
+	for _, userId := range users {
+		if ctx.Err() != nil {
+			return ret, ctx.Err()
+		}
+
+		alerts, err := UpdateStudentAtRisk(ctx, userId, rules, pool)
+		if err != nil {
+			logging.FromContext(ctx).Errorf("Cannot check whether user %s is at risk - %s", userId, err)
+			continue
+		}
+
+		ret += len(alerts)
+	}
+
+	return ret, nil
+}
+
+/*
+ * Runs the at-risk checks until the context is done, only one replica runs them at a time.
+ * See utils/leader.go
+ */
+func StartAtRiskDaemon(ctx context.Context, rules AtRiskRules, period time.Duration, pool *utils.DatabasePool) {
+	logging.FromContext(ctx).Info("Started at-risk daemon")
+	utils.RunAsLeader(ctx, pool, utils.AT_RISK_LOCK, period, func(ctx context.Context) {
+		alerts, err := CheckAtRiskStudents(ctx, rules, pool)
+		if err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).Error(err)
+		} else if alerts != 0 {
+			logging.FromContext(ctx).Infof("Raised %d low attendance alerts", alerts)
+		}
+	})
+	logging.FromContext(ctx).Info("Stopped at-risk daemon")
+}
+
+/*
+ * Gets the students that are at risk in a module.
+ */
+func GetAtRiskStudents(ctx context.Context, moduleId string, pool *utils.DatabasePool) ([]AtRiskStudent, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select users.id, users.external_id, users.firstname, users.surname, users.email, "+
+		"at_risk_students.module_id, at_risk_students.percentage, at_risk_students.consecutive_absences, "+
+		"at_risk_students.reasons, at_risk_students.since, at_risk_students.update_time "+
+		"from at_risk_students "+
+		"inner join users on users.id = at_risk_students.user_id "+
+		"where at_risk_students.module_id = $1 "+
+		"order by at_risk_students.percentage asc;", moduleId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]AtRiskStudent, 0)
+	for rows.Next() {
+		var student AtRiskStudent
+		var reasons []string
+		err = rows.Scan(&student.User.InternalId, &student.User.ExternalId, &student.User.Fname, &student.User.Sname, &student.User.Email,
+			&student.ModuleId, &student.Percentage, &student.ConsecutiveAbsences,
+			pq.Array(&reasons), &student.Since, &student.UpdateTime)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		student.Reasons = make([]AtRiskReason, len(reasons))
+		for i, reason := range reasons {
+			student.Reasons[i] = AtRiskReason(reason)
+		}
+
+		ret = append(ret, student)
+	}
+
+	return ret, nil
+}
+
+const attendanceAlertColumns = "attendance_alerts.id, users.id, users.external_id, users.firstname, users.surname, users.email, " +
+	"attendance_alerts.module_id, attendance_alerts.reason, attendance_alerts.percentage, " +
+	"attendance_alerts.consecutive_absences, attendance_alerts.creation_time, attendance_alerts.acknowledged, " +
+	"attendance_alerts.acknowledged_by, attendance_alerts.acknowledged_time "
+
+func scanAttendanceAlert(row interface{ Scan(...interface{}) error }) (AttendanceAlert, error) {
+	var alert AttendanceAlert
+	var acknowledgedBy sql.NullString
+	var acknowledgedTime sql.NullTime
+	err := row.Scan(&alert.Id, &alert.User.InternalId, &alert.User.ExternalId, &alert.User.Fname, &alert.User.Sname, &alert.User.Email,
+		&alert.ModuleId, &alert.Reason, &alert.Percentage,
+		&alert.ConsecutiveAbsences, &alert.CreationTime, &alert.Acknowledged,
+		&acknowledgedBy, &acknowledgedTime)
+
+	alert.AcknowledgedBy = acknowledgedBy.String
+	alert.AcknowledgedTime = acknowledgedTime.Time
+	return alert, err
+}
+
+/*
+ * Gets the alerts for a module, newest first.
+ *
+ * @param onlyUnacknowledged whether to leave out alerts that have been acknowledged
+ */
+func GetAttendanceAlerts(ctx context.Context, moduleId string, onlyUnacknowledged bool, pool *utils.DatabasePool) ([]AttendanceAlert, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select "+attendanceAlertColumns+
+		"from attendance_alerts "+
+		"inner join users on users.id = attendance_alerts.user_id "+
+		"where attendance_alerts.module_id = $1 and (not $2 or not attendance_alerts.acknowledged) "+
+		"order by attendance_alerts.creation_time desc;", moduleId, onlyUnacknowledged)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]AttendanceAlert, 0)
+	for rows.Next() {
+		alert, err := scanAttendanceAlert(rows)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret = append(ret, alert)
+	}
+
+	return ret, nil
+}
+
+/*
+ * Acknowledges an alert in a module, acknowledging an alert twice keeps the first acknowledgement.
+ *
+ * @param userId the user that acknowledged the alert
+ */
+func AcknowledgeAttendanceAlert(ctx context.Context, alertId string, moduleId string, userId string, pool *utils.DatabasePool) (AttendanceAlert, error) {
+	_, err := pool.Database.ExecContext(ctx, "update attendance_alerts set acknowledged = true, acknowledged_by = $3, acknowledged_time = $4 "+
+		"where id = $1 and module_id = $2 and not acknowledged;", alertId, moduleId, userId, time.Now())
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AttendanceAlert{}, err
+	}
+
+	alert, err := scanAttendanceAlert(pool.Database.QueryRowContext(ctx, "select "+attendanceAlertColumns+
+		"from attendance_alerts "+
+		"inner join users on users.id = attendance_alerts.user_id "+
+		"where attendance_alerts.id = $1 and attendance_alerts.module_id = $2;", alertId, moduleId))
+	if err == sql.ErrNoRows {
+		return AttendanceAlert{}, ErrAlertNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AttendanceAlert{}, err
+	}
+
+	return alert, nil
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestEvaluateAtRisk(t *testing.T) {
+	rules := AtRiskRules{Threshold: 80, ConsecutiveAbsences: 3, MinimumSessions: 5}
+
+	// Too few sessions for the threshold
+	reasons := evaluateAtRisk(AttendanceRecord{MarkedSessions: 0, TotalSessions: 4}, 0, rules)
+	if len(reasons) != 0 {
+		t.Log("The threshold should not apply before the minimum sessions", reasons)
+		t.Fail()
+	}
+
+	reasons = evaluateAtRisk(AttendanceRecord{MarkedSessions: 7, TotalSessions: 10}, 3, rules)
+	if len(reasons) != 2 || reasons[0] != AT_RISK_LOW_ATTENDANCE || reasons[1] != AT_RISK_CONSECUTIVE_ABSENCES {
+		t.Log("Expected both rules to be broken", reasons)
+		t.Fail()
+	}
+
+	reasons = evaluateAtRisk(AttendanceRecord{MarkedSessions: 8, TotalSessions: 10}, 2, rules)
+	if len(reasons) != 0 {
+		t.Log("Expected no rules to be broken", reasons)
+		t.Fail()
+	}
+
+	// Disabled consecutive absences
+	rules.ConsecutiveAbsences = 0
+	reasons = evaluateAtRisk(AttendanceRecord{MarkedSessions: 10, TotalSessions: 10}, 10, rules)
+	if len(reasons) != 0 {
+		t.Log("Expected consecutive absences to be disabled", reasons)
+		t.Fail()
+	}
+}
+
+func TestCountConsecutiveAbsences(t *testing.T) {
+	mark := func(status AttendanceStatus) sql.NullString {
+		return sql.NullString{String: string(status), Valid: true}
+	}
+
+	// Newest first
+	marks := []sql.NullString{{},
+		mark(ATTENDANCE_EXCUSED),
+		mark(ATTENDANCE_UNAUTHORISED_ABSENCE),
+		{},
+		mark(ATTENDANCE_LATE),
+		{}}
+	if count := countConsecutiveAbsences(marks); count != 3 {
+		t.Logf("Expected 3 absences in a row but got %d", count)
+		t.Fail()
+	}
+
+	if count := countConsecutiveAbsences(nil); count != 0 {
+		t.Logf("Expected no absences but got %d", count)
+		t.Fail()
+	}
+}
+
+func TestAcknowledgeAlertNotFound(t *testing.T) {
+	_, err := AcknowledgeAttendanceAlert(context.Background(), uuid.New().String(), uuid.New().String(), uuid.New().String(), pool_at)
+	if err != ErrAlertNotFound {
+		t.Log("Expected alert not found but got", err)
+		t.Fail()
+	}
+}
+
+func TestGetAttendanceAlertsNoModule(t *testing.T) {
+	alerts, err := GetAttendanceAlerts(context.Background(), uuid.New().String(), false, pool_at)
+	if err != nil || len(alerts) != 0 {
+		t.Log("Expected no alerts", err)
+		t.Fail()
+	}
+}
@@ -1,36 +1,170 @@
 package model
 
 import (
+	"arcio/attendance-system/config"
+	"arcio/attendance-system/logging"
 	"arcio/attendance-system/utils"
 	"context"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
-	"log"
 	"time"
 )
 
+type AttendanceStatus string
+
+// Attendance statuses
+const (
+	ATTENDANCE_PRESENT              AttendanceStatus = "present"
+	ATTENDANCE_LATE                 AttendanceStatus = "late"
+	ATTENDANCE_EXCUSED              AttendanceStatus = "excused"
+	ATTENDANCE_AUTHORISED_ABSENCE   AttendanceStatus = "authorised-absence"
+	ATTENDANCE_UNAUTHORISED_ABSENCE AttendanceStatus = "unauthorised-absence"
+)
+
+// The order that status counts are selected in, see attendanceStatusCounts
+var ATTENDANCE_STATUSES = []AttendanceStatus{ATTENDANCE_PRESENT,
+	ATTENDANCE_LATE,
+	ATTENDANCE_EXCUSED,
+	ATTENDANCE_AUTHORISED_ABSENCE,
+	ATTENDANCE_UNAUTHORISED_ABSENCE}
+
+// Marks registered this long after the start of a lesson are stored as late, set in main.go
+var LateGracePeriod time.Duration = config.DEFAULT_LATE_GRACE_PERIOD
+
+func (s AttendanceStatus) IsValid() bool {
+	for _, status := range ATTENDANCE_STATUSES {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the user attended the lesson
+func (s AttendanceStatus) IsAttended() bool {
+	return s == ATTENDANCE_PRESENT || s == ATTENDANCE_LATE
+}
+
+// Excused sessions are not counted in the total sessions for a user
+func (s AttendanceStatus) IsExcused() bool {
+	return s == ATTENDANCE_EXCUSED || s == ATTENDANCE_AUTHORISED_ABSENCE
+}
+
+/*
+ * Returns the status for a mark made at registerTime for a lesson that started at startTime.
+ */
+func GetMarkStatus(startTime time.Time, registerTime time.Time) AttendanceStatus {
+	if registerTime.After(startTime.Add(LateGracePeriod)) {
+		return ATTENDANCE_LATE
+	}
+	return ATTENDANCE_PRESENT
+}
+
+/*
+ * Returns the select columns that count attendance marks by status in the order of
+ * ATTENDANCE_STATUSES.
+ */
+func attendanceStatusCounts() string {
+	ret := ""
+	for i, status := range ATTENDANCE_STATUSES {
+		if i != 0 {
+			ret += ", "
+		}
+		ret += fmt.Sprintf("COUNT(attendance.id) FILTER (WHERE attendance.status = '%s')", status)
+	}
+	return ret
+}
+
+/*
+ * Creates an attendance record from the total lessons and, the status counts in the order of
+ * ATTENDANCE_STATUSES. Excused sessions are removed from the total sessions.
+ */
+func newAttendanceRecord(totalSessions int, counts []int) AttendanceRecord {
+	ret := AttendanceRecord{TotalSessions: totalSessions,
+		Breakdown: make(map[AttendanceStatus]int)}
+
+	for i, status := range ATTENDANCE_STATUSES {
+		count := 0
+		if i < len(counts) {
+			count = counts[i]
+		}
+
+		ret.Breakdown[status] = count
+		if status.IsAttended() {
+			ret.MarkedSessions += count
+		}
+		if status.IsExcused() {
+			ret.TotalSessions -= count
+		}
+	}
+
+	if ret.TotalSessions < 0 {
+		ret.TotalSessions = 0
+	}
+
+	return ret
+}
+
+/*
+ * Returns pointers to scan status counts into.
+ */
+func statusCountDest(counts []int) []interface{} {
+	ret := make([]interface{}, len(counts))
+	for i := range counts {
+		ret[i] = &counts[i]
+	}
+	return ret
+}
+
 /**
- * Registers a user as attending a lesson if they are part of it.
+ * Registers a user as attending a lesson if they are part of it, the mark is stored as late
+ * if it is after the grace period.
  *
  * @param UserId the user id
  * @param Pool   the database pool
  * @return error the error message to send to the user,
  *               could be the user is not in the lesson
  */
-func RegisterAttendance(UserId string, LessonId string, Pool *utils.DatabasePool) error {
+func RegisterAttendance(ctx context.Context, UserId string, LessonId string, Pool *utils.DatabasePool) error {
+	return RegisterAttendanceWithStatus(ctx, UserId, LessonId, "", "", Pool)
+}
+
+/**
+ * Registers a user as attending a lesson if they are part of it with a status.
+ *
+ * @param UserId the user id
+ * @param Status the status of the mark, if blank the status is present or, late
+ * @param Reason an optional reason for the status
+ * @param Pool   the database pool
+ * @return error the error message to send to the user,
+ *               could be the user is not in the lesson
+ */
+func RegisterAttendanceWithStatus(ctx context.Context, UserId string, LessonId string, Status AttendanceStatus, Reason string, Pool *utils.DatabasePool) error {
+	return RegisterAttendanceBy(ctx, UserId, LessonId, Status, Reason, UserId, Pool)
+}
+
+/**
+ * Registers a user as attending a lesson if they are part of it with a status, for when the
+ * mark is made by someone else, i.e: a lecturer.
+ *
+ * @param MarkedBy the user making the mark
+ * @see RegisterAttendanceWithStatus
+ */
+func RegisterAttendanceBy(ctx context.Context, UserId string, LessonId string, Status AttendanceStatus, Reason string, MarkedBy string, Pool *utils.DatabasePool) error {
+	if Status != "" && !Status.IsValid() {
+		return errors.New("Invalid attendance status")
+	}
+
 	// Create transaction
 	success := false
-	ctx := context.Background()
 	tx, err := Pool.Database.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer func() {
-		if success {
-			tx.Commit()
-		} else {
+		if !success {
 			tx.Rollback()
 		}
 	}()
@@ -41,7 +175,7 @@ func RegisterAttendance(UserId string, LessonId string, Pool *utils.DatabasePool
 	RecordTime := time.Now()
 
 	id := uuid.New().String()
-	stmt, err := Pool.Database.PrepareContext(ctx, "select 1 "+
+	stmt, err := tx.PrepareContext(ctx, "select actual_lessons.start_time "+
 		"from actual_lessons, group_lessons, module_groups, module_user_groups, "+
 		"module_users, users "+
 		"where actual_lessons.group_lesson_id = group_lessons.id and "+
@@ -52,45 +186,82 @@ func RegisterAttendance(UserId string, LessonId string, Pool *utils.DatabasePool
 		"users.id = $1 and "+
 		"actual_lessons.end_time >= CURRENT_TIMESTAMP and "+
 		"actual_lessons.start_time <= CURRENT_TIMESTAMP and "+
+		"not actual_lessons.cancelled and "+
 		"actual_lessons.id = $2;")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(UserId, LessonId)
+	rows, err := stmt.QueryContext(ctx, UserId, LessonId)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer rows.Close()
 
 	if !rows.Next() {
-		log.Println("Lesson not found")
+		logging.FromContext(ctx).Warn("Lesson not found")
 		return errors.New("Lesson not found")
 	}
 
-	stmt, err = Pool.Database.PrepareContext(ctx, "insert into attendance (id, lesson_id, user_id, register_time) values ($1, $2, $3, $4);")
-	_, err = stmt.Exec(id, LessonId, UserId, RecordTime)
+	var startTime time.Time
+	err = rows.Scan(&startTime)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
+	// The insert uses the same connection
+	rows.Close()
 
-	log.Printf("Registered attendance for user %s lesson %s successfully\n", UserId, LessonId)
+	if Status == "" {
+		Status = GetMarkStatus(startTime, RecordTime)
+	}
+
+	// A student has one mark for each lesson, marking them again changes it
+	stmt, err = tx.PrepareContext(ctx, "insert into attendance (id, lesson_id, user_id, register_time, status, reason, marked_by) values ($1, $2, $3, $4, $5, $6, $7) "+
+		"on conflict (lesson_id, user_id) do update set register_time = excluded.register_time, status = excluded.status, "+
+		"reason = excluded.reason, marked_by = excluded.marked_by;")
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, id, LessonId, UserId, RecordTime, Status, Reason, MarkedBy)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	err = publishAttendanceMarked(ctx, tx, AttendanceMarkedEvent{LessonId: LessonId,
+		UserId:       UserId,
+		Status:       Status,
+		Reason:       Reason,
+		MarkedBy:     MarkedBy,
+		RegisterTime: RecordTime})
+	if err != nil {
+		return err
+	}
 
 	success = true
+	err = tx.Commit()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	logging.FromContext(ctx).Infof("Registered attendance (%s) for user %s lesson %s successfully", Status, UserId, LessonId)
 	return nil
 }
 
-func GetStudentAttendancePercentages(UserId string, Pool *utils.DatabasePool) (AttendanceRet, error) {
+func GetStudentAttendancePercentages(ctx context.Context, UserId string, Pool *utils.DatabasePool) (AttendanceRet, error) {
 	// Create transaction
 	success := false
-	ctx := context.Background()
 	tx, err := Pool.Database.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 	}
 	defer func() {
 		if success {
@@ -101,10 +272,11 @@ func GetStudentAttendancePercentages(UserId string, Pool *utils.DatabasePool) (A
 	}()
 
 	// Get lesson count
-	stmt, err := Pool.Database.PrepareContext(ctx, "select count(actual_lessons.id), module_groups.module_id "+
+	stmt, err := tx.PrepareContext(ctx, "select count(actual_lessons.id), module_groups.module_id "+
 		"from actual_lessons, group_lessons, module_groups, module_user_groups, module_users "+
 		"where actual_lessons.group_lesson_id = group_lessons.id and "+
 		"actual_lessons.end_time <= CURRENT_TIMESTAMP and "+
+		"not actual_lessons.cancelled and "+
 		"group_lessons.module_group_id = module_groups.id and "+
 		"module_user_groups.module_group_id = module_groups.id and "+
 		"module_user_groups.module_user_id = module_users.id and "+
@@ -112,37 +284,38 @@ func GetStudentAttendancePercentages(UserId string, Pool *utils.DatabasePool) (A
 		"group by module_groups.module_id;")
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return AttendanceRet{}, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(UserId)
+	rows, err := stmt.QueryContext(ctx, UserId)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return AttendanceRet{}, err
 	}
 	defer rows.Close()
 
-	ret := make(map[string]AttendanceRecord)
+	totals := make(map[string]int)
 	for rows.Next() {
-		var rcrd AttendanceRecord
+		var total int
 		var module_id string
-		err = rows.Scan(&rcrd.TotalSessions, &module_id)
+		err = rows.Scan(&total, &module_id)
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(ctx).Error(err)
 			return AttendanceRet{}, err
 		}
 
-		ret[module_id] = rcrd
+		totals[module_id] = total
 	}
 
 	// Get attendance mark count
-	stmt, err = Pool.Database.PrepareContext(ctx, "select count(attendance.id), module_groups.module_id "+
+	stmt, err = tx.PrepareContext(ctx, "select "+attendanceStatusCounts()+", module_groups.module_id "+
 		"from attendance, actual_lessons, group_lessons, module_groups, module_user_groups, module_users "+
 		"where attendance.lesson_id = actual_lessons.id and "+
 		"attendance.user_id = $1 and "+
 		"actual_lessons.end_time <= CURRENT_TIMESTAMP and "+
+		"not actual_lessons.cancelled and "+
 		"actual_lessons.group_lesson_id = group_lessons.id and "+
 		"group_lessons.module_group_id = module_groups.id and "+
 		"module_user_groups.module_group_id = module_groups.id and "+
@@ -150,48 +323,51 @@ func GetStudentAttendancePercentages(UserId string, Pool *utils.DatabasePool) (A
 		"module_users.user_id = $1 "+
 		"group by module_groups.module_id;")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return AttendanceRet{}, err
 	}
 	defer stmt.Close()
 
-	rows, err = stmt.Query(UserId)
+	rows, err = stmt.QueryContext(ctx, UserId)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return AttendanceRet{}, err
 	}
 	defer rows.Close()
 
+	counts := make(map[string][]int)
 	for rows.Next() {
-		var count int
+		count := make([]int, len(ATTENDANCE_STATUSES))
 		var module_id string
-		err = rows.Scan(&count, &module_id)
+		err = rows.Scan(append(statusCountDest(count), &module_id)...)
 
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(ctx).Error(err)
 			return AttendanceRet{}, err
 		}
 
-		rcrd, found := ret[module_id]
+		_, found := totals[module_id]
 		if !found {
 			return AttendanceRet{}, errors.New(fmt.Sprintf("Cannot find module %s for user %s", module_id, UserId))
-		} else {
-			rcrd.MarkedSessions = count
-			ret[module_id] = rcrd
 		}
+		counts[module_id] = count
+	}
+
+	ret := make(map[string]AttendanceRecord)
+	for module_id, total := range totals {
+		ret[module_id] = newAttendanceRecord(total, counts[module_id])
 	}
 
 	success = true
 	return AttendanceRet{ModuleAttendance: ret}, nil
 }
 
-func GetStudentModuleAttendancePercentages(UserId string, ModuleId string, Pool *utils.DatabasePool) (AttendanceRecord, error) {
+func GetStudentModuleAttendancePercentages(ctx context.Context, UserId string, ModuleId string, Pool *utils.DatabasePool) (AttendanceRecord, error) {
 	// Create transaction
 	success := false
-	ctx := context.Background()
 	tx, err := Pool.Database.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 	}
 	defer func() {
 		if success {
@@ -201,13 +377,14 @@ func GetStudentModuleAttendancePercentages(UserId string, ModuleId string, Pool
 		}
 	}()
 
-	var ret AttendanceRecord
+	var total int
 
 	// Get lesson count
-	stmt, err := Pool.Database.PrepareContext(ctx, "select count(actual_lessons.id) "+
+	stmt, err := tx.PrepareContext(ctx, "select count(actual_lessons.id) "+
 		"from actual_lessons, group_lessons, module_groups, module_user_groups, module_users "+
 		"where actual_lessons.group_lesson_id = group_lessons.id and "+
 		"actual_lessons.end_time <= CURRENT_TIMESTAMP and "+
+		"not actual_lessons.cancelled and "+
 		"group_lessons.module_group_id = module_groups.id and "+
 		"module_user_groups.module_group_id = module_groups.id and "+
 		"module_user_groups.module_user_id = module_users.id and "+
@@ -215,35 +392,38 @@ func GetStudentModuleAttendancePercentages(UserId string, ModuleId string, Pool
 		"module_groups.module_id = $2;")
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return AttendanceRecord{}, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(UserId, ModuleId)
+	rows, err := stmt.QueryContext(ctx, UserId, ModuleId)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return AttendanceRecord{}, err
 	}
 	defer rows.Close()
 
 	if rows.Next() {
-		err = rows.Scan(&ret.TotalSessions)
+		err = rows.Scan(&total)
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(ctx).Error(err)
 			return AttendanceRecord{}, err
 		}
 	} else {
-		log.Println("No marks found")
+		logging.FromContext(ctx).Debug("No marks found")
 		return AttendanceRecord{}, errors.New("No marks found")
 	}
+	// The next query uses the same connection
+	rows.Close()
 
 	// Get attendance mark count
-	stmt, err = Pool.Database.PrepareContext(ctx, "select count(attendance.id) "+
+	stmt, err = tx.PrepareContext(ctx, "select "+attendanceStatusCounts()+" "+
 		"from attendance, actual_lessons, group_lessons, module_groups, module_user_groups, module_users "+
 		"where attendance.lesson_id = actual_lessons.id and "+
 		"attendance.user_id = $1 and "+
 		"actual_lessons.end_time <= CURRENT_TIMESTAMP and "+
+		"not actual_lessons.cancelled and "+
 		"actual_lessons.group_lesson_id = group_lessons.id and "+
 		"group_lessons.module_group_id = module_groups.id and "+
 		"module_user_groups.module_group_id = module_groups.id and "+
@@ -251,31 +431,32 @@ func GetStudentModuleAttendancePercentages(UserId string, ModuleId string, Pool
 		"module_users.user_id = $1 and "+
 		"module_groups.module_id = $2;")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return AttendanceRecord{}, err
 	}
 	defer stmt.Close()
 
-	rows, err = stmt.Query(UserId, ModuleId)
+	rows, err = stmt.QueryContext(ctx, UserId, ModuleId)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return AttendanceRecord{}, err
 	}
 	defer rows.Close()
 
+	counts := make([]int, len(ATTENDANCE_STATUSES))
 	if rows.Next() {
-		err = rows.Scan(&ret.MarkedSessions)
+		err = rows.Scan(statusCountDest(counts)...)
 
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(ctx).Error(err)
 			return AttendanceRecord{}, err
 		}
 
 	} else {
-		log.Println("No marks found")
+		logging.FromContext(ctx).Debug("No marks found")
 		return AttendanceRecord{}, errors.New("No marks found")
 	}
 
 	success = true
-	return ret, nil
+	return newAttendanceRecord(total, counts), nil
 }
@@ -3,9 +3,11 @@ package model
 import (
 	"arcio/attendance-system/config"
 	"arcio/attendance-system/utils"
+	"context"
 	"github.com/google/uuid"
 	"log"
 	"testing"
+	"time"
 )
 
 var conf_at config.Config
@@ -32,7 +34,7 @@ func TestUserLessonDoesNotExist(t *testing.T) {
 	userid := uuid.New().String()
 	lessonid := uuid.New().String()
 
-	err := RegisterAttendance(userid, lessonid, pool_at)
+	err := RegisterAttendance(context.Background(), userid, lessonid, pool_at)
 	if err == nil {
 		t.Log("Expected error when marking attendance for fake users")
 		t.Log(err)
@@ -43,7 +45,7 @@ func TestUserLessonDoesNotExist(t *testing.T) {
 func TestAttendancePercentageUserNotThere(t *testing.T) {
 	userid := uuid.New().String()
 
-	_, err := GetStudentAttendancePercentages(userid, pool_at)
+	_, err := GetStudentAttendancePercentages(context.Background(), userid, pool_at)
 	if err != nil {
 		t.Fail()
 	}
@@ -70,7 +72,7 @@ func TestAttendancePercentageForStudents(t *testing.T) {
 			return
 		}
 
-		ret, err := GetStudentAttendancePercentages(id, pool_at)
+		ret, err := GetStudentAttendancePercentages(context.Background(), id, pool_at)
 		if err != nil {
 			t.Log(err)
 			t.Log("FAILED to get attendance %")
@@ -132,7 +134,7 @@ func TestAttendancePercentageForStudentModules(t *testing.T) {
 			return
 		}
 
-		ret, err := GetStudentModuleAttendancePercentages(id, mid, pool_at)
+		ret, err := GetStudentModuleAttendancePercentages(context.Background(), id, mid, pool_at)
 		TotalSessions := ret.TotalSessions
 		MarkedSessions := ret.MarkedSessions
 
@@ -150,3 +152,57 @@ func TestAttendancePercentageForStudentModules(t *testing.T) {
 		net += MarkedSessions
 	}
 }
+
+func TestAttendanceStatusIsValid(t *testing.T) {
+	for _, status := range ATTENDANCE_STATUSES {
+		if !status.IsValid() {
+			t.Logf("%s should be valid", status)
+			t.Fail()
+		}
+	}
+
+	if AttendanceStatus("on holiday").IsValid() || AttendanceStatus("").IsValid() {
+		t.Log("Invalid statuses were valid")
+		t.Fail()
+	}
+}
+
+func TestGetMarkStatus(t *testing.T) {
+	start := time.Now()
+
+	if GetMarkStatus(start, start.Add(LateGracePeriod/2)) != ATTENDANCE_PRESENT {
+		t.Log("A mark inside the grace period should be present")
+		t.Fail()
+	}
+
+	if GetMarkStatus(start, start.Add(LateGracePeriod+time.Second)) != ATTENDANCE_LATE {
+		t.Log("A mark after the grace period should be late")
+		t.Fail()
+	}
+}
+
+func TestNewAttendanceRecord(t *testing.T) {
+	// present, late, excused, authorised absence, unauthorised absence
+	rcrd := newAttendanceRecord(10, []int{3, 2, 1, 1, 1})
+
+	if rcrd.MarkedSessions != 5 {
+		t.Logf("Expected 5 marked sessions got %d", rcrd.MarkedSessions)
+		t.Fail()
+	}
+
+	if rcrd.TotalSessions != 8 {
+		t.Logf("Excused sessions should not be in the total, expected 8 got %d", rcrd.TotalSessions)
+		t.Fail()
+	}
+
+	if rcrd.Breakdown[ATTENDANCE_LATE] != 2 || rcrd.Breakdown[ATTENDANCE_UNAUTHORISED_ABSENCE] != 1 {
+		t.Log("The breakdown is wrong", rcrd.Breakdown)
+		t.Fail()
+	}
+
+	empty := newAttendanceRecord(4, nil)
+	if empty.MarkedSessions != 0 || empty.TotalSessions != 4 || len(empty.Breakdown) != len(ATTENDANCE_STATUSES) {
+		t.Log("A user with no marks has the wrong record", empty)
+		t.Fail()
+	}
+}
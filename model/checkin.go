@@ -0,0 +1,252 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/security"
+	"arcio/attendance-system/utils"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var ErrCheckinSessionOpen = errors.New("A check-in session is already open for this lesson")
+var ErrCheckinSessionNotFound = errors.New("Check-in session not found or, already closed")
+
+/*
+ * Opens a check-in session for a lesson, a session lasts until the lesson ends or,
+ * it is closed by a lecturer. Only one session can be open for a lesson, the
+ * checkin_sessions_open_idx index refuses a second one with ErrCheckinSessionOpen.
+ *
+ * @param LessonId the actual lesson to open the session for
+ * @param ModuleId the module the lesson is in
+ * @param UserId   the user opening the session
+ * @param Period   how often the code rotates
+ * @param Pool     the database pool
+ * @return the new session
+ */
+func OpenCheckinSession(ctx context.Context, LessonId string, ModuleId string, UserId string, Period time.Duration, Pool *utils.DatabasePool) (CheckinSession, error) {
+	if Period < security.MIN_CHECKIN_CODE_PERIOD {
+		return CheckinSession{}, errors.New("The code period is too short")
+	}
+
+	// Create transaction
+	success := false
+	tx, err := Pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return CheckinSession{}, err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	// Check the lesson is in the module and, has not ended
+	stmt, err := tx.PrepareContext(ctx, "select actual_lessons.end_time from actual_lessons "+
+		"inner join group_lessons on group_lessons.id = actual_lessons.group_lesson_id "+
+		"inner join module_groups on module_groups.id = group_lessons.module_group_id "+
+		"where actual_lessons.id = $1 and module_groups.module_id = $2 and "+
+		"actual_lessons.end_time >= CURRENT_TIMESTAMP and not actual_lessons.cancelled;")
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return CheckinSession{}, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, LessonId, ModuleId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return CheckinSession{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return CheckinSession{}, errors.New("Lesson not found in the module, cancelled or, has ended")
+	}
+
+	var endTime time.Time
+	err = rows.Scan(&endTime)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return CheckinSession{}, err
+	}
+	// The insert uses the same connection
+	rows.Close()
+
+	// Sessions that have expired are closed so they do not count as open
+	_, err = tx.ExecContext(ctx, "update checkin_sessions set closed_time = expiry_time "+
+		"where lesson_id = $1 and closed_time is null and expiry_time <= CURRENT_TIMESTAMP;", LessonId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return CheckinSession{}, err
+	}
+
+	session := CheckinSession{Id: uuid.New().String(),
+		LessonId:   LessonId,
+		OpenedBy:   UserId,
+		CodePeriod: Period,
+		OpenedTime: time.Now(),
+		ExpiryTime: endTime}
+
+	stmt, err = tx.PrepareContext(ctx, "insert into checkin_sessions "+
+		"(id, lesson_id, opened_by, code_period, opened_time, expiry_time) "+
+		"values ($1, $2, $3, $4, $5, $6);")
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return CheckinSession{}, err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, session.Id, session.LessonId, session.OpenedBy, int(session.CodePeriod/time.Second), session.OpenedTime, session.ExpiryTime)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+		return CheckinSession{}, ErrCheckinSessionOpen
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return CheckinSession{}, err
+	}
+
+	logging.FromContext(ctx).Infof("Opened check-in session %s for lesson %s", session.Id, LessonId)
+
+	success = true
+	return session, nil
+}
+
+/*
+ * Gets the session that is currently open for a lesson in a module, an error is returned if
+ * there is no open session. Lessons in other modules are not found.
+ */
+func GetOpenCheckinSession(ctx context.Context, LessonId string, ModuleId string, Pool *utils.DatabasePool) (CheckinSession, error) {
+	_, err := GetLessonModuleGroup(ctx, LessonId, ModuleId, Pool)
+	if err != nil {
+		return CheckinSession{}, err
+	}
+
+	return getOpenCheckinSession(ctx, LessonId, Pool)
+}
+
+// Gets the session that is currently open for a lesson in any module
+func getOpenCheckinSession(ctx context.Context, LessonId string, Pool *utils.DatabasePool) (CheckinSession, error) {
+	stmt, err := Pool.Database.PrepareContext(ctx, "select id, lesson_id, opened_by, code_period, opened_time, expiry_time "+
+		"from checkin_sessions "+
+		"where lesson_id = $1 and closed_time is null and "+
+		"opened_time <= CURRENT_TIMESTAMP and "+
+		"expiry_time > CURRENT_TIMESTAMP "+
+		"order by opened_time desc limit 1;")
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return CheckinSession{}, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, LessonId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return CheckinSession{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return CheckinSession{}, errors.New("No check-in session is open for this lesson")
+	}
+
+	var session CheckinSession
+	var period int
+	err = rows.Scan(&session.Id, &session.LessonId, &session.OpenedBy, &period, &session.OpenedTime, &session.ExpiryTime)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return CheckinSession{}, err
+	}
+	session.CodePeriod = time.Duration(period) * time.Second
+
+	return session, nil
+}
+
+/*
+ * Closes a check-in session early, codes from the session are no longer accepted.
+ *
+ * @param SessionId the session to close
+ * @param LessonId  the lesson the session is for
+ * @param ModuleId  the module the lesson is in
+ * @param Pool      the database pool
+ */
+func CloseCheckinSession(ctx context.Context, SessionId string, LessonId string, ModuleId string, Pool *utils.DatabasePool) error {
+	stmt, err := Pool.Database.PrepareContext(ctx, "update checkin_sessions "+
+		"set expiry_time = CURRENT_TIMESTAMP, closed_time = CURRENT_TIMESTAMP "+
+		"where id = $1 and lesson_id = $2 and closed_time is null and expiry_time > CURRENT_TIMESTAMP and "+
+		"lesson_id in (select actual_lessons.id from actual_lessons "+
+		"inner join group_lessons on group_lessons.id = actual_lessons.group_lesson_id "+
+		"inner join module_groups on module_groups.id = group_lessons.module_group_id "+
+		"where module_groups.module_id = $3);")
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, SessionId, LessonId, ModuleId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	if count == 0 {
+		return ErrCheckinSessionNotFound
+	}
+
+	logging.FromContext(ctx).Infof("Closed check-in session %s", SessionId)
+	return nil
+}
+
+/*
+ * Gets the code that is currently valid for a session.
+ */
+func GetCheckinCode(session CheckinSession, Secret []byte) CheckinCodeRet {
+	now := time.Now()
+	step := security.CheckinCodeStep(now, session.CodePeriod)
+	validUntil := security.CheckinCodeExpiry(now, session.CodePeriod)
+	if validUntil.After(session.ExpiryTime) {
+		validUntil = session.ExpiryTime
+	}
+
+	return CheckinCodeRet{SessionId: session.Id,
+		Code:       security.GenerateCheckinCode(Secret, session.Id, step),
+		ValidUntil: validUntil}
+}
+
+/*
+ * Registers a user as attending a lesson if the code they entered is valid for the
+ * lesson's open check-in session.
+ *
+ * @param UserId   the user id
+ * @param LessonId the lesson id
+ * @param Code     the code the user entered
+ * @param Secret   the server check-in secret
+ * @param Pool     the database pool
+ * @return error the error message to send to the user
+ */
+func RegisterAttendanceWithCode(ctx context.Context, UserId string, LessonId string, Code string, Secret []byte, Pool *utils.DatabasePool) error {
+	session, err := getOpenCheckinSession(ctx, LessonId, Pool)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	if !security.CheckCheckinCode(Secret, session.Id, session.CodePeriod, Code, time.Now()) {
+		logging.FromContext(ctx).Warnf("Invalid check-in code for user %s lesson %s", UserId, LessonId)
+		return errors.New("The check-in code is invalid or, has expired")
+	}
+
+	return RegisterAttendance(ctx, UserId, LessonId, Pool)
+}
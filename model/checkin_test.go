@@ -0,0 +1,68 @@
+package model
+
+import (
+	"arcio/attendance-system/security"
+	"context"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+var checkinTestSecret = []byte("check-in test secret")
+
+func TestOpenCheckinSessionShortPeriod(t *testing.T) {
+	_, err := OpenCheckinSession(context.Background(), uuid.New().String(), uuid.New().String(), uuid.New().String(), time.Second, pool_at)
+	if err == nil {
+		t.Log("A session with a too short code period was opened")
+		t.Fail()
+	}
+}
+
+func TestOpenCheckinSessionNoLesson(t *testing.T) {
+	_, err := OpenCheckinSession(context.Background(), uuid.New().String(), uuid.New().String(), uuid.New().String(), 30*time.Second, pool_at)
+	if err == nil {
+		t.Log("A session was opened for a lesson that does not exist")
+		t.Fail()
+	}
+}
+
+func TestRegisterAttendanceWithCodeNoSession(t *testing.T) {
+	err := RegisterAttendanceWithCode(context.Background(), uuid.New().String(), uuid.New().String(), "000000", checkinTestSecret, pool_at)
+	if err == nil {
+		t.Log("Attendance was registered without a check-in session")
+		t.Fail()
+	}
+}
+
+func TestCloseCheckinSessionNotFound(t *testing.T) {
+	err := CloseCheckinSession(context.Background(), uuid.New().String(), uuid.New().String(), uuid.New().String(), pool_at)
+	if err != ErrCheckinSessionNotFound {
+		t.Log("Expected ErrCheckinSessionNotFound but got", err)
+		t.Fail()
+	}
+}
+
+func TestGetCheckinCode(t *testing.T) {
+	session := CheckinSession{Id: uuid.New().String(),
+		LessonId:   uuid.New().String(),
+		CodePeriod: 30 * time.Second,
+		OpenedTime: time.Now(),
+		ExpiryTime: time.Now().Add(time.Second)}
+
+	code := GetCheckinCode(session, checkinTestSecret)
+	if code.SessionId != session.Id {
+		t.Log("Wrong session id")
+		t.Fail()
+	}
+
+	if !security.CheckCheckinCode(checkinTestSecret, session.Id, session.CodePeriod, code.Code, time.Now()) {
+		t.Log("The generated code is not valid")
+		t.Fail()
+	}
+
+	// The code cannot outlive the session
+	if code.ValidUntil.After(session.ExpiryTime) {
+		t.Log("The code is valid after the session expires")
+		t.Fail()
+	}
+}
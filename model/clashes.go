@@ -0,0 +1,434 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/security"
+	"arcio/attendance-system/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+/*
+ * This file checks that lessons can be booked before they are created or, moved. A lesson
+ * cannot be booked into a room that is already in use, a room that is too small for the
+ * module group or, at a time that one of the module group's lecturers is teaching.
+ */
+
+// Conflict types
+const (
+	CONFLICT_ROOM_DOUBLE_BOOKED = "room-double-booked"
+	CONFLICT_ROOM_OVER_CAPACITY = "room-over-capacity"
+	CONFLICT_LECTURER_CLASH     = "lecturer-clash"
+)
+
+// The most conflicts that are returned for a booking
+const CONFLICT_LIMIT = 50
+
+// Members of a module group that have this permission at the module or, module group layer are lecturers
+const LECTURER_PERMS = security.PERMS_UPDATE_SELF
+
+const CONFLICT_TIME_FORMAT = "2006-01-02 15:04"
+
+// Returned when a lesson clashes with other bookings
+type ClashError struct {
+	Conflicts []Conflict
+}
+
+func (e *ClashError) Error() string {
+	if len(e.Conflicts) == 1 {
+		return e.Conflicts[0].Message
+	}
+
+	return fmt.Sprintf("The lesson has %d conflicts", len(e.Conflicts))
+}
+
+// Conditions used to select a schedule, these take a single id ($1)
+const (
+	LESSON_IN_ROOM           = "coalesce(actual_lessons.room_id, group_lessons.room_id) = $1"
+	REPEATING_LESSON_IN_ROOM = "group_lessons.room_id = $1"
+	GROUP_LESSON_BY_USER     = "group_lessons.module_group_id in (select module_user_groups.module_group_id from module_user_groups " +
+		"inner join module_users on module_users.id = module_user_groups.module_user_id where module_users.user_id = $1)"
+)
+
+/*
+ * A lesson that is being created or, moved. Existing lessons that are being replaced by the
+ * booking, i.e: the lesson being moved, are skipped with Ignore.
+ */
+type lessonBooking struct {
+	GroupLessonId string
+	RoomId        string // Overrides the group lesson's room when set
+	Occurrences   []Occurrence
+	Ignore        func(lesson ActualLesson) bool
+}
+
+// The occurrences of a single lesson
+func lessonOccurrences(lesson ActualLesson) []Occurrence {
+	return []Occurrence{{Start: lesson.StartTime, End: lesson.EndTime}}
+}
+
+/*
+ * Calls fn with each existing lesson that overlaps a proposed occurrence until it returns
+ * false. Lessons that end when another starts do not overlap.
+ *
+ * @param proposed the occurrences being booked, sorted by start time
+ * @param existing the lessons already booked, sorted by start time
+ */
+func findOverlaps(proposed []Occurrence, existing []ActualLesson, fn func(occurrence Occurrence, lesson ActualLesson) bool) {
+	var longest time.Duration
+	for _, lesson := range existing {
+		if d := lesson.EndTime.Sub(lesson.StartTime); d > longest {
+			longest = d
+		}
+	}
+
+	first := 0
+	for _, occurrence := range proposed {
+		// Lessons that start this early have ended before the occurrence
+		for first < len(existing) && !existing[first].StartTime.Add(longest).After(occurrence.Start) {
+			first++
+		}
+
+		for i := first; i < len(existing) && existing[i].StartTime.Before(occurrence.End); i++ {
+			if existing[i].EndTime.After(occurrence.Start) && !fn(occurrence, existing[i]) {
+				return
+			}
+		}
+	}
+}
+
+/*
+ * Gets the lessons that overlap [from, to) including the occurrences of repeating lessons
+ * that have not been spawned yet, cancelled lessons are skipped. The lessons are sorted by
+ * start time.
+ *
+ * @param lessonCondition          the condition on actual_lessons and, group_lessons
+ * @param repeatingLessonCondition the condition on repeating_lessons and, group_lessons
+ * @param id                       the id passed to the conditions
+ */
+func getSchedule(ctx context.Context, lessonCondition string, repeatingLessonCondition string, id string, from time.Time, to time.Time, pool *utils.DatabasePool) ([]ActualLesson, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select actual_lessons.id, actual_lessons.group_lesson_id, "+
+		"actual_lessons.start_time, actual_lessons.end_time, actual_lessons.summary, "+
+		"coalesce(actual_lessons.repeating_lesson_id::text, ''), actual_lessons.occurrence_start, "+
+		"coalesce(coalesce(actual_lessons.room_id, group_lessons.room_id)::text, '') "+
+		"from actual_lessons "+
+		"inner join group_lessons on group_lessons.id = actual_lessons.group_lesson_id "+
+		"where "+lessonCondition+" and not actual_lessons.cancelled and "+
+		"actual_lessons.start_time < $3 and actual_lessons.end_time > $2;",
+		id, from.UTC(), to.UTC())
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]ActualLesson, 0)
+	for rows.Next() {
+		var lesson ActualLesson
+		var occurrenceStart sql.NullTime
+		err = rows.Scan(&lesson.Id, &lesson.GroupLessonId, &lesson.StartTime, &lesson.EndTime, &lesson.Summary,
+			&lesson.RepeatingLessonId, &occurrenceStart, &lesson.RoomId)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		lesson.OccurrenceStart = occurrenceStart.Time
+		ret = append(ret, lesson)
+	}
+	rows.Close()
+
+	rows, err = pool.Database.QueryContext(ctx, "select repeating_lessons.id, repeating_lessons.group_lesson_id, "+
+		"repeating_lessons.start_repeating, repeating_lessons.stop_repeating, "+
+		"repeating_lessons.start_time, repeating_lessons.end_time, "+
+		"(EXTRACT(epoch FROM repeating_lessons.repeat_every) * 1000000000)::BIGINT, "+
+		"repeating_lessons.last_spawned_time, "+REPEATING_LESSON_RECURRENCE_COLUMNS+", "+
+		"group_lessons.summary, coalesce(group_lessons.room_id::text, '') "+
+		"from repeating_lessons "+
+		"inner join group_lessons on group_lessons.id = repeating_lessons.group_lesson_id "+
+		"where "+repeatingLessonCondition+" and "+
+		"repeating_lessons.stop_repeating >= $2 and repeating_lessons.start_repeating <= $3;",
+		id, toDate(from).Add(-DAY), toDate(to))
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	type series struct {
+		lesson  RepeatingLesson
+		summary string
+		roomId  string
+	}
+
+	repeatingLessons := make([]series, 0)
+	for rows.Next() {
+		var s series
+		var lastSpawnedTime sql.NullTime
+		var rrule, rdate, timezone string
+		err = rows.Scan(&s.lesson.Id,
+			&s.lesson.GroupLessonId,
+			&s.lesson.StartRepeating,
+			&s.lesson.StopRepeating,
+			&s.lesson.StartTime,
+			&s.lesson.EndTime,
+			&s.lesson.RepeatEvery,
+			&lastSpawnedTime,
+			&rrule,
+			&rdate,
+			&timezone,
+			&s.summary,
+			&s.roomId)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		s.lesson.LastSpawnedTime = lastSpawnedTime.Time
+		err = setRecurrenceColumns(&s.lesson, rrule, rdate, timezone)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		repeatingLessons = append(repeatingLessons, s)
+	}
+	rows.Close()
+
+	closures, err := GetClosures(ctx, from, to, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range repeatingLessons {
+		s.lesson.Exceptions, err = GetRepeatingLessonExceptions(ctx, s.lesson.Id, pool)
+		if err != nil {
+			return nil, err
+		}
+		s.lesson = withClosures(s.lesson, closures)
+
+		// Series from before lessons repeated at most daily are skipped
+		recurrence, err := lessonRecurrence(s.lesson)
+		if err != nil {
+			logging.FromContext(ctx).Errorf("Repeating lesson %s has an invalid rule - %s", s.lesson.Id, err)
+			continue
+		}
+
+		// Spawned lessons are accounted in the actual lessons query
+		for _, occurrence := range recurrence.Between(from.Add(-DAY), to) {
+			if occurrence.Exception || !occurrence.End.After(from) || !occurrence.Start.After(s.lesson.LastSpawnedTime) {
+				continue
+			}
+
+			lesson := occurrenceLesson(s.lesson, occurrence)
+			lesson.Summary = s.summary
+			lesson.RoomId = s.roomId
+			ret = append(ret, lesson)
+		}
+	}
+
+	sort.SliceStable(ret, func(i int, j int) bool {
+		return ret[i].StartTime.Before(ret[j].StartTime)
+	})
+
+	return ret, nil
+}
+
+/*
+ * Gets the members of a module group that are lecturers, see LECTURER_PERMS.
+ */
+func getModuleGroupLecturers(ctx context.Context, moduleGroupId string, pool *utils.DatabasePool) ([]string, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select distinct module_users.user_id from module_user_groups "+
+		"inner join module_users on module_users.id = module_user_groups.module_user_id "+
+		"where module_user_groups.module_group_id = $1 and "+
+		"(exists (select 1 from module_user_group_roles "+
+		"inner join roles on roles.id = module_user_group_roles.role_id "+
+		"where module_user_group_roles.module_user_group_id = module_user_groups.id and roles.overrides::bigint & $2 <> 0) or "+
+		"exists (select 1 from module_user_roles "+
+		"inner join roles on roles.id = module_user_roles.role_id "+
+		"where module_user_roles.module_user_id = module_users.id and roles.overrides::bigint & $2 <> 0));",
+		moduleGroupId, int64(LECTURER_PERMS))
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]string, 0)
+	for rows.Next() {
+		var userId string
+		err = rows.Scan(&userId)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret = append(ret, userId)
+	}
+
+	return ret, nil
+}
+
+// Describes when a clashing lesson is
+func conflictTime(lesson ActualLesson) string {
+	loc, err := time.LoadLocation(DefaultTimezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	return fmt.Sprintf("%s from %s to %s", lesson.Summary,
+		lesson.StartTime.In(loc).Format(CONFLICT_TIME_FORMAT), lesson.EndTime.In(loc).Format("15:04"))
+}
+
+/*
+ * Checks that a lesson can be booked, occurrences that have already ended are not checked.
+ * A *ClashError is returned with the conflicts if it cannot be booked.
+ */
+func checkLessonBooking(ctx context.Context, booking lessonBooking, now time.Time, pool *utils.DatabasePool) error {
+	if booking.RoomId != "" {
+		if _, err := GetRoom(ctx, booking.RoomId, pool); err != nil {
+			return err
+		}
+	}
+
+	occurrences := make([]Occurrence, 0, len(booking.Occurrences))
+	for _, occurrence := range booking.Occurrences {
+		if !occurrence.Exception && occurrence.End.After(now) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+	if len(occurrences) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(occurrences, func(i int, j int) bool {
+		return occurrences[i].Start.Before(occurrences[j].Start)
+	})
+	from := occurrences[0].Start
+	to := occurrences[0].End
+	for _, occurrence := range occurrences {
+		if occurrence.End.After(to) {
+			to = occurrence.End
+		}
+	}
+
+	var moduleGroupId, roomId string
+	err := pool.Database.QueryRowContext(ctx, "select module_group_id, coalesce(room_id::text, '') from group_lessons where id = $1;",
+		booking.GroupLessonId).Scan(&moduleGroupId, &roomId)
+	if err == sql.ErrNoRows {
+		return errors.New("Cannot find group lesson with matching id")
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	if booking.RoomId != "" {
+		roomId = booking.RoomId
+	}
+
+	conflicts := make([]Conflict, 0)
+	ignore := func(lesson ActualLesson) bool {
+		return booking.Ignore != nil && booking.Ignore(lesson)
+	}
+
+	if roomId != "" {
+		room, err := GetRoom(ctx, roomId, pool)
+		if err != nil {
+			return err
+		}
+
+		var members int
+		err = pool.Database.QueryRowContext(ctx, "select count(*) from module_user_groups where module_group_id = $1;",
+			moduleGroupId).Scan(&members)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return err
+		}
+
+		if members > room.Capacity {
+			conflicts = append(conflicts, Conflict{Type: CONFLICT_ROOM_OVER_CAPACITY,
+				Message:  fmt.Sprintf("%s holds %d people but the module group has %d members", room.Name, room.Capacity, members),
+				RoomId:   room.Id,
+				Capacity: room.Capacity,
+				Members:  members})
+		}
+
+		schedule, err := getSchedule(ctx, LESSON_IN_ROOM, REPEATING_LESSON_IN_ROOM, room.Id, from, to, pool)
+		if err != nil {
+			return err
+		}
+
+		findOverlaps(occurrences, schedule, func(occurrence Occurrence, lesson ActualLesson) bool {
+			if !ignore(lesson) {
+				conflicts = append(conflicts, Conflict{Type: CONFLICT_ROOM_DOUBLE_BOOKED,
+					Message:   fmt.Sprintf("%s is already booked for %s", room.Name, conflictTime(lesson)),
+					RoomId:    room.Id,
+					LessonId:  lesson.Id,
+					StartTime: lesson.StartTime,
+					EndTime:   lesson.EndTime})
+			}
+			return len(conflicts) < CONFLICT_LIMIT
+		})
+	}
+
+	lecturers, err := getModuleGroupLecturers(ctx, moduleGroupId, pool)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(lecturers) && len(conflicts) < CONFLICT_LIMIT; i++ {
+		schedule, err := getSchedule(ctx, GROUP_LESSON_BY_USER, GROUP_LESSON_BY_USER, lecturers[i], from, to, pool)
+		if err != nil {
+			return err
+		}
+
+		findOverlaps(occurrences, schedule, func(occurrence Occurrence, lesson ActualLesson) bool {
+			if !ignore(lesson) {
+				conflicts = append(conflicts, Conflict{Type: CONFLICT_LECTURER_CLASH,
+					Message:   "A lecturer is already teaching " + conflictTime(lesson),
+					UserId:    lecturers[i],
+					LessonId:  lesson.Id,
+					StartTime: lesson.StartTime,
+					EndTime:   lesson.EndTime})
+			}
+			return len(conflicts) < CONFLICT_LIMIT
+		})
+	}
+
+	if len(conflicts) != 0 {
+		return &ClashError{Conflicts: conflicts}
+	}
+
+	return nil
+}
+
+/*
+ * Checks that the occurrences of a repeating lesson on or, after a date can be booked, see
+ * checkLessonBooking. Closed days are skipped.
+ */
+func checkRepeatingLessonBooking(ctx context.Context, lesson RepeatingLesson, from time.Time, ignore func(lesson ActualLesson) bool, pool *utils.DatabasePool) error {
+	closures, err := GetClosures(ctx, from, time.Time{}, pool)
+	if err != nil {
+		return err
+	}
+
+	recurrence, err := lessonRecurrence(withClosures(lesson, closures))
+	if err != nil {
+		return err
+	}
+
+	occurrences := make([]Occurrence, 0)
+	recurrence.Each(func(occurrence Occurrence) bool {
+		if !toDate(occurrence.Start).Before(toDate(from)) {
+			occurrences = append(occurrences, occurrence)
+		}
+		return true
+	})
+
+	return checkLessonBooking(ctx, lessonBooking{GroupLessonId: lesson.GroupLessonId,
+		Occurrences: occurrences,
+		Ignore:      ignore}, time.Now(), pool)
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func testLesson(id string, start time.Time, length time.Duration) ActualLesson {
+	return ActualLesson{Id: id, StartTime: start, EndTime: start.Add(length)}
+}
+
+func TestFindOverlaps(t *testing.T) {
+	day := time.Date(2022, 10, 3, 0, 0, 0, 0, time.UTC)
+	existing := []ActualLesson{testLesson("all-day", day.Add(8*time.Hour), 8*time.Hour),
+		testLesson("nine", day.Add(9*time.Hour), time.Hour),
+		testLesson("ten", day.Add(10*time.Hour), time.Hour),
+		testLesson("next-day", day.Add(DAY+10*time.Hour), time.Hour)}
+
+	// 10:00 to 11:00 each day, lessons that end when it starts do not overlap
+	proposed := []Occurrence{{Start: day.Add(10 * time.Hour), End: day.Add(11 * time.Hour)},
+		{Start: day.Add(DAY + 10*time.Hour), End: day.Add(DAY + 11*time.Hour)},
+		{Start: day.Add(2*DAY + 10*time.Hour), End: day.Add(2*DAY + 11*time.Hour)}}
+
+	found := make([]string, 0)
+	findOverlaps(proposed, existing, func(occurrence Occurrence, lesson ActualLesson) bool {
+		found = append(found, lesson.Id)
+		return true
+	})
+
+	expected := []string{"all-day", "ten", "next-day"}
+	if len(found) != len(expected) {
+		t.Log("Expected", expected, "not", found)
+		t.FailNow()
+	}
+
+	for i := range expected {
+		if found[i] != expected[i] {
+			t.Log("Expected", expected, "not", found)
+			t.Fail()
+		}
+	}
+
+	// Stops when fn returns false
+	count := 0
+	findOverlaps(proposed, existing, func(occurrence Occurrence, lesson ActualLesson) bool {
+		count++
+		return false
+	})
+
+	if count != 1 {
+		t.Logf("Expected to stop after the first overlap not %d", count)
+		t.Fail()
+	}
+}
+
+func TestClashErrorMessage(t *testing.T) {
+	err := &ClashError{Conflicts: []Conflict{{Type: CONFLICT_ROOM_OVER_CAPACITY, Message: "Too small"}}}
+	if err.Error() != "Too small" {
+		t.Log("Expected a single conflict's message", err.Error())
+		t.Fail()
+	}
+
+	err.Conflicts = append(err.Conflicts, Conflict{Type: CONFLICT_LECTURER_CLASH})
+	if err.Error() != "The lesson has 2 conflicts" {
+		t.Log("Expected the number of conflicts", err.Error())
+		t.Fail()
+	}
+}
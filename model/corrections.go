@@ -0,0 +1,312 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+ * This file amends and, retracts attendance marks. Every change is stored as an
+ * AttendanceCorrection, the attendance_corrections table refuses updates and, deletes so the
+ * history cannot be rewritten.
+ */
+
+// Correction actions
+const (
+	CORRECTION_AMEND   = "amend"   // The status or, reason of a mark was changed
+	CORRECTION_RETRACT = "retract" // The mark was removed
+)
+
+// The reason given for changes made through the register, see setRegisterMarks
+const REGISTER_CORRECTION_REASON = "Changed on the register"
+
+var ErrMarkNotFound = errors.New("Cannot find an attendance mark for the user in the lesson")
+var ErrPastMark = errors.New("The lesson has ended, changing its marks needs PERMS_ATTENDANCE_ALLOW_PAST_MARK")
+
+/*
+ * Creates a correction for a change to a mark, newStatus is blank for retractions. A reason
+ * must be given and, amendments must change the mark.
+ */
+func newCorrection(action string, old LessonAttendance, newStatus AttendanceStatus, newReason string, actor string, reason string) (AttendanceCorrection, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return AttendanceCorrection{}, errors.New("A reason must be given for changing a mark")
+	}
+
+	switch action {
+	case CORRECTION_AMEND:
+		if !newStatus.IsValid() {
+			return AttendanceCorrection{}, errors.New("Invalid attendance status")
+		}
+
+		if newStatus == old.Status && newReason == old.Reason {
+			return AttendanceCorrection{}, errors.New("The mark has not changed")
+		}
+	case CORRECTION_RETRACT:
+		newStatus = ""
+		newReason = ""
+	default:
+		return AttendanceCorrection{}, fmt.Errorf("Invalid correction action %s", action)
+	}
+
+	return AttendanceCorrection{Id: uuid.New().String(),
+		LessonId:     old.LessonId,
+		UserId:       old.UserId,
+		Action:       action,
+		OldStatus:    old.Status,
+		NewStatus:    newStatus,
+		OldReason:    old.Reason,
+		NewReason:    newReason,
+		Actor:        actor,
+		Reason:       reason,
+		CreationTime: time.Now()}, nil
+}
+
+func insertCorrection(ctx context.Context, tx *sql.Tx, correction AttendanceCorrection) error {
+	_, err := tx.ExecContext(ctx, "insert into attendance_corrections "+
+		"(id, attendance_id, lesson_id, user_id, action, old_status, new_status, old_reason, new_reason, actor, reason, creation_time) "+
+		"values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12);",
+		correction.Id, correction.AttendanceId, correction.LessonId, correction.UserId, correction.Action,
+		correction.OldStatus, correction.NewStatus, correction.OldReason, correction.NewReason,
+		correction.Actor, correction.Reason, correction.CreationTime)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Locks a lesson and, the user's mark in it. Lessons that have ended can only be changed
+ * with allowPast.
+ *
+ * @return the id of the mark and, the mark
+ */
+func lockMark(ctx context.Context, tx *sql.Tx, lessonId string, userId string, allowPast bool) (string, LessonAttendance, error) {
+	if _, err := uuid.Parse(lessonId); err != nil {
+		return "", LessonAttendance{}, ErrLessonNotFound
+	}
+
+	if _, err := uuid.Parse(userId); err != nil {
+		return "", LessonAttendance{}, ErrMarkNotFound
+	}
+
+	var ended bool
+	err := tx.QueryRowContext(ctx, "select end_time < CURRENT_TIMESTAMP from actual_lessons where id = $1 for update;",
+		lessonId).Scan(&ended)
+	if err == sql.ErrNoRows {
+		return "", LessonAttendance{}, ErrLessonNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return "", LessonAttendance{}, err
+	}
+
+	if ended && !allowPast {
+		return "", LessonAttendance{}, ErrPastMark
+	}
+
+	var id string
+	mark := LessonAttendance{LessonId: lessonId, UserId: userId}
+	err = tx.QueryRowContext(ctx, "select id, register_time, status, reason from attendance "+
+		"where lesson_id = $1 and user_id = $2 for update;",
+		lessonId, userId).Scan(&id, &mark.RegisterTime, &mark.Status, &mark.Reason)
+	if err == sql.ErrNoRows {
+		return "", LessonAttendance{}, ErrMarkNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return "", LessonAttendance{}, err
+	}
+
+	return id, mark, nil
+}
+
+/*
+ * Changes the status and, reason of a user's mark for a lesson.
+ *
+ * @param actor            the user making the change
+ * @param correctionReason why the mark is being changed
+ * @param allowPast        whether the actor has PERMS_ATTENDANCE_ALLOW_PAST_MARK
+ * @return the correction that was stored
+ */
+func AmendAttendance(ctx context.Context, lessonId string, userId string, status AttendanceStatus, reason string, correctionReason string, actor string, allowPast bool, pool *utils.DatabasePool) (AttendanceCorrection, error) {
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AttendanceCorrection{}, err
+	}
+	defer func() {
+		if !success {
+			tx.Rollback()
+		}
+	}()
+
+	attendanceId, old, err := lockMark(ctx, tx, lessonId, userId, allowPast)
+	if err != nil {
+		return AttendanceCorrection{}, err
+	}
+
+	correction, err := newCorrection(CORRECTION_AMEND, old, status, reason, actor, correctionReason)
+	if err != nil {
+		return AttendanceCorrection{}, err
+	}
+	correction.AttendanceId = attendanceId
+
+	_, err = tx.ExecContext(ctx, "update attendance set status = $2, reason = $3, marked_by = $4 where id = $1;",
+		attendanceId, status, reason, actor)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AttendanceCorrection{}, err
+	}
+
+	err = insertCorrection(ctx, tx, correction)
+	if err != nil {
+		return AttendanceCorrection{}, err
+	}
+
+	err = publishAttendanceCorrected(ctx, tx, correction)
+	if err != nil {
+		return AttendanceCorrection{}, err
+	}
+
+	success = true
+	err = tx.Commit()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AttendanceCorrection{}, err
+	}
+
+	logging.FromContext(ctx).Infof("Amended attendance (%s -> %s) for user %s lesson %s", old.Status, status, userId, lessonId)
+	return correction, nil
+}
+
+/*
+ * Removes a user's mark for a lesson, they are then unmarked on the register.
+ *
+ * @see AmendAttendance
+ */
+func RetractAttendance(ctx context.Context, lessonId string, userId string, correctionReason string, actor string, allowPast bool, pool *utils.DatabasePool) (AttendanceCorrection, error) {
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AttendanceCorrection{}, err
+	}
+	defer func() {
+		if !success {
+			tx.Rollback()
+		}
+	}()
+
+	attendanceId, old, err := lockMark(ctx, tx, lessonId, userId, allowPast)
+	if err != nil {
+		return AttendanceCorrection{}, err
+	}
+
+	correction, err := newCorrection(CORRECTION_RETRACT, old, "", "", actor, correctionReason)
+	if err != nil {
+		return AttendanceCorrection{}, err
+	}
+	correction.AttendanceId = attendanceId
+
+	_, err = tx.ExecContext(ctx, "delete from attendance where lesson_id = $1 and user_id = $2;", lessonId, userId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AttendanceCorrection{}, err
+	}
+
+	err = insertCorrection(ctx, tx, correction)
+	if err != nil {
+		return AttendanceCorrection{}, err
+	}
+
+	err = publishAttendanceCorrected(ctx, tx, correction)
+	if err != nil {
+		return AttendanceCorrection{}, err
+	}
+
+	success = true
+	err = tx.Commit()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AttendanceCorrection{}, err
+	}
+
+	logging.FromContext(ctx).Infof("Retracted attendance (%s) for user %s lesson %s", old.Status, userId, lessonId)
+	return correction, nil
+}
+
+// Conditions for getCorrections
+const (
+	CORRECTIONS_BY_LESSON = "lesson_id = $1"
+	CORRECTIONS_BY_USER   = "user_id = $1 and lesson_id in (select actual_lessons.id from actual_lessons " +
+		"inner join group_lessons on group_lessons.id = actual_lessons.group_lesson_id " +
+		"inner join module_groups on module_groups.id = group_lessons.module_group_id " +
+		"where module_groups.module_id = $2)"
+)
+
+// Ids that are not uuids have no corrections
+func getCorrections(ctx context.Context, cond string, pool *utils.DatabasePool, ids ...string) ([]AttendanceCorrection, error) {
+	ret := make([]AttendanceCorrection, 0)
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err != nil {
+			return ret, nil
+		}
+		args = append(args, id)
+	}
+
+	rows, err := pool.Database.QueryContext(ctx, "select id, coalesce(attendance_id::text, ''), lesson_id, user_id, action, "+
+		"old_status, new_status, old_reason, new_reason, actor, reason, creation_time "+
+		"from attendance_corrections where "+cond+" order by creation_time asc;", args...)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var correction AttendanceCorrection
+		err = rows.Scan(&correction.Id, &correction.AttendanceId, &correction.LessonId, &correction.UserId,
+			&correction.Action, &correction.OldStatus, &correction.NewStatus, &correction.OldReason,
+			&correction.NewReason, &correction.Actor, &correction.Reason, &correction.CreationTime)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret = append(ret, correction)
+	}
+
+	return ret, nil
+}
+
+/*
+ * Gets the changes to the marks for a lesson in a module, oldest first.
+ */
+func GetLessonCorrections(ctx context.Context, lessonId string, moduleId string, pool *utils.DatabasePool) ([]AttendanceCorrection, error) {
+	_, err := GetLessonModuleGroup(ctx, lessonId, moduleId, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	return getCorrections(ctx, CORRECTIONS_BY_LESSON, pool, lessonId)
+}
+
+/*
+ * Gets the changes to a student's marks for the lessons in a module, oldest first.
+ */
+func GetUserCorrections(ctx context.Context, userId string, moduleId string, pool *utils.DatabasePool) ([]AttendanceCorrection, error) {
+	return getCorrections(ctx, CORRECTIONS_BY_USER, pool, userId, moduleId)
+}
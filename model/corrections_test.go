@@ -0,0 +1,52 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestNewCorrection(t *testing.T) {
+	old := LessonAttendance{LessonId: "lesson", UserId: "student", Status: ATTENDANCE_PRESENT}
+
+	correction, err := newCorrection(CORRECTION_AMEND, old, ATTENDANCE_LATE, "Bus", "lecturer", " Arrived after the register ")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if correction.OldStatus != ATTENDANCE_PRESENT || correction.NewStatus != ATTENDANCE_LATE || correction.NewReason != "Bus" ||
+		correction.Actor != "lecturer" || correction.Reason != "Arrived after the register" || correction.Id == "" {
+		t.Log("Expected the correction to have the old and, new mark", correction)
+		t.Fail()
+	}
+
+	correction, err = newCorrection(CORRECTION_RETRACT, old, ATTENDANCE_LATE, "Bus", "lecturer", "Marked by mistake")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if correction.NewStatus != "" || correction.NewReason != "" || correction.OldStatus != ATTENDANCE_PRESENT {
+		t.Log("Expected retractions to have a blank new mark", correction)
+		t.Fail()
+	}
+
+	if _, err = newCorrection(CORRECTION_AMEND, old, ATTENDANCE_LATE, "", "lecturer", " "); err == nil {
+		t.Log("Expected corrections without a reason to be refused")
+		t.Fail()
+	}
+
+	if _, err = newCorrection(CORRECTION_AMEND, old, ATTENDANCE_PRESENT, "", "lecturer", "No change"); err == nil {
+		t.Log("Expected amendments that do not change the mark to be refused")
+		t.Fail()
+	}
+
+	if _, err = newCorrection(CORRECTION_AMEND, old, "asleep", "", "lecturer", "Invalid"); err == nil {
+		t.Log("Expected an invalid status to be refused")
+		t.Fail()
+	}
+
+	if _, err = newCorrection("undo", old, ATTENDANCE_LATE, "", "lecturer", "Invalid"); err == nil {
+		t.Log("Expected an invalid action to be refused")
+		t.Fail()
+	}
+}
@@ -0,0 +1,800 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+ * This file lets lecturers move, cancel and, edit lessons after they are created.
+ * Single lessons are cancelled by flagging them, a single occurrence of a repeating
+ * lesson is cancelled or moved by adding its start to the series' exceptions.
+ */
+
+var ErrNoOccurrence = errors.New("The repeating lesson does not have an occurrence then")
+
+const DAY = 24 * time.Hour
+
+// Repeating lesson times are stored as a time of day
+func timeOfDay(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second +
+		time.Duration(t.Nanosecond())
+}
+
+// Truncates a time to the start of its day in its own time zone, dates are stored without a
+// time zone so the result is in UTC
+func toDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func sameDate(a time.Time, b time.Time) bool {
+	return toDate(a).Equal(toDate(b))
+}
+
+/*
+ * Checks if the occurrence of a repeating lesson that starts at a time has been cancelled,
+ * moved or, is on a closed date.
+ */
+func isRepeatingLessonException(lesson RepeatingLesson, start time.Time) bool {
+	for _, exception := range lesson.Exceptions {
+		if exception.Equal(start) {
+			return true
+		}
+	}
+
+	for _, closed := range lesson.ClosedDates {
+		if sameDate(closed, start) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The lesson for an occurrence of a repeating lesson
+func occurrenceLesson(lesson RepeatingLesson, occurrence Occurrence) ActualLesson {
+	return ActualLesson{Id: lesson.Id,
+		GroupLessonId: lesson.GroupLessonId,
+		CreationTime:  lesson.CreationTime,
+		EditTime:      lesson.EditTime,
+		StartTime:     occurrence.Start,
+		EndTime:       occurrence.End,
+		IsAbstract:    true}
+}
+
+/*
+ * Gets the first occurrence of a repeating lesson, this is the first occurrence on or, after
+ * the start repeating date.
+ */
+func firstOccurrence(lesson RepeatingLesson) ActualLesson {
+	occurrence, _ := nextOccurrence(lesson, lesson.StartRepeating)
+	return occurrence
+}
+
+/*
+ * Gets the first occurrence of a repeating lesson that starts on or, after the date,
+ * exceptions are not skipped. false is returned if the lesson stops repeating before then.
+ * The times are in the lesson's time zone, see recurrence.go
+ */
+func nextOccurrence(lesson RepeatingLesson, date time.Time) (ActualLesson, bool) {
+	recurrence, err := lessonRecurrence(lesson)
+	if err != nil {
+		logging.Default().Error(err)
+		return ActualLesson{}, false
+	}
+
+	var ret ActualLesson
+	found := false
+	date = toDate(date)
+	recurrence.Each(func(occurrence Occurrence) bool {
+		if toDate(occurrence.Start).Before(date) {
+			return true
+		}
+
+		ret = occurrenceLesson(lesson, occurrence)
+		found = true
+		return false
+	})
+
+	return ret, found
+}
+
+/*
+ * Gets the occurrence of a repeating lesson that starts at a time, a series can have more
+ * than one occurrence on a date.
+ */
+func occurrenceAt(lesson RepeatingLesson, start time.Time) (ActualLesson, error) {
+	recurrence, err := lessonRecurrence(lesson)
+	if err != nil {
+		return ActualLesson{}, err
+	}
+
+	var ret ActualLesson
+	found := false
+	recurrence.Each(func(occurrence Occurrence) bool {
+		if occurrence.Start.Before(start) {
+			return true
+		}
+
+		ret = occurrenceLesson(lesson, occurrence)
+		found = occurrence.Start.Equal(start)
+		return false
+	})
+
+	if !found {
+		return ActualLesson{}, ErrNoOccurrence
+	}
+
+	return ret, nil
+}
+
+/*
+ * Gets the starts of the cancelled and, moved occurrences of a repeating lesson.
+ */
+func GetRepeatingLessonExceptions(ctx context.Context, repeatingLessonId string, pool *utils.DatabasePool) ([]time.Time, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select exception_time from repeating_lesson_exceptions "+
+		"where repeating_lesson_id = $1 order by exception_time asc;", repeatingLessonId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]time.Time, 0)
+	for rows.Next() {
+		var start time.Time
+		err = rows.Scan(&start)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret = append(ret, start)
+	}
+
+	return ret, nil
+}
+
+/*
+ * Gets a repeating lesson in a module group of the module along with its exceptions.
+ */
+func GetRepeatingLesson(ctx context.Context, repeatingLessonId string, moduleId string, moduleGroupId string, pool *utils.DatabasePool) (RepeatingLesson, error) {
+	var lesson RepeatingLesson
+	var rrule, rdate, timezone string
+	err := pool.Database.QueryRowContext(ctx, "select repeating_lessons.id, repeating_lessons.group_lesson_id, "+
+		"repeating_lessons.start_repeating, repeating_lessons.stop_repeating, "+
+		"repeating_lessons.start_time, repeating_lessons.end_time, "+
+		"(EXTRACT(epoch FROM repeating_lessons.repeat_every) * 1000000000)::BIGINT, "+
+		"repeating_lessons.creation_time, repeating_lessons.edit_time, "+
+		REPEATING_LESSON_RECURRENCE_COLUMNS+" "+
+		"from repeating_lessons "+
+		"inner join group_lessons on group_lessons.id = repeating_lessons.group_lesson_id "+
+		"inner join module_groups on module_groups.id = group_lessons.module_group_id "+
+		"where repeating_lessons.id = $1 and group_lessons.module_group_id = $2 and module_groups.module_id = $3;",
+		repeatingLessonId, moduleGroupId, moduleId).Scan(&lesson.Id,
+		&lesson.GroupLessonId,
+		&lesson.StartRepeating,
+		&lesson.StopRepeating,
+		&lesson.StartTime,
+		&lesson.EndTime,
+		&lesson.RepeatEvery,
+		&lesson.CreationTime,
+		&lesson.EditTime,
+		&rrule,
+		&rdate,
+		&timezone)
+	if err == sql.ErrNoRows {
+		return RepeatingLesson{}, errors.New("Cannot find repeating lesson with matching id")
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return RepeatingLesson{}, err
+	}
+
+	err = setRecurrenceColumns(&lesson, rrule, rdate, timezone)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return RepeatingLesson{}, err
+	}
+
+	lesson.Exceptions, err = GetRepeatingLessonExceptions(ctx, lesson.Id, pool)
+	if err != nil {
+		return RepeatingLesson{}, err
+	}
+
+	return lesson, nil
+}
+
+/*
+ * Gets a lesson in a module group of the module.
+ */
+func GetActualLesson(ctx context.Context, lessonId string, moduleId string, moduleGroupId string, pool *utils.DatabasePool) (ActualLesson, error) {
+	var lesson ActualLesson
+	err := pool.Database.QueryRowContext(ctx, "select actual_lessons.id, actual_lessons.group_lesson_id, "+
+		"actual_lessons.start_time, actual_lessons.end_time, actual_lessons.creation_time, "+
+		"actual_lessons.edit_time, actual_lessons.summary, actual_lessons.description, "+
+		"actual_lessons.location, actual_lessons.cancelled, coalesce(actual_lessons.room_id::text, '') "+
+		"from actual_lessons "+
+		"inner join group_lessons on group_lessons.id = actual_lessons.group_lesson_id "+
+		"inner join module_groups on module_groups.id = group_lessons.module_group_id "+
+		"where actual_lessons.id = $1 and group_lessons.module_group_id = $2 and module_groups.module_id = $3;",
+		lessonId, moduleGroupId, moduleId).Scan(&lesson.Id,
+		&lesson.GroupLessonId,
+		&lesson.StartTime,
+		&lesson.EndTime,
+		&lesson.CreationTime,
+		&lesson.EditTime,
+		&lesson.Summary,
+		&lesson.Description,
+		&lesson.Location,
+		&lesson.Cancelled,
+		&lesson.RoomId)
+	if err == sql.ErrNoRows {
+		return ActualLesson{}, errors.New("Cannot find lesson with matching id")
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return ActualLesson{}, err
+	}
+
+	return lesson, nil
+}
+
+/*
+ * Edits or, moves a lesson in a module group. Fields in the update that are not set
+ * are not changed.
+ *
+ * @param update        the new lesson details, the id must be set
+ * @param moduleId      the module the module group is in
+ * @param moduleGroupId the module group the lesson is in
+ * @param pool          the database pool
+ * @return the updated lesson
+ */
+func UpdateActualLesson(ctx context.Context, update ActualLesson, moduleId string, moduleGroupId string, pool *utils.DatabasePool) (ActualLesson, error) {
+	lesson, err := GetActualLesson(ctx, update.Id, moduleId, moduleGroupId, pool)
+	if err != nil {
+		return ActualLesson{}, err
+	}
+
+	if lesson.Cancelled {
+		return ActualLesson{}, errors.New("The lesson has been cancelled")
+	}
+
+	var nullTime time.Time
+	if update.StartTime != nullTime {
+		lesson.StartTime = update.StartTime
+	}
+	if update.EndTime != nullTime {
+		lesson.EndTime = update.EndTime
+	}
+	if update.Summary != "" {
+		lesson.Summary = update.Summary
+	}
+	if update.Description != "" {
+		lesson.Description = update.Description
+	}
+	if update.Location != "" {
+		lesson.Location = update.Location
+	}
+	if update.RoomId != "" {
+		lesson.RoomId = update.RoomId
+	}
+
+	if !lesson.StartTime.Before(lesson.EndTime) {
+		return ActualLesson{}, errors.New("start-time must be earlier than end-time")
+	}
+
+	// Only moving the lesson can cause a clash
+	if update.StartTime != nullTime || update.EndTime != nullTime || update.RoomId != "" {
+		err = checkLessonBooking(ctx, lessonBooking{GroupLessonId: lesson.GroupLessonId,
+			RoomId:      lesson.RoomId,
+			Occurrences: lessonOccurrences(lesson),
+			Ignore: func(l ActualLesson) bool {
+				return l.Id == lesson.Id
+			}}, time.Now(), pool)
+		if err != nil {
+			return ActualLesson{}, err
+		}
+	}
+
+	lesson.EditTime = time.Now()
+	_, err = pool.Database.ExecContext(ctx, "update actual_lessons set start_time = $2, end_time = $3, "+
+		"summary = $4, description = $5, location = $6, edit_time = $7, room_id = nullif($8, '')::uuid where id = $1;",
+		lesson.Id, lesson.StartTime, lesson.EndTime, lesson.Summary, lesson.Description, lesson.Location, lesson.EditTime,
+		lesson.RoomId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return ActualLesson{}, err
+	}
+
+	logging.FromContext(ctx).Infof("Updated lesson %s", lesson.Id)
+	return lesson, nil
+}
+
+// Closes any check-in sessions for a lesson that is being cancelled
+func closeLessonCheckinSessions(ctx context.Context, tx *sql.Tx, lessonId string) error {
+	_, err := tx.ExecContext(ctx, "update checkin_sessions set expiry_time = CURRENT_TIMESTAMP "+
+		"where lesson_id = $1 and expiry_time > CURRENT_TIMESTAMP;", lessonId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+	}
+
+	return err
+}
+
+/*
+ * Cancels a lesson in a module group, the lesson is kept so that it shows as cancelled
+ * in timetables but, it is not counted towards attendance.
+ */
+func CancelActualLesson(ctx context.Context, lessonId string, moduleId string, moduleGroupId string, pool *utils.DatabasePool) error {
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	event := LessonCancelledEvent{LessonId: lessonId, ModuleGroupId: moduleGroupId}
+	var repeatingLessonId sql.NullString
+	err = tx.QueryRowContext(ctx, "update actual_lessons set cancelled = true, edit_time = $3 "+
+		"where id = $1 and not cancelled and "+
+		"group_lesson_id in (select group_lessons.id from group_lessons, module_groups "+
+		"where group_lessons.module_group_id = $2 and module_groups.id = group_lessons.module_group_id and "+
+		"module_groups.module_id = $4) "+
+		"returning repeating_lesson_id::text, start_time, end_time;",
+		lessonId, moduleGroupId, time.Now(), moduleId).Scan(&repeatingLessonId, &event.StartTime, &event.EndTime)
+	if err == sql.ErrNoRows {
+		return errors.New("Cannot find lesson with matching id or, it is already cancelled")
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	event.RepeatingLessonId = repeatingLessonId.String
+
+	err = closeLessonCheckinSessions(ctx, tx, lessonId)
+	if err != nil {
+		return err
+	}
+
+	logging.FromContext(ctx).Infof("Cancelled lesson %s", lessonId)
+	err = queueWebhookEventTx(ctx, tx, EVENT_LESSON_CANCELLED, event)
+	if err != nil {
+		return err
+	}
+
+	success = true
+	return nil
+}
+
+// Adds the occurrence that starts at a time to a repeating lesson's exceptions, adding it twice is not an error
+func addRepeatingLessonException(ctx context.Context, tx *sql.Tx, repeatingLessonId string, start time.Time) error {
+	_, err := tx.ExecContext(ctx, "insert into repeating_lesson_exceptions "+
+		"(id, repeating_lesson_id, exception_time, creation_time) values ($1, $2, $3, $4) "+
+		"on conflict (repeating_lesson_id, exception_time) do nothing;",
+		uuid.New().String(), repeatingLessonId, start.UTC(), time.Now())
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+	}
+
+	return err
+}
+
+/*
+ * Cancels a single occurrence of a repeating lesson, for example on a bank holiday.
+ * If the lesson has already been spawned then it is cancelled too.
+ *
+ * @param repeatingLessonId the repeating lesson
+ * @param moduleId          the module the module group is in
+ * @param moduleGroupId     the module group the lesson is in
+ * @param occurrenceStart   the start of the occurrence
+ * @param pool              the database pool
+ */
+func CancelRepeatingLessonOccurrence(ctx context.Context, repeatingLessonId string, moduleId string, moduleGroupId string, occurrenceStart time.Time, pool *utils.DatabasePool) error {
+	lesson, err := GetRepeatingLesson(ctx, repeatingLessonId, moduleId, moduleGroupId, pool)
+	if err != nil {
+		return err
+	}
+
+	occurrence, err := occurrenceAt(lesson, occurrenceStart)
+	if err != nil {
+		return err
+	}
+
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	err = addRepeatingLessonException(ctx, tx, lesson.Id, occurrence.StartTime)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, "update actual_lessons set cancelled = true, edit_time = $3 "+
+		"where (group_lesson_id = $1 and start_time = $2 or repeating_lesson_id = $4 and occurrence_start = $2) "+
+		"and not cancelled returning id;",
+		lesson.GroupLessonId, occurrence.StartTime.UTC(), time.Now(), lesson.Id)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	spawned := make([]string, 0)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			logging.FromContext(ctx).Error(err)
+			return err
+		}
+
+		spawned = append(spawned, id)
+	}
+	rows.Close()
+
+	for _, id := range spawned {
+		err = closeLessonCheckinSessions(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	logging.FromContext(ctx).Infof("Cancelled repeating lesson %s on %s", lesson.Id, occurrence.StartTime.Format("2006-01-02 15:04"))
+
+	event := LessonCancelledEvent{RepeatingLessonId: lesson.Id,
+		ModuleGroupId: moduleGroupId,
+		StartTime:     occurrence.StartTime,
+		EndTime:       occurrence.EndTime}
+	if len(spawned) != 0 {
+		event.LessonId = spawned[0]
+	}
+	err = queueWebhookEventTx(ctx, tx, EVENT_LESSON_CANCELLED, event)
+	if err != nil {
+		return err
+	}
+
+	success = true
+	return nil
+}
+
+/*
+ * Moves a single occurrence of a repeating lesson. The occurrence is added as an
+ * exception to the series and, a lesson is created at the new time, if the occurrence
+ * was already spawned then that lesson is moved instead.
+ *
+ * @param repeatingLessonId the repeating lesson
+ * @param moduleId          the module the module group is in
+ * @param moduleGroupId     the module group the lesson is in
+ * @param occurrenceStart   the start of the occurrence to move
+ * @param start             the new start time
+ * @param end               the new end time
+ * @param pool              the database pool
+ * @return the moved lesson
+ */
+func RescheduleRepeatingLessonOccurrence(ctx context.Context, repeatingLessonId string, moduleId string, moduleGroupId string, occurrenceStart time.Time, start time.Time, end time.Time, pool *utils.DatabasePool) (ActualLesson, error) {
+	if !start.Before(end) {
+		return ActualLesson{}, errors.New("start-time must be earlier than end-time")
+	}
+
+	lesson, err := GetRepeatingLesson(ctx, repeatingLessonId, moduleId, moduleGroupId, pool)
+	if err != nil {
+		return ActualLesson{}, err
+	}
+
+	occurrence, err := occurrenceAt(lesson, occurrenceStart)
+	if err != nil {
+		return ActualLesson{}, err
+	}
+
+	if isRepeatingLessonException(lesson, occurrence.StartTime) {
+		return ActualLesson{}, errors.New("The occurrence has already been cancelled or, moved")
+	}
+
+	// The occurrence being moved is replaced by the lesson
+	err = checkLessonBooking(ctx, lessonBooking{GroupLessonId: lesson.GroupLessonId,
+		Occurrences: []Occurrence{{Start: start, End: end}},
+		Ignore: func(l ActualLesson) bool {
+			return l.StartTime.Equal(occurrence.StartTime) && (l.IsAbstract && l.Id == lesson.Id || l.GroupLessonId == lesson.GroupLessonId) ||
+				l.RepeatingLessonId == lesson.Id && l.OccurrenceStart.Equal(occurrence.StartTime)
+		}}, time.Now(), pool)
+	if err != nil {
+		return ActualLesson{}, err
+	}
+
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return ActualLesson{}, err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	err = addRepeatingLessonException(ctx, tx, lesson.Id, occurrence.StartTime)
+	if err != nil {
+		return ActualLesson{}, err
+	}
+
+	now := time.Now()
+	moved := ActualLesson{GroupLessonId: lesson.GroupLessonId,
+		StartTime:    start,
+		EndTime:      end,
+		CreationTime: now,
+		EditTime:     now}
+
+	err = tx.QueryRowContext(ctx, "update actual_lessons set start_time = $3, end_time = $4, edit_time = $5 "+
+		"where (group_lesson_id = $1 and start_time = $2 or repeating_lesson_id = $6 and occurrence_start = $2) "+
+		"and not cancelled returning id, creation_time, summary, description, location;",
+		lesson.GroupLessonId, occurrence.StartTime.UTC(), start, end, now, lesson.Id).Scan(&moved.Id,
+		&moved.CreationTime,
+		&moved.Summary,
+		&moved.Description,
+		&moved.Location)
+	if err == sql.ErrNoRows {
+		// Not spawned yet
+		moved.Id = uuid.New().String()
+		err = tx.QueryRowContext(ctx, "insert into actual_lessons "+
+			"(id, group_lesson_id, start_time, end_time, creation_time, edit_time, summary, description, location) "+
+			"select $1, id, $3, $4, $5, $5, summary, description, location from group_lessons where id = $2 "+
+			"returning summary, description, location;",
+			moved.Id, lesson.GroupLessonId, start, end, now).Scan(&moved.Summary,
+			&moved.Description,
+			&moved.Location)
+	}
+
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return ActualLesson{}, err
+	}
+
+	logging.FromContext(ctx).Infof("Moved repeating lesson %s on %s to lesson %s", lesson.Id, occurrence.StartTime.Format("2006-01-02 15:04"), moved.Id)
+
+	success = true
+	return moved, nil
+}
+
+/*
+ * Gets the rule for the part of a series after it is split. A COUNT cannot be split so it is
+ * replaced with an UNTIL on the date of the series' last occurrence.
+ */
+func splitRecurrenceRule(lesson RepeatingLesson) (string, error) {
+	if lesson.RRule == "" {
+		return "", nil
+	}
+
+	rule, err := ParseRecurrenceRule(lesson.RRule)
+	if err != nil || rule.Count == 0 {
+		return lesson.RRule, err
+	}
+
+	// Only the rule's occurrences are counted
+	lesson.RDates = nil
+	recurrence, err := lessonRecurrence(lesson)
+	if err != nil {
+		return "", err
+	}
+
+	var last time.Time
+	recurrence.Each(func(occurrence Occurrence) bool {
+		last = occurrence.Start
+		return true
+	})
+
+	rule.Count = 0
+	rule.Until = toDate(last)
+	rule.untilForm = untilDate
+	return rule.String(), nil
+}
+
+// Splits RDATEs into the ones before the date and, the ones on or after it
+func splitRDates(rdates []time.Time, from time.Time) ([]time.Time, []time.Time) {
+	before := make([]time.Time, 0)
+	after := make([]time.Time, 0)
+	for _, rdate := range rdates {
+		if toDate(rdate).Before(toDate(from)) {
+			before = append(before, rdate)
+		} else {
+			after = append(after, rdate)
+		}
+	}
+
+	return before, after
+}
+
+/*
+ * Edits a repeating lesson from a date onwards, occurrences before the date are not
+ * changed. If the date is after the series started then the series is split in two,
+ * the new series keeps the same days as the old one and, takes any later exceptions.
+ * Fields in the update that are not set are not changed.
+ *
+ * @param repeatingLessonId the repeating lesson
+ * @param moduleId          the module the module group is in
+ * @param moduleGroupId     the module group the lesson is in
+ * @param from              the first date to change
+ * @param update            the new start time, end time, repeat every or rrule and, stop repeating
+ * @param pool              the database pool
+ * @return the series that runs from the date
+ */
+func UpdateRepeatingLessonFrom(ctx context.Context, repeatingLessonId string, moduleId string, moduleGroupId string, from time.Time, update RepeatingLesson, pool *utils.DatabasePool) (RepeatingLesson, error) {
+	lesson, err := GetRepeatingLesson(ctx, repeatingLessonId, moduleId, moduleGroupId, pool)
+	if err != nil {
+		return RepeatingLesson{}, err
+	}
+
+	from = toDate(from)
+	split := from.After(toDate(lesson.StartRepeating))
+
+	// Exceptions are instants, the series is split at the start of the date in its time zone
+	splitTime := inLocation(from, lessonLocation(lesson))
+
+	series := lesson
+	if split {
+		// The new series starts on the next occurrence so the days stay the same
+		occurrence, found := nextOccurrence(lesson, from)
+		if !found {
+			return RepeatingLesson{}, ErrNoOccurrence
+		}
+
+		series.Id = uuid.New().String()
+		series.StartRepeating = toDate(occurrence.StartTime)
+		series.CreationTime = time.Now()
+
+		series.RRule, err = splitRecurrenceRule(lesson)
+		if err != nil {
+			return RepeatingLesson{}, err
+		}
+
+		// Extra occurrences move to the new series
+		lesson.RDates, series.RDates = splitRDates(lesson.RDates, from)
+	}
+
+	var nullTime time.Time
+	if update.StartTime != nullTime {
+		series.StartTime = update.StartTime
+	}
+	if update.EndTime != nullTime {
+		series.EndTime = update.EndTime
+	}
+	if update.StopRepeating != nullTime {
+		series.StopRepeating = update.StopRepeating
+	}
+	if update.RepeatEvery != 0 {
+		// The series goes back to repeating every period
+		series.RepeatEvery = update.RepeatEvery
+		series.RRule = ""
+	}
+	if update.RRule != "" {
+		rule, err := ParseRecurrenceRule(update.RRule)
+		if err != nil {
+			return RepeatingLesson{}, err
+		}
+
+		series.RRule = rule.String()
+		series.RepeatEvery = rule.Period()
+	}
+	series.EditTime = time.Now()
+
+	if timeOfDay(series.StartTime) >= timeOfDay(series.EndTime) {
+		return RepeatingLesson{}, errors.New("start-time must be earlier than end-time")
+	}
+
+	if toDate(series.StartRepeating).After(toDate(series.StopRepeating)) {
+		return RepeatingLesson{}, errors.New("start-repeating must be earlier than stop-repeating")
+	}
+
+	if series.RepeatEvery <= 0 {
+		return RepeatingLesson{}, errors.New("repeat-every must be positive")
+	}
+
+	err = checkRecurrenceLimit(series)
+	if err != nil {
+		return RepeatingLesson{}, err
+	}
+
+	// The occurrences from the date are replaced by the series
+	err = checkRepeatingLessonBooking(ctx, series, from, func(l ActualLesson) bool {
+		return (l.IsAbstract && l.Id == lesson.Id || l.RepeatingLessonId == lesson.Id) && !toDate(l.StartTime).Before(from)
+	}, pool)
+	if err != nil {
+		return RepeatingLesson{}, err
+	}
+
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return RepeatingLesson{}, err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	if split {
+		// Stop the old series the day before
+		_, err = tx.ExecContext(ctx, "update repeating_lessons set stop_repeating = $2, rdate = $3, edit_time = $4 where id = $1;",
+			lesson.Id, from.Add(-DAY), formatRDates(lesson.RDates), series.EditTime)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return RepeatingLesson{}, err
+		}
+
+		_, err = tx.ExecContext(ctx, "insert into repeating_lessons "+
+			"(id, group_lesson_id, start_repeating, stop_repeating, "+
+			"start_time, end_time, repeat_every, creation_time, edit_time, rrule, rdate, timezone) "+
+			"values ($1, $2, $3, $4, $5, $6, $7 * interval '1 second', $8, $9, $10, $11, $12);",
+			series.Id, series.GroupLessonId, series.StartRepeating, series.StopRepeating,
+			series.StartTime, series.EndTime, series.RepeatEvery.Seconds(), series.CreationTime, series.EditTime,
+			series.RRule, formatRDates(series.RDates), series.Timezone)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return RepeatingLesson{}, err
+		}
+
+		_, err = tx.ExecContext(ctx, "update repeating_lesson_exceptions set repeating_lesson_id = $2 "+
+			"where repeating_lesson_id = $1 and exception_time >= $3;",
+			lesson.Id, series.Id, splitTime.UTC())
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return RepeatingLesson{}, err
+		}
+	} else {
+		_, err = tx.ExecContext(ctx, "update repeating_lessons set stop_repeating = $2, start_time = $3, "+
+			"end_time = $4, repeat_every = $5 * interval '1 second', edit_time = $6, rrule = $7 where id = $1;",
+			series.Id, series.StopRepeating, series.StartTime, series.EndTime, series.RepeatEvery.Seconds(), series.EditTime,
+			series.RRule)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return RepeatingLesson{}, err
+		}
+	}
+
+	// Only keep the exceptions that belong to the returned series
+	exceptions := make([]time.Time, 0)
+	for _, exception := range series.Exceptions {
+		if !split || !exception.Before(splitTime) {
+			exceptions = append(exceptions, exception)
+		}
+	}
+	series.Exceptions = exceptions
+
+	logging.FromContext(ctx).Infof("Updated repeating lesson %s from %s", series.Id, from.Format("2006-01-02"))
+
+	success = true
+	return series, nil
+}
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRepeatingLesson() RepeatingLesson {
+	// Every monday in July 2022 from 11:00 to 13:00
+	return RepeatingLesson{Id: uuid.New().String(),
+		GroupLessonId:  uuid.New().String(),
+		StartRepeating: time.Date(2022, 7, 4, 0, 0, 0, 0, time.UTC),
+		StopRepeating:  time.Date(2022, 7, 25, 0, 0, 0, 0, time.UTC),
+		StartTime:      time.Date(0, 1, 1, 11, 0, 0, 0, time.UTC),
+		EndTime:        time.Date(0, 1, 1, 13, 0, 0, 0, time.UTC),
+		RepeatEvery:    7 * DAY}
+}
+
+func TestTimeOfDay(t *testing.T) {
+	tod := timeOfDay(time.Date(0, 1, 1, 11, 30, 15, 0, time.UTC))
+	if tod != 11*time.Hour+30*time.Minute+15*time.Second {
+		t.Logf("Wrong time of day %s", tod)
+		t.Fail()
+	}
+}
+
+func TestFirstOccurrence(t *testing.T) {
+	lesson := firstOccurrence(testRepeatingLesson())
+	expected := time.Date(2022, 7, 4, 11, 0, 0, 0, time.UTC)
+	if !lesson.StartTime.Equal(expected) {
+		t.Logf("Expected %s got %s", expected, lesson.StartTime)
+		t.Fail()
+	}
+
+	if lesson.EndTime.Sub(lesson.StartTime) != 2*time.Hour {
+		t.Log("Wrong lesson length")
+		t.Fail()
+	}
+}
+
+func TestNextOccurrence(t *testing.T) {
+	lesson := testRepeatingLesson()
+
+	occurrence, found := nextOccurrence(lesson, time.Date(2022, 7, 6, 0, 0, 0, 0, time.UTC))
+	if !found || !occurrence.StartTime.Equal(time.Date(2022, 7, 11, 11, 0, 0, 0, time.UTC)) {
+		t.Logf("Wrong next occurrence %s", occurrence.StartTime)
+		t.Fail()
+	}
+
+	// The stop repeating date is inclusive
+	occurrence, found = nextOccurrence(lesson, time.Date(2022, 7, 25, 0, 0, 0, 0, time.UTC))
+	if !found || !sameDate(occurrence.StartTime, lesson.StopRepeating) {
+		t.Log("The last occurrence was not found")
+		t.Fail()
+	}
+
+	_, found = nextOccurrence(lesson, time.Date(2022, 7, 26, 0, 0, 0, 0, time.UTC))
+	if found {
+		t.Log("An occurrence was found after the lesson stopped repeating")
+		t.Fail()
+	}
+}
+
+func TestOccurrenceAt(t *testing.T) {
+	lesson := testRepeatingLesson()
+
+	_, err := occurrenceAt(lesson, time.Date(2022, 7, 18, 11, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Log("The lesson should occur on a monday")
+		t.Fail()
+	}
+
+	_, err = occurrenceAt(lesson, time.Date(2022, 7, 18, 12, 0, 0, 0, time.UTC))
+	if err != ErrNoOccurrence {
+		t.Log("The lesson should not occur at 12:00")
+		t.Fail()
+	}
+
+	_, err = occurrenceAt(lesson, time.Date(2022, 7, 19, 11, 0, 0, 0, time.UTC))
+	if err != ErrNoOccurrence {
+		t.Log("The lesson should not occur on a tuesday")
+		t.Fail()
+	}
+
+	_, err = occurrenceAt(lesson, time.Date(2022, 6, 27, 11, 0, 0, 0, time.UTC))
+	if err != ErrNoOccurrence {
+		t.Log("The lesson should not occur before it starts repeating")
+		t.Fail()
+	}
+}
+
+func TestIsRepeatingLessonException(t *testing.T) {
+	lesson := testRepeatingLesson()
+	lesson.Exceptions = []time.Time{time.Date(2022, 7, 11, 11, 0, 0, 0, time.UTC)}
+
+	if !isRepeatingLessonException(lesson, time.Date(2022, 7, 11, 11, 0, 0, 0, time.UTC)) {
+		t.Log("The exception was not found")
+		t.Fail()
+	}
+
+	// Another occurrence on the same day is not cancelled
+	lesson.RDates = []time.Time{time.Date(2022, 7, 11, 15, 0, 0, 0, time.UTC)}
+	if _, err := occurrenceAt(lesson, lesson.RDates[0]); err != nil || isRepeatingLessonException(lesson, lesson.RDates[0]) {
+		t.Log("An occurrence on the same day as the exception was cancelled")
+		t.Fail()
+	}
+
+	if isRepeatingLessonException(lesson, time.Date(2022, 7, 18, 11, 0, 0, 0, time.UTC)) {
+		t.Log("A lesson that was not cancelled is an exception")
+		t.Fail()
+	}
+}
+
+func TestCancelActualLessonNoLesson(t *testing.T) {
+	err := CancelActualLesson(context.Background(), uuid.New().String(), uuid.New().String(), uuid.New().String(), pool_lm)
+	if err == nil {
+		t.Log("A lesson that does not exist was cancelled")
+		t.Fail()
+	}
+}
+
+func TestUpdateActualLessonNoLesson(t *testing.T) {
+	_, err := UpdateActualLesson(context.Background(), ActualLesson{Id: uuid.New().String()}, uuid.New().String(), uuid.New().String(), pool_lm)
+	if err == nil {
+		t.Log("A lesson that does not exist was updated")
+		t.Fail()
+	}
+}
+
+func TestCancelRepeatingLessonOccurrenceNoLesson(t *testing.T) {
+	err := CancelRepeatingLessonOccurrence(context.Background(), uuid.New().String(), uuid.New().String(), uuid.New().String(), time.Now(), pool_lm)
+	if err == nil {
+		t.Log("A repeating lesson that does not exist was cancelled")
+		t.Fail()
+	}
+}
+
+func TestCancelledLessonExport(t *testing.T) {
+	lessons := []ActualLesson{{Id: uuid.New().String(),
+		StartTime: time.Now(),
+		EndTime:   time.Now().Add(time.Hour),
+		Summary:   "Cancelled lesson",
+		Cancelled: true}}
+
+	output := ExportLessonsAsIcal(lessons)
+	if !strings.Contains(output, "STATUS:CANCELLED") {
+		t.Log("The cancelled lesson was not marked as cancelled")
+		t.Fail()
+	}
+}
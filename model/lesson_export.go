@@ -1,29 +1,339 @@
 package model
 
 import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/utils"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/arran4/golang-ical"
+	"sort"
+	"time"
 )
 
-func ExportLessonsAsIcal(lessons []ActualLesson) string {
+// A repeating lesson with the details of its group lesson, see ExportTimetableAsIcal
+type IcalSeries struct {
+	Lesson      RepeatingLesson
+	Summary     string
+	Description string
+	Location    string
+}
+
+func withTzid(tzid string) ics.PropertyParameter {
+	return &ics.KeyValues{Key: string(ics.ParameterTzid), Value: []string{tzid}}
+}
+
+// The time zones that an export uses and, the times that it uses them between, see addTimezones
+type icalZones map[string]*icalZone
+
+type icalZone struct {
+	loc   *time.Location
+	first time.Time
+	last  time.Time
+}
+
+// Formats a date time value, times in UTC end with a Z and, others are given a TZID
+func (z icalZones) time(t time.Time) (string, []ics.PropertyParameter) {
+	if t.Location() == time.UTC {
+		return t.Format(ICAL_DATE_TIME_UTC_FORMAT), nil
+	}
+
+	name := t.Location().String()
+	if zone, found := z[name]; !found {
+		z[name] = &icalZone{loc: t.Location(), first: t, last: t}
+	} else if t.Before(zone.first) {
+		zone.first = t
+	} else if t.After(zone.last) {
+		zone.last = t
+	}
+
+	return t.Format(ICAL_DATE_TIME_FORMAT), []ics.PropertyParameter{withTzid(name)}
+}
+
+func (z icalZones) setTimes(event *ics.VEvent, start time.Time, end time.Time) {
+	value, params := z.time(start)
+	event.SetProperty(ics.ComponentPropertyDtStart, value, params...)
+	value, params = z.time(end)
+	event.SetProperty(ics.ComponentPropertyDtEnd, value, params...)
+}
+
+// Formats a UTC offset in seconds, i.e: +0100
+func icalOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+
+	ret := fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset/60%60)
+	if offset%60 != 0 {
+		ret += fmt.Sprintf("%02d", offset%60)
+	}
+	return ret
+}
+
+func icalProperty(property ics.Property, value string) ics.IANAProperty {
+	return ics.IANAProperty{BaseProperty: ics.BaseProperty{IANAToken: string(property), Value: value}}
+}
+
+/*
+ * Gets the STANDARD or, DAYLIGHT part of a VTIMEZONE for a change of offset.
+ *
+ * @param at the instant the offset changes, the part starts at this in the old offset
+ */
+func icalObservance(loc *time.Location, at time.Time, offsetFrom int) ics.Component {
+	name, offset := at.In(loc).Zone()
+	start := at.UTC().Add(time.Duration(offsetFrom) * time.Second)
+	base := ics.ComponentBase{Properties: []ics.IANAProperty{
+		icalProperty(ics.PropertyDtstart, start.Format(ICAL_DATE_TIME_FORMAT)),
+		icalProperty(ics.PropertyTzoffsetfrom, icalOffset(offsetFrom)),
+		icalProperty(ics.PropertyTzoffsetto, icalOffset(offset)),
+		icalProperty(ics.PropertyTzname, name)}}
+
+	if at.In(loc).IsDST() {
+		return &ics.Daylight{ComponentBase: base}
+	}
+	return &ics.Standard{ComponentBase: base}
+}
+
+/*
+ * Gets the VTIMEZONE for a time zone. Go does not give the zone's rules so each change of
+ * offset from the start of the year of the first time to the end of the year of the last time
+ * is its own part, the first part is the offset at the start.
+ */
+func icalTimezone(name string, zone *icalZone) *ics.VTimezone {
+	from := time.Date(zone.first.Year(), 1, 1, 0, 0, 0, 0, zone.loc)
+	to := time.Date(zone.last.Year()+1, 1, 1, 0, 0, 0, 0, zone.loc)
+
+	_, offset := from.Zone()
+	timezone := &ics.VTimezone{ComponentBase: ics.ComponentBase{
+		Properties: []ics.IANAProperty{icalProperty(ics.PropertyTzid, name)},
+		Components: []ics.Component{icalObservance(zone.loc, from, offset)}}}
+
+	// Offsets change at most once a day, the change is then found to the second
+	for t := from; t.Before(to); t = t.Add(DAY) {
+		next := t.Add(DAY)
+		if _, nextOffset := next.Zone(); nextOffset == offset {
+			continue
+		}
+
+		low, high := t, next
+		for high.Sub(low) > time.Second {
+			mid := low.Add(high.Sub(low) / 2)
+			if _, midOffset := mid.Zone(); midOffset == offset {
+				low = mid
+			} else {
+				high = mid
+			}
+		}
+
+		high = high.Truncate(time.Second)
+		timezone.Components = append(timezone.Components, icalObservance(zone.loc, high, offset))
+		_, offset = high.Zone()
+	}
+
+	return timezone
+}
+
+// Adds a VTIMEZONE for each time zone that the calendar's times use, before the events
+func (z icalZones) addTimezones(cal *ics.Calendar) {
+	names := make([]string, 0, len(z))
+	for name := range z {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	timezones := make([]ics.Component, 0, len(names))
+	for _, name := range names {
+		timezones = append(timezones, icalTimezone(name, z[name]))
+	}
+
+	cal.Components = append(timezones, cal.Components...)
+}
+
+/*
+ * Adds a repeating lesson as one event with an RRULE. The rule's COUNT and, UNTIL are replaced
+ * with an UNTIL on the last occurrence so that the stop repeating date is kept, exceptions are
+ * written as EXDATEs.
+ */
+func addIcalSeries(cal *ics.Calendar, series IcalSeries, zones icalZones) {
+	lesson := series.Lesson
+	recurrence, err := lessonRecurrence(lesson)
+	if err != nil {
+		logging.Default().Errorf("Cannot export repeating lesson %s - %s", lesson.Id, err)
+		return
+	}
+
+	// RDATEs are written separately
+	rdates := recurrence.RDates
+	recurrence.RDates = nil
+
+	var first, last Occurrence
+	count := 0
+	exdates := make([]time.Time, 0)
+	recurrence.Each(func(occurrence Occurrence) bool {
+		if count == 0 {
+			first = occurrence
+		}
+		last = occurrence
+		count++
+
+		if occurrence.Exception {
+			exdates = append(exdates, occurrence.Start)
+		}
+		return true
+	})
+
+	// DTSTART is always an occurrence so a series without any cannot be written
+	if count == 0 {
+		return
+	}
+
+	rule := recurrence.Rule
+	rule.Count = 0
+	rule.Until = last.Start.UTC()
+	rule.untilForm = untilUtc
+
+	event := cal.AddEvent(lesson.Id)
+	event.SetCreatedTime(lesson.CreationTime)
+	event.SetDtStampTime(lesson.CreationTime)
+	event.SetModifiedAt(lesson.EditTime)
+	zones.setTimes(event, first.Start, first.End)
+	event.AddRrule(rule.String())
+	for _, exdate := range exdates {
+		value, params := zones.time(exdate)
+		event.AddExdate(value, params...)
+	}
+	for _, rdate := range rdates {
+		rdate = inLocation(rdate, first.Start.Location())
+		if !toDate(rdate).After(toDate(lesson.StopRepeating)) && !recurrence.isException(rdate) {
+			value, params := zones.time(rdate)
+			event.AddRdate(value, params...)
+		}
+	}
+	event.SetSummary(series.Summary)
+	event.SetLocation(series.Location)
+	event.SetDescription(series.Description)
+}
+
+/*
+ * Gets the occurrence of a series that a spawned lesson overrides, false is returned if the
+ * lesson should be exported on its own.
+ */
+func overriddenOccurrence(lesson ActualLesson, series map[string]IcalSeries) (IcalSeries, ActualLesson, bool) {
+	parent, found := series[lesson.RepeatingLessonId]
+	if lesson.RepeatingLessonId == "" || !found || isRepeatingLessonException(parent.Lesson, lesson.OccurrenceStart) {
+		return IcalSeries{}, ActualLesson{}, false
+	}
+
+	occurrence, err := occurrenceAt(parent.Lesson, lesson.OccurrenceStart)
+	if err != nil {
+		return IcalSeries{}, ActualLesson{}, false
+	}
+
+	return parent, occurrence, true
+}
+
+/*
+ * Exports a timetable as an iCal feed. Each repeating lesson is one event with an RRULE,
+ * lessons that were spawned from one are written as overrides of its occurrences. Lessons that
+ * are an occurrence of one of the repeating lessons are left out, see GetLessons.
+ *
+ * @param lessons the lessons, including spawned lessons
+ * @param series  the repeating lessons
+ */
+func ExportTimetableAsIcal(lessons []ActualLesson, series []IcalSeries) string {
 	cal := ics.NewCalendar()
 	cal.SetMethod(ics.MethodPublish)
 	cal.SetName("arcio timetable, CHANGE ME")
-	cal.SetXWRTimezone("Europe/London")
+	cal.SetXWRTimezone(DefaultTimezone)
 	cal.SetProductId("arcio")
 
+	// Times in a series' time zone are given a TZID which the calendar must define
+	zones := make(icalZones)
+	seriesMap := make(map[string]IcalSeries)
+	for _, s := range series {
+		seriesMap[s.Lesson.Id] = s
+		addIcalSeries(cal, s, zones)
+	}
+
 	for i := 0; i < len(lessons); i++ {
 		lesson := lessons[i]
+		if _, found := seriesMap[lesson.Id]; lesson.IsAbstract && found {
+			continue
+		}
 
-		event := cal.AddEvent(lesson.Id)
+		id := lesson.Id
+		parent, occurrence, override := overriddenOccurrence(lesson, seriesMap)
+		if override {
+			id = parent.Lesson.Id
+		}
+
+		event := cal.AddEvent(id)
 		event.SetCreatedTime(lesson.CreationTime)
 		event.SetDtStampTime(lesson.CreationTime)
 		event.SetModifiedAt(lesson.EditTime)
-		event.SetStartAt(lesson.StartTime)
-		event.SetEndAt(lesson.EndTime)
+		if override {
+			value, params := zones.time(occurrence.StartTime)
+			event.SetProperty(ics.ComponentProperty(ics.PropertyRecurrenceId), value, params...)
+			loc := occurrence.StartTime.Location()
+			zones.setTimes(event, lesson.StartTime.In(loc), lesson.EndTime.In(loc))
+		} else {
+			event.SetStartAt(lesson.StartTime)
+			event.SetEndAt(lesson.EndTime)
+		}
 		event.SetSummary(lesson.Summary)
 		event.SetLocation(lesson.Location)
 		event.SetDescription(lesson.Description)
+		if lesson.Cancelled {
+			event.SetStatus(ics.ObjectStatusCancelled)
+		}
 	}
 
+	zones.addTimezones(cal)
 	return cal.Serialize()
 }
+
+func ExportLessonsAsIcal(lessons []ActualLesson) string {
+	return ExportTimetableAsIcal(lessons, nil)
+}
+
+/*
+ * Gets a user's timetable as an iCal feed, see ExportTimetableAsIcal.
+ */
+func GetIcalTimetable(ctx context.Context, userId string, pool *utils.DatabasePool) (string, error) {
+	lessons, err := GetLessons(ctx, userId, pool)
+	if err != nil {
+		return "", err
+	}
+
+	repeatingLessons, err := GetRepeatingLessons(ctx, userId, pool)
+	if err != nil {
+		return "", err
+	}
+
+	closures, err := GetClosures(ctx, time.Time{}, time.Time{}, pool)
+	if err != nil {
+		return "", err
+	}
+
+	series := make([]IcalSeries, 0, len(repeatingLessons))
+	for _, lesson := range repeatingLessons {
+		s := IcalSeries{Lesson: lesson}
+		err = pool.Database.QueryRowContext(ctx, "select summary, description, location from group_lessons where id = $1;",
+			lesson.GroupLessonId).Scan(&s.Summary, &s.Description, &s.Location)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return "", errors.New("Cannot find group lesson")
+		}
+
+		s.Lesson.Exceptions, err = GetRepeatingLessonExceptions(ctx, lesson.Id, pool)
+		if err != nil {
+			return "", err
+		}
+		s.Lesson = withClosures(s.Lesson, closures)
+
+		series = append(series, s)
+	}
+
+	return ExportTimetableAsIcal(lessons, series), nil
+}
@@ -1,8 +1,10 @@
 package model
 
 import (
+	"context"
 	"fmt"
 	"github.com/google/uuid"
+	"strings"
 	"testing"
 	"time"
 )
@@ -32,7 +34,7 @@ func TestLessonExport(t *testing.T) {
 }
 
 func BenchmarkAllUserLessonExports(t *testing.B) {
-	users, err := GetUsers(pool_lm)
+	users, err := GetUsers(context.Background(), pool_lm)
 	if err != nil {
 		t.Log("users are nil")
 		t.Fail()
@@ -40,7 +42,7 @@ func BenchmarkAllUserLessonExports(t *testing.B) {
 
 	count := 0
 	for i := 0; i < len(users); i++ {
-		lessons, err := GetLessons(users[i].InternalId, pool_lm)
+		lessons, err := GetLessons(context.Background(), users[i].InternalId, pool_lm)
 
 		if err != nil {
 			t.Log(fmt.Sprintf("%s has error getting lesson", users[i].Fname))
@@ -65,3 +67,59 @@ func BenchmarkAllUserLessonExports(t *testing.B) {
 		t.Fail()
 	}
 }
+
+func TestTimetableExportRecurrence(t *testing.T) {
+	// 10:00 on Mondays in London, the clocks go back on the 30th
+	series := IcalSeries{Lesson: RepeatingLesson{Id: uuid.New().String(),
+		GroupLessonId:  uuid.New().String(),
+		StartRepeating: time.Date(2022, 10, 17, 0, 0, 0, 0, time.UTC),
+		StopRepeating:  time.Date(2022, 11, 7, 0, 0, 0, 0, time.UTC),
+		StartTime:      time.Date(0, 1, 1, 10, 0, 0, 0, time.UTC),
+		EndTime:        time.Date(0, 1, 1, 11, 0, 0, 0, time.UTC),
+		RRule:          "FREQ=WEEKLY;BYDAY=MO",
+		RDates:         []time.Time{time.Date(2022, 10, 20, 14, 0, 0, 0, time.UTC)},
+		Exceptions:     []time.Time{time.Date(2022, 10, 24, 9, 0, 0, 0, time.UTC)},
+		Timezone:       "Europe/London"},
+		Summary: "Beans on toast"}
+
+	// A spawned occurrence that was moved to 11:00, and the lessons from GetLessons
+	spawned := ActualLesson{Id: uuid.New().String(),
+		StartTime:         time.Date(2022, 10, 31, 11, 0, 0, 0, time.UTC),
+		EndTime:           time.Date(2022, 10, 31, 12, 0, 0, 0, time.UTC),
+		RepeatingLessonId: series.Lesson.Id,
+		OccurrenceStart:   time.Date(2022, 10, 31, 10, 0, 0, 0, time.UTC)}
+	abstract := ActualLesson{Id: series.Lesson.Id,
+		StartTime:  time.Date(2022, 11, 7, 10, 0, 0, 0, time.UTC),
+		EndTime:    time.Date(2022, 11, 7, 11, 0, 0, 0, time.UTC),
+		IsAbstract: true}
+
+	output := ExportTimetableAsIcal([]ActualLesson{spawned, abstract}, []IcalSeries{series})
+	expected := []string{"DTSTART;TZID=Europe/London:20221017T100000",
+		"RRULE:FREQ=WEEKLY;UNTIL=20221107T100000Z;BYDAY=MO",
+		"EXDATE;TZID=Europe/London:20221024T100000",
+		"RDATE;TZID=Europe/London:20221020T140000",
+		"RECURRENCE-ID;TZID=Europe/London:20221031T100000",
+		"DTSTART;TZID=Europe/London:20221031T110000"}
+	for _, line := range expected {
+		if !strings.Contains(output, line) {
+			t.Logf("Expected %s in\n%s", line, output)
+			t.Fail()
+		}
+	}
+
+	// The TZID must be defined, the clocks change in March and, October
+	timezone := "BEGIN:VTIMEZONE\r\nTZID:Europe/London\r\n" +
+		"BEGIN:STANDARD\r\nDTSTART:20220101T000000\r\nTZOFFSETFROM:+0000\r\nTZOFFSETTO:+0000\r\nTZNAME:GMT\r\nEND:STANDARD\r\n" +
+		"BEGIN:DAYLIGHT\r\nDTSTART:20220327T010000\r\nTZOFFSETFROM:+0000\r\nTZOFFSETTO:+0100\r\nTZNAME:BST\r\nEND:DAYLIGHT\r\n" +
+		"BEGIN:STANDARD\r\nDTSTART:20221030T020000\r\nTZOFFSETFROM:+0100\r\nTZOFFSETTO:+0000\r\nTZNAME:GMT\r\nEND:STANDARD\r\n" +
+		"END:VTIMEZONE\r\n"
+	if !strings.Contains(output, timezone) || strings.Index(output, "BEGIN:VTIMEZONE") > strings.Index(output, "BEGIN:VEVENT") {
+		t.Logf("Expected the time zone before the events in\n%s", output)
+		t.Fail()
+	}
+
+	if strings.Count(output, "BEGIN:VEVENT") != 2 {
+		t.Log("Expected the series and, the override only", output)
+		t.Fail()
+	}
+}
@@ -1,9 +1,11 @@
 package model
 
 import (
+	"arcio/attendance-system/logging"
 	"arcio/attendance-system/utils"
+	"context"
+	"database/sql"
 	"errors"
-	"log"
 	"sort"
 	"sync"
 	"time"
@@ -11,21 +13,26 @@ import (
 	"github.com/google/uuid"
 )
 
-func CreateActualLesson(lesson ActualLesson, pool *utils.DatabasePool) error {
+func CreateActualLesson(ctx context.Context, lesson ActualLesson, pool *utils.DatabasePool) error {
+	return createActualLesson(ctx, &lesson, pool)
+}
+
+// Creates a lesson and, sets its id, see CreateActualLesson
+func createActualLesson(ctx context.Context, lesson *ActualLesson, pool *utils.DatabasePool) error {
 	lesson.CreationTime = time.Now()
 	lesson.EditTime = lesson.CreationTime
 	lesson.Id = uuid.New().String()
 
-	stmt, err := pool.Database.Prepare("select (summary, description, location) from group_lessons where id = $1;")
+	stmt, err := pool.Database.PrepareContext(ctx, "select (summary, description, location) from group_lessons where id = $1;")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(lesson.GroupLessonId)
+	rows, err := stmt.QueryContext(ctx, lesson.GroupLessonId)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer rows.Close()
@@ -38,52 +45,73 @@ func CreateActualLesson(lesson ActualLesson, pool *utils.DatabasePool) error {
 	var description string
 	var location string
 	rows.Scan(&summary, &description, &location)
+	rows.Close()
+
+	err = checkLessonBooking(ctx, lessonBooking{GroupLessonId: lesson.GroupLessonId,
+		RoomId:      lesson.RoomId,
+		Occurrences: lessonOccurrences(*lesson)}, time.Now(), pool)
+	if err != nil {
+		return err
+	}
 
-	stmt, err = pool.Database.Prepare("insert into actual_lessons (id, group_lesson_id, start_time, end_time, creation_time, edit_time, summary, description, location) values($1, $2, $3, $4, $5, $6, $7, $8, $9);")
+	stmt, err = pool.Database.PrepareContext(ctx, "insert into actual_lessons (id, group_lesson_id, start_time, end_time, creation_time, edit_time, summary, description, location, room_id) values($1, $2, $3, $4, $5, $6, $7, $8, $9, nullif($10, '')::uuid);")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(lesson.Id, lesson.GroupLessonId, lesson.StartTime, lesson.EndTime, lesson.CreationTime, lesson.EditTime, summary, description, location)
+	_, err = stmt.ExecContext(ctx, lesson.Id, lesson.GroupLessonId, lesson.StartTime, lesson.EndTime, lesson.CreationTime, lesson.EditTime, summary, description, location, lesson.RoomId)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 
+	lesson.Summary = summary
+	lesson.Description = description
+	lesson.Location = location
+	QueueWebhookEvent(EVENT_LESSON_CREATED, *lesson, pool)
+
 	return nil
 }
 
-//
-func GetRepeatingLessons(UserId string, pool *utils.DatabasePool) ([]RepeatingLesson, error) {
+// Gets a user's repeating lessons that have not stopped repeating
+func GetRepeatingLessons(ctx context.Context, UserId string, pool *utils.DatabasePool) ([]RepeatingLesson, error) {
+	return getRepeatingLessonsFrom(ctx, UserId, time.Now(), pool)
+}
+
+// Gets a user's repeating lessons that repeat on or, after a date
+func getRepeatingLessonsFrom(ctx context.Context, UserId string, from time.Time, pool *utils.DatabasePool) ([]RepeatingLesson, error) {
 	repeatingLessonsRet := make([]RepeatingLesson, 0)
 
-	getUpcomingRepeatingLessons, err := pool.Database.Prepare("SELECT repeating_lessons.id, repeating_lessons.start_repeating, repeating_lessons.stop_repeating, repeating_lessons.start_time, " +
-		"repeating_lessons.end_time, repeating_lessons.group_lesson_id, " +
-		"(EXTRACT(epoch FROM repeating_lessons.repeat_every) * 1000000000)::BIGINT, " +
-		"repeating_lessons.creation_time, repeating_lessons.edit_time " +
-		"FROM repeating_lessons " +
-		"INNER JOIN group_lessons ON group_lessons.id = repeating_lessons.group_lesson_id " +
-		"INNER JOIN module_groups ON module_groups.id = group_lessons.module_group_id " +
-		"INNER JOIN module_user_groups ON module_user_groups.module_group_id = module_groups.id " +
-		"INNER JOIN module_users ON module_users.id = module_user_groups.module_user_id " +
-		"INNER JOIN users ON users.id = module_users.user_id " +
-		"WHERE repeating_lessons.start_repeating <= CURRENT_DATE and repeating_lessons.stop_repeating >= CURRENT_DATE AND " +
+	getUpcomingRepeatingLessons, err := pool.Database.PrepareContext(ctx, "SELECT repeating_lessons.id, repeating_lessons.start_repeating, repeating_lessons.stop_repeating, repeating_lessons.start_time, "+
+		"repeating_lessons.end_time, repeating_lessons.group_lesson_id, "+
+		"(EXTRACT(epoch FROM repeating_lessons.repeat_every) * 1000000000)::BIGINT, "+
+		"repeating_lessons.creation_time, repeating_lessons.edit_time, repeating_lessons.last_spawned_time, "+
+		REPEATING_LESSON_RECURRENCE_COLUMNS+" "+
+		"FROM repeating_lessons "+
+		"INNER JOIN group_lessons ON group_lessons.id = repeating_lessons.group_lesson_id "+
+		"INNER JOIN module_groups ON module_groups.id = group_lessons.module_group_id "+
+		"INNER JOIN module_user_groups ON module_user_groups.module_group_id = module_groups.id "+
+		"INNER JOIN module_users ON module_users.id = module_user_groups.module_user_id "+
+		"INNER JOIN users ON users.id = module_users.user_id "+
+		"WHERE repeating_lessons.stop_repeating >= $2 AND "+
 		"users.id=$1")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 
-	rows, err := getUpcomingRepeatingLessons.Query(UserId)
+	rows, err := getUpcomingRepeatingLessons.QueryContext(ctx, UserId, toDate(from))
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 
 	for rows.Next() {
 		var singleRepeatingLesson RepeatingLesson
+		var lastSpawnedTime sql.NullTime
+		var rrule, rdate, timezone string
 		err := rows.Scan(&singleRepeatingLesson.Id,
 			&singleRepeatingLesson.StartRepeating,
 			&singleRepeatingLesson.StopRepeating,
@@ -92,12 +120,23 @@ func GetRepeatingLessons(UserId string, pool *utils.DatabasePool) ([]RepeatingLe
 			&singleRepeatingLesson.GroupLessonId,
 			&singleRepeatingLesson.RepeatEvery,
 			&singleRepeatingLesson.CreationTime,
-			&singleRepeatingLesson.EditTime)
-		repeatingLessonsRet = append(repeatingLessonsRet, singleRepeatingLesson)
+			&singleRepeatingLesson.EditTime,
+			&lastSpawnedTime,
+			&rrule,
+			&rdate,
+			&timezone)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		singleRepeatingLesson.LastSpawnedTime = lastSpawnedTime.Time
+		err = setRecurrenceColumns(&singleRepeatingLesson, rrule, rdate, timezone)
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(ctx).Error(err)
 			return nil, err
 		}
+		repeatingLessonsRet = append(repeatingLessonsRet, singleRepeatingLesson)
 	}
 
 	return repeatingLessonsRet, nil
@@ -105,7 +144,7 @@ func GetRepeatingLessons(UserId string, pool *utils.DatabasePool) ([]RepeatingLe
 
 const LESSON_QUERY_LIMIT = 1000
 
-func GetLessons(UserId string, Pool *utils.DatabasePool) ([]ActualLesson, error) {
+func GetLessons(ctx context.Context, UserId string, Pool *utils.DatabasePool) ([]ActualLesson, error) {
 	var wg sync.WaitGroup
 	var lock sync.Mutex
 	var reterr error = nil
@@ -116,27 +155,29 @@ func GetLessons(UserId string, Pool *utils.DatabasePool) ([]ActualLesson, error)
 	// Get one off lessons
 	go func() {
 		defer wg.Done()
-		stmt, err := Pool.Database.Prepare("select actual_lessons.id, actual_lessons.group_lesson_id, " +
-			"actual_lessons.start_time, actual_lessons.end_time, actual_lessons.creation_time, " +
-			"actual_lessons.edit_time, actual_lessons.description, actual_lessons.summary, " +
-			"actual_lessons.location " +
-			"from group_lessons, actual_lessons, module_user_groups, module_users " +
-			"where " +
-			"group_lessons.module_group_id = module_user_groups.module_group_id and " +
-			"module_user_groups.module_user_id = module_users.id and module_users.user_id = $1 and " +
-			"actual_lessons.group_lesson_id = group_lessons.id and " +
-			"end_time >= CURRENT_TIMESTAMP - interval '3 weeks'" +
+		stmt, err := Pool.Database.PrepareContext(ctx, "select actual_lessons.id, actual_lessons.group_lesson_id, "+
+			"actual_lessons.start_time, actual_lessons.end_time, actual_lessons.creation_time, "+
+			"actual_lessons.edit_time, actual_lessons.description, actual_lessons.summary, "+
+			"actual_lessons.location, actual_lessons.cancelled, "+
+			"coalesce(actual_lessons.repeating_lesson_id::text, ''), actual_lessons.occurrence_start, "+
+			"coalesce(actual_lessons.room_id, group_lessons.room_id)::text "+
+			"from group_lessons, actual_lessons, module_user_groups, module_users "+
+			"where "+
+			"group_lessons.module_group_id = module_user_groups.module_group_id and "+
+			"module_user_groups.module_user_id = module_users.id and module_users.user_id = $1 and "+
+			"actual_lessons.group_lesson_id = group_lessons.id and "+
+			"end_time >= CURRENT_TIMESTAMP - interval '3 weeks'"+
 			"order by start_time asc;")
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(ctx).Error(err)
 			reterr = err
 			return
 		}
 		defer stmt.Close()
 
-		rows, err := stmt.Query(UserId)
+		rows, err := stmt.QueryContext(ctx, UserId)
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(ctx).Error(err)
 			reterr = err
 			return
 		}
@@ -144,11 +185,16 @@ func GetLessons(UserId string, Pool *utils.DatabasePool) ([]ActualLesson, error)
 
 		for rows.Next() && len(ret) < LESSON_QUERY_LIMIT {
 			var lesson ActualLesson
-			err := rows.Scan(&lesson.Id, &lesson.GroupLessonId, &lesson.StartTime, &lesson.EndTime, &lesson.CreationTime, &lesson.EditTime, &lesson.Description, &lesson.Summary, &lesson.Location)
+			var occurrenceStart sql.NullTime
+			var roomId sql.NullString
+			err := rows.Scan(&lesson.Id, &lesson.GroupLessonId, &lesson.StartTime, &lesson.EndTime, &lesson.CreationTime, &lesson.EditTime, &lesson.Description, &lesson.Summary, &lesson.Location, &lesson.Cancelled,
+				&lesson.RepeatingLessonId, &occurrenceStart, &roomId)
+			lesson.OccurrenceStart = occurrenceStart.Time
+			lesson.RoomId = roomId.String
 
 			if err != nil {
 				reterr = err
-				log.Println(err)
+				logging.FromContext(ctx).Error(err)
 				return
 			}
 
@@ -161,9 +207,16 @@ func GetLessons(UserId string, Pool *utils.DatabasePool) ([]ActualLesson, error)
 	go func() {
 		defer wg.Done()
 		// Get repeating lessons
-		lessons, err := GetRepeatingLessons(UserId, Pool)
+		lessons, err := GetRepeatingLessons(ctx, UserId, Pool)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			reterr = err
+			return
+		}
+
+		// Closed days are skipped, see academic_calendar.go
+		closures, err := GetClosures(ctx, time.Now(), time.Time{}, Pool)
 		if err != nil {
-			log.Println(err)
 			reterr = err
 			return
 		}
@@ -176,18 +229,18 @@ func GetLessons(UserId string, Pool *utils.DatabasePool) ([]ActualLesson, error)
 				defer wg_inner.Done()
 				rlesson := lessons[i]
 
-				stmt, err := Pool.Database.Prepare("select summary, description, location from group_lessons where id = $1;")
+				stmt, err := Pool.Database.PrepareContext(ctx, "select summary, description, location, coalesce(room_id::text, '') from group_lessons where id = $1;")
 				if err != nil {
 					reterr = err
-					log.Println(err)
+					logging.FromContext(ctx).Error(err)
 					return
 				}
 				defer stmt.Close()
 
-				rows, err := stmt.Query(rlesson.GroupLessonId)
+				rows, err := stmt.QueryContext(ctx, rlesson.GroupLessonId)
 				if err != nil {
 					reterr = err
-					log.Println(err)
+					logging.FromContext(ctx).Error(err)
 					return
 				}
 				defer rows.Close()
@@ -195,57 +248,59 @@ func GetLessons(UserId string, Pool *utils.DatabasePool) ([]ActualLesson, error)
 				if !rows.Next() {
 					err := errors.New("Cannot find group lesson")
 					reterr = err
-					log.Println(err)
+					logging.FromContext(ctx).Error(err)
 					return
 				}
 
 				var summary string
 				var description string
 				var location string
-				err = rows.Scan(&summary, &description, &location)
+				var roomId string
+				err = rows.Scan(&summary, &description, &location, &roomId)
 				if err != nil {
 					reterr = err
-					log.Println(err)
+					logging.FromContext(ctx).Error(err)
 					return
 				}
 
-				now := time.Now()
-				var epoch time.Time
-				// you need to add one because it was off by one for some reason - idk man
-				lesson := ActualLesson{Id: rlesson.Id,
-					GroupLessonId: rlesson.GroupLessonId,
-					CreationTime:  rlesson.CreationTime,
-					EditTime:      rlesson.EditTime,
-					StartTime:     rlesson.StartRepeating.Add(rlesson.StartTime.Sub(epoch)),
-					EndTime:       rlesson.StartRepeating.Add(rlesson.EndTime.Sub(epoch)),
-					Summary:       summary,
-					Description:   description,
-					Location:      location,
-					IsAbstract:    true}
+				rlesson.Exceptions, err = GetRepeatingLessonExceptions(ctx, rlesson.Id, Pool)
+				if err != nil {
+					reterr = err
+					return
+				}
+				rlesson = withClosures(rlesson, closures)
+
+				// Series from before lessons repeated at most daily are skipped
+				recurrence, err := lessonRecurrence(rlesson)
+				if err != nil {
+					logging.FromContext(ctx).Errorf("Repeating lesson %s has an invalid rule - %s", rlesson.Id, err)
+					return
+				}
 
 				// Sanity check: I do not want to add too many lessons,
 				// lets say they want lessons from now to 9999 then that will be silly
-				for j := 0; j < LESSON_QUERY_LIMIT; j++ {
-					// Check upper bound
-					if lesson.StartTime.After(rlesson.StopRepeating) {
-						break
+				now := time.Now()
+				count := 0
+				recurrence.Each(func(occurrence Occurrence) bool {
+					// Spawned lessons are accounted in the actual lessons check, cancelled
+					// and moved lessons are exceptions. Only add lessons that after now()
+					if occurrence.Exception || !occurrence.Start.After(now) || !occurrence.Start.After(rlesson.LastSpawnedTime) {
+						return true
 					}
 
-					// Spawned lessons are accounted in the actual lessons check
-					if lesson.StartTime.After(rlesson.LastSpawnedTime) {
+					lesson := occurrenceLesson(rlesson, occurrence)
+					lesson.Summary = summary
+					lesson.Description = description
+					lesson.Location = location
+					lesson.RoomId = roomId
 
-						// Only add lessons that after now()
-						if lesson.StartTime.After(now) {
-							lessonCopy := lesson
-							lock.Lock()
-							ret = append(ret, lessonCopy)
-							lock.Unlock()
-						}
-					}
+					lock.Lock()
+					ret = append(ret, lesson)
+					lock.Unlock()
 
-					lesson.StartTime = lesson.StartTime.Add(rlesson.RepeatEvery)
-					lesson.EndTime = lesson.EndTime.Add(rlesson.RepeatEvery)
-				}
+					count++
+					return count < LESSON_QUERY_LIMIT
+				})
 			}(__i)
 		}
 
@@ -259,7 +314,7 @@ func GetLessons(UserId string, Pool *utils.DatabasePool) ([]ActualLesson, error)
 
 	// Sort and truncate
 	if len(ret) > LESSON_QUERY_LIMIT {
-		log.Println("Truncated lessons")
+		logging.FromContext(ctx).Info("Truncated lessons")
 		ret = ret[0 : LESSON_QUERY_LIMIT+1] // Slice to LESSON_QUERY_LIMIT
 	}
 	sort.SliceStable(ret, func(i int, j int) bool {
@@ -269,22 +324,22 @@ func GetLessons(UserId string, Pool *utils.DatabasePool) ([]ActualLesson, error)
 	return ret, nil
 }
 
-func GetAllCurrentLessons(DatabasePool *utils.DatabasePool) ([]ActualLesson, error) {
+func GetAllCurrentLessons(ctx context.Context, DatabasePool *utils.DatabasePool) ([]ActualLesson, error) {
 	var returnActualLessons []ActualLesson
 
-	getActualLessons, err := DatabasePool.Database.Prepare("SELECT id, group_lesson_id, start_time, end_time, " +
-		"creation_time, edit_time, summary, description, location " +
-		"FROM actual_lessons " +
-		"WHERE start_time <= CURRENT_TIMESTAMP AND end_time >= CURRENT_TIMESTAMP")
+	getActualLessons, err := DatabasePool.Database.PrepareContext(ctx, "SELECT id, group_lesson_id, start_time, end_time, "+
+		"creation_time, edit_time, summary, description, location "+
+		"FROM actual_lessons "+
+		"WHERE start_time <= CURRENT_TIMESTAMP AND end_time >= CURRENT_TIMESTAMP AND NOT cancelled")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer getActualLessons.Close()
 
-	rows, err := getActualLessons.Query()
+	rows, err := getActualLessons.QueryContext(ctx)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -299,7 +354,7 @@ func GetAllCurrentLessons(DatabasePool *utils.DatabasePool) ([]ActualLesson, err
 	return returnActualLessons, err
 }
 
-func GetLessonsDetails(lessons []ActualLesson, pool *utils.DatabasePool) (LessonsRet, error) {
+func GetLessonsDetails(ctx context.Context, lessons []ActualLesson, pool *utils.DatabasePool) (LessonsRet, error) {
 	var ret LessonsRet = LessonsRet{Lessons: lessons,
 		GroupLessons: make([]GroupLesson, 0),
 		Modules:      make([]Module, 0)}
@@ -312,23 +367,23 @@ func GetLessonsDetails(lessons []ActualLesson, pool *utils.DatabasePool) (Lesson
 		_, seen := seenGrouops[lessons[i].GroupLessonId]
 		if !seen {
 			seenGrouops[lessons[i].GroupLessonId] = lessons[i].GroupLessonId
-			stmt, err := pool.Database.Prepare("select id, module_group_id, attendance_required, creation_time, edit_time, summary, description, location from group_lessons where id = $1;")
+			stmt, err := pool.Database.PrepareContext(ctx, "select id, module_group_id, attendance_required, creation_time, edit_time, summary, description, location, coalesce(room_id::text, '') from group_lessons where id = $1;")
 			if err != nil {
-				log.Println(err)
+				logging.FromContext(ctx).Error(err)
 				return ret, err
 			}
 			defer stmt.Close()
 
-			rows, err := stmt.Query(lessons[i].GroupLessonId)
+			rows, err := stmt.QueryContext(ctx, lessons[i].GroupLessonId)
 			if err != nil {
-				log.Println(err)
+				logging.FromContext(ctx).Error(err)
 				return ret, err
 			}
 			defer rows.Close()
 
 			for rows.Next() {
 				var gl GroupLesson
-				rows.Scan(&gl.Id, &gl.ModuleGroupId, &gl.AttendanceRequired, &gl.CreationTime, &gl.EditTime, &gl.Summary, &gl.Description, &gl.Location)
+				rows.Scan(&gl.Id, &gl.ModuleGroupId, &gl.AttendanceRequired, &gl.CreationTime, &gl.EditTime, &gl.Summary, &gl.Description, &gl.Location, &gl.RoomId)
 
 				ret.GroupLessons = append(ret.GroupLessons, gl)
 				seenModules[gl.ModuleGroupId] = gl.ModuleGroupId
@@ -338,15 +393,15 @@ func GetLessonsDetails(lessons []ActualLesson, pool *utils.DatabasePool) (Lesson
 
 	// Get Modules
 	for _, key := range seenModules {
-		stmt, err := pool.Database.Prepare("select modules.id, modules.name, modules.external_id, modules.creation_time, modules.edit_time from modules, module_groups where module_groups.id = $1 and modules.id = module_groups.module_id;")
+		stmt, err := pool.Database.PrepareContext(ctx, "select modules.id, modules.name, modules.external_id, modules.creation_time, modules.edit_time from modules, module_groups where module_groups.id = $1 and modules.id = module_groups.module_id;")
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(ctx).Error(err)
 			return ret, err
 		}
 
-		rows, err := stmt.Query(key)
+		rows, err := stmt.QueryContext(ctx, key)
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(ctx).Error(err)
 			return ret, err
 		}
 
@@ -361,57 +416,414 @@ func GetLessonsDetails(lessons []ActualLesson, pool *utils.DatabasePool) (Lesson
 	return ret, nil
 }
 
-func CreateRepeatingLesson(lesson RepeatingLesson, pool *utils.DatabasePool) error {
+func CreateRepeatingLesson(ctx context.Context, lesson RepeatingLesson, pool *utils.DatabasePool) error {
 
 	// Search for group lesson with matching id
-	query, err := pool.Database.Query("SELECT * FROM group_lessons WHERE id = $1;", lesson.GroupLessonId)
+	query, err := pool.Database.QueryContext(ctx, "SELECT * FROM group_lessons WHERE id = $1;", lesson.GroupLessonId)
 	if err != nil || !query.Next() {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return errors.New("no group lesson found with matching id")
 	}
 	defer query.Close()
 
+	// An RRULE takes priority over repeat every, see recurrence.go
+	if lesson.RRule != "" {
+		rule, err := ParseRecurrenceRule(lesson.RRule)
+		if err != nil {
+			return err
+		}
+
+		lesson.RRule = rule.String()
+		if lesson.RepeatEvery == 0 {
+			lesson.RepeatEvery = rule.Period()
+		}
+	}
+
+	// EXDATEs are wall clock times in the time zone like RDATEs, exceptions are the starts of occurrences
+	exceptions := make([]time.Time, len(lesson.Exceptions))
+	for i, exception := range lesson.Exceptions {
+		exceptions[i] = inLocation(exception, lessonLocation(lesson))
+	}
+	lesson.Exceptions = exceptions
+
+	err = checkRecurrenceLimit(lesson)
+	if err != nil {
+		return err
+	}
+
+	err = checkRepeatingLessonBooking(ctx, lesson, lesson.StartRepeating, nil, pool)
+	if err != nil {
+		return err
+	}
+
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
 	// create repeating lesson
-	_, err = pool.Database.Exec("INSERT INTO repeating_lessons "+
+	_, err = tx.ExecContext(ctx, "INSERT INTO repeating_lessons "+
 		"(id, group_lesson_id, start_repeating, stop_repeating,"+
-		"start_time, end_time, repeat_every, creation_time, edit_time)"+
-		"VALUES ($1,$2,$3,$4,$5,$6,to_timestamp($7),$8,$9);",
+		"start_time, end_time, repeat_every, creation_time, edit_time, rrule, rdate, timezone)"+
+		"VALUES ($1,$2,$3,$4,$5,$6,$7 * interval '1 second',$8,$9,$10,$11,$12);",
 		lesson.Id, lesson.GroupLessonId, lesson.StartRepeating, lesson.StopRepeating,
-		lesson.StartTime, lesson.EndTime, lesson.RepeatEvery.Seconds(), lesson.CreationTime, lesson.EditTime)
+		lesson.StartTime, lesson.EndTime, lesson.RepeatEvery.Seconds(), lesson.CreationTime, lesson.EditTime,
+		lesson.RRule, formatRDates(lesson.RDates), lesson.Timezone)
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return errors.New("issuing creating new repeating lesson")
 	}
 
+	// EXDATEs are stored as exceptions, the same as cancelled occurrences
+	for _, exception := range lesson.Exceptions {
+		err = addRepeatingLessonException(ctx, tx, lesson.Id, exception)
+		if err != nil {
+			return errors.New("issuing creating new repeating lesson")
+		}
+	}
+
+	success = true
 	return nil
 }
 
-func CreateGroupLesson(group *GroupLesson, pool *utils.DatabasePool) error {
+func CreateGroupLesson(ctx context.Context, group *GroupLesson, pool *utils.DatabasePool) error {
 
 	group.CreationTime = time.Now()
 	group.EditTime = time.Now()
 	group.Id = uuid.NewString()
 
 	// Check group lesson exists
-	query, err := pool.Database.Query("SELECT * FROM module_groups WHERE id = $1;", group.ModuleGroupId)
+	query, err := pool.Database.QueryContext(ctx, "SELECT * FROM module_groups WHERE id = $1;", group.ModuleGroupId)
 	if err != nil || !query.Next() {
 		return errors.New("ot module group with matching id found")
 	}
 	defer query.Close()
 
 	// Create lesson group
-	_, err = pool.Database.Exec("INSERT INTO public.group_lessons "+
+	_, err = pool.Database.ExecContext(ctx, "INSERT INTO public.group_lessons "+
 		"(id, module_group_id, \"name\", creation_time, "+
-		"edit_time, attendance_required, description, \"location\", summary) "+
-		"VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9);",
+		"edit_time, attendance_required, description, \"location\", summary, room_id) "+
+		"VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, nullif($10, '')::uuid);",
 		group.Id, group.ModuleGroupId, group.Name, group.CreationTime, group.EditTime,
-		group.AttendanceRequired, group.Description, group.Location, group.Summary)
+		group.AttendanceRequired, group.Description, group.Location, group.Summary, group.RoomId)
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return errors.New("issue creating lesson group")
 	}
 
 	return nil
 }
+
+// Returned when deleting something that has lessons attached without cascading
+var ErrHasLessons = errors.New("There are lessons attached, set cascade to delete them")
+var ErrGroupLessonNotFound = errors.New("Cannot find group lesson with matching id")
+
+// Conditions on group_lessons used to select which group lessons to delete, these take a single id ($1)
+const (
+	GROUP_LESSON_BY_ID           = "group_lessons.id = $1"
+	GROUP_LESSON_BY_MODULE_GROUP = "group_lessons.module_group_id = $1"
+	GROUP_LESSON_BY_MODULE       = "group_lessons.module_group_id in (select module_groups.id from module_groups where module_groups.module_id = $1)"
+)
+
+/*
+ * Counts the actual and, repeating lessons attached to the group lessons matching the condition.
+ */
+func countAttachedLessons(ctx context.Context, tx *sql.Tx, condition string, id string) (int, error) {
+	stmt, err := tx.PrepareContext(ctx, "select "+
+		"(select count(actual_lessons.id) from actual_lessons, group_lessons "+
+		"where actual_lessons.group_lesson_id = group_lessons.id and "+condition+") + "+
+		"(select count(repeating_lessons.id) from repeating_lessons, group_lessons "+
+		"where repeating_lessons.group_lesson_id = group_lessons.id and "+condition+");")
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var count int
+	err = stmt.QueryRowContext(ctx, id).Scan(&count)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+/*
+ * Deletes the group lessons matching the condition, their lessons and, the attendance for the lessons.
+ * If cascade is false then ErrHasLessons is returned when there are lessons attached.
+ */
+func deleteGroupLessons(ctx context.Context, tx *sql.Tx, condition string, id string, cascade bool) error {
+	if !cascade {
+		count, err := countAttachedLessons(ctx, tx, condition, id)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return ErrHasLessons
+		}
+	}
+
+	lessons := "(select actual_lessons.id from actual_lessons, group_lessons " +
+		"where actual_lessons.group_lesson_id = group_lessons.id and " + condition + ")"
+	groupLessons := "(select group_lessons.id from group_lessons where " + condition + ")"
+
+	// Children first
+	queries := []string{"delete from attendance where lesson_id in " + lessons + ";",
+		"delete from checkin_sessions where lesson_id in " + lessons + ";",
+		"delete from actual_lessons where group_lesson_id in " + groupLessons + ";",
+		"delete from repeating_lesson_exceptions where repeating_lesson_id in " +
+			"(select repeating_lessons.id from repeating_lessons where repeating_lessons.group_lesson_id in " + groupLessons + ");",
+		"delete from repeating_lessons where group_lesson_id in " + groupLessons + ";",
+		"delete from group_lessons where " + condition + ";"}
+
+	for _, query := range queries {
+		_, err := tx.ExecContext(ctx, query, id)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func GetGroupLessons(ctx context.Context, moduleGroupId string, pool *utils.DatabasePool) ([]GroupLesson, error) {
+	stmt, err := pool.Database.PrepareContext(ctx, "select id, module_group_id, name, attendance_required, creation_time, edit_time, summary, description, location, "+
+		"coalesce(room_id::text, '') "+
+		"from group_lessons where module_group_id = $1;")
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, moduleGroupId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]GroupLesson, 0)
+	for rows.Next() {
+		var gl GroupLesson
+		err = rows.Scan(&gl.Id, &gl.ModuleGroupId, &gl.Name, &gl.AttendanceRequired, &gl.CreationTime, &gl.EditTime, &gl.Summary, &gl.Description, &gl.Location, &gl.RoomId)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret = append(ret, gl)
+	}
+
+	return ret, nil
+}
+
+/*
+ * Updates a group lesson in a module group of the module, the summary, description and, location
+ * of lessons that have not started yet are updated to match.
+ */
+func UpdateGroupLesson(ctx context.Context, moduleId string, group *GroupLesson, pool *utils.DatabasePool) error {
+	if group.Name == "" {
+		return errors.New("The group lesson name cannot be empty")
+	}
+
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	group.EditTime = time.Now()
+	err = tx.QueryRowContext(ctx, "update group_lessons set "+
+		"name = $3, attendance_required = $4, summary = $5, description = $6, location = $7, edit_time = $8, "+
+		"room_id = nullif($9, '')::uuid "+
+		"where id = $1 and module_group_id = $2 and "+
+		"module_group_id in (select id from module_groups where module_id = $10) returning creation_time;",
+		group.Id, group.ModuleGroupId, group.Name, group.AttendanceRequired, group.Summary, group.Description, group.Location, group.EditTime,
+		group.RoomId, moduleId).Scan(&group.CreationTime)
+	if err == sql.ErrNoRows {
+		return ErrGroupLessonNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "update actual_lessons set summary = $2, description = $3, location = $4, edit_time = $5 "+
+		"where group_lesson_id = $1 and start_time > CURRENT_TIMESTAMP;",
+		group.Id, group.Summary, group.Description, group.Location, group.EditTime)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	success = true
+	return nil
+}
+
+/*
+ * Deletes a group lesson in a module group of the module, if cascade is set then its lessons and,
+ * their attendance are deleted otherwise ErrHasLessons is returned if there are any lessons.
+ */
+func DeleteGroupLesson(ctx context.Context, groupLessonId string, moduleId string, moduleGroupId string, cascade bool, pool *utils.DatabasePool) error {
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, "select exists(select 1 from group_lessons, module_groups "+
+		"where group_lessons.id = $1 and group_lessons.module_group_id = $2 and "+
+		"module_groups.id = group_lessons.module_group_id and module_groups.module_id = $3);",
+		groupLessonId, moduleGroupId, moduleId).Scan(&exists)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	if !exists {
+		return ErrGroupLessonNotFound
+	}
+
+	err = deleteGroupLessons(ctx, tx, GROUP_LESSON_BY_ID, groupLessonId, cascade)
+	if err != nil {
+		return err
+	}
+
+	logging.FromContext(ctx).Infof("Deleted group lesson %s", groupLessonId)
+
+	success = true
+	return nil
+}
+
+/*
+ * Gets a user's lessons that start in [from, to), including the occurrences of repeating
+ * lessons that have not been spawned yet. Unlike GetLessons past lessons are included.
+ */
+func GetLessonsBetween(ctx context.Context, userId string, from time.Time, to time.Time, pool *utils.DatabasePool) ([]ActualLesson, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select actual_lessons.id, actual_lessons.group_lesson_id, "+
+		"actual_lessons.start_time, actual_lessons.end_time, actual_lessons.creation_time, "+
+		"actual_lessons.edit_time, actual_lessons.description, actual_lessons.summary, "+
+		"actual_lessons.location, actual_lessons.cancelled, "+
+		"coalesce(actual_lessons.repeating_lesson_id::text, ''), actual_lessons.occurrence_start, "+
+		"coalesce(coalesce(actual_lessons.room_id, group_lessons.room_id)::text, '') "+
+		"from actual_lessons "+
+		"inner join group_lessons on group_lessons.id = actual_lessons.group_lesson_id "+
+		"inner join module_user_groups on module_user_groups.module_group_id = group_lessons.module_group_id "+
+		"inner join module_users on module_users.id = module_user_groups.module_user_id "+
+		"where module_users.user_id = $1 and actual_lessons.start_time >= $2 and actual_lessons.start_time < $3 "+
+		"order by actual_lessons.start_time asc limit $4;",
+		userId, from.UTC(), to.UTC(), LESSON_QUERY_LIMIT)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]ActualLesson, 0)
+	for rows.Next() {
+		var lesson ActualLesson
+		var occurrenceStart sql.NullTime
+		err = rows.Scan(&lesson.Id, &lesson.GroupLessonId, &lesson.StartTime, &lesson.EndTime, &lesson.CreationTime,
+			&lesson.EditTime, &lesson.Description, &lesson.Summary, &lesson.Location, &lesson.Cancelled,
+			&lesson.RepeatingLessonId, &occurrenceStart, &lesson.RoomId)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		lesson.OccurrenceStart = occurrenceStart.Time
+		ret = append(ret, lesson)
+	}
+	rows.Close()
+
+	repeatingLessons, err := getRepeatingLessonsFrom(ctx, userId, from, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	closures, err := GetClosures(ctx, from, to, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rlesson := range repeatingLessons {
+		rlesson.Exceptions, err = GetRepeatingLessonExceptions(ctx, rlesson.Id, pool)
+		if err != nil {
+			return nil, err
+		}
+		rlesson = withClosures(rlesson, closures)
+
+		// Series from before lessons repeated at most daily are skipped
+		recurrence, err := lessonRecurrence(rlesson)
+		if err != nil {
+			logging.FromContext(ctx).Errorf("Repeating lesson %s has an invalid rule - %s", rlesson.Id, err)
+			continue
+		}
+
+		var summary, description, location, roomId string
+		err = pool.Database.QueryRowContext(ctx, "select summary, description, location, coalesce(room_id::text, '') from group_lessons where id = $1;",
+			rlesson.GroupLessonId).Scan(&summary, &description, &location, &roomId)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, errors.New("Cannot find group lesson")
+		}
+
+		// Spawned lessons are accounted in the actual lessons query
+		for _, occurrence := range recurrence.Between(from, to) {
+			if occurrence.Exception || !occurrence.Start.After(rlesson.LastSpawnedTime) {
+				continue
+			}
+
+			lesson := occurrenceLesson(rlesson, occurrence)
+			lesson.Summary = summary
+			lesson.Description = description
+			lesson.Location = location
+			lesson.RoomId = roomId
+			ret = append(ret, lesson)
+		}
+	}
+
+	sort.SliceStable(ret, func(i int, j int) bool {
+		return ret[i].StartTime.Before(ret[j].StartTime)
+	})
+	if len(ret) > LESSON_QUERY_LIMIT {
+		ret = ret[:LESSON_QUERY_LIMIT]
+	}
+
+	return ret, nil
+}
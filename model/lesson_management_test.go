@@ -3,6 +3,7 @@ package model
 import (
 	"arcio/attendance-system/config"
 	"arcio/attendance-system/utils"
+	"context"
 	"fmt"
 	"github.com/google/uuid"
 	"log"
@@ -34,7 +35,7 @@ func TestCreateLessonBadGId(t *testing.T) {
 		StartTime: time.Now(),
 		EndTime:   time.Unix(time.Now().Unix()+4000, 0)}
 
-	err := CreateActualLesson(newActualLesson, pool_lm)
+	err := CreateActualLesson(context.Background(), newActualLesson, pool_lm)
 	if err == nil {
 		t.Log(err)
 		t.Fail()
@@ -42,7 +43,7 @@ func TestCreateLessonBadGId(t *testing.T) {
 }
 
 func TestGetLessons(t *testing.T) {
-	lessons, err := GetLessons(uuid.New().String(), pool_lm)
+	lessons, err := GetLessons(context.Background(), uuid.New().String(), pool_lm)
 	if err != nil {
 		t.Log("A random uuid should return no lessons and no err")
 		t.Log(err)
@@ -56,7 +57,7 @@ func TestGetLessons(t *testing.T) {
 }
 
 func TestGetLessonsWithRet(t *testing.T) {
-	users, err := GetUsers(pool_lm)
+	users, err := GetUsers(context.Background(), pool_lm)
 	if err != nil {
 		t.Log("users are nil")
 		t.Fail()
@@ -68,7 +69,7 @@ func TestGetLessonsWithRet(t *testing.T) {
 			break
 		}
 		t.Log(fmt.Sprintf("%d/%d done...", i, len(users)))
-		lessons, err := GetLessons(users[i].InternalId, pool_lm)
+		lessons, err := GetLessons(context.Background(), users[i].InternalId, pool_lm)
 		t.Log(fmt.Sprintf("%s has %d lessons", users[i].Fname, len(lessons)))
 
 		if err != nil {
@@ -91,7 +92,7 @@ func TestGetLessonsWithRet(t *testing.T) {
 			}
 
 			// Test GetLessonsDetails
-			deets, err := GetLessonsDetails(lessons, pool_lm)
+			deets, err := GetLessonsDetails(context.Background(), lessons, pool_lm)
 			if err != nil {
 				t.Log(err)
 				t.Log("Cannot get lesson details")
@@ -124,3 +125,29 @@ func TestGetLessonsWithRet(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestUpdateGroupLessonNoName(t *testing.T) {
+	groupLesson := GroupLesson{Id: uuid.New().String(), ModuleGroupId: uuid.New().String()}
+	err := UpdateGroupLesson(context.Background(), uuid.New().String(), &groupLesson, pool_lm)
+	if err == nil {
+		t.Log("Updating a group lesson with no name should fail")
+		t.Fail()
+	}
+}
+
+func TestUpdateMissingGroupLesson(t *testing.T) {
+	groupLesson := GroupLesson{Id: uuid.New().String(), ModuleGroupId: uuid.New().String(), Name: "Test"}
+	err := UpdateGroupLesson(context.Background(), uuid.New().String(), &groupLesson, pool_lm)
+	if err == nil {
+		t.Log("Updating a group lesson that does not exist should fail")
+		t.Fail()
+	}
+}
+
+func TestDeleteMissingGroupLesson(t *testing.T) {
+	err := DeleteGroupLesson(context.Background(), uuid.New().String(), uuid.New().String(), uuid.New().String(), true, pool_lm)
+	if err == nil {
+		t.Log("Deleting a group lesson that does not exist should fail")
+		t.Fail()
+	}
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"arcio/attendance-system/config"
 	"arcio/attendance-system/utils"
+	"context"
 	"log"
 	"testing"
 
@@ -31,7 +32,7 @@ func TestInit(t *testing.T) {
 }
 
 func TestGetUsersForModule(t *testing.T) {
-	empty_users, err := GetUsersForModule(uuid.New().String(), pool)
+	empty_users, err := GetUsersForModule(context.Background(), uuid.New().String(), pool)
 	if err != nil {
 		t.Log("Expected success for random id")
 		t.Log(err)
@@ -43,7 +44,7 @@ func TestGetUsersForModule(t *testing.T) {
 		t.Fail()
 	}
 
-	nil_users, err := GetUsersForModule("fail", pool)
+	nil_users, err := GetUsersForModule(context.Background(), "fail", pool)
 	if err == nil {
 		t.Log("Expected failure for bad course id")
 		t.Fail()
@@ -56,7 +57,7 @@ func TestGetUsersForModule(t *testing.T) {
 }
 
 func TestGetGroupsForCourse(t *testing.T) {
-	groups, err := GetGroupsForModule(uuid.New().String(), pool)
+	groups, err := GetGroupsForModule(context.Background(), uuid.New().String(), pool)
 	if err != nil {
 		t.Log("Expected success for random id")
 		t.Log(err)
@@ -68,7 +69,7 @@ func TestGetGroupsForCourse(t *testing.T) {
 		t.Fail()
 	}
 
-	nil_groups, err := GetGroupsForModule("fail", pool)
+	nil_groups, err := GetGroupsForModule(context.Background(), "fail", pool)
 	if err == nil {
 		t.Log("Expected failure for bad course id")
 		t.Fail()
@@ -81,14 +82,14 @@ func TestGetGroupsForCourse(t *testing.T) {
 }
 
 func TestGetUserModules(t *testing.T) {
-	groups, err := GetUsersModules("fail", pool)
+	groups, err := GetUsersModules(context.Background(), "fail", pool)
 	if err == nil {
 		t.Log("Expected fail for bad id for get users courses")
 		t.Log(err)
 		t.Fail()
 	}
 
-	groups, err = GetUsersModules(uuid.New().String(), pool)
+	groups, err = GetUsersModules(context.Background(), uuid.New().String(), pool)
 	if err != nil {
 		t.Log("Expected success for getting user courses")
 		t.Log(err)
@@ -103,7 +104,7 @@ func TestGetUserModules(t *testing.T) {
 
 func TestGetUpcomingLessons(t *testing.T) {
 	log.SetFlags(log.Llongfile | log.Ldate | log.Ltime | log.Lmicroseconds)
-	lessons, err := GetLessons(uuid.New().String(), pool)
+	lessons, err := GetLessons(context.Background(), uuid.New().String(), pool)
 	if err != nil {
 		t.Log(err)
 		t.Fail()
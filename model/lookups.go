@@ -1,24 +1,25 @@
 package model
 
 import (
+	"arcio/attendance-system/logging"
 	"arcio/attendance-system/utils"
-	"log"
+	"context"
 )
 
 // Funcs
-func GetUsersForModule(ModuleId string, Pool *utils.DatabasePool) ([]User, error) {
-	stmt, err := Pool.Database.Prepare("select (users.id, users.external_id, firstname, surname, email, users.creation_time, users.edit_time) " +
-		"from users, module_users " +
+func GetUsersForModule(ctx context.Context, ModuleId string, Pool *utils.DatabasePool) ([]User, error) {
+	stmt, err := Pool.Database.PrepareContext(ctx, "select users.id, users.external_id, firstname, surname, email, users.creation_time, users.edit_time "+
+		"from users, module_users "+
 		"where user_id = users.id and module_id = $1;")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(ModuleId)
+	rows, err := stmt.QueryContext(ctx, ModuleId)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -34,17 +35,17 @@ func GetUsersForModule(ModuleId string, Pool *utils.DatabasePool) ([]User, error
 	return ret, nil
 }
 
-func GetGroupsForModule(ModuleId string, Pool *utils.DatabasePool) ([]ModuleGroup, error) {
-	stmt, err := Pool.Database.Prepare("select id, module_id, name, creation_time, edit_time from module_groups where module_id = $1;")
+func GetGroupsForModule(ctx context.Context, ModuleId string, Pool *utils.DatabasePool) ([]ModuleGroup, error) {
+	stmt, err := Pool.Database.PrepareContext(ctx, "select id, module_id, name, creation_time, edit_time from module_groups where module_id = $1;")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(ModuleId)
+	rows, err := stmt.QueryContext(ctx, ModuleId)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -63,17 +64,17 @@ const GET_USER_COURSES = "select modules.id, modules.external_id, modules.name,
 	"from modules, module_users " +
 	"where modules.id = module_users.module_id and module_users.user_id = $1;"
 
-func GetUsersModules(UserId string, Pool *utils.DatabasePool) ([]Module, error) {
-	stmt, err := Pool.Database.Prepare(GET_USER_COURSES)
+func GetUsersModules(ctx context.Context, UserId string, Pool *utils.DatabasePool) ([]Module, error) {
+	stmt, err := Pool.Database.PrepareContext(ctx, GET_USER_COURSES)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(UserId)
+	rows, err := stmt.QueryContext(ctx, UserId)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -92,25 +93,25 @@ func GetUsersModules(UserId string, Pool *utils.DatabasePool) ([]Module, error)
 /*
 * Returns all the module groups the user is in by inner joining users, module_users, module_users_groups.
  */
-func GetUsersModuleGroups(UserId string, pool *utils.DatabasePool) ([]ModuleGroup, error) {
+func GetUsersModuleGroups(ctx context.Context, UserId string, pool *utils.DatabasePool) ([]ModuleGroup, error) {
 
 	userModuleGroups := make([]ModuleGroup, 0)
 
-	getModuleGroupsId, err := pool.Database.Prepare("select module_groups.id, module_groups.module_id, module_groups.name, module_groups.creation_time, module_groups.edit_time " +
-		"from module_groups " +
-		"INNER JOIN module_user_groups ON module_user_groups.module_group_id = module_groups.id " +
-		"INNER JOIN module_users ON module_users.id = module_user_groups.module_user_id " +
-		"INNER JOIN users ON users.id = module_users.user_id " +
+	getModuleGroupsId, err := pool.Database.PrepareContext(ctx, "select module_groups.id, module_groups.module_id, module_groups.name, module_groups.creation_time, module_groups.edit_time "+
+		"from module_groups "+
+		"INNER JOIN module_user_groups ON module_user_groups.module_group_id = module_groups.id "+
+		"INNER JOIN module_users ON module_users.id = module_user_groups.module_user_id "+
+		"INNER JOIN users ON users.id = module_users.user_id "+
 		"WHERE users.id=$1;")
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 
-	rows, err := getModuleGroupsId.Query(UserId)
+	rows, err := getModuleGroupsId.QueryContext(ctx, UserId)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 
@@ -1,16 +1,18 @@
 package model
 
 import (
+	"arcio/attendance-system/logging"
 	"arcio/attendance-system/utils"
 	"context"
+	"database/sql"
 	"errors"
-	"log"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-func CreateModule(module *Module, pool *utils.DatabasePool) error {
+// Checks the fields that modules must have
+func validateModule(module Module) error {
 	if module.Name == "" {
 		return errors.New("The module name cannot be empty")
 	}
@@ -19,64 +21,81 @@ func CreateModule(module *Module, pool *utils.DatabasePool) error {
 		return errors.New("The external id (tag) cannot be empty")
 	}
 
+	return nil
+}
+
+// Checks the fields that module groups must have
+func validateModuleGroup(moduleGroup ModuleGroup) error {
+	if moduleGroup.Name == "" {
+		return errors.New("The module group name cannot be empty")
+	}
+
+	return nil
+}
+
+func CreateModule(ctx context.Context, module *Module, pool *utils.DatabasePool) error {
+	if err := validateModule(*module); err != nil {
+		return err
+	}
+
 	module.CreationTime = time.Now()
 	module.EditTime = module.CreationTime
 	module.Id = uuid.New().String()
-	stmt, err := pool.Database.Prepare("insert into modules (id, name, external_id, creation_time, edit_time) values ($1, $2, $3, $4, $5);")
+	stmt, err := pool.Database.PrepareContext(ctx, "insert into modules (id, name, external_id, creation_time, edit_time) values ($1, $2, $3, $4, $5);")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(module.Id, module.Name, module.ExternalId, module.CreationTime, module.EditTime)
+	_, err = stmt.ExecContext(ctx, module.Id, module.Name, module.ExternalId, module.CreationTime, module.EditTime)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 
 	return nil
 }
 
-func CreateModuleGroup(moduleGroup *ModuleGroup, pool *utils.DatabasePool) error {
-	if moduleGroup.Name == "" {
-		return errors.New("The module group name cannot be empty")
+func CreateModuleGroup(ctx context.Context, moduleGroup *ModuleGroup, pool *utils.DatabasePool) error {
+	if err := validateModuleGroup(*moduleGroup); err != nil {
+		return err
 	}
 
 	moduleGroup.CreationTime = time.Now()
 	moduleGroup.EditTime = moduleGroup.CreationTime
 	moduleGroup.Id = uuid.New().String()
 
-	createModuleGroup, err := pool.Database.Prepare("insert into module_groups (id, module_id, name, creation_time, edit_time) " +
+	createModuleGroup, err := pool.Database.PrepareContext(ctx, "insert into module_groups (id, module_id, name, creation_time, edit_time) "+
 		"values ($1, $2, $3, $4, $5);")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer createModuleGroup.Close()
 
-	_, err = createModuleGroup.Exec(moduleGroup.Id, moduleGroup.ModuleId, moduleGroup.Name, moduleGroup.CreationTime, moduleGroup.EditTime)
+	_, err = createModuleGroup.ExecContext(ctx, moduleGroup.Id, moduleGroup.ModuleId, moduleGroup.Name, moduleGroup.CreationTime, moduleGroup.EditTime)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 
 	return nil
 }
 
-func GetAllModules(DatabasePool *utils.DatabasePool) ([]Module, error) {
+func GetAllModules(ctx context.Context, DatabasePool *utils.DatabasePool) ([]Module, error) {
 	var returnModules []Module
 
-	getUsers, err := DatabasePool.Database.Prepare("SELECT id, external_id, name, creation_time, edit_time FROM modules;")
+	getUsers, err := DatabasePool.Database.PrepareContext(ctx, "SELECT id, external_id, name, creation_time, edit_time FROM modules;")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer getUsers.Close()
 
-	rows, err := getUsers.Query()
+	rows, err := getUsers.QueryContext(ctx)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -91,21 +110,21 @@ func GetAllModules(DatabasePool *utils.DatabasePool) ([]Module, error) {
 	return returnModules, nil
 }
 
-func GetModuleStudents(moduleId string, DatabasePool *utils.DatabasePool) ([]ModuleUserAttendanceRet, error) {
+func GetModuleStudents(ctx context.Context, moduleId string, DatabasePool *utils.DatabasePool) ([]ModuleUserAttendanceRet, error) {
 
 	//Gets the number of lessons for a given module.
-	getModuleCount, err := DatabasePool.Database.Query(`
+	getModuleCount, err := DatabasePool.Database.QueryContext(ctx, `
 SELECT COUNT(actual_lessons.id), modules.name
 FROM modules
 LEFT JOIN module_groups ON module_groups.module_id = modules.id
 LEFT JOIN group_lessons ON group_lessons.module_group_id = module_groups.id
-LEFT JOIN actual_lessons ON actual_lessons.group_lesson_id = group_lessons.id
+LEFT JOIN actual_lessons ON actual_lessons.group_lesson_id = group_lessons.id AND NOT actual_lessons.cancelled
 WHERE modules.id = $1
 GROUP BY modules.name
   `, moduleId)
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 
@@ -124,20 +143,20 @@ GROUP BY modules.name
 	}
 
 	//Gets the users information with attendance for this module.
-	getModuleUsers, err := DatabasePool.Database.Query(`SELECT COUNT(attendance.id), users.id, users.external_id, users.firstname, users.surname, users.email
+	getModuleUsers, err := DatabasePool.Database.QueryContext(ctx, `SELECT `+attendanceStatusCounts()+`, users.id, users.external_id, users.firstname, users.surname, users.email
 FROM users
 INNER JOIN module_users ON module_users.user_id = users.id
 INNER JOIN modules ON module_users.module_id = modules.id
 LEFT JOIN module_groups ON module_groups.module_id = modules.id
 LEFT JOIN group_lessons ON group_lessons.module_group_id = module_groups.id
-LEFT JOIN actual_lessons ON actual_lessons.group_lesson_id = group_lessons.id
+LEFT JOIN actual_lessons ON actual_lessons.group_lesson_id = group_lessons.id AND NOT actual_lessons.cancelled
 LEFT JOIN attendance ON attendance.lesson_id = actual_lessons.id AND attendance.user_id = users.id
 WHERE modules.id = $1
 GROUP BY users.id, users.external_id, users.firstname, users.surname, users.email
 	`, moduleId)
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 
@@ -145,47 +164,45 @@ GROUP BY users.id, users.external_id, users.firstname, users.surname, users.emai
 
 	for getModuleUsers.Next() {
 		var user ModuleUserAttendanceRet
-		var attendance AttendanceRecord
-		attendance.TotalSessions = moduleInfo.Count
+		counts := make([]int, len(ATTENDANCE_STATUSES))
 
-		getModuleUsers.Scan(&attendance.MarkedSessions, &user.InternalId, &user.ExternalId, &user.Fname,
-			&user.Sname, &user.Email)
+		getModuleUsers.Scan(append(statusCountDest(counts), &user.InternalId, &user.ExternalId, &user.Fname,
+			&user.Sname, &user.Email)...)
 
-		user.Attendance = attendance
+		user.Attendance = newAttendanceRecord(moduleInfo.Count, counts)
 		users = append(users, user)
 	}
 
 	return users, nil
 }
 
-func AddUserToModule(uid string, mid string, pool *utils.DatabasePool) error {
+func AddUserToModule(ctx context.Context, uid string, mid string, pool *utils.DatabasePool) error {
 	id := uuid.New().String()
 	creationTime := time.Now()
 	editTime := creationTime
 
-	stmt, err := pool.Database.Prepare("insert into module_users (id, user_id, module_id, creation_time, edit_time) values ($1, $2, $3, $4, $5);")
+	stmt, err := pool.Database.PrepareContext(ctx, "insert into module_users (id, user_id, module_id, creation_time, edit_time) values ($1, $2, $3, $4, $5);")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(id, uid, mid, creationTime, editTime)
+	_, err = stmt.ExecContext(ctx, id, uid, mid, creationTime, editTime)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 
 	return nil
 }
 
-func AddUsersToModule(uids []string, mid string, pool *utils.DatabasePool) error {
+func AddUsersToModule(ctx context.Context, uids []string, mid string, pool *utils.DatabasePool) error {
 	// Create transaction
 	success := false
-	ctx := context.Background()
 	tx, err := pool.Database.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer func() {
@@ -198,18 +215,18 @@ func AddUsersToModule(uids []string, mid string, pool *utils.DatabasePool) error
 	creationTime := time.Now()
 	editTime := creationTime
 
-	stmt, err := pool.Database.PrepareContext(ctx, "insert into module_users (id, user_id, module_id, creation_time, edit_time) values ($1, $2, $3, $4, $5);")
+	stmt, err := tx.PrepareContext(ctx, "insert into module_users (id, user_id, module_id, creation_time, edit_time) values ($1, $2, $3, $4, $5);")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer stmt.Close()
 
 	for i := 0; i < len(uids); i++ {
 		id := uuid.New().String()
-		_, err = stmt.Exec(id, uids[i], mid, creationTime, editTime)
+		_, err = stmt.ExecContext(ctx, id, uids[i], mid, creationTime, editTime)
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(ctx).Error(err)
 			return err
 		}
 	}
@@ -218,13 +235,12 @@ func AddUsersToModule(uids []string, mid string, pool *utils.DatabasePool) error
 	return nil
 }
 
-func RemoveUserFromModule(uid string, mid string, pool *utils.DatabasePool) error {
+func RemoveUserFromModule(ctx context.Context, uid string, mid string, pool *utils.DatabasePool) error {
 	// Create transaction
 	success := false
-	ctx := context.Background()
 	tx, err := pool.Database.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer func() {
@@ -236,53 +252,55 @@ func RemoveUserFromModule(uid string, mid string, pool *utils.DatabasePool) erro
 	}()
 
 	// Get module user id
-	stmt, err := pool.Database.PrepareContext(ctx, "select id from module_users where user_id = $1 and module_id = $2;")
+	stmt, err := tx.PrepareContext(ctx, "select id from module_users where user_id = $1 and module_id = $2;")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(uid, mid)
+	rows, err := stmt.QueryContext(ctx, uid, mid)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer rows.Close()
 
 	if !rows.Next() {
-		log.Println("Cannot find user")
+		logging.FromContext(ctx).Warn("Cannot find user")
 		return errors.New("cannot find user")
 	}
 	var moduleUserId string
 	rows.Scan(&moduleUserId)
+	// The deletes use the same connection
+	rows.Close()
 
 	// Delete module user from groups
-	stmt, err = pool.Database.PrepareContext(ctx, "delete from module_user_groups where module_user_id = $1;")
+	stmt, err = tx.PrepareContext(ctx, "delete from module_user_groups where module_user_id = $1;")
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(moduleUserId)
+	_, err = stmt.ExecContext(ctx, moduleUserId)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 
 	// Remove module user
-	stmt, err = pool.Database.PrepareContext(ctx, "delete from module_users where id = $1;")
+	stmt, err = tx.PrepareContext(ctx, "delete from module_users where id = $1;")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(moduleUserId)
+	_, err = stmt.ExecContext(ctx, moduleUserId)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 
@@ -290,13 +308,12 @@ func RemoveUserFromModule(uid string, mid string, pool *utils.DatabasePool) erro
 	return nil
 }
 
-func RemoveUserFromModuleGroup(uid string, mid string, pool *utils.DatabasePool) error {
+func RemoveUserFromModuleGroup(ctx context.Context, uid string, mid string, pool *utils.DatabasePool) error {
 	// Create transaction
 	success := false
-	ctx := context.Background()
 	tx, err := pool.Database.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer func() {
@@ -308,57 +325,65 @@ func RemoveUserFromModuleGroup(uid string, mid string, pool *utils.DatabasePool)
 	}()
 
 	// Get module user id
-	stmt, err := pool.Database.PrepareContext(ctx, "select id from module_users where user_id = $1;")
+	stmt, err := tx.PrepareContext(ctx, "select id from module_users where user_id = $1;")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(uid)
+	rows, err := stmt.QueryContext(ctx, uid)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer rows.Close()
 
 	if !rows.Next() {
-		log.Println("Cannot find user")
+		logging.FromContext(ctx).Warn("Cannot find user")
 		return errors.New("Cannot find user")
 	}
 
 	var muid string
 	rows.Scan(&muid)
+	rows.Close()
 
 	// Get groups
-	stmt, err = pool.Database.PrepareContext(ctx, "select id from module_groups where module_id = $1;")
+	stmt, err = tx.PrepareContext(ctx, "select id from module_groups where module_id = $1;")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer stmt.Close()
 
-	rows, err = stmt.Query(mid)
+	rows, err = stmt.QueryContext(ctx, mid)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer rows.Close()
 
+	// The groups are read before deleting as the deletes use the same connection
+	groupIds := make([]string, 0)
 	for rows.Next() {
 		var mgid string
 		rows.Scan(&mgid)
-		stmt, err = pool.Database.PrepareContext(ctx, "delete from module_user_groups "+
-			"where module_user_groups.module_user_id = $1 and module_user_groups.module_group_id = $2;")
-		if err != nil {
-			log.Println(err)
-			return err
-		}
-		defer stmt.Close()
+		groupIds = append(groupIds, mgid)
+	}
+	rows.Close()
+
+	stmt, err = tx.PrepareContext(ctx, "delete from module_user_groups "+
+		"where module_user_groups.module_user_id = $1 and module_user_groups.module_group_id = $2;")
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer stmt.Close()
 
-		_, err = stmt.Exec(muid, mgid)
+	for _, mgid := range groupIds {
+		_, err = stmt.ExecContext(ctx, muid, mgid)
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(ctx).Error(err)
 			return err
 		}
 	}
@@ -367,23 +392,23 @@ func RemoveUserFromModuleGroup(uid string, mid string, pool *utils.DatabasePool)
 	return nil
 }
 
-func GetModulesForUser(userid string, DatabasePool *utils.DatabasePool) ([]Module, error) {
+func GetModulesForUser(ctx context.Context, userid string, DatabasePool *utils.DatabasePool) ([]Module, error) {
 	var returnModules []Module
 
-	getUserModules, err := DatabasePool.Database.Prepare("SELECT modules.id, modules.external_id, modules.name, " +
-		"modules.creation_time, modules.edit_time FROM modules " +
-		"INNER JOIN module_users ON module_users.module_id = modules.id " +
-		"INNER JOIN users ON users.id = module_users.user_id " +
+	getUserModules, err := DatabasePool.Database.PrepareContext(ctx, "SELECT modules.id, modules.external_id, modules.name, "+
+		"modules.creation_time, modules.edit_time FROM modules "+
+		"INNER JOIN module_users ON module_users.module_id = modules.id "+
+		"INNER JOIN users ON users.id = module_users.user_id "+
 		"WHERE users.id=$1")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer getUserModules.Close()
 
-	rows, err := getUserModules.Query(userid)
+	rows, err := getUserModules.QueryContext(ctx, userid)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -398,13 +423,12 @@ func GetModulesForUser(userid string, DatabasePool *utils.DatabasePool) ([]Modul
 	return returnModules, nil
 }
 
-func AddUserToModuleGroup(userid string, modulegroupid string, DatabasePool *utils.DatabasePool) error {
+func AddUserToModuleGroup(ctx context.Context, userid string, modulegroupid string, DatabasePool *utils.DatabasePool) error {
 	// Create transaction
 	success := false
-	ctx := context.Background()
 	tx, err := DatabasePool.Database.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer func() {
@@ -416,7 +440,7 @@ func AddUserToModuleGroup(userid string, modulegroupid string, DatabasePool *uti
 	}()
 
 	// Get the module user id, to check if the user is in this module.
-	stmt, err := DatabasePool.Database.PrepareContext(ctx, "SELECT module_users.id "+
+	stmt, err := tx.PrepareContext(ctx, "SELECT module_users.id "+
 		"FROM module_users "+
 		"INNER JOIN modules ON modules.id = module_users.module_id "+
 		"INNER JOIN module_groups ON module_groups.module_id = modules.id "+
@@ -424,36 +448,38 @@ func AddUserToModuleGroup(userid string, modulegroupid string, DatabasePool *uti
 		"AND module_users.user_id=$2;")
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(modulegroupid, userid)
+	rows, err := stmt.QueryContext(ctx, modulegroupid, userid)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	defer rows.Close()
 
 	if !rows.Next() {
 		err = errors.New("User not found in module")
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 
 	var muid string
 	rows.Scan(&muid)
+	// The insert uses the same connection
+	rows.Close()
 
-	stmt, err = DatabasePool.Database.PrepareContext(ctx, "insert into module_user_groups (id, module_user_id, module_group_id) values ($1, $2, $3);")
+	stmt, err = tx.PrepareContext(ctx, "insert into module_user_groups (id, module_user_id, module_group_id) values ($1, $2, $3);")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 
-	_, err = stmt.Exec(uuid.New().String(), muid, modulegroupid)
+	_, err = stmt.ExecContext(ctx, uuid.New().String(), muid, modulegroupid)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return err
 	}
 	success = true
@@ -461,13 +487,12 @@ func AddUserToModuleGroup(userid string, modulegroupid string, DatabasePool *uti
 	return nil
 }
 
-func GetModuleGroupStudents(moduleGroupId string, DatabasePool *utils.DatabasePool) (ModuleGroupRet, error) {
+func GetModuleGroupStudents(ctx context.Context, moduleGroupId string, DatabasePool *utils.DatabasePool) (ModuleGroupRet, error) {
 	// Create transaction
 	success := false
-	ctx := context.Background()
 	tx, err := DatabasePool.Database.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return ModuleGroupRet{}, err
 	}
 	defer func() {
@@ -481,62 +506,65 @@ func GetModuleGroupStudents(moduleGroupId string, DatabasePool *utils.DatabasePo
 	var moduleGroup ModuleGroupRet
 
 	//Get the module name
-	nameQuery, err := DatabasePool.Database.QueryContext(ctx,
+	nameQuery, err := tx.QueryContext(ctx,
 		`SELECT name FROM module_groups WHERE id=$1`, moduleGroupId)
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return ModuleGroupRet{}, err
 	}
 	defer nameQuery.Close()
 
 	if !nameQuery.Next() {
-		log.Println("Error getting module group name")
+		logging.FromContext(ctx).Error("Error getting module group name")
 		return ModuleGroupRet{}, errors.New("error getting module group lesson count")
 	}
 	nameQuery.Scan(&moduleGroup.ModuleName)
+	// The next queries use the same connection
+	nameQuery.Close()
 
 	// Module inf
-	groupLessons, err := DatabasePool.Database.QueryContext(ctx, `SELECT COUNT(actual_lessons.id) 
+	groupLessons, err := tx.QueryContext(ctx, `SELECT COUNT(actual_lessons.id) 
 FROM module_groups
 LEFT JOIN group_lessons ON group_lessons.module_group_id = module_groups.id
-LEFT JOIN actual_lessons ON actual_lessons.group_lesson_id = group_lessons.id
+LEFT JOIN actual_lessons ON actual_lessons.group_lesson_id = group_lessons.id AND NOT actual_lessons.cancelled
 WHERE module_groups.id = $1
 AND actual_lessons.end_time <= current_timestamp;`, moduleGroupId)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return ModuleGroupRet{}, err
 	}
 	defer groupLessons.Close()
 
 	if !groupLessons.Next() {
-		log.Println("Error getting module group info")
+		logging.FromContext(ctx).Error("Error getting module group info")
 		return ModuleGroupRet{}, errors.New("error getting module group lesson count")
 	}
 
 	var groupLessonCount int
 	err = groupLessons.Scan(&groupLessonCount)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return ModuleGroupRet{}, err
 	}
+	groupLessons.Close()
 
 	moduleGroup.ModuleGroupId = moduleGroupId
 
 	// Get attendance and, users
-	userAttendance, err := DatabasePool.Database.Query("SELECT COUNT(attendance.id), users.id, users.external_id, users.firstname, users.surname, users.email "+
+	userAttendance, err := tx.QueryContext(ctx, "SELECT "+attendanceStatusCounts()+", users.id, users.external_id, users.firstname, users.surname, users.email "+
 		"FROM users "+
 		"INNER JOIN module_users ON module_users.user_id = users.id "+
 		"INNER JOIN module_user_groups ON module_user_groups.module_user_id = module_users.id "+
 		"INNER JOIN module_groups ON module_groups.id = module_user_groups.module_group_id "+
 		"LEFT JOIN group_lessons ON group_lessons.module_group_id = module_groups.id "+
-		"LEFT JOIN actual_lessons ON actual_lessons.group_lesson_id = group_lessons.id "+
+		"LEFT JOIN actual_lessons ON actual_lessons.group_lesson_id = group_lessons.id AND NOT actual_lessons.cancelled "+
 		"LEFT JOIN attendance ON attendance.lesson_id = actual_lessons.id AND attendance.user_id = users.id "+
 		"WHERE module_user_groups.module_group_id  = $1 "+
 		"GROUP BY users.id, users.firstname, users.surname, users.email, users.external_id;", moduleGroupId)
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return ModuleGroupRet{}, err
 	}
 	defer userAttendance.Close()
@@ -544,13 +572,14 @@ AND actual_lessons.end_time <= current_timestamp;`, moduleGroupId)
 	users_arr := make([]ModuleUserAttendanceRet, 0)
 	for userAttendance.Next() {
 		var userAttRet ModuleUserAttendanceRet
-		err = userAttendance.Scan(&userAttRet.Attendance.MarkedSessions, &userAttRet.InternalId, &userAttRet.ExternalId, &userAttRet.Fname, &userAttRet.Sname, &userAttRet.Email)
+		counts := make([]int, len(ATTENDANCE_STATUSES))
+		err = userAttendance.Scan(append(statusCountDest(counts), &userAttRet.InternalId, &userAttRet.ExternalId, &userAttRet.Fname, &userAttRet.Sname, &userAttRet.Email)...)
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(ctx).Error(err)
 			return ModuleGroupRet{}, err
 		}
 
-		userAttRet.Attendance.TotalSessions = groupLessonCount
+		userAttRet.Attendance = newAttendanceRecord(groupLessonCount, counts)
 		users_arr = append(users_arr, userAttRet)
 	}
 	moduleGroup.UserAttendance = users_arr
@@ -559,13 +588,186 @@ AND actual_lessons.end_time <= current_timestamp;`, moduleGroupId)
 	return moduleGroup, nil
 }
 
-func AddUsersToModuleGroup(userid []string, modulegroupid string, DatabasePool *utils.DatabasePool) error {
+func AddUsersToModuleGroup(ctx context.Context, userid []string, modulegroupid string, DatabasePool *utils.DatabasePool) error {
 	for i := 0; i < len(userid); i++ {
-		err := AddUserToModuleGroup(userid[i], modulegroupid, DatabasePool)
+		err := AddUserToModuleGroup(ctx, userid[i], modulegroupid, DatabasePool)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+func UpdateModule(ctx context.Context, module *Module, pool *utils.DatabasePool) error {
+	if err := validateModule(*module); err != nil {
+		return err
+	}
+
+	module.EditTime = time.Now()
+	stmt, err := pool.Database.PrepareContext(ctx, "update modules set name = $2, external_id = $3, edit_time = $4 where id = $1 returning creation_time;")
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRowContext(ctx, module.Id, module.Name, module.ExternalId, module.EditTime).Scan(&module.CreationTime)
+	if err == sql.ErrNoRows {
+		return errors.New("Cannot find module with matching id")
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Deletes a module, its groups and, its members. If cascade is set then the lessons of the module and,
+ * their attendance are deleted otherwise ErrHasLessons is returned if there are any lessons.
+ */
+func DeleteModule(ctx context.Context, moduleId string, cascade bool, pool *utils.DatabasePool) error {
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, "select exists(select 1 from modules where id = $1);", moduleId).Scan(&exists)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	if !exists {
+		return errors.New("Cannot find module with matching id")
+	}
+
+	err = deleteGroupLessons(ctx, tx, GROUP_LESSON_BY_MODULE, moduleId, cascade)
+	if err != nil {
+		return err
+	}
+
+	moduleUsers := "(select module_users.id from module_users where module_users.module_id = $1)"
+	moduleUserGroups := "(select module_user_groups.id from module_user_groups, module_groups " +
+		"where module_user_groups.module_group_id = module_groups.id and module_groups.module_id = $1)"
+
+	// Children first
+	queries := []string{"delete from module_user_group_roles where module_user_group_id in " + moduleUserGroups + ";",
+		"delete from module_user_groups where id in " + moduleUserGroups + ";",
+		"delete from module_groups where module_id = $1;",
+		"delete from module_user_roles where module_user_id in " + moduleUsers + ";",
+		"delete from module_users where module_id = $1;",
+		"delete from modules where id = $1;"}
+
+	for _, query := range queries {
+		_, err = tx.ExecContext(ctx, query, moduleId)
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(ctx).Error(err)
 			return err
 		}
 	}
+
+	logging.FromContext(ctx).Infof("Deleted module %s", moduleId)
+
+	success = true
+	return nil
+}
+
+/*
+ * Renames a module group, the group must be in moduleGroup.ModuleId otherwise
+ * ErrModuleGroupNotFound is returned.
+ */
+func UpdateModuleGroup(ctx context.Context, moduleGroup *ModuleGroup, pool *utils.DatabasePool) error {
+	if err := validateModuleGroup(*moduleGroup); err != nil {
+		return err
+	}
+
+	moduleGroup.EditTime = time.Now()
+	stmt, err := pool.Database.PrepareContext(ctx, "update module_groups set name = $3, edit_time = $4 "+
+		"where id = $1 and module_id = $2 returning creation_time;")
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRowContext(ctx, moduleGroup.Id, moduleGroup.ModuleId, moduleGroup.Name, moduleGroup.EditTime).Scan(&moduleGroup.CreationTime)
+	if err == sql.ErrNoRows {
+		return ErrModuleGroupNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Deletes a module group and, removes its members from it. If cascade is set then the lessons of the
+ * group and, their attendance are deleted otherwise ErrHasLessons is returned if there are any lessons.
+ * ErrModuleGroupNotFound is returned if the group is not in the module.
+ */
+func DeleteModuleGroup(ctx context.Context, moduleId string, moduleGroupId string, cascade bool, pool *utils.DatabasePool) error {
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, "select exists(select 1 from module_groups where id = $1 and module_id = $2);",
+		moduleGroupId, moduleId).Scan(&exists)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	if !exists {
+		return ErrModuleGroupNotFound
+	}
+
+	err = deleteGroupLessons(ctx, tx, GROUP_LESSON_BY_MODULE_GROUP, moduleGroupId, cascade)
+	if err != nil {
+		return err
+	}
+
+	// Children first
+	queries := []string{"delete from module_user_group_roles where module_user_group_id in " +
+		"(select module_user_groups.id from module_user_groups where module_user_groups.module_group_id = $1);",
+		"delete from module_user_groups where module_group_id = $1;",
+		"delete from module_groups where id = $1;"}
+
+	for _, query := range queries {
+		_, err = tx.ExecContext(ctx, query, moduleGroupId)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return err
+		}
+	}
+
+	logging.FromContext(ctx).Infof("Deleted module group %s", moduleGroupId)
+
+	success = true
 	return nil
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/google/uuid"
 	"log"
 	"testing"
+	"time"
 )
 
 var conf_cm config.Config
@@ -29,7 +30,7 @@ func TestInitCmTests(t *testing.T) {
 
 func TestAddBadCourse(t *testing.T) {
 	var module Module
-	err := CreateModule(&module, pool_cm)
+	err := CreateModule(context.Background(), &module, pool_cm)
 
 	if err == nil {
 		t.Log("Adding a bad course should fail")
@@ -39,7 +40,7 @@ func TestAddBadCourse(t *testing.T) {
 	t.Log(err)
 
 	module.Name = "test"
-	err = CreateModule(&module, pool_cm)
+	err = CreateModule(context.Background(), &module, pool_cm)
 
 	if err == nil {
 		t.Log("Adding a bad course should fail")
@@ -51,7 +52,7 @@ func TestAddBadCourse(t *testing.T) {
 
 func TestAddGoodCourse(t *testing.T) {
 	module := Module{Name: "Test", ExternalId: "CS1812"}
-	err := CreateModule(&module, pool_cm)
+	err := CreateModule(context.Background(), &module, pool_cm)
 
 	if err != nil {
 		t.Log(err)
@@ -74,7 +75,7 @@ func TestAddRandUserToRandModule(t *testing.T) {
 	uid := uuid.New().String()
 	mid := uuid.New().String()
 
-	err := AddUserToModule(uid, mid, pool_cm)
+	err := AddUserToModule(context.Background(), uid, mid, pool_cm)
 	if err == nil {
 		t.Log("Expected failure for insert of random module and user")
 		t.Fail()
@@ -87,7 +88,7 @@ func TestRmUserFromModuleGroup(t *testing.T) {
 	uid := uuid.New().String()
 	mid := uuid.New().String()
 
-	err := RemoveUserFromModuleGroup(uid, mid, pool_cm)
+	err := RemoveUserFromModuleGroup(context.Background(), uid, mid, pool_cm)
 	if err == nil {
 		t.Log("Expected failure for insert of random module and user")
 		t.Fail()
@@ -200,7 +201,7 @@ func TestAddThenRmUserFromModuleGroup(t *testing.T) {
 	}
 
 	// Remove from module group
-	err = RemoveUserFromModuleGroup(id, mgid, pool_cm)
+	err = RemoveUserFromModuleGroup(context.Background(), id, mgid, pool_cm)
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -258,7 +259,7 @@ func TestRmRandomUserFromRandModule(t *testing.T) {
 	uid := uuid.New().String()
 	mid := uuid.New().String()
 
-	err := RemoveUserFromModule(uid, mid, pool_cm)
+	err := RemoveUserFromModule(context.Background(), uid, mid, pool_cm)
 	if err == nil {
 		t.Log("Expected failure for delete of random module and user")
 		t.Fail()
@@ -268,7 +269,7 @@ func TestRmRandomUserFromRandModule(t *testing.T) {
 }
 
 func TestGetAllModules(t *testing.T) {
-	ret, err := GetAllModules(pool_cm)
+	ret, err := GetAllModules(context.Background(), pool_cm)
 	if err != nil {
 		t.Log(err)
 		t.Log("Cannot get modules")
@@ -285,3 +286,108 @@ func TestGetAllModules(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestUpdateBadModule(t *testing.T) {
+	module := Module{Id: uuid.New().String()}
+	err := UpdateModule(context.Background(), &module, pool_cm)
+	if err == nil {
+		t.Log("Updating a module with no name should fail")
+		t.Fail()
+	}
+}
+
+func TestUpdateMissingModule(t *testing.T) {
+	module := Module{Id: uuid.New().String(), Name: "Test", ExternalId: "CS1812"}
+	err := UpdateModule(context.Background(), &module, pool_cm)
+	if err == nil {
+		t.Log("Updating a module that does not exist should fail")
+		t.Fail()
+	}
+}
+
+func TestDeleteMissingModule(t *testing.T) {
+	err := DeleteModule(context.Background(), uuid.New().String(), true, pool_cm)
+	if err == nil {
+		t.Log("Deleting a module that does not exist should fail")
+		t.Fail()
+	}
+}
+
+func TestModuleCrud(t *testing.T) {
+	module := Module{Name: "Test", ExternalId: "CS1812"}
+	err := CreateModule(context.Background(), &module, pool_cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moduleGroup := ModuleGroup{ModuleId: module.Id, Name: "Test group"}
+	err = CreateModuleGroup(context.Background(), &moduleGroup, pool_cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module.Name = "Test renamed"
+	err = UpdateModule(context.Background(), &module, pool_cm)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	moduleGroup.Name = "Test group renamed"
+	err = UpdateModuleGroup(context.Background(), &moduleGroup, pool_cm)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	if moduleGroup.ModuleId != module.Id {
+		t.Log("The module group has the wrong module id after updating")
+		t.Fail()
+	}
+
+	// The group is not in another module
+	otherModule := moduleGroup
+	otherModule.ModuleId = uuid.New().String()
+	err = UpdateModuleGroup(context.Background(), &otherModule, pool_cm)
+	if err != ErrModuleGroupNotFound {
+		t.Log("Expected ErrModuleGroupNotFound for the wrong module got", err)
+		t.Fail()
+	}
+
+	err = DeleteModuleGroup(context.Background(), otherModule.ModuleId, moduleGroup.Id, true, pool_cm)
+	if err != ErrModuleGroupNotFound {
+		t.Log("Expected ErrModuleGroupNotFound for the wrong module got", err)
+		t.Fail()
+	}
+
+	groupLesson := GroupLesson{ModuleGroupId: moduleGroup.Id, Name: "Test lesson"}
+	err = CreateGroupLesson(context.Background(), &groupLesson, pool_cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lesson := ActualLesson{GroupLessonId: groupLesson.Id, StartTime: time.Now(), EndTime: time.Now().Add(time.Hour)}
+	err = CreateActualLesson(context.Background(), lesson, pool_cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// There is a lesson so it should not be deleted without cascading
+	err = DeleteModuleGroup(context.Background(), module.Id, moduleGroup.Id, false, pool_cm)
+	if err != ErrHasLessons {
+		t.Log("Expected ErrHasLessons got", err)
+		t.Fail()
+	}
+
+	err = DeleteModule(context.Background(), module.Id, true, pool_cm)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	groups, err := GetGroupsForModule(context.Background(), module.Id, pool_cm)
+	if err != nil || len(groups) != 0 {
+		t.Log("The module groups were not deleted", err)
+		t.Fail()
+	}
+}
@@ -1,21 +1,22 @@
 package model
 
 import (
+	"arcio/attendance-system/logging"
 	"arcio/attendance-system/security"
 	"arcio/attendance-system/utils"
 	"context"
-	"log"
+	"database/sql"
 )
 
-func getAttendancePerms(userId string, pool *utils.DatabasePool, ctx context.Context) (security.Overrides, error) {
-	stmt, err := pool.Database.PrepareContext(ctx, "select overrides from roles, attendance_user_roles "+
+func getAttendancePerms(ctx context.Context, tx *sql.Tx, userId string) (security.Overrides, error) {
+	stmt, err := tx.PrepareContext(ctx, "select overrides from roles, attendance_user_roles "+
 		"where attendance_user_roles.user_id = $1 and roles.id = attendance_user_roles.role_id;")
 	if err != nil {
 		return security.PERMS_NONE, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(userId)
+	rows, err := stmt.QueryContext(ctx, userId)
 	if err != nil {
 		return security.PERMS_NONE, err
 	}
@@ -36,22 +37,21 @@ func getAttendancePerms(userId string, pool *utils.DatabasePool, ctx context.Con
 	return security.CalculatePermissionsInner(overrides), nil
 }
 
-func getModuleGroupPerms(userId string, moduleId string, moduleGroupId string, pool *utils.DatabasePool, ctx context.Context) (security.Overrides, error) {
-	stmt, err := pool.Database.PrepareContext(ctx, "select overrides from module_user_group_roles, "+
-		"module_groups, module_user_groups, module_users, roles "+
+func getModuleGroupPerms(ctx context.Context, tx *sql.Tx, userId string, moduleId string, moduleGroupId string) (security.Overrides, error) {
+	stmt, err := tx.PrepareContext(ctx, "select overrides from module_user_group_roles, "+
+		"module_user_groups, module_users, roles "+
 		"where roles.id = module_user_group_roles.role_id and "+
 		"module_user_group_roles.module_user_group_id = module_user_groups.id and "+
 		"module_users.user_id = $1 and "+
 		"module_user_groups.module_user_id = module_users.id and "+
 		"module_users.module_id = $2 and "+
-		"module_user_roles.role_id = roles.id and "+
-		"module_group_roles.module_group_id = $3;")
+		"module_user_groups.module_group_id = $3;")
 	if err != nil {
 		return security.PERMS_NONE, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(userId, moduleId, moduleGroupId)
+	rows, err := stmt.QueryContext(ctx, userId, moduleId, moduleGroupId)
 	if err != nil {
 		return security.PERMS_NONE, err
 	}
@@ -72,8 +72,8 @@ func getModuleGroupPerms(userId string, moduleId string, moduleGroupId string, p
 	return security.CalculatePermissionsInner(overrides), nil
 }
 
-func getModulePerms(userId string, moduleId string, pool *utils.DatabasePool, ctx context.Context) (security.Overrides, error) {
-	stmt, err := pool.Database.PrepareContext(ctx, "select overrides from roles, module_user_roles, module_users "+
+func getModulePerms(ctx context.Context, tx *sql.Tx, userId string, moduleId string) (security.Overrides, error) {
+	stmt, err := tx.PrepareContext(ctx, "select overrides from roles, module_user_roles, module_users "+
 		"where module_users.user_id = $1 and "+
 		"module_users.module_id = $2 and "+
 		"roles.id = module_user_roles.role_id and "+
@@ -83,7 +83,7 @@ func getModulePerms(userId string, moduleId string, pool *utils.DatabasePool, ct
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(userId, moduleId)
+	rows, err := stmt.QueryContext(ctx, userId, moduleId)
 	if err != nil {
 		return security.PERMS_NONE, err
 	}
@@ -104,15 +104,15 @@ func getModulePerms(userId string, moduleId string, pool *utils.DatabasePool, ct
 	return security.CalculatePermissionsInner(overrides), nil
 }
 
-func getGlobalPerms(userId string, pool *utils.DatabasePool, ctx context.Context) (security.Overrides, error) {
-	stmt, err := pool.Database.PrepareContext(ctx, "select overrides from roles, role_users "+
+func getGlobalPerms(ctx context.Context, tx *sql.Tx, userId string) (security.Overrides, error) {
+	stmt, err := tx.PrepareContext(ctx, "select overrides from roles, role_users "+
 		"where role_users.user_id = $1 and role_users.role_id = roles.id;")
 	if err != nil {
 		return security.PERMS_NONE, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(userId)
+	rows, err := stmt.QueryContext(ctx, userId)
 	if err != nil {
 		return security.PERMS_NONE, err
 	}
@@ -134,17 +134,35 @@ func getGlobalPerms(userId string, pool *utils.DatabasePool, ctx context.Context
 }
 
 /*
- * Permissions getter
- * For moduleId and, moduleGroupId these are set to blank if the layer is above their respective location.
- * If a layer has a blank ID then it skips the layer continues to the parent layer
+ * Checks that a module group is in a module, otherwise the module's permissions would apply to
+ * another module's group. ErrModuleGroupNotInModule is returned if it is not.
+ */
+func checkModuleGroupInModule(ctx context.Context, tx *sql.Tx, moduleId string, moduleGroupId string) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx, "select exists(select 1 from module_groups where id = $1 and module_id = $2);",
+		moduleGroupId, moduleId).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrModuleGroupNotInModule
+	}
+	return nil
+}
+
+/*
+ * Gets the raw overrides for each layer from the requested layer up to the global layer, the
+ * requested layer is first. Layers that have a blank ID are skipped, the module group must be in
+ * the module.
  */
-func GetPermissions(userId string, moduleId string, moduleGroupId string, l security.Layer, pool *utils.DatabasePool) (security.Overrides, error) {
+func getLayerPermissions(ctx context.Context, userId string, moduleId string, moduleGroupId string, l security.Layer, pool *utils.DatabasePool) ([]LayerPermissions, error) {
 	// Create transaction
 	success := false
-	ctx := context.Background()
 	tx, err := pool.Database.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
+		return nil, err
 	}
 	defer func() {
 		if success {
@@ -153,56 +171,118 @@ func GetPermissions(userId string, moduleId string, moduleGroupId string, l secu
 			tx.Rollback()
 		}
 	}()
+	if moduleId != "" && moduleGroupId != "" {
+		err = checkModuleGroupInModule(ctx, tx, moduleId, moduleGroupId)
+		if err == ErrModuleGroupNotInModule {
+			return nil, err
+		} else if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+	}
+
 	// Init ret
-	overrides := make([]security.Overrides, 0)
+	layers := make([]LayerPermissions, 0)
 
 	// All cases are meant to flow
 	switch l {
 	case security.Attendance:
-		t, err := getAttendancePerms(userId, pool, ctx)
+		t, err := getAttendancePerms(ctx, tx, userId)
 		if err != nil {
-			log.Println(err)
-			return security.PERMS_NONE, err
+			logging.FromContext(ctx).Error(err)
+			return nil, err
 		}
 
-		overrides = append(overrides, t)
+		layers = append(layers, LayerPermissions{Layer: security.Attendance, Overrides: t})
 		fallthrough
 	case security.ModuleGroup:
 		if moduleGroupId != "" && moduleId != "" {
-			t, err := getModuleGroupPerms(userId, moduleId, moduleGroupId, pool, ctx)
+			t, err := getModuleGroupPerms(ctx, tx, userId, moduleId, moduleGroupId)
 			if err != nil {
-				log.Println(err)
-				return security.PERMS_NONE, err
+				logging.FromContext(ctx).Error(err)
+				return nil, err
 			}
 
-			overrides = append(overrides, t)
+			layers = append(layers, LayerPermissions{Layer: security.ModuleGroup, Overrides: t})
 		}
 		fallthrough
 	case security.Module:
 		if moduleId != "" {
-			t, err := getModulePerms(userId, moduleId, pool, ctx)
+			t, err := getModulePerms(ctx, tx, userId, moduleId)
 			if err != nil {
-				log.Println(err)
-				return security.PERMS_NONE, err
+				logging.FromContext(ctx).Error(err)
+				return nil, err
 			}
 
-			overrides = append(overrides, t)
+			layers = append(layers, LayerPermissions{Layer: security.Module, Overrides: t})
 		}
 		fallthrough
 	case security.Global:
-		t, err := getGlobalPerms(userId, pool, ctx)
+		t, err := getGlobalPerms(ctx, tx, userId)
 		if err != nil {
-			log.Println(err)
-			return security.PERMS_NONE, err
+			logging.FromContext(ctx).Error(err)
+			return nil, err
 		}
 
-		overrides = append(overrides, t)
+		layers = append(layers, LayerPermissions{Layer: security.Global, Overrides: t})
 	}
 
-	if len(overrides) == 0 {
-		return security.PERMS_NONE, nil
+	success = true
+	return layers, nil
+}
+
+// The first layer is the current layer, see getLayerPermissions
+func calculateLayerPermissions(layers []LayerPermissions) security.Overrides {
+	if len(layers) == 0 {
+		return security.PERMS_NONE
 	}
 
-	success = true
-	return security.CalculatePermissions(overrides[0], overrides[1:]), nil
+	prevLayers := make([]security.Overrides, 0, len(layers)-1)
+	for _, layer := range layers[1:] {
+		prevLayers = append(prevLayers, layer.Overrides)
+	}
+
+	return security.CalculatePermissions(layers[0].Overrides, prevLayers)
+}
+
+/*
+ * Permissions getter
+ * For moduleId and, moduleGroupId these are set to blank if the layer is above their respective location.
+ * If a layer has a blank ID then it skips the layer continues to the parent layer
+ */
+func GetPermissions(ctx context.Context, userId string, moduleId string, moduleGroupId string, l security.Layer, pool *utils.DatabasePool) (security.Overrides, error) {
+	layers, err := getLayerPermissions(ctx, userId, moduleId, moduleGroupId, l, pool)
+	if err != nil {
+		return security.PERMS_NONE, err
+	}
+
+	return calculateLayerPermissions(layers), nil
+}
+
+/*
+ * Explains how a user's permissions are calculated, this is the same calculation as GetPermissions
+ * but, each layer's raw overrides are kept and, the flags are named.
+ */
+func ExplainPermissions(ctx context.Context, userId string, moduleId string, moduleGroupId string, l security.Layer, pool *utils.DatabasePool) (PermissionsExplanation, error) {
+	layers, err := getLayerPermissions(ctx, userId, moduleId, moduleGroupId, l, pool)
+	if err != nil {
+		return PermissionsExplanation{}, err
+	}
+
+	return explainLayerPermissions(userId, moduleId, moduleGroupId, l, layers), nil
+}
+
+func explainLayerPermissions(userId string, moduleId string, moduleGroupId string, l security.Layer, layers []LayerPermissions) PermissionsExplanation {
+	for i := range layers {
+		layers[i].Flags = layers[i].Overrides.Flags()
+	}
+
+	effective := calculateLayerPermissions(layers)
+	return PermissionsExplanation{UserId: userId,
+		ModuleId:      moduleId,
+		ModuleGroupId: moduleGroupId,
+		Layer:         l,
+		Layers:        layers,
+		Effective:     effective,
+		Flags:         effective.Flags()}
 }
@@ -4,6 +4,7 @@ import (
 	"arcio/attendance-system/config"
 	"arcio/attendance-system/security"
 	"arcio/attendance-system/utils"
+	"context"
 	"log"
 	"testing"
 )
@@ -51,7 +52,7 @@ func TestGetPermissionsGlobalLayer(t *testing.T) {
 		return
 	}
 
-	_, err = GetPermissions(id, "", "", security.Global, pool_pt)
+	_, err = GetPermissions(context.Background(), id, "", "", security.Global, pool_pt)
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -82,10 +83,52 @@ func TestGetPermissionsAttendance1(t *testing.T) {
 		return
 	}
 
-	_, err = GetPermissions(id, "", "", security.Attendance, pool_pt)
+	_, err = GetPermissions(context.Background(), id, "", "", security.Attendance, pool_pt)
 	if err != nil {
 		t.Log(err)
 		t.Fail()
 		return
 	}
 }
+
+func TestExplainLayerPermissions(t *testing.T) {
+	layers := []LayerPermissions{
+		{Layer: security.Module, Overrides: security.PERMS_READ_SELF},
+		{Layer: security.Global, Overrides: security.PERMS_CREATE_SELF | security.PERMS_CREATE_CHILDREN},
+	}
+
+	explanation := explainLayerPermissions("user", "module", "", security.Module, layers)
+
+	// The global self flag does not apply to the module layer
+	expected := security.Overrides(security.PERMS_READ_SELF | security.PERMS_CREATE_CHILDREN)
+	if explanation.Effective != expected {
+		t.Logf("Expected %d got %d", expected, explanation.Effective)
+		t.Fail()
+	}
+
+	if len(explanation.Flags) != 2 || len(explanation.Layers[1].Flags) != 2 {
+		t.Log("The flags were not named")
+		t.Fail()
+	}
+}
+
+func TestExplainPermissions(t *testing.T) {
+	var id string
+	err := pool_pt.Database.QueryRow("select id from users limit 1;").Scan(&id)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	explanation, err := ExplainPermissions(context.Background(), id, "", "", security.Global, pool_pt)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	perms, err := GetPermissions(context.Background(), id, "", "", security.Global, pool_pt)
+	if err != nil || perms != explanation.Effective {
+		t.Log("The explanation does not match GetPermissions")
+		t.Fail()
+	}
+}
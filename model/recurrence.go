@@ -0,0 +1,540 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * The recurrence engine for repeating lessons, the spawner, timetables and, the iCal export all
+ * use it so that they agree on when a lesson happens. Recurrences follow RFC 5545, the supported
+ * RRULE parts are FREQ (DAILY or, WEEKLY), INTERVAL, COUNT, UNTIL, BYDAY (without ordinals)
+ * and, WKST. Occurrences are found in the series' time zone so that a 10:00 lesson stays at 10:00
+ * across daylight saving changes.
+ */
+
+const (
+	FREQ_DAILY  = "DAILY"
+	FREQ_WEEKLY = "WEEKLY"
+)
+
+// Stops runaway recurrences, a lesson every day for ten years is under this. Series are checked
+// against it when they are made so that their later occurrences are not silently dropped.
+const RECURRENCE_LIMIT = 5000
+
+var ErrTooManyOccurrences = fmt.Errorf("A repeating lesson cannot have %d or, more occurrences, stop-repeating must be sooner", RECURRENCE_LIMIT)
+
+const (
+	ICAL_DATE_FORMAT          = "20060102"
+	ICAL_DATE_TIME_FORMAT     = "20060102T150405"
+	ICAL_DATE_TIME_UTC_FORMAT = "20060102T150405Z"
+	ICAL_WEEKDAYS             = "SUMOTUWETHFRSA" // In time.Weekday order
+)
+
+// The time zone that new repeating lessons are in when they do not set one, see config TIMEZONE
+var DefaultTimezone = "UTC"
+
+// How UNTIL was written, it changes how it is compared and, printed
+const (
+	untilUtc = iota
+	untilFloating
+	untilDate
+)
+
+type RecurrenceRule struct {
+	Freq      string
+	Interval  int
+	Count     int
+	Until     time.Time // Zero for no until, see untilForm
+	ByDay     []time.Weekday
+	WeekStart time.Weekday
+	untilForm int
+}
+
+type Recurrence struct {
+	Start    time.Time // The first possible occurrence, in the series' time zone
+	Duration time.Duration
+	Rule     RecurrenceRule
+	Stop     time.Time   // The last date that occurrences can start on, zero for no limit
+	ExDates  []time.Time // Starts of the occurrences that do not happen
+	Closed   []time.Time // Dates that the lesson does not happen on
+	RDates   []time.Time // Extra occurrences, in the series' time zone
+}
+
+type Occurrence struct {
+	Start     time.Time
+	End       time.Time
+	Exception bool // Whether the occurrence is an exception or, on a closed date
+}
+
+func parseIcalWeekday(day string) (time.Weekday, error) {
+	i := strings.Index(ICAL_WEEKDAYS, day)
+	if len(day) != 2 || i < 0 || i%2 != 0 {
+		return 0, fmt.Errorf("Invalid weekday %s, BYDAY ordinals are not supported", day)
+	}
+
+	return time.Weekday(i / 2), nil
+}
+
+func formatIcalWeekday(day time.Weekday) string {
+	return ICAL_WEEKDAYS[2*day : 2*day+2]
+}
+
+/*
+ * Parses an RFC 5545 RRULE value, the `RRULE:` prefix is optional.
+ */
+func ParseRecurrenceRule(rule string) (RecurrenceRule, error) {
+	ret := RecurrenceRule{Interval: 1, WeekStart: time.Monday}
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	if rule == "" {
+		return ret, errors.New("The rrule is empty")
+	}
+
+	for _, part := range strings.Split(rule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return ret, fmt.Errorf("Invalid rrule part %s", part)
+		}
+
+		key := strings.ToUpper(kv[0])
+		value := strings.ToUpper(kv[1])
+		var err error
+		switch key {
+		case "FREQ":
+			switch value {
+			case FREQ_DAILY, FREQ_WEEKLY:
+				ret.Freq = value
+			default:
+				return ret, fmt.Errorf("FREQ=%s is not supported, lessons repeat at most daily", value)
+			}
+		case "INTERVAL":
+			ret.Interval, err = strconv.Atoi(value)
+			if err != nil || ret.Interval < 1 {
+				return ret, errors.New("INTERVAL must be a positive integer")
+			}
+		case "COUNT":
+			ret.Count, err = strconv.Atoi(value)
+			if err != nil || ret.Count < 1 {
+				return ret, errors.New("COUNT must be a positive integer")
+			}
+		case "UNTIL":
+			if ret.Until, err = time.Parse(ICAL_DATE_TIME_UTC_FORMAT, value); err == nil {
+				ret.untilForm = untilUtc
+			} else if ret.Until, err = time.Parse(ICAL_DATE_TIME_FORMAT, value); err == nil {
+				ret.untilForm = untilFloating
+			} else if ret.Until, err = time.Parse(ICAL_DATE_FORMAT, value); err == nil {
+				ret.untilForm = untilDate
+			} else {
+				return ret, fmt.Errorf("Invalid UNTIL %s", value)
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday, err := parseIcalWeekday(day)
+				if err != nil {
+					return ret, err
+				}
+
+				ret.ByDay = append(ret.ByDay, weekday)
+			}
+		case "WKST":
+			ret.WeekStart, err = parseIcalWeekday(value)
+			if err != nil {
+				return ret, err
+			}
+		default:
+			return ret, fmt.Errorf("%s is not supported", key)
+		}
+	}
+
+	if ret.Freq == "" {
+		return ret, errors.New("The rrule must have a FREQ")
+	}
+
+	if ret.Count != 0 && !ret.Until.IsZero() {
+		return ret, errors.New("The rrule cannot have both COUNT and, UNTIL")
+	}
+
+	return ret, nil
+}
+
+func (r RecurrenceRule) String() string {
+	ret := "FREQ=" + r.Freq
+	if r.Interval > 1 {
+		ret += ";INTERVAL=" + strconv.Itoa(r.Interval)
+	}
+
+	if r.Count != 0 {
+		ret += ";COUNT=" + strconv.Itoa(r.Count)
+	}
+
+	if !r.Until.IsZero() {
+		switch r.untilForm {
+		case untilUtc:
+			ret += ";UNTIL=" + r.Until.UTC().Format(ICAL_DATE_TIME_UTC_FORMAT)
+		case untilFloating:
+			ret += ";UNTIL=" + r.Until.Format(ICAL_DATE_TIME_FORMAT)
+		case untilDate:
+			ret += ";UNTIL=" + r.Until.Format(ICAL_DATE_FORMAT)
+		}
+	}
+
+	if len(r.ByDay) != 0 {
+		days := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			days[i] = formatIcalWeekday(day)
+		}
+		ret += ";BYDAY=" + strings.Join(days, ",")
+	}
+
+	if r.WeekStart != time.Monday {
+		ret += ";WKST=" + formatIcalWeekday(r.WeekStart)
+	}
+
+	return ret
+}
+
+/*
+ * Returns the length of one interval of the rule, this is what repeat every is set to for
+ * series with a rule.
+ */
+func (r RecurrenceRule) Period() time.Duration {
+	units := map[string]time.Duration{FREQ_DAILY: DAY,
+		FREQ_WEEKLY: 7 * DAY}
+
+	return time.Duration(r.Interval) * units[r.Freq]
+}
+
+/*
+ * Returns the rule for a series that only has a repeat every, series without a time zone are
+ * in UTC so the old behaviour of adding the interval is kept.
+ */
+func recurrenceRuleFromPeriod(period time.Duration) (RecurrenceRule, error) {
+	ret := RecurrenceRule{Interval: 1, WeekStart: time.Monday}
+	switch {
+	case period < DAY || period%DAY != 0:
+		return ret, errors.New("repeat-every must be a whole number of days")
+	case period%(7*DAY) == 0:
+		ret.Freq, ret.Interval = FREQ_WEEKLY, int(period/(7*DAY))
+	default:
+		ret.Freq, ret.Interval = FREQ_DAILY, int(period/DAY)
+	}
+
+	return ret, nil
+}
+
+// The until as an instant, occurrences must start before it
+func (r RecurrenceRule) untilBefore(loc *time.Location) time.Time {
+	switch r.untilForm {
+	case untilFloating:
+		return inLocation(r.Until, loc).Add(time.Nanosecond)
+	case untilDate:
+		return inLocation(r.Until, loc).AddDate(0, 0, 1)
+	default:
+		return r.Until.Add(time.Nanosecond)
+	}
+}
+
+// Reads the wall clock time of t in another time zone
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// Days after the week start for a weekday
+func weekdayOffset(day time.Weekday, weekStart time.Weekday) int {
+	return (int(day) - int(weekStart) + 7) % 7
+}
+
+/*
+ * Returns a function that gives each occurrence of the rule in order, false is returned when
+ * there are no more.
+ */
+func (r Recurrence) ruleOccurrences() func() (time.Time, bool) {
+	rule := r.Rule
+	loc := r.Start.Location()
+	year, month, day := r.Start.Date()
+	hour, min, sec := r.Start.Clock()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, r.Start.Nanosecond(), loc)
+	}
+
+	// The weekdays that a DAILY rule is limited to
+	byDay := make(map[time.Weekday]bool)
+	for _, d := range rule.ByDay {
+		byDay[d] = true
+	}
+
+	offsets := make([]int, 0)
+	if rule.Freq == FREQ_WEEKLY {
+		days := rule.ByDay
+		if len(days) == 0 {
+			days = []time.Weekday{r.Start.Weekday()}
+		}
+
+		for _, d := range days {
+			offsets = append(offsets, weekdayOffset(d, rule.WeekStart))
+		}
+		sort.Ints(offsets)
+	}
+	weekStart := at(year, month, day-weekdayOffset(r.Start.Weekday(), rule.WeekStart))
+
+	var until time.Time
+	if !rule.Until.IsZero() {
+		until = rule.untilBefore(loc)
+	}
+
+	pending := make([]time.Time, 0)
+	period := 0
+	count := 0
+	return func() (time.Time, bool) {
+		for len(pending) == 0 {
+			// Each stops after RECURRENCE_LIMIT occurrences, this only stops a rule that has none
+			if period > 7*RECURRENCE_LIMIT {
+				return time.Time{}, false
+			}
+
+			switch rule.Freq {
+			case FREQ_WEEKLY:
+				base := weekStart.AddDate(0, 0, 7*period*rule.Interval)
+				for _, offset := range offsets {
+					t := at(base.Year(), base.Month(), base.Day()+offset)
+					if !t.Before(r.Start) {
+						pending = append(pending, t)
+					}
+				}
+			case FREQ_DAILY:
+				t := at(year, month, day+period*rule.Interval)
+				if len(byDay) == 0 || byDay[t.Weekday()] {
+					pending = append(pending, t)
+				}
+			}
+
+			period++
+		}
+
+		t := pending[0]
+		pending = pending[1:]
+		count++
+		if rule.Count != 0 && count > rule.Count {
+			return time.Time{}, false
+		}
+
+		if !until.IsZero() && !t.Before(until) {
+			return time.Time{}, false
+		}
+
+		if !r.Stop.IsZero() && toDate(t).After(toDate(r.Stop)) {
+			return time.Time{}, false
+		}
+
+		return t, true
+	}
+}
+
+func (r Recurrence) isException(t time.Time) bool {
+	for _, exception := range r.ExDates {
+		if exception.Equal(t) {
+			return true
+		}
+	}
+
+	for _, closed := range r.Closed {
+		if sameDate(closed, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * Calls fn with each occurrence in order until it returns false. Exceptions and, occurrences
+ * on closed dates are included, RDATEs are merged in with the rule's occurrences.
+ */
+func (r Recurrence) Each(fn func(occurrence Occurrence) bool) {
+	loc := r.Start.Location()
+	rdates := make([]time.Time, 0, len(r.RDates))
+	for _, rdate := range r.RDates {
+		rdate = inLocation(rdate, loc)
+		if r.Stop.IsZero() || !toDate(rdate).After(toDate(r.Stop)) {
+			rdates = append(rdates, rdate)
+		}
+	}
+	sort.Slice(rdates, func(i int, j int) bool {
+		return rdates[i].Before(rdates[j])
+	})
+
+	next := r.ruleOccurrences()
+	t, more := next()
+	for i := 0; i < RECURRENCE_LIMIT && (more || len(rdates) != 0); i++ {
+		var start time.Time
+		if more && (len(rdates) == 0 || !rdates[0].Before(t)) {
+			// An RDATE that is the same as an occurrence of the rule is only counted once
+			if len(rdates) != 0 && rdates[0].Equal(t) {
+				rdates = rdates[1:]
+			}
+
+			start = t
+			t, more = next()
+		} else {
+			start = rdates[0]
+			rdates = rdates[1:]
+		}
+
+		occurrence := Occurrence{Start: start,
+			End:       start.Add(r.Duration),
+			Exception: r.isException(start)}
+		if !fn(occurrence) {
+			return
+		}
+	}
+}
+
+/*
+ * Gets the occurrences that start in [from, to), exceptions and, occurrences on closed dates are included.
+ */
+func (r Recurrence) Between(from time.Time, to time.Time) []Occurrence {
+	ret := make([]Occurrence, 0)
+	r.Each(func(occurrence Occurrence) bool {
+		if !occurrence.Start.Before(to) {
+			return false
+		}
+
+		if !occurrence.Start.Before(from) {
+			ret = append(ret, occurrence)
+		}
+
+		return true
+	})
+
+	return ret
+}
+
+/*
+ * Checks that a repeating lesson stops before RECURRENCE_LIMIT occurrences, Each does not go
+ * past it.
+ */
+func checkRecurrenceLimit(lesson RepeatingLesson) error {
+	recurrence, err := lessonRecurrence(lesson)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	recurrence.Each(func(occurrence Occurrence) bool {
+		count++
+		return true
+	})
+
+	if count >= RECURRENCE_LIMIT {
+		return ErrTooManyOccurrences
+	}
+
+	return nil
+}
+
+/*
+ * Parses a comma separated list of RDATE values, these are wall clock times in the series'
+ * time zone.
+ */
+func ParseRDates(value string) ([]time.Time, error) {
+	ret := make([]time.Time, 0)
+	for _, rdate := range strings.Split(value, ",") {
+		rdate = strings.TrimSpace(rdate)
+		if rdate == "" {
+			continue
+		}
+
+		t, err := time.Parse(ICAL_DATE_TIME_FORMAT, rdate)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid rdate %s, the format is %s", rdate, ICAL_DATE_TIME_FORMAT)
+		}
+
+		ret = append(ret, t)
+	}
+
+	return ret, nil
+}
+
+func formatRDates(rdates []time.Time) string {
+	values := make([]string, len(rdates))
+	for i, rdate := range rdates {
+		values[i] = rdate.Format(ICAL_DATE_TIME_FORMAT)
+	}
+
+	return strings.Join(values, ",")
+}
+
+// The recurrence columns of repeating_lessons, see setRecurrenceColumns
+const REPEATING_LESSON_RECURRENCE_COLUMNS = "repeating_lessons.rrule, repeating_lessons.rdate, repeating_lessons.timezone"
+
+/*
+ * Sets a repeating lesson's recurrence from the columns.
+ */
+func setRecurrenceColumns(lesson *RepeatingLesson, rrule string, rdate string, timezone string) error {
+	rdates, err := ParseRDates(rdate)
+	if err != nil {
+		return err
+	}
+
+	lesson.RRule = rrule
+	lesson.RDates = rdates
+	lesson.Timezone = timezone
+	return nil
+}
+
+/*
+ * Gets the time zone of a repeating lesson, series without one are in UTC.
+ */
+func lessonLocation(lesson RepeatingLesson) *time.Location {
+	if lesson.Timezone == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(lesson.Timezone)
+	if err != nil {
+		logging.Default().Warnf("Repeating lesson %s has an invalid time zone %s", lesson.Id, lesson.Timezone)
+		return time.UTC
+	}
+
+	return loc
+}
+
+/*
+ * Gets the rule for a repeating lesson, lessons without an RRULE repeat every RepeatEvery.
+ */
+func lessonRecurrenceRule(lesson RepeatingLesson) (RecurrenceRule, error) {
+	if lesson.RRule == "" {
+		return recurrenceRuleFromPeriod(lesson.RepeatEvery)
+	}
+
+	return ParseRecurrenceRule(lesson.RRule)
+}
+
+/*
+ * Gets the recurrence for a repeating lesson. The series starts on the start repeating date at
+ * the start time and, stops after the stop repeating date.
+ */
+func lessonRecurrence(lesson RepeatingLesson) (Recurrence, error) {
+	rule, err := lessonRecurrenceRule(lesson)
+	if err != nil {
+		return Recurrence{}, err
+	}
+
+	// The start time is a wall clock time, see timeOfDay
+	start := time.Date(lesson.StartRepeating.Year(), lesson.StartRepeating.Month(), lesson.StartRepeating.Day(),
+		lesson.StartTime.Hour(), lesson.StartTime.Minute(), lesson.StartTime.Second(), lesson.StartTime.Nanosecond(),
+		lessonLocation(lesson))
+
+	return Recurrence{Start: start,
+		Duration: timeOfDay(lesson.EndTime) - timeOfDay(lesson.StartTime),
+		Rule:     rule,
+		Stop:     lesson.StopRepeating,
+		ExDates:  lesson.Exceptions,
+		Closed:   lesson.ClosedDates,
+		RDates:   lesson.RDates}, nil
+}
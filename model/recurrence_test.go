@@ -0,0 +1,211 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRecurrenceRule(t *testing.T) {
+	rules := map[string]string{
+		"FREQ=WEEKLY": "FREQ=WEEKLY",
+		"RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE":   "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE",
+		"freq=daily;count=10":                        "FREQ=DAILY;COUNT=10",
+		"FREQ=WEEKLY;UNTIL=20221216T235959Z;WKST=SU": "FREQ=WEEKLY;UNTIL=20221216T235959Z;WKST=SU",
+		"FREQ=WEEKLY;UNTIL=20221216":                 "FREQ=WEEKLY;UNTIL=20221216",
+	}
+
+	for rule, expected := range rules {
+		parsed, err := ParseRecurrenceRule(rule)
+		if err != nil {
+			t.Logf("Cannot parse %s - %s", rule, err)
+			t.Fail()
+		} else if parsed.String() != expected {
+			t.Logf("Expected %s but got %s", expected, parsed.String())
+			t.Fail()
+		}
+	}
+
+	invalid := []string{"",
+		"INTERVAL=2",
+		"FREQ=YEARLY",
+		"FREQ=WEEKLY;INTERVAL=0",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=WEEKLY;COUNT=2;UNTIL=20221216",
+		"FREQ=HOURLY",
+		"FREQ=MINUTELY;INTERVAL=30",
+		"FREQ=WEEKLY;BYMONTH=1"}
+	for _, rule := range invalid {
+		if _, err := ParseRecurrenceRule(rule); err == nil {
+			t.Logf("Expected %s to be invalid", rule)
+			t.Fail()
+		}
+	}
+}
+
+func testRecurrence(t *testing.T, rule string, loc *time.Location) Recurrence {
+	parsed, err := ParseRecurrenceRule(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mondays at 10:00 in the autumn term of 2022
+	return Recurrence{Start: time.Date(2022, 9, 26, 10, 0, 0, 0, loc),
+		Duration: time.Hour,
+		Rule:     parsed,
+		Stop:     time.Date(2022, 12, 16, 0, 0, 0, 0, time.UTC)}
+}
+
+func occurrenceDates(occurrences []Occurrence) []string {
+	ret := make([]string, 0)
+	for _, occurrence := range occurrences {
+		if !occurrence.Exception {
+			ret = append(ret, occurrence.Start.Format("01-02 15:04"))
+		}
+	}
+
+	return ret
+}
+
+func expectOccurrences(t *testing.T, occurrences []Occurrence, expected ...string) {
+	dates := occurrenceDates(occurrences)
+	if len(dates) != len(expected) {
+		t.Logf("Expected %v but got %v", expected, dates)
+		t.Fail()
+		return
+	}
+
+	for i := range dates {
+		if dates[i] != expected[i] {
+			t.Logf("Expected %v but got %v", expected, dates)
+			t.Fail()
+			return
+		}
+	}
+}
+
+func TestRecurrenceWeekly(t *testing.T) {
+	// Fortnightly with a reading week
+	recurrence := testRecurrence(t, "FREQ=WEEKLY;INTERVAL=2", time.UTC)
+	recurrence.ExDates = []time.Time{time.Date(2022, 11, 7, 10, 0, 0, 0, time.UTC)}
+	expectOccurrences(t, recurrence.Between(recurrence.Start, recurrence.Stop.Add(DAY)),
+		"09-26 10:00", "10-10 10:00", "10-24 10:00", "11-21 10:00", "12-05 10:00")
+
+	// Several days a week, days before the start are skipped
+	recurrence = testRecurrence(t, "FREQ=WEEKLY;BYDAY=FR,MO;COUNT=4", time.UTC)
+	recurrence.Start = time.Date(2022, 9, 28, 10, 0, 0, 0, time.UTC)
+	expectOccurrences(t, recurrence.Between(recurrence.Start, recurrence.Stop.Add(DAY)),
+		"09-30 10:00", "10-03 10:00", "10-07 10:00", "10-10 10:00")
+}
+
+func TestRecurrenceDaily(t *testing.T) {
+	recurrence := testRecurrence(t, "FREQ=DAILY;BYDAY=MO,TU;UNTIL=20221004", time.UTC)
+	expectOccurrences(t, recurrence.Between(recurrence.Start, recurrence.Stop.Add(DAY)),
+		"09-26 10:00", "09-27 10:00", "10-03 10:00", "10-04 10:00")
+
+	// The stop date is inclusive
+	recurrence = testRecurrence(t, "FREQ=DAILY", time.UTC)
+	recurrence.Stop = time.Date(2022, 9, 28, 0, 0, 0, 0, time.UTC)
+	expectOccurrences(t, recurrence.Between(recurrence.Start, recurrence.Stop.Add(DAY)),
+		"09-26 10:00", "09-27 10:00", "09-28 10:00")
+}
+
+func TestRecurrenceDaylightSaving(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("No time zone database")
+	}
+
+	// The clocks go back on the 30th of October
+	recurrence := testRecurrence(t, "FREQ=WEEKLY;COUNT=2", london)
+	recurrence.Start = time.Date(2022, 10, 24, 10, 0, 0, 0, london)
+	occurrences := recurrence.Between(recurrence.Start, recurrence.Stop.Add(DAY))
+	expectOccurrences(t, occurrences, "10-24 10:00", "10-31 10:00")
+
+	if len(occurrences) == 2 && occurrences[1].Start.Sub(occurrences[0].Start) != 7*DAY+time.Hour {
+		t.Log("The lesson did not stay at the same local time")
+		t.Fail()
+	}
+}
+
+func TestRecurrenceRDates(t *testing.T) {
+	recurrence := testRecurrence(t, "FREQ=WEEKLY;COUNT=2", time.UTC)
+	recurrence.RDates = []time.Time{time.Date(2022, 9, 29, 14, 0, 0, 0, time.UTC),
+		time.Date(2022, 10, 3, 10, 0, 0, 0, time.UTC), // Same as an occurrence
+		time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)}  // After the stop date
+
+	expectOccurrences(t, recurrence.Between(recurrence.Start, recurrence.Stop.Add(DAY)),
+		"09-26 10:00", "09-29 14:00", "10-03 10:00")
+
+	// Between is half open
+	expectOccurrences(t, recurrence.Between(time.Date(2022, 9, 29, 14, 0, 0, 0, time.UTC), time.Date(2022, 10, 3, 10, 0, 0, 0, time.UTC)),
+		"09-29 14:00")
+}
+
+func TestRecurrenceRuleFromPeriod(t *testing.T) {
+	periods := map[time.Duration]string{7 * DAY: "FREQ=WEEKLY",
+		14 * DAY: "FREQ=WEEKLY;INTERVAL=2",
+		3 * DAY:  "FREQ=DAILY;INTERVAL=3"}
+
+	for period, expected := range periods {
+		rule, err := recurrenceRuleFromPeriod(period)
+		if err != nil || rule.String() != expected {
+			t.Logf("Expected %s but got %s", expected, rule.String())
+			t.Fail()
+		}
+	}
+
+	for _, period := range []time.Duration{0, 2 * time.Hour, 36 * time.Hour} {
+		if _, err := recurrenceRuleFromPeriod(period); err == nil {
+			t.Logf("A period of %s should be invalid", period)
+			t.Fail()
+		}
+	}
+}
+
+func TestCheckRecurrenceLimit(t *testing.T) {
+	// Mondays for 20 years is under the limit
+	lesson := RepeatingLesson{StartRepeating: time.Date(2022, 9, 26, 0, 0, 0, 0, time.UTC),
+		StopRepeating: time.Date(2042, 9, 26, 0, 0, 0, 0, time.UTC),
+		StartTime:     time.Date(0, 1, 1, 10, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(0, 1, 1, 11, 0, 0, 0, time.UTC),
+		RRule:         "FREQ=WEEKLY;BYDAY=MO"}
+	if err := checkRecurrenceLimit(lesson); err != nil {
+		t.Log("Expected a weekly series for 20 years to be allowed", err)
+		t.Fail()
+	}
+
+	// Every day for 20 years is not
+	lesson.RRule = "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR,SA,SU"
+	if err := checkRecurrenceLimit(lesson); err != ErrTooManyOccurrences {
+		t.Log("Expected a daily series for 20 years to have too many occurrences", err)
+		t.Fail()
+	}
+}
+
+func TestSplitRecurrenceRule(t *testing.T) {
+	// Mondays and, Thursdays for 4 lessons, the last one is on Thursday the 6th
+	lesson := RepeatingLesson{StartRepeating: time.Date(2022, 9, 26, 0, 0, 0, 0, time.UTC),
+		StopRepeating: time.Date(2022, 12, 31, 0, 0, 0, 0, time.UTC),
+		StartTime:     time.Date(0, 1, 1, 10, 0, 0, 0, time.UTC),
+		EndTime:       time.Date(0, 1, 1, 11, 0, 0, 0, time.UTC),
+		RRule:         "FREQ=WEEKLY;COUNT=4;BYDAY=MO,TH",
+		RDates:        []time.Time{time.Date(2022, 12, 1, 10, 0, 0, 0, time.UTC)}}
+
+	rule, err := splitRecurrenceRule(lesson)
+	if err != nil || rule != "FREQ=WEEKLY;UNTIL=20221006;BYDAY=MO,TH" {
+		t.Log("Expected the count to become an until", rule, err)
+		t.Fail()
+	}
+
+	lesson.RRule = "FREQ=DAILY"
+	if rule, _ = splitRecurrenceRule(lesson); rule != lesson.RRule {
+		t.Log("Expected rules without a count to be kept", rule)
+		t.Fail()
+	}
+
+	before, after := splitRDates(lesson.RDates, time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC))
+	if len(before) != 0 || len(after) != 1 {
+		t.Log("Expected the rdate on the split date to move to the new series", before, after)
+		t.Fail()
+	}
+}
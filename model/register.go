@@ -0,0 +1,358 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+ * This file lets lecturers see and, mark the register for a lesson. The students expected at a
+ * lesson are the members of its module group that are not lecturers, see LECTURER_PERMS.
+ */
+
+var ErrLessonNotFound = errors.New("Cannot find lesson with matching id")
+
+// Gets the module and, module group a lesson is in
+func getLessonModule(ctx context.Context, lessonId string, pool *utils.DatabasePool) (string, string, error) {
+	if _, err := uuid.Parse(lessonId); err != nil {
+		return "", "", ErrLessonNotFound
+	}
+
+	var moduleId, moduleGroupId string
+	err := pool.Database.QueryRowContext(ctx, "select module_groups.module_id, group_lessons.module_group_id from actual_lessons "+
+		"inner join group_lessons on group_lessons.id = actual_lessons.group_lesson_id "+
+		"inner join module_groups on module_groups.id = group_lessons.module_group_id "+
+		"where actual_lessons.id = $1;", lessonId).Scan(&moduleId, &moduleGroupId)
+	if err == sql.ErrNoRows {
+		return "", "", ErrLessonNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return "", "", err
+	}
+
+	return moduleId, moduleGroupId, nil
+}
+
+/*
+ * Gets the module group a lesson is in, lessons in other modules are not found so that
+ * permissions in one module cannot be used on another module's lessons.
+ */
+func GetLessonModuleGroup(ctx context.Context, lessonId string, moduleId string, pool *utils.DatabasePool) (string, error) {
+	lessonModuleId, moduleGroupId, err := getLessonModule(ctx, lessonId, pool)
+	if err != nil {
+		return "", err
+	}
+
+	if lessonModuleId != moduleId {
+		return "", ErrLessonNotFound
+	}
+
+	return moduleGroupId, nil
+}
+
+// Gets a lesson and, the module group it is in
+func getLessonWithModuleGroup(ctx context.Context, lessonId string, pool *utils.DatabasePool) (ActualLesson, string, error) {
+	moduleId, moduleGroupId, err := getLessonModule(ctx, lessonId, pool)
+	if err != nil {
+		return ActualLesson{}, "", err
+	}
+
+	lesson, err := GetActualLesson(ctx, lessonId, moduleId, moduleGroupId, pool)
+	if err != nil {
+		return ActualLesson{}, "", err
+	}
+
+	return lesson, moduleGroupId, nil
+}
+
+// Gets a lesson in a module and, the module group it is in
+func getModuleLesson(ctx context.Context, lessonId string, moduleId string, pool *utils.DatabasePool) (ActualLesson, string, error) {
+	moduleGroupId, err := GetLessonModuleGroup(ctx, lessonId, moduleId, pool)
+	if err != nil {
+		return ActualLesson{}, "", err
+	}
+
+	lesson, err := GetActualLesson(ctx, lessonId, moduleId, moduleGroupId, pool)
+	if err != nil {
+		return ActualLesson{}, "", err
+	}
+
+	return lesson, moduleGroupId, nil
+}
+
+/*
+ * Gets the register for a lesson in a module with each student's mark.
+ */
+func GetLessonRegister(ctx context.Context, lessonId string, moduleId string, pool *utils.DatabasePool) (LessonRegister, error) {
+	lesson, moduleGroupId, err := getModuleLesson(ctx, lessonId, moduleId, pool)
+	if err != nil {
+		return LessonRegister{}, err
+	}
+
+	return getRegister(ctx, lesson, moduleGroupId, pool)
+}
+
+// Gets the register for a lesson in a module group
+func getRegister(ctx context.Context, lesson ActualLesson, moduleGroupId string, pool *utils.DatabasePool) (LessonRegister, error) {
+	students, err := getModuleGroupStudentIds(ctx, moduleGroupId, pool)
+	if err != nil {
+		return LessonRegister{}, err
+	}
+
+	isStudent := make(map[string]bool)
+	for _, student := range students {
+		isStudent[student] = true
+	}
+
+	rows, err := pool.Database.QueryContext(ctx, "select distinct on (users.surname, users.firstname, users.id) "+
+		"users.id, users.external_id, users.firstname, users.surname, users.email, "+
+		"coalesce(attendance.status, ''), coalesce(attendance.reason, ''), attendance.register_time, "+
+		"coalesce(attendance.marked_by::text, ''), coalesce(markers.firstname || ' ' || markers.surname, '') "+
+		"from module_user_groups "+
+		"inner join module_users on module_users.id = module_user_groups.module_user_id "+
+		"inner join users on users.id = module_users.user_id "+
+		"left join attendance on attendance.lesson_id = $2 and attendance.user_id = users.id "+
+		"left join users markers on markers.id = attendance.marked_by "+
+		"where module_user_groups.module_group_id = $1 "+
+		"order by users.surname, users.firstname, users.id, attendance.register_time desc;",
+		moduleGroupId, lesson.Id)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return LessonRegister{}, err
+	}
+	defer rows.Close()
+
+	ret := LessonRegister{Lesson: lesson, ModuleGroupId: moduleGroupId, Students: make([]RegisterEntry, 0)}
+	for rows.Next() {
+		var entry RegisterEntry
+		var registerTime sql.NullTime
+		err = rows.Scan(&entry.UserId, &entry.ExternalId, &entry.Fname, &entry.Sname, &entry.Email,
+			&entry.Status, &entry.Reason, &registerTime, &entry.MarkedBy, &entry.MarkedByName)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return LessonRegister{}, err
+		}
+
+		entry.RegisterTime = registerTime.Time
+		if isStudent[entry.UserId] {
+			ret.Students = append(ret.Students, entry)
+		}
+	}
+
+	return ret, nil
+}
+
+/*
+ * Checks the marks for a register, each student can only be marked once and, every status
+ * must be valid.
+ */
+func validateRegisterMarks(marks []RegisterMark, unmarkedStatus AttendanceStatus, students map[string]bool) error {
+	if unmarkedStatus != "" && !unmarkedStatus.IsValid() {
+		return errors.New("Invalid attendance status for the unmarked students")
+	}
+
+	seen := make(map[string]bool)
+	for _, mark := range marks {
+		if !mark.Status.IsValid() {
+			return fmt.Errorf("Invalid attendance status for user %s", mark.UserId)
+		}
+
+		if !students[mark.UserId] {
+			return fmt.Errorf("User %s is not on the register", mark.UserId)
+		}
+
+		if seen[mark.UserId] {
+			return fmt.Errorf("User %s is marked more than once", mark.UserId)
+		}
+		seen[mark.UserId] = true
+	}
+
+	return nil
+}
+
+/*
+ * Sets the statuses for students on the register of a lesson in a module, existing marks are changed and, the
+ * changes are stored as corrections, see corrections.go. Students that are not in marks and,
+ * have not been marked are given unmarkedStatus if it is set. Registers can only be marked while
+ * the lesson is happening.
+ *
+ * @param markedBy the lecturer making the marks
+ * @return the updated register
+ */
+func SetRegisterMarks(ctx context.Context, lessonId string, moduleId string, marks []RegisterMark, unmarkedStatus AttendanceStatus, markedBy string, pool *utils.DatabasePool) (LessonRegister, error) {
+	lesson, moduleGroupId, err := getModuleLesson(ctx, lessonId, moduleId, pool)
+	if err != nil {
+		return LessonRegister{}, err
+	}
+
+	students, err := getModuleGroupStudentIds(ctx, moduleGroupId, pool)
+	if err != nil {
+		return LessonRegister{}, err
+	}
+
+	isStudent := make(map[string]bool)
+	for _, student := range students {
+		isStudent[student] = true
+	}
+
+	err = validateRegisterMarks(marks, unmarkedStatus, isStudent)
+	if err != nil {
+		return LessonRegister{}, err
+	}
+
+	err = setRegisterMarks(ctx, lesson, students, marks, unmarkedStatus, markedBy, pool)
+	if err != nil {
+		return LessonRegister{}, err
+	}
+
+	return getRegister(ctx, lesson, moduleGroupId, pool)
+}
+
+func setRegisterMarks(ctx context.Context, lesson ActualLesson, students []string, marks []RegisterMark, unmarkedStatus AttendanceStatus, markedBy string, pool *utils.DatabasePool) error {
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer func() {
+		if !success {
+			tx.Rollback()
+		}
+	}()
+
+	// Locking the lesson stops two registers being marked at once
+	var cancelled, happening bool
+	err = tx.QueryRowContext(ctx, "select cancelled, start_time <= CURRENT_TIMESTAMP and end_time >= CURRENT_TIMESTAMP "+
+		"from actual_lessons where id = $1 for update;",
+		lesson.Id).Scan(&cancelled, &happening)
+	if err == sql.ErrNoRows {
+		return ErrLessonNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	now := time.Now()
+	if cancelled {
+		return errors.New("The lesson has been cancelled")
+	}
+
+	if !happening {
+		return errors.New("The register can only be marked while the lesson is happening")
+	}
+
+	marked := make(map[string]bool)
+	events := make([]AttendanceMarkedEvent, 0)
+	for _, mark := range marks {
+		marked[mark.UserId] = true
+		event := AttendanceMarkedEvent{LessonId: lesson.Id,
+			UserId:       mark.UserId,
+			Status:       mark.Status,
+			Reason:       mark.Reason,
+			MarkedBy:     markedBy,
+			RegisterTime: now}
+
+		var attendanceId string
+		old := LessonAttendance{LessonId: lesson.Id, UserId: mark.UserId}
+		err = tx.QueryRowContext(ctx, "select id, status, reason from attendance "+
+			"where lesson_id = $1 and user_id = $2 for update;",
+			lesson.Id, mark.UserId).Scan(&attendanceId, &old.Status, &old.Reason)
+		if err == sql.ErrNoRows {
+			_, err = tx.ExecContext(ctx, "insert into attendance (id, lesson_id, user_id, register_time, status, reason, marked_by) "+
+				"values ($1, $2, $3, $4, $5, $6, $7);",
+				uuid.New().String(), lesson.Id, mark.UserId, now, mark.Status, mark.Reason, markedBy)
+			if err != nil {
+				logging.FromContext(ctx).Error(err)
+				return err
+			}
+
+			events = append(events, event)
+			continue
+		} else if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return err
+		}
+
+		if old.Status == mark.Status && old.Reason == mark.Reason {
+			continue
+		}
+
+		// Changing an existing mark is a correction
+		correction, err := newCorrection(CORRECTION_AMEND, old, mark.Status, mark.Reason, markedBy, REGISTER_CORRECTION_REASON)
+		if err != nil {
+			return err
+		}
+		correction.AttendanceId = attendanceId
+
+		_, err = tx.ExecContext(ctx, "update attendance set status = $2, reason = $3, marked_by = $4 where id = $1;",
+			attendanceId, mark.Status, mark.Reason, markedBy)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return err
+		}
+
+		err = insertCorrection(ctx, tx, correction)
+		if err != nil {
+			return err
+		}
+
+		events = append(events, event)
+	}
+
+	if unmarkedStatus != "" {
+		for _, student := range students {
+			if marked[student] {
+				continue
+			}
+
+			res, err := tx.ExecContext(ctx, "insert into attendance (id, lesson_id, user_id, register_time, status, reason, marked_by) "+
+				"values ($1, $2, $3, $4, $5, '', $6) on conflict (lesson_id, user_id) do nothing;",
+				uuid.New().String(), lesson.Id, student, now, unmarkedStatus, markedBy)
+			if err != nil {
+				logging.FromContext(ctx).Error(err)
+				return err
+			}
+
+			count, err := res.RowsAffected()
+			if err != nil {
+				logging.FromContext(ctx).Error(err)
+				return err
+			}
+
+			if count != 0 {
+				events = append(events, AttendanceMarkedEvent{LessonId: lesson.Id,
+					UserId:       student,
+					Status:       unmarkedStatus,
+					MarkedBy:     markedBy,
+					RegisterTime: now})
+			}
+		}
+	}
+
+	logging.FromContext(ctx).Infof("Marked the register for lesson %s, %d marks", lesson.Id, len(marks))
+
+	for _, event := range events {
+		err = publishAttendanceMarked(ctx, tx, event)
+		if err != nil {
+			return err
+		}
+	}
+
+	success = true
+	err = tx.Commit()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,283 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/utils"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/*
+ * This file streams live updates to lesson registers, see GetLessonRegister. Marks are sent on
+ * a Postgres notification channel so that every replica hears about marks made on the others,
+ * each replica then passes them on to its subscribers with the register's running counts.
+ */
+
+const REGISTER_CHANNEL = "register_updates"
+
+// Register stream event types
+const (
+	REGISTER_EVENT_SNAPSHOT   = "register"   // The whole register, sent when subscribing
+	REGISTER_EVENT_MARK       = "mark"       // AttendanceMarkedEvent
+	REGISTER_EVENT_CORRECTION = "correction" // AttendanceCorrection
+)
+
+// Slow subscribers miss events instead of blocking the others
+const REGISTER_SUBSCRIBER_BUFFER = 16
+
+const REGISTER_LISTENER_MIN_RECONNECT = 5 * time.Second
+const REGISTER_LISTENER_MAX_RECONNECT = time.Minute
+
+// A notification on REGISTER_CHANNEL
+type registerNotification struct {
+	Type     string          `json:"type"`
+	LessonId string          `json:"lesson-id"`
+	Data     json.RawMessage `json:"data"`
+}
+
+/*
+ * Counts the students on a register by status.
+ */
+func countRegister(students []RegisterEntry) RegisterCounts {
+	ret := RegisterCounts{Expected: len(students), Breakdown: make(map[AttendanceStatus]int)}
+	for _, status := range ATTENDANCE_STATUSES {
+		ret.Breakdown[status] = 0
+	}
+
+	for _, student := range students {
+		if student.Status == "" {
+			ret.Unmarked++
+			continue
+		}
+
+		ret.Breakdown[student.Status]++
+		if student.Status.IsAttended() {
+			ret.Attended++
+		}
+	}
+
+	return ret
+}
+
+/*
+ * Passes register updates to the subscribers for each lesson.
+ */
+type RegisterBroker struct {
+	lock        sync.Mutex
+	subscribers map[string]map[chan RegisterStreamEvent]bool
+	closed      bool
+}
+
+func NewRegisterBroker() *RegisterBroker {
+	return &RegisterBroker{subscribers: make(map[string]map[chan RegisterStreamEvent]bool)}
+}
+
+// The broker for this process, see StartRegisterListener
+var RegisterStream = NewRegisterBroker()
+
+/*
+ * Subscribes to the updates for a lesson, the channel is closed when the broker is.
+ *
+ * @return the updates and, a func to unsubscribe
+ */
+func (b *RegisterBroker) Subscribe(lessonId string) (<-chan RegisterStreamEvent, func()) {
+	ch := make(chan RegisterStreamEvent, REGISTER_SUBSCRIBER_BUFFER)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	if b.subscribers[lessonId] == nil {
+		b.subscribers[lessonId] = make(map[chan RegisterStreamEvent]bool)
+	}
+	b.subscribers[lessonId][ch] = true
+
+	return ch, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if b.subscribers[lessonId][ch] {
+			delete(b.subscribers[lessonId], ch)
+			if len(b.subscribers[lessonId]) == 0 {
+				delete(b.subscribers, lessonId)
+			}
+			close(ch)
+		}
+	}
+}
+
+func (b *RegisterBroker) HasSubscribers(lessonId string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.subscribers[lessonId]) != 0
+}
+
+/*
+ * Sends an event to the subscribers for a lesson, subscribers with a full buffer miss it.
+ */
+func (b *RegisterBroker) Publish(lessonId string, event RegisterStreamEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for ch := range b.subscribers[lessonId] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+/*
+ * Closes every subscriber's channel, this is called on shutdown so that streams end.
+ */
+func (b *RegisterBroker) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, subscribers := range b.subscribers {
+		for ch := range subscribers {
+			close(ch)
+		}
+	}
+
+	b.subscribers = make(map[string]map[chan RegisterStreamEvent]bool)
+	b.closed = true
+}
+
+/*
+ * Gets the whole register for a lesson in a module with its counts, this is the first event on a
+ * stream.
+ */
+func GetRegisterSnapshot(ctx context.Context, lessonId string, moduleId string, pool *utils.DatabasePool) (RegisterStreamEvent, error) {
+	register, err := GetLessonRegister(ctx, lessonId, moduleId, pool)
+	if err != nil {
+		return RegisterStreamEvent{}, err
+	}
+
+	data, err := json.Marshal(register)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return RegisterStreamEvent{}, err
+	}
+
+	return RegisterStreamEvent{Type: REGISTER_EVENT_SNAPSHOT, Data: data, Counts: countRegister(register.Students)}, nil
+}
+
+/*
+ * Sends an update for a lesson's register on REGISTER_CHANNEL, Postgres only delivers it when the
+ * transaction commits so that streams do not see changes that are rolled back.
+ */
+func notifyRegister(ctx context.Context, tx *sql.Tx, eventType string, lessonId string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	payload, err := json.Marshal(registerNotification{Type: eventType, LessonId: lessonId, Data: raw})
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "select pg_notify($1, $2);", REGISTER_CHANNEL, string(payload))
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Sends a new mark to webhooks and, register streams when tx commits.
+ */
+func publishAttendanceMarked(ctx context.Context, tx *sql.Tx, event AttendanceMarkedEvent) error {
+	err := queueWebhookEventTx(ctx, tx, EVENT_ATTENDANCE_MARKED, event)
+	if err != nil {
+		return err
+	}
+
+	return notifyRegister(ctx, tx, REGISTER_EVENT_MARK, event.LessonId, event)
+}
+
+/*
+ * Sends a correction to webhooks and, register streams when tx commits.
+ */
+func publishAttendanceCorrected(ctx context.Context, tx *sql.Tx, correction AttendanceCorrection) error {
+	err := queueWebhookEventTx(ctx, tx, EVENT_ATTENDANCE_CORRECTED, correction)
+	if err != nil {
+		return err
+	}
+
+	return notifyRegister(ctx, tx, REGISTER_EVENT_CORRECTION, correction.LessonId, correction)
+}
+
+// Passes a notification to this process's subscribers with the register's counts
+func handleRegisterNotification(ctx context.Context, payload string, pool *utils.DatabasePool) {
+	var notification registerNotification
+	err := json.Unmarshal([]byte(payload), &notification)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return
+	}
+
+	if !RegisterStream.HasSubscribers(notification.LessonId) {
+		return
+	}
+
+	lesson, moduleGroupId, err := getLessonWithModuleGroup(ctx, notification.LessonId, pool)
+	if err != nil {
+		return
+	}
+
+	register, err := getRegister(ctx, lesson, moduleGroupId, pool)
+	if err != nil {
+		return
+	}
+
+	RegisterStream.Publish(notification.LessonId, RegisterStreamEvent{Type: notification.Type,
+		Data:   notification.Data,
+		Counts: countRegister(register.Students)})
+}
+
+/*
+ * Listens for register updates until the context is done, every replica runs this so that
+ * its subscribers get the updates.
+ *
+ * @param databaseUrl the connection string, see utils.DatabaseUrl
+ */
+func StartRegisterListener(ctx context.Context, databaseUrl string, pool *utils.DatabasePool) {
+	listener := pq.NewListener(databaseUrl, REGISTER_LISTENER_MIN_RECONNECT, REGISTER_LISTENER_MAX_RECONNECT,
+		func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				logging.FromContext(ctx).Error(err)
+			}
+		})
+	defer listener.Close()
+
+	err := listener.Listen(REGISTER_CHANNEL)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return
+	}
+
+	logging.FromContext(ctx).Info("Started register listener")
+	for {
+		select {
+		case <-ctx.Done():
+			logging.FromContext(ctx).Info("Stopped register listener")
+			return
+		case notification := <-listener.Notify:
+			// nil is sent after reconnecting, updates may have been missed
+			if notification != nil {
+				handleRegisterNotification(ctx, notification.Extra, pool)
+			}
+		}
+	}
+}
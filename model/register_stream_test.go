@@ -0,0 +1,69 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestCountRegister(t *testing.T) {
+	students := []RegisterEntry{{UserId: "a", Status: ATTENDANCE_PRESENT},
+		{UserId: "b", Status: ATTENDANCE_LATE},
+		{UserId: "c", Status: ATTENDANCE_UNAUTHORISED_ABSENCE},
+		{UserId: "d"}}
+
+	counts := countRegister(students)
+	if counts.Expected != 4 || counts.Attended != 2 || counts.Unmarked != 1 {
+		t.Log("Bad counts", counts)
+		t.FailNow()
+	}
+
+	if len(counts.Breakdown) != len(ATTENDANCE_STATUSES) || counts.Breakdown[ATTENDANCE_UNAUTHORISED_ABSENCE] != 1 || counts.Breakdown[ATTENDANCE_EXCUSED] != 0 {
+		t.Log("Bad breakdown", counts.Breakdown)
+		t.Fail()
+	}
+}
+
+func TestRegisterBroker(t *testing.T) {
+	broker := NewRegisterBroker()
+	events, unsubscribe := broker.Subscribe("lesson")
+	other, _ := broker.Subscribe("other")
+
+	if !broker.HasSubscribers("lesson") || broker.HasSubscribers("nobody") {
+		t.Log("Bad subscribers")
+		t.FailNow()
+	}
+
+	broker.Publish("lesson", RegisterStreamEvent{Type: REGISTER_EVENT_MARK})
+	if event := <-events; event.Type != REGISTER_EVENT_MARK {
+		t.Log("Expected the mark, got", event)
+		t.Fail()
+	}
+
+	if len(other) != 0 {
+		t.Log("Expected other lessons not to get the mark")
+		t.Fail()
+	}
+
+	// Full subscribers must not block publishing
+	for i := 0; i < REGISTER_SUBSCRIBER_BUFFER*2; i++ {
+		broker.Publish("lesson", RegisterStreamEvent{Type: REGISTER_EVENT_MARK})
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if broker.HasSubscribers("lesson") {
+		t.Log("Expected no subscribers after unsubscribing")
+		t.Fail()
+	}
+
+	broker.Close()
+	if _, ok := <-other; ok {
+		t.Log("Expected closing to end subscriptions")
+		t.Fail()
+	}
+
+	late, _ := broker.Subscribe("lesson")
+	if _, ok := <-late; ok {
+		t.Log("Expected subscriptions after closing to be closed")
+		t.Fail()
+	}
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestValidateRegisterMarks(t *testing.T) {
+	students := map[string]bool{"a": true, "b": true}
+
+	marks := []RegisterMark{{UserId: "a", Status: ATTENDANCE_PRESENT}, {UserId: "b", Status: ATTENDANCE_LATE, Reason: "Bus"}}
+	if err := validateRegisterMarks(marks, ATTENDANCE_UNAUTHORISED_ABSENCE, students); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if err := validateRegisterMarks(nil, "", students); err != nil {
+		t.Log("Expected an empty register to be valid", err)
+		t.Fail()
+	}
+
+	if err := validateRegisterMarks(nil, "asleep", students); err == nil {
+		t.Log("Expected an invalid unmarked status to be refused")
+		t.Fail()
+	}
+
+	if err := validateRegisterMarks([]RegisterMark{{UserId: "a", Status: "asleep"}}, "", students); err == nil {
+		t.Log("Expected an invalid status to be refused")
+		t.Fail()
+	}
+
+	if err := validateRegisterMarks([]RegisterMark{{UserId: "c", Status: ATTENDANCE_PRESENT}}, "", students); err == nil {
+		t.Log("Expected students that are not on the register to be refused")
+		t.Fail()
+	}
+
+	marks = []RegisterMark{{UserId: "a", Status: ATTENDANCE_PRESENT}, {UserId: "a", Status: ATTENDANCE_LATE}}
+	if err := validateRegisterMarks(marks, "", students); err == nil {
+		t.Log("Expected students that are marked twice to be refused")
+		t.Fail()
+	}
+}
@@ -1,27 +1,38 @@
 package model
 
 import (
+	"arcio/attendance-system/logging"
 	"arcio/attendance-system/utils"
-	"errors"
-	"log"
+	"context"
+	"database/sql"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 /*
 * This file creates an actual lesson when a repeating lesson is about to happen.
 * It does this by polling the database for new repeating lessons.
+* Each occurrence is spawned once, actual_lessons is unique on the repeating lesson and,
+* the start time so replicas cannot spawn the same lesson twice.
  */
 
-func GetDaemonRepeatingLessons(pool *utils.DatabasePool) ([]RepeatingLesson, error) {
-	rows, err := pool.Database.Query("SELECT (id, group_lesson_id, " +
-		"last_spawned_time, start_repeating, " +
-		"stop_repeating, start_time, end_time, repeat_every) " +
-		"FROM repeating_lessons " +
-		"WHERE start_repeating <= CURRENT_TIMESTAMP " +
-		"AND stop_repeating >= CURRENT_TIMESTAMP;")
+/*
+ * Gets the repeating lessons that may need to be spawned, these have started repeating
+ * or, start tomorrow and, have not stopped repeating.
+ */
+func GetDaemonRepeatingLessons(ctx context.Context, pool *utils.DatabasePool) ([]RepeatingLesson, error) {
+	rows, err := pool.Database.QueryContext(ctx, "SELECT id, group_lesson_id, "+
+		"last_spawned_time, start_repeating, "+
+		"stop_repeating, start_time, end_time, "+
+		"(EXTRACT(epoch FROM repeat_every) * 1000000000)::BIGINT, "+
+		REPEATING_LESSON_RECURRENCE_COLUMNS+" "+
+		"FROM repeating_lessons "+
+		"WHERE start_repeating <= CURRENT_DATE + 1 "+
+		"AND stop_repeating >= CURRENT_DATE;")
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -30,14 +41,35 @@ func GetDaemonRepeatingLessons(pool *utils.DatabasePool) ([]RepeatingLesson, err
 
 	for rows.Next() {
 		var lesson RepeatingLesson
-		rows.Scan(&lesson.Id,
+		var lastSpawnedTime sql.NullTime
+		var rrule, rdate, timezone string
+		err = rows.Scan(&lesson.Id,
 			&lesson.GroupLessonId,
-			&lesson.LastSpawnedTime,
+			&lastSpawnedTime,
 			&lesson.StartRepeating,
 			&lesson.StopRepeating,
-			&lesson.RepeatEvery,
 			&lesson.StartTime,
-			&lesson.EndTime)
+			&lesson.EndTime,
+			&lesson.RepeatEvery,
+			&rrule,
+			&rdate,
+			&timezone)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+		lesson.LastSpawnedTime = lastSpawnedTime.Time
+
+		err = setRecurrenceColumns(&lesson, rrule, rdate, timezone)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		lesson.Exceptions, err = GetRepeatingLessonExceptions(ctx, lesson.Id, pool)
+		if err != nil {
+			return nil, err
+		}
 
 		repeatingLessons = append(repeatingLessons, lesson)
 	}
@@ -45,65 +77,201 @@ func GetDaemonRepeatingLessons(pool *utils.DatabasePool) ([]RepeatingLesson, err
 	return repeatingLessons, nil
 }
 
+// Poll every 30 seconds
+const REPEATING_LESSON_POLL_TIME = 30 * time.Second
+
+// Lessons are spawned this long before they start so that check-in can be opened early
+const REPEATING_LESSON_SPAWN_AHEAD = 15 * time.Minute
+
+// Occurrences missed while the spawner was not running are only caught up if they started this recently
+const REPEATING_LESSON_CATCH_UP = 24 * time.Hour
+
 /*
-* Checks if any repeating lesson needs to be spawned.
-* Returns an ActualLesson or an error which indicates that the
-* lesson does not need to be spawned.
+ * Gets the occurrences of a repeating lesson after the last spawned one that start before
+ * the spawn ahead time, exceptions are not skipped. Occurrences that were missed while the
+ * spawner was not running are included up to REPEATING_LESSON_CATCH_UP ago, series that have
+ * never been spawned start from now so that their past occurrences are not made.
  */
-func __CheckLessonHappening(currentTime time.Time, lesson RepeatingLesson) (ActualLesson, error) {
-	if lesson.StopRepeating.Unix() < currentTime.Unix() {
-		return ActualLesson{}, errors.New("This lesson has stopped repeating")
+func pendingOccurrences(lesson RepeatingLesson, now time.Time) []ActualLesson {
+	ret := make([]ActualLesson, 0)
+	recurrence, err := lessonRecurrence(lesson)
+	if err != nil {
+		logging.Default().Errorf("Repeating lesson %s has an invalid rule - %s", lesson.Id, err)
+		return ret
 	}
 
-	var nullLessonTime time.Time
-	if lesson.LastSpawnedTime == nullLessonTime {
-		return ActualLesson{}, errors.New("This lesson was already made")
+	// Occurrences must start after this
+	from := lesson.LastSpawnedTime
+	if from.IsZero() {
+		from = now.Add(-time.Nanosecond)
+	} else if catchUp := now.Add(-REPEATING_LESSON_CATCH_UP); from.Before(catchUp) {
+		from = catchUp
 	}
 
-	startTime := lesson.StartTime.Unix()
-	endTime := lesson.EndTime.Unix()
-	startRepeating := lesson.StartRepeating.Unix()
-	interval := int64(lesson.RepeatEvery)
+	recurrence.Each(func(occurrence Occurrence) bool {
+		if occurrence.Start.After(now.Add(REPEATING_LESSON_SPAWN_AHEAD)) {
+			return false
+		}
+
+		if occurrence.Start.After(from) {
+			ret = append(ret, occurrenceLesson(lesson, occurrence))
+		}
+		return true
+	})
 
-	numberOfIntervals := (currentTime.Unix() - startTime - startRepeating) / interval
+	return ret
+}
 
-	//If the lesson start is bigger than current time and smaller than end time
-	//Then we know that the lesson is happening right now.
-	if startRepeating+(numberOfIntervals*interval)+startTime <= currentTime.Unix() &&
-		startRepeating+(numberOfIntervals*interval)+endTime >= currentTime.Unix() {
-		newActualLesson := ActualLesson{GroupLessonId: lesson.GroupLessonId,
-			StartTime: lesson.StartTime,
-			EndTime:   lesson.EndTime}
+/*
+ * Creates the lesson for an occurrence of a repeating lesson. Nothing is created if the
+ * occurrence has already been spawned, or if there is already a lesson for the group lesson
+ * at that time.
+ *
+ * @return the lesson and, whether it was created
+ */
+func spawnOccurrence(ctx context.Context, tx *sql.Tx, lesson RepeatingLesson, occurrence ActualLesson) (ActualLesson, bool, error) {
+	occurrence.Id = uuid.New().String()
+	occurrence.GroupLessonId = lesson.GroupLessonId
+	occurrence.RepeatingLessonId = lesson.Id
+	occurrence.CreationTime = time.Now()
+	occurrence.EditTime = occurrence.CreationTime
 
-		return newActualLesson, nil
+	err := tx.QueryRowContext(ctx, "insert into actual_lessons "+
+		"(id, group_lesson_id, start_time, end_time, creation_time, edit_time, summary, description, location, "+
+		"repeating_lesson_id, occurrence_start) "+
+		"select $1, id, $3, $4, $5, $5, summary, description, location, $6, $7 from group_lessons "+
+		"where id = $2 and not exists "+
+		"(select 1 from actual_lessons where group_lesson_id = $2 and start_time = $3) "+
+		"on conflict (repeating_lesson_id, start_time) do nothing "+
+		"returning summary, description, location;",
+		occurrence.Id, lesson.GroupLessonId, occurrence.StartTime.UTC(), occurrence.EndTime.UTC(), occurrence.CreationTime,
+		lesson.Id, occurrence.StartTime.UTC()).Scan(&occurrence.Summary, &occurrence.Description, &occurrence.Location)
+	if err == sql.ErrNoRows {
+		return ActualLesson{}, false, nil
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return ActualLesson{}, false, err
 	}
 
-	return ActualLesson{}, errors.New("Lesson is not occuring")
+	return occurrence, true, nil
 }
 
-func CheckLessonHappening(lesson RepeatingLesson) (ActualLesson, error) {
-	return __CheckLessonHappening(time.Now(), lesson)
+/*
+ * Spawns the pending occurrences of a repeating lesson and, records the last spawned time.
+ *
+ * @return the number of lessons that were created
+ */
+func spawnRepeatingLesson(ctx context.Context, lesson RepeatingLesson, now time.Time, pool *utils.DatabasePool) (int, error) {
+	occurrences := pendingOccurrences(lesson, now)
+	if len(occurrences) == 0 {
+		return 0, nil
+	}
+
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return 0, err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	spawned := make([]ActualLesson, 0)
+	for _, occurrence := range occurrences {
+		if isRepeatingLessonException(lesson, occurrence.StartTime) {
+			continue
+		}
+
+		spawnedLesson, created, err := spawnOccurrence(ctx, tx, lesson, occurrence)
+		if err != nil {
+			return 0, err
+		}
+
+		if created {
+			logging.FromContext(ctx).Infof("Spawned repeating lesson %s on %s", lesson.Id, occurrence.StartTime.Format("2006-01-02 15:04"))
+			spawned = append(spawned, spawnedLesson)
+		}
+	}
+
+	// Exceptions count as spawned so that they are not checked again
+	_, err = tx.ExecContext(ctx, "update repeating_lessons set last_spawned_time = $2 "+
+		"where id = $1 and (last_spawned_time is null or last_spawned_time < $2);",
+		lesson.Id, occurrences[len(occurrences)-1].StartTime.UTC())
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return 0, err
+	}
+
+	// The events are queued in the transaction so they are not sent if it is rolled back
+	for _, spawnedLesson := range spawned {
+		err = queueWebhookEventTx(ctx, tx, EVENT_LESSON_CREATED, spawnedLesson)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	success = true
+	return len(spawned), nil
 }
 
-// Poll every second
-const REPEATING_LESSON_POLL_TIME = time.Millisecond * 1000
+/*
+ * Spawns every repeating lesson that is about to happen.
+ *
+ * @return the number of lessons that were created
+ */
+func SpawnRepeatingLessons(ctx context.Context, now time.Time, pool *utils.DatabasePool) (int, error) {
+	lessons, err := GetDaemonRepeatingLessons(ctx, pool)
+	if err != nil {
+		return 0, err
+	}
+
+	// Lessons are not spawned on closed days, see academic_calendar.go
+	closures, err := GetClosures(ctx, time.Time{}, time.Time{}, pool)
+	if err != nil {
+		return 0, err
+	}
+
+	ret := 0
+	for _, lesson := range lessons {
+		if ctx.Err() != nil {
+			return ret, ctx.Err()
+		}
 
-func StartLessonSpawnDaemon(pool *utils.DatabasePool) {
-	defer log.Println("Lesson spawner daemon crashed")
-	for true {
-		lessons, err := GetDaemonRepeatingLessons(pool)
+		count, err := spawnRepeatingLesson(ctx, withClosures(lesson, closures), now, pool)
 		if err != nil {
-			log.Println(err)
-		} else {
-			for i := 0; i < len(lessons); i++ {
-				spawn, err := CheckLessonHappening(lessons[i])
-				if err == nil {
-					log.Printf("Creating new lesson %s %s\n", spawn.Summary, spawn.Description)
-					CreateActualLesson(spawn, pool)
-				}
-			}
+			logging.FromContext(ctx).Errorf("Cannot spawn repeating lesson %s - %s", lesson.Id, err)
+			continue
 		}
 
-		time.Sleep(REPEATING_LESSON_POLL_TIME)
+		ret += count
 	}
+
+	return ret, nil
+}
+
+/*
+ * Runs the spawner until the context is done, only one replica spawns lessons at a time.
+ * Approved absence requests are applied to the new lessons too. See utils/leader.go
+ */
+func StartLessonSpawnDaemon(ctx context.Context, pool *utils.DatabasePool) {
+	logging.FromContext(ctx).Info("Started lesson spawner daemon")
+	utils.RunAsLeader(ctx, pool, utils.LESSON_SPAWNER_LOCK, REPEATING_LESSON_POLL_TIME, func(ctx context.Context) {
+		_, err := SpawnRepeatingLessons(ctx, time.Now(), pool)
+		if err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).Error(err)
+		}
+
+		// New lessons may be covered by approved absence requests, see absence_requests.go
+		_, err = ApplyAbsenceRequests(ctx, time.Now(), pool)
+		if err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).Error(err)
+		}
+	})
+	logging.FromContext(ctx).Info("Stopped lesson spawner daemon")
 }
@@ -3,6 +3,7 @@ package model
 import (
 	"arcio/attendance-system/config"
 	"arcio/attendance-system/utils"
+	"context"
 	"testing"
 	"time"
 
@@ -32,7 +33,7 @@ func TestInitRepeatingLesson(t *testing.T) {
 }
 
 func TestGetDaemonRepeatingLessons(t *testing.T) {
-	repeatingLessons, err := GetDaemonRepeatingLessons(poolLdTest)
+	repeatingLessons, err := GetDaemonRepeatingLessons(context.Background(), poolLdTest)
 
 	if err != nil {
 		t.Log("Cannot get repeating lessons")
@@ -47,93 +48,54 @@ func TestGetDaemonRepeatingLessons(t *testing.T) {
 	}
 }
 
-func TestCheckLessonNotHappening(t *testing.T) {
-	//Started repeating in 1970, seems legit.
-	var startRepeating int64 = 0
-	var stopRepeating int64 = 60 * 60 * 24 * 7
-
-	var startTime int64 = 7 * 60 * 60
-	var endTime int64 = 19 * 60 * 60
-
-	var interval int64 = 60 * 60 * 24
-
-	happeningNow := RepeatingLesson{Id: uuid.New().String(),
-		GroupLessonId:   uuid.New().String(),
-		StartRepeating:  time.Unix(startRepeating, 0),
-		StopRepeating:   time.Unix(stopRepeating, 0),
-		StartTime:       time.Unix(startTime, 0),
-		EndTime:         time.Unix(endTime, 0),
-		CreationTime:    time.Now(),
-		EditTime:        time.Now(),
-		LastSpawnedTime: time.Now(),
-		RepeatEvery:     time.Duration(interval)}
-
-	_, err := CheckLessonHappening(happeningNow)
-
-	if err == nil {
-		t.Log("The lesson which is not happening is apparently happening - blame danny")
+func TestPendingOccurrences(t *testing.T) {
+	// Every day at 9 to 10 for a week
+	lesson := RepeatingLesson{Id: uuid.New().String(),
+		GroupLessonId:  uuid.New().String(),
+		StartRepeating: time.Date(2022, 7, 4, 0, 0, 0, 0, time.UTC),
+		StopRepeating:  time.Date(2022, 7, 10, 0, 0, 0, 0, time.UTC),
+		StartTime:      time.Date(0, 1, 1, 9, 0, 0, 0, time.UTC),
+		EndTime:        time.Date(0, 1, 1, 10, 0, 0, 0, time.UTC),
+		RepeatEvery:    DAY}
+
+	// Not spawned yet, so the past occurrences are not made
+	now := time.Date(2022, 7, 6, 8, 50, 0, 0, time.UTC)
+	occurrences := pendingOccurrences(lesson, now)
+	if len(occurrences) != 1 || !occurrences[0].StartTime.Equal(time.Date(2022, 7, 6, 9, 0, 0, 0, time.UTC)) {
+		t.Log("Expected only the next occurrence", occurrences)
 		t.Fail()
 	}
-}
 
-func TestCheckLessonHappening(t *testing.T) {
-	//Started repeating in 1970, seems legit.
-	var startRepeating int64 = 0
-	var stopRepeating int64 = 60 * 60 * 24 * 7
-
-	var startTime int64 = 7 * 60 * 60
-	var endTime int64 = 19 * 60 * 60
-
-	var interval int64 = 60 * 60 * 24
-
-	happeningNow := RepeatingLesson{Id: uuid.New().String(),
-		GroupLessonId:   uuid.New().String(),
-		StartRepeating:  time.Unix(startRepeating, 0),
-		StopRepeating:   time.Unix(stopRepeating, 0),
-		StartTime:       time.Unix(startTime, 0),
-		EndTime:         time.Unix(endTime, 0),
-		CreationTime:    time.Now(),
-		EditTime:        time.Now(),
-		LastSpawnedTime: time.Now(),
-		RepeatEvery:     time.Duration(interval)}
-
-	simulatedCurrentTime := time.Unix(startTime, 0)
+	// Already spawned
+	lesson.LastSpawnedTime = occurrences[0].StartTime
+	if occurrences = pendingOccurrences(lesson, now); len(occurrences) != 0 {
+		t.Log("Expected no occurrences after the last spawned one", occurrences)
+		t.Fail()
+	}
 
-	_, err := __CheckLessonHappening(simulatedCurrentTime, happeningNow)
+	// Missed occurrences are caught up for a day
+	now = time.Date(2022, 7, 9, 8, 50, 0, 0, time.UTC)
+	occurrences = pendingOccurrences(lesson, now)
+	if len(occurrences) != 2 || !occurrences[0].StartTime.Equal(time.Date(2022, 7, 8, 9, 0, 0, 0, time.UTC)) {
+		t.Log("Expected the occurrences in the catch up window", occurrences)
+		t.Fail()
+	}
 
-	if err != nil {
-		t.Log("The lesson which is happening is apparently not happening - blame danny")
+	// The stop repeating date is inclusive
+	lesson.LastSpawnedTime = occurrences[1].StartTime
+	now = time.Date(2022, 7, 11, 8, 50, 0, 0, time.UTC)
+	occurrences = pendingOccurrences(lesson, now)
+	if len(occurrences) != 1 || !occurrences[0].StartTime.Equal(time.Date(2022, 7, 10, 9, 0, 0, 0, time.UTC)) {
+		t.Log("Expected the occurrences up to the stop repeating date", occurrences)
 		t.Fail()
 	}
 }
 
-func TestCheckLessonNotHappeningWithinRepeating(t *testing.T) {
-	//Started repeating in 1970, seems legit.
-	var startRepeating int64 = 0
-	var stopRepeating int64 = 60 * 60 * 24 * 7
-
-	var startTime int64 = 7 * 60 * 60
-	var endTime int64 = 19 * 60 * 60
-
-	var interval int64 = 60 * 60 * 24
-
-	happeningNow := RepeatingLesson{Id: uuid.New().String(),
-		GroupLessonId:   uuid.New().String(),
-		StartRepeating:  time.Unix(startRepeating, 0),
-		StopRepeating:   time.Unix(stopRepeating, 0),
-		StartTime:       time.Unix(startTime, 0),
-		EndTime:         time.Unix(endTime, 0),
-		CreationTime:    time.Now(),
-		EditTime:        time.Now(),
-		LastSpawnedTime: time.Now(),
-		RepeatEvery:     time.Duration(interval)}
-
-	simulatedCurrentTime := time.Unix(0, 0)
-
-	_, err := __CheckLessonHappening(simulatedCurrentTime, happeningNow)
-
-	if err == nil {
-		t.Log("The lesson which is not happening is apparently happening - blame danny")
+func TestSpawnRepeatingLessons(t *testing.T) {
+	_, err := SpawnRepeatingLessons(context.Background(), time.Now(), poolLdTest)
+	if err != nil {
+		t.Log("Cannot spawn repeating lessons")
+		t.Log(err)
 		t.Fail()
 	}
 }
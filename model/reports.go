@@ -0,0 +1,310 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/utils"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/*
+ * Attendance reports have one row per student and, one column per lesson with the mark status.
+ * Only lessons that have ended and, are not cancelled are included, lessons for groups that a
+ * student is not in are left blank.
+ */
+
+// Lessons that a student should have attended but, has no mark for
+const REPORT_UNMARKED = "absent"
+
+type AttendanceReport struct {
+	Title   string                `json:"title"`
+	From    time.Time             `json:"from"`
+	To      time.Time             `json:"to"`
+	Lessons []ActualLesson        `json:"lessons"`
+	Rows    []AttendanceReportRow `json:"rows"`
+}
+
+type AttendanceReportRow struct {
+	User   User             `json:"user"`
+	Marks  []string         `json:"marks"` // One per lesson
+	Record AttendanceRecord `json:"record"`
+}
+
+// Lessons and, students are selected by a scope, $1 is the module, module group or, user id
+const (
+	REPORT_SCOPE_MODULE       = "module_groups.module_id = $1"
+	REPORT_SCOPE_MODULE_GROUP = "module_groups.id = $1"
+	REPORT_SCOPE_STUDENT      = "module_users.user_id = $1"
+)
+
+type reportLesson struct {
+	Lesson        ActualLesson
+	ModuleGroupId string
+}
+
+type reportStudent struct {
+	User   User
+	Groups map[string]bool
+}
+
+/*
+ * Returns the percentage of sessions attended, 0 if there are no sessions.
+ */
+func (r AttendanceRecord) Percentage() float64 {
+	if r.TotalSessions == 0 {
+		return 0
+	}
+
+	return float64(r.MarkedSessions) * 100 / float64(r.TotalSessions)
+}
+
+/*
+ * Builds a report from the lessons, the students and, their marks keyed by user id then lesson id.
+ */
+func buildAttendanceReport(title string, from time.Time, to time.Time, lessons []reportLesson, students []reportStudent, marks map[string]map[string]AttendanceStatus) AttendanceReport {
+	ret := AttendanceReport{Title: title,
+		From:    from,
+		To:      to,
+		Lessons: make([]ActualLesson, len(lessons)),
+		Rows:    make([]AttendanceReportRow, 0, len(students))}
+
+	for i, lesson := range lessons {
+		ret.Lessons[i] = lesson.Lesson
+	}
+
+	for _, student := range students {
+		row := AttendanceReportRow{User: student.User, Marks: make([]string, len(lessons))}
+		total := 0
+		counts := make([]int, len(ATTENDANCE_STATUSES))
+
+		for i, lesson := range lessons {
+			if !student.Groups[lesson.ModuleGroupId] {
+				continue
+			}
+
+			total++
+			status, marked := marks[student.User.InternalId][lesson.Lesson.Id]
+			if !marked {
+				row.Marks[i] = REPORT_UNMARKED
+				continue
+			}
+
+			row.Marks[i] = string(status)
+			for j, s := range ATTENDANCE_STATUSES {
+				if s == status {
+					counts[j]++
+				}
+			}
+		}
+
+		row.Record = newAttendanceRecord(total, counts)
+		ret.Rows = append(ret.Rows, row)
+	}
+
+	return ret
+}
+
+/*
+ * Gets an attendance report for a scope over a date range.
+ *
+ * @param scope the REPORT_SCOPE_* for the id
+ * @param id    the module, module group or, user id
+ * @param from  the start of the range, inclusive
+ * @param to    the end of the range, exclusive
+ */
+func getAttendanceReport(ctx context.Context, title string, scope string, id string, from time.Time, to time.Time, pool *utils.DatabasePool) (AttendanceReport, error) {
+	// Lessons
+	rows, err := pool.Database.QueryContext(ctx, "select distinct actual_lessons.id, actual_lessons.group_lesson_id, "+
+		"actual_lessons.start_time, actual_lessons.end_time, actual_lessons.summary, actual_lessons.location, "+
+		"module_groups.id "+
+		"from actual_lessons "+
+		"inner join group_lessons on group_lessons.id = actual_lessons.group_lesson_id "+
+		"inner join module_groups on module_groups.id = group_lessons.module_group_id "+
+		"left join module_user_groups on module_user_groups.module_group_id = module_groups.id "+
+		"left join module_users on module_users.id = module_user_groups.module_user_id "+
+		"where "+scope+" and not actual_lessons.cancelled and "+
+		"actual_lessons.end_time <= CURRENT_TIMESTAMP and "+
+		"actual_lessons.start_time >= $2 and actual_lessons.start_time < $3 "+
+		"order by actual_lessons.start_time asc;", id, from, to)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AttendanceReport{}, err
+	}
+	defer rows.Close()
+
+	lessons := make([]reportLesson, 0)
+	lessonIds := make([]string, 0)
+	for rows.Next() {
+		var lesson reportLesson
+		err = rows.Scan(&lesson.Lesson.Id, &lesson.Lesson.GroupLessonId, &lesson.Lesson.StartTime, &lesson.Lesson.EndTime,
+			&lesson.Lesson.Summary, &lesson.Lesson.Location, &lesson.ModuleGroupId)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return AttendanceReport{}, err
+		}
+
+		lessons = append(lessons, lesson)
+		lessonIds = append(lessonIds, lesson.Lesson.Id)
+	}
+
+	// Students and, their groups
+	rows, err = pool.Database.QueryContext(ctx, "select users.id, users.external_id, users.firstname, users.surname, users.email, "+
+		"module_groups.id "+
+		"from users "+
+		"inner join module_users on module_users.user_id = users.id "+
+		"inner join module_user_groups on module_user_groups.module_user_id = module_users.id "+
+		"inner join module_groups on module_groups.id = module_user_groups.module_group_id "+
+		"where "+scope+" "+
+		"order by users.surname asc, users.firstname asc;", id)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AttendanceReport{}, err
+	}
+	defer rows.Close()
+
+	students := make([]reportStudent, 0)
+	seen := make(map[string]int)
+	for rows.Next() {
+		var user User
+		var groupId string
+		err = rows.Scan(&user.InternalId, &user.ExternalId, &user.Fname, &user.Sname, &user.Email, &groupId)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return AttendanceReport{}, err
+		}
+
+		i, found := seen[user.InternalId]
+		if !found {
+			i = len(students)
+			seen[user.InternalId] = i
+			students = append(students, reportStudent{User: user, Groups: make(map[string]bool)})
+		}
+
+		students[i].Groups[groupId] = true
+	}
+
+	// Marks
+	rows, err = pool.Database.QueryContext(ctx, "select user_id, lesson_id, status from attendance where lesson_id = any($1);",
+		pq.Array(lessonIds))
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AttendanceReport{}, err
+	}
+	defer rows.Close()
+
+	marks := make(map[string]map[string]AttendanceStatus)
+	for rows.Next() {
+		var userId string
+		var lessonId string
+		var status AttendanceStatus
+		err = rows.Scan(&userId, &lessonId, &status)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return AttendanceReport{}, err
+		}
+
+		if marks[userId] == nil {
+			marks[userId] = make(map[string]AttendanceStatus)
+		}
+		marks[userId][lessonId] = status
+	}
+
+	return buildAttendanceReport(title, from, to, lessons, students, marks), nil
+}
+
+func GetModuleReport(ctx context.Context, moduleId string, from time.Time, to time.Time, pool *utils.DatabasePool) (AttendanceReport, error) {
+	var name string
+	err := pool.Database.QueryRowContext(ctx, "select name from modules where id = $1;", moduleId).Scan(&name)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AttendanceReport{}, err
+	}
+
+	return getAttendanceReport(ctx, name, REPORT_SCOPE_MODULE, moduleId, from, to, pool)
+}
+
+func GetModuleGroupReport(ctx context.Context, moduleGroupId string, from time.Time, to time.Time, pool *utils.DatabasePool) (AttendanceReport, error) {
+	var name string
+	err := pool.Database.QueryRowContext(ctx, "select modules.name || ' ' || module_groups.name from modules, module_groups "+
+		"where module_groups.id = $1 and modules.id = module_groups.module_id;", moduleGroupId).Scan(&name)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AttendanceReport{}, err
+	}
+
+	return getAttendanceReport(ctx, name, REPORT_SCOPE_MODULE_GROUP, moduleGroupId, from, to, pool)
+}
+
+func GetStudentReport(ctx context.Context, userId string, from time.Time, to time.Time, pool *utils.DatabasePool) (AttendanceReport, error) {
+	var name string
+	err := pool.Database.QueryRowContext(ctx, "select firstname || ' ' || surname from users where id = $1;", userId).Scan(&name)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return AttendanceReport{}, err
+	}
+
+	return getAttendanceReport(ctx, name, REPORT_SCOPE_STUDENT, userId, from, to, pool)
+}
+
+/*
+ * Returns the report as a table, the first row is the header.
+ */
+func (r AttendanceReport) Table() [][]interface{} {
+	header := []interface{}{"Student Number", "Firstname", "Surname"}
+	for _, lesson := range r.Lessons {
+		header = append(header, fmt.Sprintf("%s %s", lesson.Summary, lesson.StartTime.Format("2006-01-02 15:04")))
+	}
+	header = append(header, "Attended", "Excused", "Total", "Percentage")
+
+	ret := [][]interface{}{header}
+	for _, row := range r.Rows {
+		line := []interface{}{row.User.ExternalId, row.User.Fname, row.User.Sname}
+		for _, mark := range row.Marks {
+			line = append(line, mark)
+		}
+
+		excused := 0
+		for status, count := range row.Record.Breakdown {
+			if status.IsExcused() {
+				excused += count
+			}
+		}
+
+		line = append(line, row.Record.MarkedSessions, excused, row.Record.TotalSessions, row.Record.Percentage())
+		ret = append(ret, line)
+	}
+
+	return ret
+}
+
+func ExportReportAsCsv(report AttendanceReport, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	for _, row := range report.Table() {
+		record := make([]string, len(row))
+		for i, value := range row {
+			switch v := value.(type) {
+			case float64:
+				record[i] = fmt.Sprintf("%.2f", v)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+
+		err := writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func ExportReportAsXlsx(report AttendanceReport, w io.Writer) error {
+	return utils.WriteXlsx(w, report.Title, report.Table())
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+)
+
+func testReport() AttendanceReport {
+	start := time.Date(2022, 7, 4, 11, 0, 0, 0, time.UTC)
+	lessons := []reportLesson{
+		{Lesson: ActualLesson{Id: "l1", Summary: "Lecture", StartTime: start}, ModuleGroupId: "g1"},
+		{Lesson: ActualLesson{Id: "l2", Summary: "Lab", StartTime: start.Add(DAY)}, ModuleGroupId: "g2"},
+		{Lesson: ActualLesson{Id: "l3", Summary: "Lecture", StartTime: start.Add(7 * DAY)}, ModuleGroupId: "g1"},
+	}
+
+	students := []reportStudent{
+		{User: User{InternalId: "u1", ExternalId: "100123", Fname: "Beans", Sname: "Toast"}, Groups: map[string]bool{"g1": true, "g2": true}},
+		{User: User{InternalId: "u2", ExternalId: "100124", Fname: "Cheese", Sname: "Toast"}, Groups: map[string]bool{"g1": true}},
+	}
+
+	marks := map[string]map[string]AttendanceStatus{
+		"u1": {"l1": ATTENDANCE_PRESENT, "l2": ATTENDANCE_LATE, "l3": ATTENDANCE_EXCUSED},
+		"u2": {"l1": ATTENDANCE_PRESENT},
+	}
+
+	return buildAttendanceReport("Test", start, start.Add(14*DAY), lessons, students, marks)
+}
+
+func TestBuildAttendanceReport(t *testing.T) {
+	report := testReport()
+
+	if len(report.Rows) != 2 || len(report.Lessons) != 3 {
+		t.Log("Wrong report size")
+		t.FailNow()
+	}
+
+	// Excused sessions are not in the total
+	first := report.Rows[0].Record
+	if first.MarkedSessions != 2 || first.TotalSessions != 2 || first.Percentage() != 100 {
+		t.Logf("Wrong record for the first student %+v", first)
+		t.Fail()
+	}
+
+	// The lab is not for the second student and, they missed the second lecture
+	second := report.Rows[1]
+	if second.Marks[1] != "" || second.Marks[2] != REPORT_UNMARKED {
+		t.Logf("Wrong marks for the second student %v", second.Marks)
+		t.Fail()
+	}
+
+	if second.Record.TotalSessions != 2 || second.Record.Percentage() != 50 {
+		t.Logf("Wrong record for the second student %+v", second.Record)
+		t.Fail()
+	}
+}
+
+func TestExportReportAsCsv(t *testing.T) {
+	var buf bytes.Buffer
+	err := ExportReportAsCsv(testReport(), &buf)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// Header and, two students, 3 name columns, 3 lessons and, 4 totals
+	if len(records) != 3 || len(records[0]) != 10 {
+		t.Logf("Wrong table size %d %d", len(records), len(records[0]))
+		t.FailNow()
+	}
+
+	if records[2][9] != "50.00" {
+		t.Logf("Wrong percentage %s", records[2][9])
+		t.Fail()
+	}
+}
+
+func TestExportReportAsXlsx(t *testing.T) {
+	var buf bytes.Buffer
+	err := ExportReportAsXlsx(testReport(), &buf)
+	if err != nil || buf.Len() == 0 {
+		t.Log("The report was not exported")
+		t.Fail()
+	}
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"arcio/attendance-system/security"
+	"arcio/attendance-system/utils"
+	"context"
+	"errors"
+	"time"
+)
+
+/*
+ * This file has a repository for the core of each aggregate so that it can be used without
+ * Postgres. NewPostgresRepositories uses the database, NewMemoryRepositories keeps the core in
+ * memory for hermetic tests. Only the handlers listed in the README use the repositories, the
+ * others still need the database until they are moved behind repositories too, see "Still to
+ * move behind the repositories" in the README. See repository_postgres.go and,
+ * repository_memory.go
+ */
+
+var ErrUserNotFound = errors.New("Cannot find user with matching id")
+var ErrModuleNotFound = errors.New("Cannot find module with matching id")
+var ErrModuleGroupNotFound = errors.New("Cannot find module group with matching id")
+var ErrModuleGroupNotInModule = errors.New("The module group is not in the module")
+
+type UserRepository interface {
+	GetUsers(ctx context.Context) ([]User, error)
+	GetUser(ctx context.Context, userId string) (User, error)
+	GetUserByExternalId(ctx context.Context, externalId string) (User, error)
+	CreateUser(ctx context.Context, user *User) error
+}
+
+type ModuleRepository interface {
+	GetModules(ctx context.Context) ([]Module, error)
+	GetModule(ctx context.Context, moduleId string) (Module, error)
+	CreateModule(ctx context.Context, module *Module) error
+	AddUser(ctx context.Context, userId string, moduleId string) error
+	RemoveUser(ctx context.Context, userId string, moduleId string) error
+	GetUsers(ctx context.Context, moduleId string) ([]User, error)
+	GetUserModules(ctx context.Context, userId string) ([]Module, error)
+}
+
+type ModuleGroupRepository interface {
+	GetGroups(ctx context.Context, moduleId string) ([]ModuleGroup, error)
+	GetGroup(ctx context.Context, moduleGroupId string) (ModuleGroup, error)
+	CreateGroup(ctx context.Context, moduleGroup *ModuleGroup) error
+	AddUser(ctx context.Context, userId string, moduleGroupId string) error
+	GetUserGroups(ctx context.Context, userId string) ([]ModuleGroup, error)
+}
+
+type LessonRepository interface {
+	CreateGroupLesson(ctx context.Context, group *GroupLesson) error
+	CreateLesson(ctx context.Context, lesson *ActualLesson) error
+	GetLesson(ctx context.Context, lessonId string) (ActualLesson, string, error) // With the module group id
+	GetUserLessons(ctx context.Context, userId string) ([]ActualLesson, error)
+}
+
+type AttendanceRepository interface {
+	// A blank status is present or, late, see GetMarkStatus
+	RegisterAttendance(ctx context.Context, userId string, lessonId string, status AttendanceStatus, reason string, markedBy string) error
+	GetLessonMarks(ctx context.Context, lessonId string) ([]LessonAttendance, error)
+	GetStudentAttendance(ctx context.Context, userId string) (AttendanceRet, error)
+}
+
+type PermissionRepository interface {
+	CreateRole(ctx context.Context, role *Role) error
+	AssignRole(ctx context.Context, roleId string, userId string, moduleId string, moduleGroupId string, l security.Layer) error
+	// The requested layer is first, see getLayerPermissions
+	GetLayerPermissions(ctx context.Context, userId string, moduleId string, moduleGroupId string, l security.Layer) ([]LayerPermissions, error)
+}
+
+type Repositories struct {
+	Users        UserRepository
+	Modules      ModuleRepository
+	ModuleGroups ModuleGroupRepository
+	Lessons      LessonRepository
+	Attendance   AttendanceRepository
+	Permissions  PermissionRepository
+}
+
+/*
+ * Gets a user's permissions at a layer from any repository.
+ *
+ * @see GetPermissions
+ */
+func GetRepositoryPermissions(ctx context.Context, repo PermissionRepository, userId string, moduleId string, moduleGroupId string, l security.Layer) (security.Overrides, error) {
+	layers, err := repo.GetLayerPermissions(ctx, userId, moduleId, moduleGroupId, l)
+	if err != nil {
+		return security.PERMS_NONE, err
+	}
+
+	return calculateLayerPermissions(layers), nil
+}
+
+// Uses the database for everything
+func NewPostgresRepositories(pool *utils.DatabasePool) Repositories {
+	return Repositories{Users: postgresUsers{pool},
+		Modules:      postgresModules{pool},
+		ModuleGroups: postgresModuleGroups{pool},
+		Lessons:      postgresLessons{pool},
+		Attendance:   postgresAttendance{pool},
+		Permissions:  postgresPermissions{pool}}
+}
+
+/*
+ * Keeps everything in memory, now is used as the current time so that tests are deterministic.
+ *
+ * @param now the clock, time.Now if nil
+ */
+func NewMemoryRepositories(now func() time.Time) Repositories {
+	store := NewMemoryStore(now)
+	return Repositories{Users: store,
+		Modules:      memoryModules{store},
+		ModuleGroups: memoryModuleGroups{store},
+		Lessons:      store,
+		Attendance:   store,
+		Permissions:  store}
+}
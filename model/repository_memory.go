@@ -0,0 +1,640 @@
+package model
+
+import (
+	"arcio/attendance-system/security"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+ * The in-memory repositories, these follow the rules of the Postgres repositories so that routes
+ * and, model logic can be tested without a database. Room bookings and, webhooks are not
+ * checked or, sent. See repository.go
+ */
+
+// A role given to a user at a layer, ids below the layer are blank
+type memoryRoleAssignment struct {
+	RoleId        string
+	UserId        string
+	ModuleId      string
+	ModuleGroupId string
+	Layer         security.Layer
+}
+
+type memoryMark struct {
+	Mark     LessonAttendance
+	MarkedBy string
+}
+
+type MemoryStore struct {
+	lock sync.RWMutex
+	now  func() time.Time
+
+	users        map[string]User
+	modules      map[string]Module
+	moduleUsers  map[string]map[string]bool // Module id to user ids
+	moduleGroups map[string]ModuleGroup
+	groupUsers   map[string]map[string]bool // Module group id to user ids
+	groupLessons map[string]GroupLesson
+	lessons      map[string]ActualLesson
+	marks        []memoryMark
+	roles        map[string]Role
+	assignments  map[memoryRoleAssignment]bool
+}
+
+/*
+ * Creates an empty store, see NewMemoryRepositories.
+ *
+ * @param now the clock, time.Now if nil
+ */
+func NewMemoryStore(now func() time.Time) *MemoryStore {
+	if now == nil {
+		now = time.Now
+	}
+
+	return &MemoryStore{now: now,
+		users:        make(map[string]User),
+		modules:      make(map[string]Module),
+		moduleUsers:  make(map[string]map[string]bool),
+		moduleGroups: make(map[string]ModuleGroup),
+		groupUsers:   make(map[string]map[string]bool),
+		groupLessons: make(map[string]GroupLesson),
+		lessons:      make(map[string]ActualLesson),
+		marks:        make([]memoryMark, 0),
+		roles:        make(map[string]Role),
+		assignments:  make(map[memoryRoleAssignment]bool)}
+}
+
+// Users
+
+func (s *MemoryStore) GetUsers(ctx context.Context) ([]User, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ret := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		ret = append(ret, user)
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ExternalId < ret[j].ExternalId })
+	return ret, nil
+}
+
+func (s *MemoryStore) GetUser(ctx context.Context, userId string) (User, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	user, found := s.users[userId]
+	if !found {
+		return User{}, ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+func (s *MemoryStore) GetUserByExternalId(ctx context.Context, externalId string) (User, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, user := range s.users {
+		if user.ExternalId == externalId {
+			return user, nil
+		}
+	}
+
+	return User{}, ErrUserNotFound
+}
+
+func (s *MemoryStore) CreateUser(ctx context.Context, user *User) error {
+	err := validateUser(*user)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, other := range s.users {
+		if other.ExternalId == user.ExternalId || other.Email == user.Email {
+			return errors.New("A user with the external id or, email already exists")
+		}
+	}
+
+	user.InternalId = uuid.New().String()
+	user.CreationTime = s.now()
+	user.EditTime = user.CreationTime
+	s.users[user.InternalId] = *user
+	return nil
+}
+
+// Modules
+
+func (s *MemoryStore) inModule(userId string, moduleId string) bool {
+	return s.moduleUsers[moduleId][userId]
+}
+
+func (s *MemoryStore) inModuleGroup(userId string, moduleGroupId string) bool {
+	return s.groupUsers[moduleGroupId][userId]
+}
+
+// Modules and, module groups both have users so they are separate types
+type memoryModules struct {
+	*MemoryStore
+}
+
+func (s memoryModules) GetModules(ctx context.Context) ([]Module, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ret := make([]Module, 0, len(s.modules))
+	for _, module := range s.modules {
+		ret = append(ret, module)
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ExternalId < ret[j].ExternalId })
+	return ret, nil
+}
+
+func (s memoryModules) GetModule(ctx context.Context, moduleId string) (Module, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	module, found := s.modules[moduleId]
+	if !found {
+		return Module{}, ErrModuleNotFound
+	}
+
+	return module, nil
+}
+
+func (s memoryModules) CreateModule(ctx context.Context, module *Module) error {
+	err := validateModule(*module)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	module.Id = uuid.New().String()
+	module.CreationTime = s.now()
+	module.EditTime = module.CreationTime
+	s.modules[module.Id] = *module
+	s.moduleUsers[module.Id] = make(map[string]bool)
+	return nil
+}
+
+func (s memoryModules) AddUser(ctx context.Context, userId string, moduleId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, found := s.users[userId]; !found {
+		return ErrUserNotFound
+	}
+
+	if _, found := s.modules[moduleId]; !found {
+		return ErrModuleNotFound
+	}
+
+	if s.inModule(userId, moduleId) {
+		return errors.New("The user is already in the module")
+	}
+
+	s.moduleUsers[moduleId][userId] = true
+	return nil
+}
+
+// Users are removed from the module's groups too
+func (s memoryModules) RemoveUser(ctx context.Context, userId string, moduleId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.inModule(userId, moduleId) {
+		return errors.New("cannot find user")
+	}
+
+	delete(s.moduleUsers[moduleId], userId)
+	for _, moduleGroup := range s.moduleGroups {
+		if moduleGroup.ModuleId == moduleId {
+			delete(s.groupUsers[moduleGroup.Id], userId)
+		}
+	}
+
+	for assignment := range s.assignments {
+		if assignment.UserId == userId && assignment.ModuleId == moduleId {
+			delete(s.assignments, assignment)
+		}
+	}
+
+	return nil
+}
+
+func (s memoryModules) GetUsers(ctx context.Context, moduleId string) ([]User, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ret := make([]User, 0)
+	for userId := range s.moduleUsers[moduleId] {
+		ret = append(ret, s.users[userId])
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ExternalId < ret[j].ExternalId })
+	return ret, nil
+}
+
+func (s memoryModules) GetUserModules(ctx context.Context, userId string) ([]Module, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ret := make([]Module, 0)
+	for moduleId, module := range s.modules {
+		if s.inModule(userId, moduleId) {
+			ret = append(ret, module)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ExternalId < ret[j].ExternalId })
+	return ret, nil
+}
+
+// Module groups
+
+type memoryModuleGroups struct {
+	*MemoryStore
+}
+
+func (s memoryModuleGroups) GetGroups(ctx context.Context, moduleId string) ([]ModuleGroup, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ret := make([]ModuleGroup, 0)
+	for _, moduleGroup := range s.moduleGroups {
+		if moduleGroup.ModuleId == moduleId {
+			ret = append(ret, moduleGroup)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret, nil
+}
+
+func (s memoryModuleGroups) GetGroup(ctx context.Context, moduleGroupId string) (ModuleGroup, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	moduleGroup, found := s.moduleGroups[moduleGroupId]
+	if !found {
+		return ModuleGroup{}, ErrModuleGroupNotFound
+	}
+
+	return moduleGroup, nil
+}
+
+func (s memoryModuleGroups) CreateGroup(ctx context.Context, moduleGroup *ModuleGroup) error {
+	err := validateModuleGroup(*moduleGroup)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, found := s.modules[moduleGroup.ModuleId]; !found {
+		return ErrModuleNotFound
+	}
+
+	moduleGroup.Id = uuid.New().String()
+	moduleGroup.CreationTime = s.now()
+	moduleGroup.EditTime = moduleGroup.CreationTime
+	s.moduleGroups[moduleGroup.Id] = *moduleGroup
+	s.groupUsers[moduleGroup.Id] = make(map[string]bool)
+	return nil
+}
+
+// Users must be in the group's module first, see AddUserToModuleGroup
+func (s memoryModuleGroups) AddUser(ctx context.Context, userId string, moduleGroupId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	moduleGroup, found := s.moduleGroups[moduleGroupId]
+	if !found || !s.inModule(userId, moduleGroup.ModuleId) {
+		return errors.New("User not found in module")
+	}
+
+	if s.inModuleGroup(userId, moduleGroupId) {
+		return errors.New("The user is already in the module group")
+	}
+
+	s.groupUsers[moduleGroupId][userId] = true
+	return nil
+}
+
+func (s memoryModuleGroups) GetUserGroups(ctx context.Context, userId string) ([]ModuleGroup, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ret := make([]ModuleGroup, 0)
+	for moduleGroupId, moduleGroup := range s.moduleGroups {
+		if s.inModuleGroup(userId, moduleGroupId) {
+			ret = append(ret, moduleGroup)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret, nil
+}
+
+// Lessons
+
+func (s *MemoryStore) CreateGroupLesson(ctx context.Context, group *GroupLesson) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, found := s.moduleGroups[group.ModuleGroupId]; !found {
+		return ErrModuleGroupNotFound
+	}
+
+	group.Id = uuid.New().String()
+	group.CreationTime = s.now()
+	group.EditTime = group.CreationTime
+	s.groupLessons[group.Id] = *group
+	return nil
+}
+
+// The summary, description and, location are copied from the group lesson
+func (s *MemoryStore) CreateLesson(ctx context.Context, lesson *ActualLesson) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	group, found := s.groupLessons[lesson.GroupLessonId]
+	if !found {
+		return errors.New("Cannot find group lesson with matching id")
+	}
+
+	lesson.Id = uuid.New().String()
+	lesson.CreationTime = s.now()
+	lesson.EditTime = lesson.CreationTime
+	lesson.Summary = group.Summary
+	lesson.Description = group.Description
+	lesson.Location = group.Location
+	s.lessons[lesson.Id] = *lesson
+	return nil
+}
+
+func (s *MemoryStore) getLesson(lessonId string) (ActualLesson, string, error) {
+	lesson, found := s.lessons[lessonId]
+	if !found {
+		return ActualLesson{}, "", ErrLessonNotFound
+	}
+
+	return lesson, s.groupLessons[lesson.GroupLessonId].ModuleGroupId, nil
+}
+
+func (s *MemoryStore) GetLesson(ctx context.Context, lessonId string) (ActualLesson, string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.getLesson(lessonId)
+}
+
+// Lessons that ended over 3 weeks ago are not included, see GetLessons
+func (s *MemoryStore) GetUserLessons(ctx context.Context, userId string) ([]ActualLesson, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	from := s.now().Add(-3 * 7 * 24 * time.Hour)
+	ret := make([]ActualLesson, 0)
+	for _, lesson := range s.lessons {
+		if s.inModuleGroup(userId, s.groupLessons[lesson.GroupLessonId].ModuleGroupId) && !lesson.EndTime.Before(from) {
+			ret = append(ret, lesson)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].StartTime.Before(ret[j].StartTime) })
+	if len(ret) > LESSON_QUERY_LIMIT {
+		ret = ret[:LESSON_QUERY_LIMIT]
+	}
+
+	return ret, nil
+}
+
+// Attendance
+
+// Marks can only be made while the lesson is happening, see RegisterAttendanceBy
+func (s *MemoryStore) RegisterAttendance(ctx context.Context, userId string, lessonId string, status AttendanceStatus, reason string, markedBy string) error {
+	if status != "" && !status.IsValid() {
+		return errors.New("Invalid attendance status")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now()
+	lesson, moduleGroupId, err := s.getLesson(lessonId)
+	if err != nil || lesson.Cancelled || !s.inModuleGroup(userId, moduleGroupId) ||
+		now.Before(lesson.StartTime) || now.After(lesson.EndTime) {
+		return errors.New("Lesson not found")
+	}
+
+	if status == "" {
+		status = GetMarkStatus(lesson.StartTime, now)
+	}
+
+	mark := memoryMark{Mark: LessonAttendance{LessonId: lessonId,
+		UserId:       userId,
+		RegisterTime: now,
+		Status:       status,
+		Reason:       reason}, MarkedBy: markedBy}
+
+	// Marking a student again changes their mark, see attendance_lesson_user_idx
+	for i, existing := range s.marks {
+		if existing.Mark.LessonId == lessonId && existing.Mark.UserId == userId {
+			s.marks[i] = mark
+			return nil
+		}
+	}
+
+	s.marks = append(s.marks, mark)
+	return nil
+}
+
+func (s *MemoryStore) GetLessonMarks(ctx context.Context, lessonId string) ([]LessonAttendance, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ret := make([]LessonAttendance, 0)
+	for _, mark := range s.marks {
+		if mark.Mark.LessonId == lessonId {
+			ret = append(ret, mark.Mark)
+		}
+	}
+
+	return ret, nil
+}
+
+// Only lessons that have ended and, are not cancelled count, see GetStudentAttendancePercentages
+func (s *MemoryStore) GetStudentAttendance(ctx context.Context, userId string) (AttendanceRet, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	now := s.now()
+	totals := make(map[string]int)
+	moduleLessons := make(map[string]string) // Lesson id to module id
+	for _, lesson := range s.lessons {
+		moduleGroupId := s.groupLessons[lesson.GroupLessonId].ModuleGroupId
+		if lesson.Cancelled || lesson.EndTime.After(now) || !s.inModuleGroup(userId, moduleGroupId) {
+			continue
+		}
+
+		moduleId := s.moduleGroups[moduleGroupId].ModuleId
+		totals[moduleId]++
+		moduleLessons[lesson.Id] = moduleId
+	}
+
+	counts := make(map[string][]int)
+	for _, mark := range s.marks {
+		moduleId, found := moduleLessons[mark.Mark.LessonId]
+		if !found || mark.Mark.UserId != userId {
+			continue
+		}
+
+		if counts[moduleId] == nil {
+			counts[moduleId] = make([]int, len(ATTENDANCE_STATUSES))
+		}
+
+		for i, status := range ATTENDANCE_STATUSES {
+			if mark.Mark.Status == status {
+				counts[moduleId][i]++
+			}
+		}
+	}
+
+	ret := make(map[string]AttendanceRecord)
+	for moduleId, total := range totals {
+		ret[moduleId] = newAttendanceRecord(total, counts[moduleId])
+	}
+
+	return AttendanceRet{ModuleAttendance: ret}, nil
+}
+
+// Permissions
+
+func (s *MemoryStore) CreateRole(ctx context.Context, role *Role) error {
+	err := validateRole(*role)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	role.Id = uuid.New().String()
+	role.Overrides &= security.PERMS_VALID_MASK
+	role.CreationTime = s.now()
+	role.EditTime = role.CreationTime
+	s.roles[role.Id] = *role
+	return nil
+}
+
+/*
+ * Gets the key for a role assignment, ids above the layer are cleared. For the module and,
+ * module group layers the user must be in the module or, module group.
+ *
+ * @see getRoleAssignee
+ */
+func (s *MemoryStore) roleAssignment(roleId string, userId string, moduleId string, moduleGroupId string, l security.Layer) (memoryRoleAssignment, error) {
+	ret := memoryRoleAssignment{RoleId: roleId, UserId: userId, Layer: l}
+	switch l {
+	case security.Global, security.Attendance:
+	case security.Module:
+		if !s.inModule(userId, moduleId) {
+			return memoryRoleAssignment{}, errors.New("The user is not in the module")
+		}
+
+		ret.ModuleId = moduleId
+	case security.ModuleGroup:
+		if !s.inModule(userId, moduleId) || s.moduleGroups[moduleGroupId].ModuleId != moduleId ||
+			!s.inModuleGroup(userId, moduleGroupId) {
+			return memoryRoleAssignment{}, errors.New("The user is not in the module group")
+		}
+
+		ret.ModuleId = moduleId
+		ret.ModuleGroupId = moduleGroupId
+	default:
+		return memoryRoleAssignment{}, errors.New("Invalid layer")
+	}
+
+	return ret, nil
+}
+
+// Assigning a role twice is not an error, see AssignRole
+func (s *MemoryStore) AssignRole(ctx context.Context, roleId string, userId string, moduleId string, moduleGroupId string, l security.Layer) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, found := s.roles[roleId]; !found {
+		return ErrRoleNotFound
+	}
+
+	assignment, err := s.roleAssignment(roleId, userId, moduleId, moduleGroupId, l)
+	if err != nil {
+		return err
+	}
+
+	s.assignments[assignment] = true
+	return nil
+}
+
+// Combines the overrides of the roles a user has at a layer
+func (s *MemoryStore) layerOverrides(userId string, moduleId string, moduleGroupId string, l security.Layer) security.Overrides {
+	overrides := make([]security.Overrides, 0)
+	for assignment := range s.assignments {
+		if assignment.UserId == userId && assignment.Layer == l &&
+			assignment.ModuleId == moduleId && assignment.ModuleGroupId == moduleGroupId {
+			overrides = append(overrides, s.roles[assignment.RoleId].Overrides)
+		}
+	}
+
+	return security.CalculatePermissionsInner(overrides)
+}
+
+// Layers that have a blank id are skipped, see getLayerPermissions
+func (s *MemoryStore) GetLayerPermissions(ctx context.Context, userId string, moduleId string, moduleGroupId string, l security.Layer) ([]LayerPermissions, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if moduleId != "" && moduleGroupId != "" && s.moduleGroups[moduleGroupId].ModuleId != moduleId {
+		return nil, ErrModuleGroupNotInModule
+	}
+
+	layers := make([]LayerPermissions, 0)
+
+	// All cases are meant to flow
+	switch l {
+	case security.Attendance:
+		layers = append(layers, LayerPermissions{Layer: security.Attendance,
+			Overrides: s.layerOverrides(userId, "", "", security.Attendance)})
+		fallthrough
+	case security.ModuleGroup:
+		if moduleGroupId != "" && moduleId != "" {
+			layers = append(layers, LayerPermissions{Layer: security.ModuleGroup,
+				Overrides: s.layerOverrides(userId, moduleId, moduleGroupId, security.ModuleGroup)})
+		}
+		fallthrough
+	case security.Module:
+		if moduleId != "" {
+			layers = append(layers, LayerPermissions{Layer: security.Module,
+				Overrides: s.layerOverrides(userId, moduleId, "", security.Module)})
+		}
+		fallthrough
+	case security.Global:
+		layers = append(layers, LayerPermissions{Layer: security.Global,
+			Overrides: s.layerOverrides(userId, "", "", security.Global)})
+	}
+
+	return layers, nil
+}
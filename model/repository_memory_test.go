@@ -0,0 +1,153 @@
+package model
+
+import (
+	"arcio/attendance-system/security"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryRepositories(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2022, 3, 1, 10, 5, 0, 0, time.UTC)
+	store := NewMemoryRepositories(func() time.Time { return now })
+
+	student := User{ExternalId: "w1", Fname: "Bob", Sname: "McTestingTon", Email: "bob@example.com"}
+	if err := store.Users.CreateUser(ctx, &student); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if err := store.Users.CreateUser(ctx, &User{ExternalId: "w1", Fname: "Bob", Sname: "Again", Email: "again@example.com"}); err == nil {
+		t.Log("Expected duplicate external ids to be refused")
+		t.Fail()
+	}
+
+	if user, err := store.Users.GetUserByExternalId(ctx, "w1"); err != nil || user.InternalId != student.InternalId {
+		t.Log("Cannot find the user by external id", err)
+		t.Fail()
+	}
+
+	module := Module{Name: "Testing", ExternalId: "CS101"}
+	if err := store.Modules.CreateModule(ctx, &module); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	group := ModuleGroup{ModuleId: module.Id, Name: "Lab A"}
+	if err := store.ModuleGroups.CreateGroup(ctx, &group); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if err := store.ModuleGroups.AddUser(ctx, student.InternalId, group.Id); err == nil {
+		t.Log("Expected users outside of the module to be refused")
+		t.Fail()
+	}
+
+	if err := store.Modules.AddUser(ctx, student.InternalId, module.Id); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if err := store.ModuleGroups.AddUser(ctx, student.InternalId, group.Id); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	groupLesson := GroupLesson{ModuleGroupId: group.Id, Name: "Lab", Summary: "Lab", Location: "Room 1"}
+	if err := store.Lessons.CreateGroupLesson(ctx, &groupLesson); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	current := ActualLesson{GroupLessonId: groupLesson.Id, StartTime: now.Add(-15 * time.Minute), EndTime: now.Add(45 * time.Minute)}
+	past := ActualLesson{GroupLessonId: groupLesson.Id, StartTime: now.Add(-48 * time.Hour), EndTime: now.Add(-47 * time.Hour)}
+	for _, lesson := range []*ActualLesson{&current, &past} {
+		if err := store.Lessons.CreateLesson(ctx, lesson); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+
+	if lessons, err := store.Lessons.GetUserLessons(ctx, student.InternalId); err != nil || len(lessons) != 2 || lessons[0].Id != past.Id {
+		t.Log("Bad lessons", lessons, err)
+		t.Fail()
+	}
+
+	if err := store.Attendance.RegisterAttendance(ctx, student.InternalId, past.Id, "", "", student.InternalId); err == nil {
+		t.Log("Expected marks for lessons that have ended to be refused")
+		t.Fail()
+	}
+
+	if err := store.Attendance.RegisterAttendance(ctx, student.InternalId, current.Id, "", "", student.InternalId); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	marks, err := store.Attendance.GetLessonMarks(ctx, current.Id)
+	if err != nil || len(marks) != 1 || marks[0].Status != ATTENDANCE_LATE {
+		t.Log("Expected a late mark after the grace period", marks, err)
+		t.Fail()
+	}
+
+	// Marking the student again changes their mark
+	if err := store.Attendance.RegisterAttendance(ctx, student.InternalId, current.Id, ATTENDANCE_PRESENT, "", student.InternalId); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	marks, err = store.Attendance.GetLessonMarks(ctx, current.Id)
+	if err != nil || len(marks) != 1 || marks[0].Status != ATTENDANCE_PRESENT {
+		t.Log("Expected one present mark after marking again", marks, err)
+		t.Fail()
+	}
+
+	// Only the lesson that has ended counts and, it was missed
+	attendance, err := store.Attendance.GetStudentAttendance(ctx, student.InternalId)
+	record := attendance.ModuleAttendance[module.Id]
+	if err != nil || record.TotalSessions != 1 || record.MarkedSessions != 0 {
+		t.Log("Bad attendance", attendance, err)
+		t.Fail()
+	}
+
+	role := Role{Name: "Lecturer", Overrides: security.PERMS_CAN_READ_ALL}
+	if err := store.Permissions.CreateRole(ctx, &role); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	perms, err := GetRepositoryPermissions(ctx, store.Permissions, student.InternalId, module.Id, "", security.Module)
+	if err != nil || security.CheckPerms(perms, security.PERMS_CAN_READ_ALL) {
+		t.Log("Expected no permissions before the role is assigned", err)
+		t.Fail()
+	}
+
+	if err := store.Permissions.AssignRole(ctx, role.Id, student.InternalId, module.Id, "", security.Module); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	perms, err = GetRepositoryPermissions(ctx, store.Permissions, student.InternalId, module.Id, "", security.Module)
+	if err != nil || !security.CheckPerms(perms, security.PERMS_CAN_READ_ALL) {
+		t.Log("Expected the role's permissions in the module", err)
+		t.Fail()
+	}
+
+	// Removing the user from the module removes their groups and, roles in it
+	if err := store.Modules.RemoveUser(ctx, student.InternalId, module.Id); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if groups, _ := store.ModuleGroups.GetUserGroups(ctx, student.InternalId); len(groups) != 0 {
+		t.Log("Expected the user to be removed from the module's groups", groups)
+		t.Fail()
+	}
+
+	perms, _ = GetRepositoryPermissions(ctx, store.Permissions, student.InternalId, module.Id, "", security.Module)
+	if security.CheckPerms(perms, security.PERMS_CAN_READ_ALL) {
+		t.Log("Expected the role to be removed with the user")
+		t.Fail()
+	}
+}
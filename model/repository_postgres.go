@@ -0,0 +1,234 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/security"
+	"arcio/attendance-system/utils"
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+ * The Postgres repositories, these mostly use the existing model funcs. See repository.go
+ */
+
+type postgresUsers struct {
+	pool *utils.DatabasePool
+}
+
+func (r postgresUsers) GetUsers(ctx context.Context) ([]User, error) {
+	return GetUsers(ctx, r.pool)
+}
+
+func (r postgresUsers) getUser(ctx context.Context, cond string, arg string) (User, error) {
+	var user User
+	err := r.pool.Database.QueryRowContext(ctx, "select id, external_id, firstname, surname, email, creation_time, edit_time "+
+		"from users where "+cond+";", arg).Scan(&user.InternalId, &user.ExternalId, &user.Fname, &user.Sname,
+		&user.Email, &user.CreationTime, &user.EditTime)
+	if err == sql.ErrNoRows {
+		return User{}, ErrUserNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (r postgresUsers) GetUser(ctx context.Context, userId string) (User, error) {
+	if _, err := uuid.Parse(userId); err != nil {
+		return User{}, ErrUserNotFound
+	}
+
+	return r.getUser(ctx, "id = $1", userId)
+}
+
+func (r postgresUsers) GetUserByExternalId(ctx context.Context, externalId string) (User, error) {
+	return r.getUser(ctx, "external_id = $1", externalId)
+}
+
+// Users sign in through the identity provider so they are created without a password
+func (r postgresUsers) CreateUser(ctx context.Context, user *User) error {
+	err := validateUser(*user)
+	if err != nil {
+		return err
+	}
+
+	user.InternalId = uuid.New().String()
+	user.CreationTime = time.Now()
+	user.EditTime = user.CreationTime
+
+	_, err = r.pool.Database.ExecContext(ctx, "insert into users "+
+		"(id, external_id, firstname, surname, email, password, salt, creation_time, edit_time) "+
+		"values ($1, $2, $3, $4, $5, '', '', $6, $7);",
+		user.InternalId, user.ExternalId, user.Fname, user.Sname, user.Email, user.CreationTime, user.EditTime)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	return nil
+}
+
+type postgresModules struct {
+	pool *utils.DatabasePool
+}
+
+func (r postgresModules) GetModules(ctx context.Context) ([]Module, error) {
+	return GetAllModules(ctx, r.pool)
+}
+
+func (r postgresModules) GetModule(ctx context.Context, moduleId string) (Module, error) {
+	if _, err := uuid.Parse(moduleId); err != nil {
+		return Module{}, ErrModuleNotFound
+	}
+
+	var module Module
+	err := r.pool.Database.QueryRowContext(ctx, "select id, external_id, name, creation_time, edit_time from modules where id = $1;",
+		moduleId).Scan(&module.Id, &module.ExternalId, &module.Name, &module.CreationTime, &module.EditTime)
+	if err == sql.ErrNoRows {
+		return Module{}, ErrModuleNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return Module{}, err
+	}
+
+	return module, nil
+}
+
+func (r postgresModules) CreateModule(ctx context.Context, module *Module) error {
+	return CreateModule(ctx, module, r.pool)
+}
+
+func (r postgresModules) AddUser(ctx context.Context, userId string, moduleId string) error {
+	return AddUserToModule(ctx, userId, moduleId, r.pool)
+}
+
+func (r postgresModules) RemoveUser(ctx context.Context, userId string, moduleId string) error {
+	return RemoveUserFromModule(ctx, userId, moduleId, r.pool)
+}
+
+func (r postgresModules) GetUsers(ctx context.Context, moduleId string) ([]User, error) {
+	return GetUsersForModule(ctx, moduleId, r.pool)
+}
+
+func (r postgresModules) GetUserModules(ctx context.Context, userId string) ([]Module, error) {
+	return GetModulesForUser(ctx, userId, r.pool)
+}
+
+type postgresModuleGroups struct {
+	pool *utils.DatabasePool
+}
+
+func (r postgresModuleGroups) GetGroups(ctx context.Context, moduleId string) ([]ModuleGroup, error) {
+	return GetGroupsForModule(ctx, moduleId, r.pool)
+}
+
+func (r postgresModuleGroups) GetGroup(ctx context.Context, moduleGroupId string) (ModuleGroup, error) {
+	if _, err := uuid.Parse(moduleGroupId); err != nil {
+		return ModuleGroup{}, ErrModuleGroupNotFound
+	}
+
+	var moduleGroup ModuleGroup
+	err := r.pool.Database.QueryRowContext(ctx, "select id, module_id, name, creation_time, edit_time from module_groups where id = $1;",
+		moduleGroupId).Scan(&moduleGroup.Id, &moduleGroup.ModuleId, &moduleGroup.Name, &moduleGroup.CreationTime, &moduleGroup.EditTime)
+	if err == sql.ErrNoRows {
+		return ModuleGroup{}, ErrModuleGroupNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return ModuleGroup{}, err
+	}
+
+	return moduleGroup, nil
+}
+
+func (r postgresModuleGroups) CreateGroup(ctx context.Context, moduleGroup *ModuleGroup) error {
+	return CreateModuleGroup(ctx, moduleGroup, r.pool)
+}
+
+func (r postgresModuleGroups) AddUser(ctx context.Context, userId string, moduleGroupId string) error {
+	return AddUserToModuleGroup(ctx, userId, moduleGroupId, r.pool)
+}
+
+func (r postgresModuleGroups) GetUserGroups(ctx context.Context, userId string) ([]ModuleGroup, error) {
+	return GetUsersModuleGroups(ctx, userId, r.pool)
+}
+
+type postgresLessons struct {
+	pool *utils.DatabasePool
+}
+
+func (r postgresLessons) CreateGroupLesson(ctx context.Context, group *GroupLesson) error {
+	return CreateGroupLesson(ctx, group, r.pool)
+}
+
+func (r postgresLessons) CreateLesson(ctx context.Context, lesson *ActualLesson) error {
+	return createActualLesson(ctx, lesson, r.pool)
+}
+
+func (r postgresLessons) GetLesson(ctx context.Context, lessonId string) (ActualLesson, string, error) {
+	return getLessonWithModuleGroup(ctx, lessonId, r.pool)
+}
+
+func (r postgresLessons) GetUserLessons(ctx context.Context, userId string) ([]ActualLesson, error) {
+	return GetLessons(ctx, userId, r.pool)
+}
+
+type postgresAttendance struct {
+	pool *utils.DatabasePool
+}
+
+func (r postgresAttendance) RegisterAttendance(ctx context.Context, userId string, lessonId string, status AttendanceStatus, reason string, markedBy string) error {
+	return RegisterAttendanceBy(ctx, userId, lessonId, status, reason, markedBy, r.pool)
+}
+
+func (r postgresAttendance) GetLessonMarks(ctx context.Context, lessonId string) ([]LessonAttendance, error) {
+	ret := make([]LessonAttendance, 0)
+	if _, err := uuid.Parse(lessonId); err != nil {
+		return ret, nil
+	}
+
+	rows, err := r.pool.Database.QueryContext(ctx, "select lesson_id, user_id, register_time, status, reason from attendance "+
+		"where lesson_id = $1 order by register_time asc;", lessonId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mark LessonAttendance
+		err = rows.Scan(&mark.LessonId, &mark.UserId, &mark.RegisterTime, &mark.Status, &mark.Reason)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret = append(ret, mark)
+	}
+
+	return ret, nil
+}
+
+func (r postgresAttendance) GetStudentAttendance(ctx context.Context, userId string) (AttendanceRet, error) {
+	return GetStudentAttendancePercentages(ctx, userId, r.pool)
+}
+
+type postgresPermissions struct {
+	pool *utils.DatabasePool
+}
+
+func (r postgresPermissions) CreateRole(ctx context.Context, role *Role) error {
+	return CreateRole(ctx, role, r.pool)
+}
+
+func (r postgresPermissions) AssignRole(ctx context.Context, roleId string, userId string, moduleId string, moduleGroupId string, l security.Layer) error {
+	return AssignRole(ctx, roleId, userId, moduleId, moduleGroupId, l, r.pool)
+}
+
+func (r postgresPermissions) GetLayerPermissions(ctx context.Context, userId string, moduleId string, moduleGroupId string, l security.Layer) ([]LayerPermissions, error) {
+	return getLayerPermissions(ctx, userId, moduleId, moduleGroupId, l, r.pool)
+}
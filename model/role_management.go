@@ -0,0 +1,348 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/security"
+	"arcio/attendance-system/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+ * Roles are a named overrides bitmask, they are assigned to users at a layer.
+ * See model/perms.go for how they are combined.
+ */
+
+var ErrRoleNotFound = errors.New("Cannot find role with matching id")
+
+// Checks the fields that roles must have
+func validateRole(role Role) error {
+	if role.Name == "" {
+		return errors.New("The role name cannot be empty")
+	}
+
+	return nil
+}
+
+func CreateRole(ctx context.Context, role *Role, pool *utils.DatabasePool) error {
+	if err := validateRole(*role); err != nil {
+		return err
+	}
+
+	role.Id = uuid.New().String()
+	role.Overrides &= security.PERMS_VALID_MASK
+	role.CreationTime = time.Now()
+	role.EditTime = role.CreationTime
+
+	_, err := pool.Database.ExecContext(ctx, "insert into roles (id, name, overrides, creation_time, edit_time) values ($1, $2, $3, $4, $5);",
+		role.Id, role.Name, role.Overrides, role.CreationTime, role.EditTime)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	return nil
+}
+
+func GetRoles(ctx context.Context, pool *utils.DatabasePool) ([]Role, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select id, name, overrides, creation_time, edit_time from roles order by name asc;")
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]Role, 0)
+	for rows.Next() {
+		var role Role
+		err = rows.Scan(&role.Id, &role.Name, &role.Overrides, &role.CreationTime, &role.EditTime)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret = append(ret, role)
+	}
+
+	return ret, nil
+}
+
+func GetRole(ctx context.Context, roleId string, pool *utils.DatabasePool) (Role, error) {
+	var role Role
+	err := pool.Database.QueryRowContext(ctx, "select id, name, overrides, creation_time, edit_time from roles where id = $1;",
+		roleId).Scan(&role.Id, &role.Name, &role.Overrides, &role.CreationTime, &role.EditTime)
+	if err == sql.ErrNoRows {
+		return Role{}, ErrRoleNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return Role{}, err
+	}
+
+	return role, nil
+}
+
+func UpdateRole(ctx context.Context, role *Role, pool *utils.DatabasePool) error {
+	if err := validateRole(*role); err != nil {
+		return err
+	}
+
+	role.Overrides &= security.PERMS_VALID_MASK
+	role.EditTime = time.Now()
+
+	err := pool.Database.QueryRowContext(ctx, "update roles set name = $2, overrides = $3, edit_time = $4 where id = $1 returning creation_time;",
+		role.Id, role.Name, role.Overrides, role.EditTime).Scan(&role.CreationTime)
+	if err == sql.ErrNoRows {
+		return ErrRoleNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Deletes a role, it is revoked from everyone that has it.
+ */
+func DeleteRole(ctx context.Context, roleId string, pool *utils.DatabasePool) error {
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	queries := []string{"delete from role_users where role_id = $1;",
+		"delete from module_user_roles where role_id = $1;",
+		"delete from module_user_group_roles where role_id = $1;",
+		"delete from attendance_user_roles where role_id = $1;"}
+
+	for _, query := range queries {
+		_, err = tx.ExecContext(ctx, query, roleId)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return err
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, "delete from roles where id = $1;", roleId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	if count == 0 {
+		return ErrRoleNotFound
+	}
+
+	success = true
+	return nil
+}
+
+/*
+ * Gets the table, the column and, the id that a role is assigned to for a layer.
+ * For the module and, module group layers the user must be in the module or, module group.
+ */
+func getRoleAssignee(ctx context.Context, tx *sql.Tx, userId string, moduleId string, moduleGroupId string, l security.Layer) (string, string, string, error) {
+	switch l {
+	case security.Global:
+		return "role_users", "user_id", userId, nil
+	case security.Attendance:
+		// These apply in every module, routes/roles.go needs global permissions to manage them
+		return "attendance_user_roles", "user_id", userId, nil
+	case security.Module:
+		var moduleUserId string
+		err := tx.QueryRowContext(ctx, "select id from module_users where user_id = $1 and module_id = $2;",
+			userId, moduleId).Scan(&moduleUserId)
+		if err == sql.ErrNoRows {
+			return "", "", "", errors.New("The user is not in the module")
+		} else if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return "", "", "", err
+		}
+
+		return "module_user_roles", "module_user_id", moduleUserId, nil
+	case security.ModuleGroup:
+		var moduleUserGroupId string
+		err := tx.QueryRowContext(ctx, "select module_user_groups.id from module_user_groups, module_users "+
+			"where module_user_groups.module_user_id = module_users.id and "+
+			"module_users.user_id = $1 and module_users.module_id = $2 and "+
+			"module_user_groups.module_group_id = $3;",
+			userId, moduleId, moduleGroupId).Scan(&moduleUserGroupId)
+		if err == sql.ErrNoRows {
+			return "", "", "", errors.New("The user is not in the module group")
+		} else if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return "", "", "", err
+		}
+
+		return "module_user_group_roles", "module_user_group_id", moduleUserGroupId, nil
+	}
+
+	return "", "", "", errors.New("Invalid layer")
+}
+
+/*
+ * Assigns a role to a user at a layer, assigning a role twice is not an error.
+ * For moduleId and, moduleGroupId these are ignored if the layer is above their respective location.
+ */
+func AssignRole(ctx context.Context, roleId string, userId string, moduleId string, moduleGroupId string, l security.Layer, pool *utils.DatabasePool) error {
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, "select exists(select 1 from roles where id = $1);", roleId).Scan(&exists)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	if !exists {
+		return ErrRoleNotFound
+	}
+
+	table, column, id, err := getRoleAssignee(ctx, tx, userId, moduleId, moduleGroupId, l)
+	if err != nil {
+		return err
+	}
+
+	// The table and, column are not user input
+	_, err = tx.ExecContext(ctx, "insert into "+table+" (role_id, "+column+") values ($1, $2) on conflict do nothing;",
+		roleId, id)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	logging.FromContext(ctx).Infof("Assigned role %s to user %s", roleId, userId)
+
+	success = true
+	return nil
+}
+
+/*
+ * Revokes a role from a user at a layer.
+ */
+func RevokeRole(ctx context.Context, roleId string, userId string, moduleId string, moduleGroupId string, l security.Layer, pool *utils.DatabasePool) error {
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	table, column, id, err := getRoleAssignee(ctx, tx, userId, moduleId, moduleGroupId, l)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "delete from "+table+" where role_id = $1 and "+column+" = $2;", roleId, id)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	if count == 0 {
+		return errors.New("The user does not have the role")
+	}
+
+	logging.FromContext(ctx).Infof("Revoked role %s from user %s", roleId, userId)
+
+	success = true
+	return nil
+}
+
+/*
+ * Gets the roles a user has been assigned at a layer, roles from other layers are not included.
+ */
+func GetUserRoles(ctx context.Context, userId string, moduleId string, moduleGroupId string, l security.Layer, pool *utils.DatabasePool) ([]Role, error) {
+	var rows *sql.Rows
+	var err error
+	const fields = "select roles.id, roles.name, roles.overrides, roles.creation_time, roles.edit_time "
+
+	switch l {
+	case security.Global:
+		rows, err = pool.Database.QueryContext(ctx, fields+"from roles, role_users "+
+			"where role_users.role_id = roles.id and role_users.user_id = $1 order by roles.name asc;", userId)
+	case security.Attendance:
+		rows, err = pool.Database.QueryContext(ctx, fields+"from roles, attendance_user_roles "+
+			"where attendance_user_roles.role_id = roles.id and attendance_user_roles.user_id = $1 order by roles.name asc;", userId)
+	case security.Module:
+		rows, err = pool.Database.QueryContext(ctx, fields+"from roles, module_user_roles, module_users "+
+			"where module_user_roles.role_id = roles.id and module_user_roles.module_user_id = module_users.id and "+
+			"module_users.user_id = $1 and module_users.module_id = $2 order by roles.name asc;", userId, moduleId)
+	case security.ModuleGroup:
+		rows, err = pool.Database.QueryContext(ctx, fields+"from roles, module_user_group_roles, module_user_groups, module_users "+
+			"where module_user_group_roles.role_id = roles.id and "+
+			"module_user_group_roles.module_user_group_id = module_user_groups.id and "+
+			"module_user_groups.module_user_id = module_users.id and "+
+			"module_users.user_id = $1 and module_users.module_id = $2 and "+
+			"module_user_groups.module_group_id = $3 order by roles.name asc;", userId, moduleId, moduleGroupId)
+	default:
+		return nil, errors.New("Invalid layer")
+	}
+
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]Role, 0)
+	for rows.Next() {
+		var role Role
+		err = rows.Scan(&role.Id, &role.Name, &role.Overrides, &role.CreationTime, &role.EditTime)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret = append(ret, role)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"arcio/attendance-system/security"
+	"context"
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestRoleCrud(t *testing.T) {
+	role := Role{Name: "test role", Overrides: security.PERMS_CAN_READ | 1<<20}
+	err := CreateRole(context.Background(), &role, pool_pt)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer DeleteRole(context.Background(), role.Id, pool_pt)
+
+	if role.Overrides&^security.PERMS_VALID_MASK != 0 {
+		t.Log("Invalid permission bits were saved")
+		t.Fail()
+	}
+
+	role.Name = "test role 2"
+	role.Overrides = security.PERMS_CAN_UPDATE
+	err = UpdateRole(context.Background(), &role, pool_pt)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	got, err := GetRole(context.Background(), role.Id, pool_pt)
+	if err != nil || got.Name != role.Name || got.Overrides != role.Overrides {
+		t.Log("The role was not updated")
+		t.Fail()
+	}
+
+	err = DeleteRole(context.Background(), role.Id, pool_pt)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	_, err = GetRole(context.Background(), role.Id, pool_pt)
+	if err != ErrRoleNotFound {
+		t.Log("The role was not deleted")
+		t.Fail()
+	}
+}
+
+func TestAssignGlobalRole(t *testing.T) {
+	var userId string
+	err := pool_pt.Database.QueryRow("select id from users limit 1;").Scan(&userId)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	role := Role{Name: "test role", Overrides: security.PERMS_MANAGE_ROLES}
+	err = CreateRole(context.Background(), &role, pool_pt)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer DeleteRole(context.Background(), role.Id, pool_pt)
+
+	err = AssignRole(context.Background(), role.Id, userId, "", "", security.Global, pool_pt)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	perms, err := GetPermissions(context.Background(), userId, "", "", security.Global, pool_pt)
+	if err != nil || perms&security.PERMS_MANAGE_ROLES == 0 {
+		t.Log("The role was not applied")
+		t.Fail()
+	}
+
+	roles, err := GetUserRoles(context.Background(), userId, "", "", security.Global, pool_pt)
+	found := false
+	for _, r := range roles {
+		found = found || r.Id == role.Id
+	}
+
+	if err != nil || !found {
+		t.Log("The role was not listed for the user")
+		t.Fail()
+	}
+
+	err = RevokeRole(context.Background(), role.Id, userId, "", "", security.Global, pool_pt)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	err = RevokeRole(context.Background(), role.Id, userId, "", "", security.Global, pool_pt)
+	if err == nil {
+		t.Log("A role was revoked twice")
+		t.Fail()
+	}
+}
+
+func TestAssignRoleNotInModule(t *testing.T) {
+	role := Role{Name: "test role", Overrides: security.PERMS_CAN_READ}
+	err := CreateRole(context.Background(), &role, pool_pt)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer DeleteRole(context.Background(), role.Id, pool_pt)
+
+	err = AssignRole(context.Background(), role.Id, uuid.New().String(), uuid.New().String(), "", security.Module, pool_pt)
+	if err == nil {
+		t.Log("A module role was assigned to a user that is not in the module")
+		t.Fail()
+	}
+}
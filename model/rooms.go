@@ -0,0 +1,168 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var ErrRoomNotFound = errors.New("Cannot find room with matching id")
+
+// Trims, removes duplicates from and, sorts a room's features
+func normaliseFeatures(features []string) []string {
+	seen := make(map[string]bool)
+	ret := make([]string, 0, len(features))
+	for _, feature := range features {
+		feature = strings.ToLower(strings.TrimSpace(feature))
+		if feature != "" && !seen[feature] {
+			seen[feature] = true
+			ret = append(ret, feature)
+		}
+	}
+
+	sort.Strings(ret)
+	return ret
+}
+
+func validateRoom(room *Room) error {
+	room.Name = strings.TrimSpace(room.Name)
+	if room.Name == "" {
+		return errors.New("The room name cannot be empty")
+	}
+
+	if room.Capacity < 0 {
+		return errors.New("capacity cannot be negative")
+	}
+
+	room.Features = normaliseFeatures(room.Features)
+	return nil
+}
+
+func CreateRoom(ctx context.Context, room *Room, pool *utils.DatabasePool) error {
+	err := validateRoom(room)
+	if err != nil {
+		return err
+	}
+
+	room.Id = uuid.New().String()
+	room.CreationTime = time.Now()
+	room.EditTime = room.CreationTime
+
+	_, err = pool.Database.ExecContext(ctx, "insert into rooms (id, name, capacity, features, creation_time, edit_time) "+
+		"values ($1, $2, $3, $4, $5, $6);",
+		room.Id, room.Name, room.Capacity, pq.Array(room.Features), room.CreationTime, room.EditTime)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return errors.New("Cannot create the room, the name must be unique")
+	}
+
+	return nil
+}
+
+/*
+ * Gets the rooms that hold at least minCapacity people and, have all of the features, sorted
+ * by name.
+ */
+func GetRooms(ctx context.Context, minCapacity int, features []string, pool *utils.DatabasePool) ([]Room, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select id, name, capacity, features, creation_time, edit_time from rooms "+
+		"where capacity >= $1 and features @> $2 order by name asc;",
+		minCapacity, pq.Array(normaliseFeatures(features)))
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]Room, 0)
+	for rows.Next() {
+		var room Room
+		err = rows.Scan(&room.Id, &room.Name, &room.Capacity, pq.Array(&room.Features), &room.CreationTime, &room.EditTime)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret = append(ret, room)
+	}
+
+	return ret, nil
+}
+
+func GetRoom(ctx context.Context, roomId string, pool *utils.DatabasePool) (Room, error) {
+	if _, err := uuid.Parse(roomId); err != nil {
+		return Room{}, ErrRoomNotFound
+	}
+
+	var room Room
+	err := pool.Database.QueryRowContext(ctx, "select id, name, capacity, features, creation_time, edit_time from rooms where id = $1;",
+		roomId).Scan(&room.Id, &room.Name, &room.Capacity, pq.Array(&room.Features), &room.CreationTime, &room.EditTime)
+	if err == sql.ErrNoRows {
+		return Room{}, ErrRoomNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return Room{}, err
+	}
+
+	return room, nil
+}
+
+/*
+ * Updates a room's name, capacity and, features. Lessons that are already booked into the
+ * room are not checked against the new capacity.
+ */
+func UpdateRoom(ctx context.Context, room *Room, pool *utils.DatabasePool) error {
+	if _, err := uuid.Parse(room.Id); err != nil {
+		return ErrRoomNotFound
+	}
+
+	err := validateRoom(room)
+	if err != nil {
+		return err
+	}
+
+	room.EditTime = time.Now()
+	err = pool.Database.QueryRowContext(ctx, "update rooms set name = $2, capacity = $3, features = $4, edit_time = $5 "+
+		"where id = $1 returning creation_time;",
+		room.Id, room.Name, room.Capacity, pq.Array(room.Features), room.EditTime).Scan(&room.CreationTime)
+	if err == sql.ErrNoRows {
+		return ErrRoomNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return errors.New("Cannot update the room, the name must be unique")
+	}
+
+	return nil
+}
+
+/*
+ * Deletes a room, lessons that were booked into it are left without a room.
+ */
+func DeleteRoom(ctx context.Context, roomId string, pool *utils.DatabasePool) error {
+	if _, err := uuid.Parse(roomId); err != nil {
+		return ErrRoomNotFound
+	}
+
+	res, err := pool.Database.ExecContext(ctx, "delete from rooms where id = $1;", roomId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	} else if count == 0 {
+		return ErrRoomNotFound
+	}
+
+	return nil
+}
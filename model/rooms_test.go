@@ -0,0 +1,28 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestValidateRoom(t *testing.T) {
+	room := Room{Name: " Lab 1 ", Capacity: 30, Features: []string{"Projector", " whiteboard", "projector", ""}}
+	if err := validateRoom(&room); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if room.Name != "Lab 1" || len(room.Features) != 2 || room.Features[0] != "projector" || room.Features[1] != "whiteboard" {
+		t.Log("Expected the name and, features to be normalised", room)
+		t.Fail()
+	}
+
+	if err := validateRoom(&Room{Name: "", Capacity: 30}); err == nil {
+		t.Log("Expected rooms without a name to be invalid")
+		t.Fail()
+	}
+
+	if err := validateRoom(&Room{Name: "Cupboard", Capacity: -1}); err == nil {
+		t.Log("Expected negative capacities to be invalid")
+		t.Fail()
+	}
+}
@@ -0,0 +1,338 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/utils"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+ * Roster imports add modules, module groups and, memberships from a CSV keyed by external ids.
+ * Imports only add things, missing modules and, groups are created and, users are never removed.
+ * The whole import is ran in one transaction, it is rolled back for dry runs and, when any row
+ * has an error so that the returned diff is exactly what would be applied.
+ */
+
+// Accepted header names for each column, the SIS export names are accepted as well as our own
+var rosterColumns = map[string][]string{
+	"module-external-id": {"module-external-id", "module code", "module_code", "module"},
+	"module-name":        {"module-name", "module title", "module_title", "module name"},
+	"group":              {"group", "group name", "group_name", "module-group"},
+	"student-number":     {"student-number", "student number", "student_number", "student id", "external-id"},
+}
+
+type RosterRow struct {
+	Line             int
+	ModuleExternalId string
+	ModuleName       string
+	GroupName        string
+	StudentNumber    string
+}
+
+type RosterRowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type RosterGroup struct {
+	ModuleExternalId string `json:"module-external-id"`
+	Name             string `json:"name"`
+}
+
+type RosterMembership struct {
+	ModuleExternalId string `json:"module-external-id"`
+	GroupName        string `json:"group,omitempty"`
+	StudentNumber    string `json:"student-number"`
+}
+
+type RosterImportResult struct {
+	DryRun            bool               `json:"dry-run"`
+	Applied           bool               `json:"applied"`
+	ModulesCreated    []string           `json:"modules-created"`
+	GroupsCreated     []RosterGroup      `json:"groups-created"`
+	ModuleMemberships []RosterMembership `json:"module-memberships-added"`
+	GroupMemberships  []RosterMembership `json:"group-memberships-added"`
+	Unchanged         int                `json:"unchanged"`
+	Errors            []RosterRowError   `json:"errors"`
+}
+
+/*
+ * Parses a roster CSV, the first row must be a header. The module external id and, student
+ * number columns are required, the group column is optional as is the module name which is
+ * only used to create modules.
+ *
+ * @return the rows and, the rows that could not be read
+ */
+func ParseRosterCsv(r io.Reader) ([]RosterRow, []RosterRowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("The roster is empty")
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	// Find columns
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for column, aliases := range rosterColumns {
+			for _, alias := range aliases {
+				if name == alias {
+					columns[column] = i
+				}
+			}
+		}
+	}
+
+	for _, column := range []string{"module-external-id", "student-number"} {
+		if _, found := columns[column]; !found {
+			return nil, nil, errors.New("The roster is missing the " + column + " column")
+		}
+	}
+
+	get := func(record []string, column string) string {
+		i, found := columns[column]
+		if !found || i >= len(record) {
+			return ""
+		}
+
+		return strings.TrimSpace(record[i])
+	}
+
+	rows := make([]RosterRow, 0)
+	rowErrors := make([]RosterRowError, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			rowErrors = append(rowErrors, RosterRowError{Line: line, Error: err.Error()})
+			continue
+		}
+
+		row := RosterRow{Line: line,
+			ModuleExternalId: get(record, "module-external-id"),
+			ModuleName:       get(record, "module-name"),
+			GroupName:        get(record, "group"),
+			StudentNumber:    get(record, "student-number")}
+
+		if row.ModuleExternalId == "" || row.StudentNumber == "" {
+			rowErrors = append(rowErrors, RosterRowError{Line: line, Error: "The module and, student number cannot be empty"})
+			continue
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, rowErrors, nil
+}
+
+/*
+ * Imports roster rows, see the top of this file.
+ *
+ * @param rows   the parsed rows, see ParseRosterCsv
+ * @param dryRun whether to only return the diff
+ * @param pool   the database pool
+ * @return the changes, errors that are specific to a row are in the result
+ */
+func ImportRoster(ctx context.Context, rows []RosterRow, dryRun bool, pool *utils.DatabasePool) (RosterImportResult, error) {
+	ret := RosterImportResult{DryRun: dryRun,
+		ModulesCreated:    make([]string, 0),
+		GroupsCreated:     make([]RosterGroup, 0),
+		ModuleMemberships: make([]RosterMembership, 0),
+		GroupMemberships:  make([]RosterMembership, 0),
+		Errors:            make([]RosterRowError, 0)}
+
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return ret, err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	// External ids to internal ids
+	modules := make(map[string]string)
+	groups := make(map[RosterGroup]string)
+	users := make(map[string]string)
+	now := time.Now()
+
+	for _, row := range rows {
+		rowError := func(msg string) {
+			ret.Errors = append(ret.Errors, RosterRowError{Line: row.Line, Error: msg})
+		}
+
+		// Resolve the user
+		userId, found := users[row.StudentNumber]
+		if !found {
+			err = tx.QueryRowContext(ctx, "select id from users where external_id = $1;", row.StudentNumber).Scan(&userId)
+			if err == sql.ErrNoRows {
+				rowError(fmt.Sprintf("Cannot find user with student number %s", row.StudentNumber))
+				continue
+			} else if err != nil {
+				logging.FromContext(ctx).Error(err)
+				return ret, err
+			}
+
+			users[row.StudentNumber] = userId
+		}
+
+		// Resolve or, create the module
+		moduleId, found := modules[row.ModuleExternalId]
+		if !found {
+			err = tx.QueryRowContext(ctx, "select id from modules where external_id = $1;", row.ModuleExternalId).Scan(&moduleId)
+			if err == sql.ErrNoRows {
+				if row.ModuleName == "" {
+					rowError(fmt.Sprintf("Cannot find module %s and, there is no module name to create it with", row.ModuleExternalId))
+					continue
+				}
+
+				moduleId = uuid.New().String()
+				_, err = tx.ExecContext(ctx, "insert into modules (id, name, external_id, creation_time, edit_time) values ($1, $2, $3, $4, $4);",
+					moduleId, row.ModuleName, row.ModuleExternalId, now)
+				if err != nil {
+					logging.FromContext(ctx).Error(err)
+					return ret, err
+				}
+
+				ret.ModulesCreated = append(ret.ModulesCreated, row.ModuleExternalId)
+			} else if err != nil {
+				logging.FromContext(ctx).Error(err)
+				return ret, err
+			}
+
+			modules[row.ModuleExternalId] = moduleId
+		}
+
+		changed := false
+
+		// Add the user to the module
+		var moduleUserId string
+		err = tx.QueryRowContext(ctx, "select id from module_users where user_id = $1 and module_id = $2;", userId, moduleId).Scan(&moduleUserId)
+		if err == sql.ErrNoRows {
+			moduleUserId = uuid.New().String()
+			_, err = tx.ExecContext(ctx, "insert into module_users (id, user_id, module_id, creation_time, edit_time) values ($1, $2, $3, $4, $4);",
+				moduleUserId, userId, moduleId, now)
+			if err != nil {
+				logging.FromContext(ctx).Error(err)
+				return ret, err
+			}
+
+			ret.ModuleMemberships = append(ret.ModuleMemberships, RosterMembership{ModuleExternalId: row.ModuleExternalId,
+				StudentNumber: row.StudentNumber})
+			changed = true
+		} else if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return ret, err
+		}
+
+		if row.GroupName != "" {
+			// Resolve or, create the group
+			key := RosterGroup{ModuleExternalId: row.ModuleExternalId, Name: row.GroupName}
+			groupId, found := groups[key]
+			if !found {
+				err = tx.QueryRowContext(ctx, "select id from module_groups where module_id = $1 and name = $2;", moduleId, row.GroupName).Scan(&groupId)
+				if err == sql.ErrNoRows {
+					groupId = uuid.New().String()
+					_, err = tx.ExecContext(ctx, "insert into module_groups (id, module_id, name, creation_time, edit_time) values ($1, $2, $3, $4, $4);",
+						groupId, moduleId, row.GroupName, now)
+					if err != nil {
+						logging.FromContext(ctx).Error(err)
+						return ret, err
+					}
+
+					ret.GroupsCreated = append(ret.GroupsCreated, key)
+				} else if err != nil {
+					logging.FromContext(ctx).Error(err)
+					return ret, err
+				}
+
+				groups[key] = groupId
+			}
+
+			// Add the user to the group
+			var exists bool
+			err = tx.QueryRowContext(ctx, "select exists(select 1 from module_user_groups where module_user_id = $1 and module_group_id = $2);",
+				moduleUserId, groupId).Scan(&exists)
+			if err != nil {
+				logging.FromContext(ctx).Error(err)
+				return ret, err
+			}
+
+			if !exists {
+				_, err = tx.ExecContext(ctx, "insert into module_user_groups (id, module_user_id, module_group_id) values ($1, $2, $3);",
+					uuid.New().String(), moduleUserId, groupId)
+				if err != nil {
+					logging.FromContext(ctx).Error(err)
+					return ret, err
+				}
+
+				ret.GroupMemberships = append(ret.GroupMemberships, RosterMembership{ModuleExternalId: row.ModuleExternalId,
+					GroupName:     row.GroupName,
+					StudentNumber: row.StudentNumber})
+				changed = true
+			}
+		}
+
+		if !changed {
+			ret.Unchanged++
+		}
+	}
+
+	if !dryRun && len(ret.Errors) == 0 {
+		logging.FromContext(ctx).Infof("Imported roster: %d modules, %d groups, %d module memberships and, %d group memberships added",
+			len(ret.ModulesCreated), len(ret.GroupsCreated), len(ret.ModuleMemberships), len(ret.GroupMemberships))
+
+		ret.Applied = true
+		success = true
+	}
+
+	return ret, nil
+}
+
+/*
+ * Parses and, imports a roster CSV, nothing is applied if any row cannot be read.
+ */
+func ImportRosterCsv(ctx context.Context, r io.Reader, dryRun bool, pool *utils.DatabasePool) (RosterImportResult, error) {
+	rows, parseErrors, err := ParseRosterCsv(r)
+	if err != nil {
+		return RosterImportResult{}, err
+	}
+
+	ret, err := ImportRoster(ctx, rows, dryRun || len(parseErrors) != 0, pool)
+	if err != nil {
+		return ret, err
+	}
+
+	ret.DryRun = dryRun
+	ret.Errors = append(parseErrors, ret.Errors...)
+	sort.SliceStable(ret.Errors, func(i int, j int) bool {
+		return ret.Errors[i].Line < ret.Errors[j].Line
+	})
+
+	return ret, nil
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"strings"
+	"testing"
+)
+
+func TestParseRosterCsv(t *testing.T) {
+	roster := "Module Code,Module Title,Group,Student Number\n" +
+		"CS1860,Maths,Lab A,100123\n" +
+		"CS1860,Maths,,100124\n" +
+		",Maths,Lab A,100125\n"
+
+	rows, rowErrors, err := ParseRosterCsv(strings.NewReader(roster))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if len(rows) != 2 {
+		t.Logf("Expected 2 rows got %d", len(rows))
+		t.FailNow()
+	}
+
+	if rows[0].ModuleExternalId != "CS1860" || rows[0].ModuleName != "Maths" ||
+		rows[0].GroupName != "Lab A" || rows[0].StudentNumber != "100123" || rows[0].Line != 2 {
+		t.Logf("The first row was parsed wrong %+v", rows[0])
+		t.Fail()
+	}
+
+	if rows[1].GroupName != "" {
+		t.Log("The group should be empty")
+		t.Fail()
+	}
+
+	if len(rowErrors) != 1 || rowErrors[0].Line != 4 {
+		t.Logf("Expected an error on line 4 got %+v", rowErrors)
+		t.Fail()
+	}
+}
+
+func TestParseRosterCsvMissingColumn(t *testing.T) {
+	_, _, err := ParseRosterCsv(strings.NewReader("module-external-id,group\nCS1860,Lab A\n"))
+	if err == nil {
+		t.Log("A roster without student numbers was parsed")
+		t.Fail()
+	}
+
+	_, _, err = ParseRosterCsv(strings.NewReader(""))
+	if err == nil {
+		t.Log("An empty roster was parsed")
+		t.Fail()
+	}
+}
+
+func TestImportRosterUnknownUser(t *testing.T) {
+	roster := "module-external-id,module-name,group,student-number\n" +
+		uuid.New().String() + ",test module," + "test group," + uuid.New().String() + "\n"
+
+	result, err := ImportRosterCsv(context.Background(), strings.NewReader(roster), false, pool_pt)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if len(result.Errors) != 1 || result.Applied {
+		t.Log("A roster with an unknown user was applied")
+		t.Fail()
+	}
+}
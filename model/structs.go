@@ -1,6 +1,8 @@
 package model
 
 import (
+	"arcio/attendance-system/security"
+	"encoding/json"
 	"time"
 )
 
@@ -33,6 +35,32 @@ type ModuleUser struct {
 	EditTime     time.Time `json:"edit-time,omitempty"`
 }
 
+// Roles
+type Role struct {
+	Id           string             `json:"id"`
+	Name         string             `json:"name"`
+	Overrides    security.Overrides `json:"overrides"`
+	CreationTime time.Time          `json:"creation-time,omitempty"`
+	EditTime     time.Time          `json:"edit-time,omitempty"`
+}
+
+// Raw overrides of a single layer, see model/perms.go
+type LayerPermissions struct {
+	Layer     security.Layer     `json:"layer"`
+	Overrides security.Overrides `json:"overrides"`
+	Flags     []string           `json:"flags"`
+}
+
+type PermissionsExplanation struct {
+	UserId        string             `json:"user-id"`
+	ModuleId      string             `json:"module-id,omitempty"`
+	ModuleGroupId string             `json:"module-group-id,omitempty"`
+	Layer         security.Layer     `json:"layer"`
+	Layers        []LayerPermissions `json:"layers"` // The requested layer is first
+	Effective     security.Overrides `json:"effective"`
+	Flags         []string           `json:"flags"`
+}
+
 // Group
 type ModuleGroup struct {
 	Id           string    `json:"id"`
@@ -53,6 +81,7 @@ type GroupLesson struct {
 	Summary            string    `json:"summary"`
 	Description        string    `json:"description"`
 	Location           string    `json:"location"`
+	RoomId             string    `json:"room-id,omitempty"`
 }
 
 // Lessons
@@ -67,6 +96,12 @@ type ActualLesson struct {
 	Description   string    `json:"description"`
 	Location      string    `json:"location"`
 	IsAbstract    bool      `json:"is-abstract,omitempty"`
+	Cancelled     bool      `json:"cancelled,omitempty"`
+	RoomId        string    `json:"room-id,omitempty"` // Overrides the group lesson's room when set
+
+	// Set for lessons spawned from a repeating lesson, see repeating_lesson_daemon.go
+	RepeatingLessonId string    `json:"repeating-lesson-id,omitempty"`
+	OccurrenceStart   time.Time `json:"-"` // Not changed when the lesson is moved
 }
 
 type RepeatingLesson struct {
@@ -80,6 +115,82 @@ type RepeatingLesson struct {
 	EditTime        time.Time     `json:"edit-time"`                   // Datetime
 	LastSpawnedTime time.Time     `json:"last-spawned-time,omitempty"` // Datetime
 	RepeatEvery     time.Duration `json:"repeat-every"`
+	Exceptions      []time.Time   `json:"exceptions,omitempty"` // Starts of the occurrences that do not happen
+	ClosedDates     []time.Time   `json:"-"`                    // Dates that the lesson does not happen on, see withClosures
+	RRule           string        `json:"rrule,omitempty"`      // RFC 5545 RRULE, see recurrence.go
+	RDates          []time.Time   `json:"rdates,omitempty"`     // Extra occurrences as a wall clock time in the time zone
+	Timezone        string        `json:"timezone,omitempty"`   // IANA time zone, UTC when empty
+}
+
+// Academic calendar
+type AcademicYear struct {
+	Id           string    `json:"id"`
+	Name         string    `json:"name"`
+	StartDate    time.Time `json:"start-date"` // Date
+	EndDate      time.Time `json:"end-date"`   // Date
+	CreationTime time.Time `json:"creation-time"`
+	EditTime     time.Time `json:"edit-time"`
+	Terms        []Term    `json:"terms"`
+}
+
+type Term struct {
+	Id             string    `json:"id"`
+	AcademicYearId string    `json:"academic-year-id"`
+	Name           string    `json:"name"`
+	StartDate      time.Time `json:"start-date"` // Date
+	EndDate        time.Time `json:"end-date"`   // Date
+	FirstWeek      int       `json:"first-week"` // The number of the term's first teaching week
+	CreationTime   time.Time `json:"creation-time"`
+	EditTime       time.Time `json:"edit-time"`
+}
+
+type TeachingWeek struct {
+	Number    int       `json:"number"`
+	TermId    string    `json:"term-id"`
+	StartDate time.Time `json:"start-date"` // Date
+	EndDate   time.Time `json:"end-date"`   // Date, inclusive
+}
+
+type Closure struct {
+	Id           string    `json:"id"`
+	StartDate    time.Time `json:"start-date"` // Date
+	EndDate      time.Time `json:"end-date"`   // Date, inclusive
+	Reason       string    `json:"reason"`
+	CreationTime time.Time `json:"creation-time"`
+}
+
+// Rooms
+type Room struct {
+	Id           string    `json:"id"`
+	Name         string    `json:"name"`
+	Capacity     int       `json:"capacity"`
+	Features     []string  `json:"features"`
+	CreationTime time.Time `json:"creation-time"`
+	EditTime     time.Time `json:"edit-time"`
+}
+
+// A reason that a lesson cannot be booked, see rooms.go
+type Conflict struct {
+	Type      string    `json:"type"`
+	Message   string    `json:"message"`
+	RoomId    string    `json:"room-id,omitempty"`
+	UserId    string    `json:"user-id,omitempty"`
+	LessonId  string    `json:"lesson-id,omitempty"` // The clashing lesson, or repeating lesson for occurrences that have not been spawned
+	StartTime time.Time `json:"start-time,omitempty"`
+	EndTime   time.Time `json:"end-time,omitempty"`
+	Capacity  int       `json:"capacity,omitempty"`
+	Members   int       `json:"members,omitempty"`
+}
+
+// Two lessons in a student's timetable that overlap, the first starts first
+type TimetableClash struct {
+	First  ActualLesson `json:"first"`
+	Second ActualLesson `json:"second"`
+}
+
+type StudentClashes struct {
+	UserId  string           `json:"user-id"`
+	Clashes []TimetableClash `json:"clashes"`
 }
 
 // Attendance
@@ -87,6 +198,174 @@ type LessonAttendance struct {
 	LessonId     string
 	UserId       string
 	RegisterTime time.Time
+	Status       AttendanceStatus
+	Reason       string
+}
+
+// A student on a lesson's register, the mark is blank if they have not been marked
+type RegisterEntry struct {
+	UserId       string           `json:"user-id"`
+	ExternalId   string           `json:"external-id"`
+	Fname        string           `json:"firstname"`
+	Sname        string           `json:"surname"`
+	Email        string           `json:"email"`
+	Status       AttendanceStatus `json:"status,omitempty"`
+	Reason       string           `json:"reason,omitempty"`
+	RegisterTime time.Time        `json:"register-time,omitempty"`
+	MarkedBy     string           `json:"marked-by,omitempty"`      // User id
+	MarkedByName string           `json:"marked-by-name,omitempty"` // Firstname and, surname
+}
+
+type LessonRegister struct {
+	Lesson        ActualLesson    `json:"lesson"`
+	ModuleGroupId string          `json:"module-group-id"`
+	Students      []RegisterEntry `json:"students"`
+}
+
+// Running counts for a lesson's register, see the register stream
+type RegisterCounts struct {
+	Expected  int                      `json:"expected"`
+	Attended  int                      `json:"attended"`
+	Unmarked  int                      `json:"unmarked"`
+	Breakdown map[AttendanceStatus]int `json:"breakdown"`
+}
+
+// An update sent to register streams, data is the register, mark or, correction
+type RegisterStreamEvent struct {
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data"`
+	Counts RegisterCounts  `json:"counts"`
+}
+
+// A status to set on a lesson's register, see SetRegisterMarks
+type RegisterMark struct {
+	UserId string           `json:"user-id"`
+	Status AttendanceStatus `json:"status"`
+	Reason string           `json:"reason,omitempty"`
+}
+
+// A change to an attendance mark, these are never changed once they are made
+type AttendanceCorrection struct {
+	Id           string           `json:"id"`
+	AttendanceId string           `json:"attendance-id"`
+	LessonId     string           `json:"lesson-id"`
+	UserId       string           `json:"user-id"`
+	Action       string           `json:"action"` // See CORRECTION_AMEND
+	OldStatus    AttendanceStatus `json:"old-status"`
+	NewStatus    AttendanceStatus `json:"new-status"` // Blank when the mark is retracted
+	OldReason    string           `json:"old-reason"`
+	NewReason    string           `json:"new-reason"`
+	Actor        string           `json:"actor"`  // User id
+	Reason       string           `json:"reason"` // Why the mark was changed
+	CreationTime time.Time        `json:"creation-time"`
+}
+
+// Absence requests
+// Details of a file sent with an absence request, i.e: a doctor's note. The file is stored elsewhere
+type AbsenceAttachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content-type,omitempty"`
+	Size        int64  `json:"size,omitempty"` // Bytes
+	Url         string `json:"url,omitempty"`
+}
+
+type AbsenceRequest struct {
+	Id             string             `json:"id"`
+	UserId         string             `json:"user-id"`
+	Reason         string             `json:"reason"`
+	StartTime      time.Time          `json:"start-time,omitempty"` // Zero when the request is for lessons
+	EndTime        time.Time          `json:"end-time,omitempty"`
+	LessonIds      []string           `json:"lesson-ids"`
+	Attachment     *AbsenceAttachment `json:"attachment,omitempty"`
+	Status         string             `json:"status"` // See ABSENCE_SUBMITTED
+	DecidedBy      string             `json:"decided-by,omitempty"`
+	DecisionReason string             `json:"decision-reason,omitempty"`
+	CreationTime   time.Time          `json:"creation-time"`
+	DecisionTime   time.Time          `json:"decision-time,omitempty"`
+}
+
+// Webhooks
+type Webhook struct {
+	Id           string    `json:"id"`
+	Url          string    `json:"url"`
+	Events       []string  `json:"events"` // See WEBHOOK_EVENTS
+	Description  string    `json:"description,omitempty"`
+	Active       bool      `json:"active"`
+	Secret       string    `json:"secret,omitempty"` // Only shown when the webhook is created
+	CreatedBy    string    `json:"created-by,omitempty"`
+	CreationTime time.Time `json:"creation-time"`
+	EditTime     time.Time `json:"edit-time"`
+}
+
+// The body of a webhook delivery
+type WebhookEvent struct {
+	Id           string      `json:"id"`
+	Event        string      `json:"event"`
+	CreationTime time.Time   `json:"creation-time"`
+	Data         interface{} `json:"data"`
+}
+
+type WebhookDelivery struct {
+	Id              string    `json:"id"`
+	WebhookId       string    `json:"webhook-id"`
+	EventId         string    `json:"event-id"`
+	Event           string    `json:"event"`
+	Status          string    `json:"status"` // See WEBHOOK_PENDING
+	Attempts        int       `json:"attempts"`
+	NextAttemptTime time.Time `json:"next-attempt-time"`
+	CreationTime    time.Time `json:"creation-time"`
+	DeliveredTime   time.Time `json:"delivered-time,omitempty"`
+}
+
+// An attempt to send a webhook delivery
+type WebhookAttempt struct {
+	Id          string    `json:"id"`
+	DeliveryId  string    `json:"delivery-id"`
+	Attempt     int       `json:"attempt"`
+	StatusCode  int       `json:"status-code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Duration    int       `json:"duration"` // Milliseconds
+	AttemptTime time.Time `json:"attempt-time"`
+}
+
+type WebhookDeadLetter struct {
+	Id           string          `json:"id"`
+	DeliveryId   string          `json:"delivery-id"`
+	WebhookId    string          `json:"webhook-id"`
+	Event        string          `json:"event"`
+	Payload      json.RawMessage `json:"payload"`
+	Attempts     int             `json:"attempts"`
+	LastError    string          `json:"last-error"`
+	CreationTime time.Time       `json:"creation-time"`
+}
+
+// The data for EVENT_ATTENDANCE_MARKED
+type AttendanceMarkedEvent struct {
+	LessonId     string           `json:"lesson-id"`
+	UserId       string           `json:"user-id"`
+	Status       AttendanceStatus `json:"status"`
+	Reason       string           `json:"reason,omitempty"`
+	MarkedBy     string           `json:"marked-by,omitempty"`
+	RegisterTime time.Time        `json:"register-time"`
+}
+
+// The data for EVENT_LESSON_CANCELLED, the lesson id is blank for occurrences that were not spawned
+type LessonCancelledEvent struct {
+	LessonId          string    `json:"lesson-id,omitempty"`
+	RepeatingLessonId string    `json:"repeating-lesson-id,omitempty"`
+	ModuleGroupId     string    `json:"module-group-id"`
+	StartTime         time.Time `json:"start-time,omitempty"`
+	EndTime           time.Time `json:"end-time,omitempty"`
+}
+
+// Check-in sessions
+type CheckinSession struct {
+	Id         string        `json:"id"`
+	LessonId   string        `json:"lesson-id"`
+	OpenedBy   string        `json:"opened-by"`
+	CodePeriod time.Duration `json:"code-period"`
+	OpenedTime time.Time     `json:"opened-time"`
+	ExpiryTime time.Time     `json:"expiry-time"`
 }
 
 // Ret Structures
@@ -96,9 +375,11 @@ type LessonsRet struct {
 	Modules      []Module       `json:"modules"`
 }
 
+// Marked sessions are present or, late marks and, excused sessions are not in the total
 type AttendanceRecord struct {
-	MarkedSessions int `json:"marked-sessions"`
-	TotalSessions  int `json:"total-sessions"`
+	MarkedSessions int                      `json:"marked-sessions"`
+	TotalSessions  int                      `json:"total-sessions"`
+	Breakdown      map[AttendanceStatus]int `json:"breakdown"`
 }
 
 type AttendanceRet struct {
@@ -139,11 +420,13 @@ type ModuleUserBulkEdit struct {
 type ModuleGroupEdit struct {
 	ModuleGroupId string `json:"module-group-id"`
 	UserId        string `json:"user-id"`
+	OnClash       string `json:"on-clash,omitempty"` // See ON_CLASH_WARN and, ON_CLASH_REFUSE
 }
 
 type ModuleGroupBulkEdit struct {
 	ModuleGroupId string   `json:"module-group-id"`
 	UserIds       []string `json:"user-ids"`
+	OnClash       string   `json:"on-clash,omitempty"`
 }
 
 type CalenderJwtRet struct {
@@ -154,6 +437,12 @@ type MarkAttendanceJson struct {
 	LessonId string `json:"lesson-id"`
 }
 
+type CheckinCodeRet struct {
+	SessionId  string    `json:"session-id"`
+	Code       string    `json:"code"`
+	ValidUntil time.Time `json:"valid-until"`
+}
+
 type ModuleGroupRet struct {
 	UserAttendance []ModuleUserAttendanceRet `json:"users"`
 	ModuleName     string                    `json:"module-group-name"`
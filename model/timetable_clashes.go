@@ -0,0 +1,262 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/utils"
+	"context"
+	"sort"
+	"time"
+)
+
+/*
+ * This file finds students with overlapping lessons, i.e: when they are in two module groups
+ * whose lessons are at the same time. Lecturers are checked when lessons are booked instead,
+ * see clashes.go
+ */
+
+// What to do when adding a student to a module group gives them clashing lessons
+const (
+	ON_CLASH_WARN   = "warn"   // Add them and, return the clashes
+	ON_CLASH_REFUSE = "refuse" // Do not add anyone if there are clashes
+)
+
+// Returned when a membership change is refused because of clashes
+type MembershipClashError struct {
+	Students []StudentClashes
+}
+
+func (e *MembershipClashError) Error() string {
+	return "The module group's lessons clash with the timetables of some of the students"
+}
+
+/*
+ * Finds the pairs of lessons that overlap. Cancelled lessons and, lessons that ended before
+ * now are skipped. If relevant is set then only clashes that involve a relevant lesson are
+ * returned.
+ *
+ * @param lessons the lessons in a timetable, see GetLessons
+ */
+func FindTimetableClashes(lessons []ActualLesson, now time.Time, relevant func(lesson ActualLesson) bool) []TimetableClash {
+	upcoming := make([]ActualLesson, 0, len(lessons))
+	for _, lesson := range lessons {
+		if !lesson.Cancelled && lesson.EndTime.After(now) {
+			upcoming = append(upcoming, lesson)
+		}
+	}
+
+	sort.SliceStable(upcoming, func(i int, j int) bool {
+		return upcoming[i].StartTime.Before(upcoming[j].StartTime)
+	})
+
+	ret := make([]TimetableClash, 0)
+	for i, first := range upcoming {
+		for _, second := range upcoming[i+1:] {
+			if !second.StartTime.Before(first.EndTime) {
+				break
+			}
+
+			if first.Id == second.Id && first.StartTime.Equal(second.StartTime) {
+				continue
+			}
+
+			if relevant == nil || relevant(first) || relevant(second) {
+				ret = append(ret, TimetableClash{First: first, Second: second})
+			}
+		}
+	}
+
+	return ret
+}
+
+/*
+ * Gets the clashes in a user's timetable.
+ */
+func GetUserTimetableClashes(ctx context.Context, userId string, pool *utils.DatabasePool) ([]TimetableClash, error) {
+	lessons, err := GetLessons(ctx, userId, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	return FindTimetableClashes(lessons, time.Now(), nil), nil
+}
+
+// Gets the ids of a module group's group lessons
+func getGroupLessonIds(ctx context.Context, moduleGroupId string, pool *utils.DatabasePool) (map[string]bool, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select id from group_lessons where module_group_id = $1;", moduleGroupId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret[id] = true
+	}
+
+	return ret, nil
+}
+
+// Gets the ids of the members of a module group that are not lecturers, see LECTURER_PERMS
+func getModuleGroupStudentIds(ctx context.Context, moduleGroupId string, pool *utils.DatabasePool) ([]string, error) {
+	lecturers, err := getModuleGroupLecturers(ctx, moduleGroupId, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	isLecturer := make(map[string]bool)
+	for _, lecturer := range lecturers {
+		isLecturer[lecturer] = true
+	}
+
+	rows, err := pool.Database.QueryContext(ctx, "select distinct module_users.user_id from module_user_groups "+
+		"inner join module_users on module_users.id = module_user_groups.module_user_id "+
+		"where module_user_groups.module_group_id = $1;", moduleGroupId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]string, 0)
+	for rows.Next() {
+		var userId string
+		err = rows.Scan(&userId)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		if !isLecturer[userId] {
+			ret = append(ret, userId)
+		}
+	}
+
+	return ret, nil
+}
+
+/*
+ * Gets the clashes between a module group's lessons and, its students' other lessons. Only
+ * students with clashes are returned.
+ */
+func GetModuleGroupTimetableClashes(ctx context.Context, moduleGroupId string, pool *utils.DatabasePool) ([]StudentClashes, error) {
+	groupLessonIds, err := getGroupLessonIds(ctx, moduleGroupId, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	students, err := getModuleGroupStudentIds(ctx, moduleGroupId, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]StudentClashes, 0)
+	now := time.Now()
+	for _, userId := range students {
+		lessons, err := GetLessons(ctx, userId, pool)
+		if err != nil {
+			return nil, err
+		}
+
+		clashes := FindTimetableClashes(lessons, now, func(lesson ActualLesson) bool {
+			return groupLessonIds[lesson.GroupLessonId]
+		})
+		if len(clashes) != 0 {
+			ret = append(ret, StudentClashes{UserId: userId, Clashes: clashes})
+		}
+	}
+
+	return ret, nil
+}
+
+/*
+ * Gets the clashes that users would have if they were added to a module group, these are
+ * between the group's lessons and, their current timetable. Only users with clashes are
+ * returned.
+ */
+func CheckMembershipClashes(ctx context.Context, userIds []string, moduleGroupId string, pool *utils.DatabasePool) ([]StudentClashes, error) {
+	groupLessonIds, err := getGroupLessonIds(ctx, moduleGroupId, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	timetables := make([][]ActualLesson, len(userIds))
+	to := now
+	for i, userId := range userIds {
+		timetables[i], err = GetLessons(ctx, userId, pool)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, lesson := range timetables[i] {
+			if lesson.EndTime.After(to) {
+				to = lesson.EndTime
+			}
+		}
+	}
+
+	ret := make([]StudentClashes, 0)
+	if !to.After(now) {
+		return ret, nil
+	}
+
+	groupLessons, err := getSchedule(ctx, GROUP_LESSON_BY_MODULE_GROUP, GROUP_LESSON_BY_MODULE_GROUP, moduleGroupId, now, to, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	isGroupLesson := func(lesson ActualLesson) bool {
+		return groupLessonIds[lesson.GroupLessonId]
+	}
+
+	for i, userId := range userIds {
+		lessons := append(append(make([]ActualLesson, 0, len(timetables[i])+len(groupLessons)), timetables[i]...), groupLessons...)
+
+		// Clashes between the group's own lessons are not new
+		clashes := make([]TimetableClash, 0)
+		for _, clash := range FindTimetableClashes(lessons, now, isGroupLesson) {
+			if isGroupLesson(clash.First) != isGroupLesson(clash.Second) {
+				clashes = append(clashes, clash)
+			}
+		}
+
+		if len(clashes) != 0 {
+			ret = append(ret, StudentClashes{UserId: userId, Clashes: clashes})
+		}
+	}
+
+	return ret, nil
+}
+
+/*
+ * Adds users to a module group after checking for new clashes, see CheckMembershipClashes.
+ * With ON_CLASH_REFUSE nobody is added if anyone would have a clash and, a
+ * *MembershipClashError is returned.
+ *
+ * @return the clashes that the users now have
+ */
+func AddUsersToModuleGroupChecked(ctx context.Context, userIds []string, moduleGroupId string, onClash string, pool *utils.DatabasePool) ([]StudentClashes, error) {
+	clashes, err := CheckMembershipClashes(ctx, userIds, moduleGroupId, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	if onClash == ON_CLASH_REFUSE && len(clashes) != 0 {
+		return nil, &MembershipClashError{Students: clashes}
+	}
+
+	err = AddUsersToModuleGroup(ctx, userIds, moduleGroupId, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	return clashes, nil
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindTimetableClashes(t *testing.T) {
+	now := time.Date(2022, 10, 3, 9, 0, 0, 0, time.UTC)
+	maths := testLesson("maths", now.Add(time.Hour), time.Hour)
+	physics := testLesson("physics", now.Add(90*time.Minute), time.Hour)
+	after := testLesson("after", now.Add(2*time.Hour), time.Hour)
+	cancelled := testLesson("cancelled", now.Add(time.Hour), time.Hour)
+	cancelled.Cancelled = true
+	past := testLesson("past", now.Add(-2*time.Hour), 3*time.Hour/2)
+	pastOverlap := testLesson("past-overlap", now.Add(-time.Hour), time.Hour/2)
+
+	maths.GroupLessonId = "group"
+	lessons := []ActualLesson{after, physics, maths, cancelled, past, pastOverlap}
+
+	// Physics overlaps maths and, after. Cancelled and, past lessons are skipped
+	clashes := FindTimetableClashes(lessons, now, nil)
+	if len(clashes) != 2 {
+		t.Log("Expected two clashes", clashes)
+		t.FailNow()
+	}
+
+	if clashes[0].First.Id != "maths" || clashes[0].Second.Id != "physics" {
+		t.Log("Expected maths to clash with physics", clashes[0])
+		t.Fail()
+	}
+
+	if clashes[1].First.Id != "physics" || clashes[1].Second.Id != "after" {
+		t.Log("Expected physics to clash with after", clashes[1])
+		t.Fail()
+	}
+
+	// Only clashes with the group's lessons
+	clashes = FindTimetableClashes(lessons, now, func(lesson ActualLesson) bool {
+		return lesson.GroupLessonId == "group"
+	})
+	if len(clashes) != 1 || clashes[0].First.Id != "maths" {
+		t.Log("Expected only the clash with maths", clashes)
+		t.Fail()
+	}
+}
@@ -1,24 +1,27 @@
 package model
 
 import (
+	"arcio/attendance-system/logging"
 	"arcio/attendance-system/utils"
-	"log"
+	"context"
+	"errors"
+	"strings"
 )
 
-func GetUsers(DatabasePool *utils.DatabasePool) ([]User, error) {
+func GetUsers(ctx context.Context, DatabasePool *utils.DatabasePool) ([]User, error) {
 	var returnUsers []User
 
-	getUsers, err := DatabasePool.Database.Prepare("SELECT id, external_id, firstname, surname, " +
-		"email, creation_time, edit_time " +
+	getUsers, err := DatabasePool.Database.PrepareContext(ctx, "SELECT id, external_id, firstname, surname, "+
+		"email, creation_time, edit_time "+
 		"FROM users;")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 
-	rows, err := getUsers.Query()
+	rows, err := getUsers.QueryContext(ctx)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 
@@ -27,7 +30,7 @@ func GetUsers(DatabasePool *utils.DatabasePool) ([]User, error) {
 		err = rows.Scan(&newUser.InternalId, &newUser.ExternalId, &newUser.Fname, &newUser.Sname, &newUser.Email,
 			&newUser.CreationTime, &newUser.EditTime)
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(ctx).Error(err)
 			return nil, err
 		}
 		returnUsers = append(returnUsers, newUser)
@@ -36,21 +39,21 @@ func GetUsers(DatabasePool *utils.DatabasePool) ([]User, error) {
 	return returnUsers, nil
 }
 
-func GetModuleGroupsForUserModule(moduleuserid string, moduleid string, DatabasePool *utils.DatabasePool) ([]ModuleGroup, error) {
-	stmt, err := DatabasePool.Database.Prepare("select module_groups.id, module_groups.module_id, module_groups.name, module_groups.creation_time, module_groups.edit_time " +
-		"from module_groups, module_user_groups, module_users " +
-		"where module_user_groups.module_user_id = module_users.id " +
-		"and module_users.user_id = $1 and module_groups.module_id = $2 " +
+func GetModuleGroupsForUserModule(ctx context.Context, moduleuserid string, moduleid string, DatabasePool *utils.DatabasePool) ([]ModuleGroup, error) {
+	stmt, err := DatabasePool.Database.PrepareContext(ctx, "select module_groups.id, module_groups.module_id, module_groups.name, module_groups.creation_time, module_groups.edit_time "+
+		"from module_groups, module_user_groups, module_users "+
+		"where module_user_groups.module_user_id = module_users.id "+
+		"and module_users.user_id = $1 and module_groups.module_id = $2 "+
 		"and module_user_groups.module_group_id = module_groups.id;")
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(moduleuserid, moduleid)
+	rows, err := stmt.QueryContext(ctx, moduleuserid, moduleid)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer rows.Next()
@@ -66,25 +69,25 @@ func GetModuleGroupsForUserModule(moduleuserid string, moduleid string, Database
 	return ret, nil
 }
 
-func GetUserModuleGroups(userid string, moduleid string, pool *utils.DatabasePool) ([]ModuleGroup, error) {
+func GetUserModuleGroups(ctx context.Context, userid string, moduleid string, pool *utils.DatabasePool) ([]ModuleGroup, error) {
 	var moduleGroups []ModuleGroup
 
-	stmt, err := pool.Database.Prepare("SELECT " +
-		"module_groups.id, module_groups.module_id, module_groups.name, module_groups.creation_time, module_groups.edit_time " +
-		"FROM module_users " +
-		"INNER JOIN module_user_groups ON module_user_groups.module_user_id = module_users.id " +
-		"INNER JOIN module_groups ON module_user_groups.module_group_id = module_groups.id " +
+	stmt, err := pool.Database.PrepareContext(ctx, "SELECT "+
+		"module_groups.id, module_groups.module_id, module_groups.name, module_groups.creation_time, module_groups.edit_time "+
+		"FROM module_users "+
+		"INNER JOIN module_user_groups ON module_user_groups.module_user_id = module_users.id "+
+		"INNER JOIN module_groups ON module_user_groups.module_group_id = module_groups.id "+
 		"WHERE module_users.user_id = $1 AND module_users.module_id = $2;")
 
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(userid, moduleid)
+	rows, err := stmt.QueryContext(ctx, userid, moduleid)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error(err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -99,3 +102,22 @@ func GetUserModuleGroups(userid string, moduleid string, pool *utils.DatabasePoo
 
 	return moduleGroups, nil
 }
+
+/*
+ * Checks that a user has the fields that are needed to create them.
+ */
+func validateUser(user User) error {
+	if strings.TrimSpace(user.ExternalId) == "" {
+		return errors.New("The external id cannot be empty")
+	}
+
+	if strings.TrimSpace(user.Fname) == "" || strings.TrimSpace(user.Sname) == "" {
+		return errors.New("The firstname and, surname cannot be empty")
+	}
+
+	if !strings.Contains(user.Email, "@") {
+		return errors.New("Invalid email")
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"arcio/attendance-system/config"
 	"arcio/attendance-system/utils"
+	"context"
 	"math/rand"
 	"strings"
 	"testing"
@@ -28,7 +29,7 @@ func TestInitUmTests(t *testing.T) {
 }
 
 func TestAddUserToModuleGroup(t *testing.T) {
-	users, err := GetUsers(pool_um)
+	users, err := GetUsers(context.Background(), pool_um)
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -38,14 +39,14 @@ func TestAddUserToModuleGroup(t *testing.T) {
 	r := rand.Intn(len(users))
 	uid := users[r].InternalId
 	t.Log(users[r])
-	modules, err := GetModulesForUser(uid, pool_um)
+	modules, err := GetModulesForUser(context.Background(), uid, pool_um)
 	if err != nil {
 		t.Log(err)
 		t.Fail()
 		return
 	}
 
-	mgroups, err := GetModuleGroupsForUserModule(uid, modules[0].Id, pool_um)
+	mgroups, err := GetModuleGroupsForUserModule(context.Background(), uid, modules[0].Id, pool_um)
 	if err != nil {
 		t.Log(err)
 		t.Fail()
@@ -60,7 +61,7 @@ func TestAddUserToModuleGroup(t *testing.T) {
 
 	r = rand.Intn(len(mgroups))
 
-	err = AddUserToModuleGroup(uid, mgroups[r].Id, pool_um)
+	err = AddUserToModuleGroup(context.Background(), uid, mgroups[r].Id, pool_um)
 	t.Log(err)
 
 	if strings.Index(string(err.Error()), "duplicate key value violates") != -1 {
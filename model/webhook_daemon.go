@@ -0,0 +1,256 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/security"
+	"arcio/attendance-system/utils"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+ * The webhook daemon sends pending deliveries, see webhooks.go. Failed deliveries are retried
+ * with exponential backoff and, are moved to the dead letters once they run out of attempts.
+ * Every attempt is written to the delivery log.
+ */
+
+// Poll every 5 seconds
+const WEBHOOK_POLL_TIME = 5 * time.Second
+
+// The most deliveries that are sent in one poll
+const WEBHOOK_BATCH_SIZE = 50
+
+const WEBHOOK_TIMEOUT = 10 * time.Second
+const WEBHOOK_MAX_ATTEMPTS = 8
+
+// The first retry is after WEBHOOK_BASE_BACKOFF, the wait then doubles up to WEBHOOK_MAX_BACKOFF
+const WEBHOOK_BASE_BACKOFF = 30 * time.Second
+const WEBHOOK_MAX_BACKOFF = 6 * time.Hour
+
+// The most of a failed response's body that is logged
+const WEBHOOK_ERROR_BODY_LIMIT = 512
+
+// Headers sent with each delivery
+const (
+	WEBHOOK_EVENT_HEADER     = "X-Arcio-Event"
+	WEBHOOK_DELIVERY_HEADER  = "X-Arcio-Delivery"
+	WEBHOOK_TIMESTAMP_HEADER = "X-Arcio-Timestamp" // Unix time, part of the signature
+	WEBHOOK_SIGNATURE_HEADER = "X-Arcio-Signature" // See security.SignWebhookPayload
+)
+
+/*
+ * Returns how long to wait before the next attempt after a number of failed attempts.
+ */
+func webhookBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	ret := WEBHOOK_BASE_BACKOFF
+	for i := 1; i < attempts; i++ {
+		ret *= 2
+		if ret >= WEBHOOK_MAX_BACKOFF {
+			return WEBHOOK_MAX_BACKOFF
+		}
+	}
+
+	return ret
+}
+
+type pendingWebhookDelivery struct {
+	Id       string
+	Url      string
+	Secret   string
+	Event    string
+	Payload  string
+	Attempts int
+}
+
+// Gets the deliveries for active webhooks that are due to be sent
+func getDueWebhookDeliveries(ctx context.Context, now time.Time, pool *utils.DatabasePool) ([]pendingWebhookDelivery, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select webhook_deliveries.id, webhook_subscriptions.url, "+
+		"webhook_subscriptions.secret, webhook_deliveries.event, webhook_deliveries.payload, webhook_deliveries.attempts "+
+		"from webhook_deliveries "+
+		"inner join webhook_subscriptions on webhook_subscriptions.id = webhook_deliveries.subscription_id "+
+		"where webhook_deliveries.status = $1 and webhook_deliveries.next_attempt_time <= $2 and webhook_subscriptions.active "+
+		"order by webhook_deliveries.next_attempt_time asc limit $3;",
+		WEBHOOK_PENDING, now.UTC(), WEBHOOK_BATCH_SIZE)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]pendingWebhookDelivery, 0)
+	for rows.Next() {
+		var delivery pendingWebhookDelivery
+		err = rows.Scan(&delivery.Id, &delivery.Url, &delivery.Secret, &delivery.Event, &delivery.Payload, &delivery.Attempts)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret = append(ret, delivery)
+	}
+
+	return ret, nil
+}
+
+/*
+ * Sends a delivery, responses that are not 2xx are errors.
+ *
+ * @return the response's status code, 0 if there was no response
+ */
+func sendWebhook(ctx context.Context, client *http.Client, delivery pendingWebhookDelivery, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, WEBHOOK_TIMEOUT)
+	defer cancel()
+
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WEBHOOK_EVENT_HEADER, delivery.Event)
+	req.Header.Set(WEBHOOK_DELIVERY_HEADER, delivery.Id)
+	req.Header.Set(WEBHOOK_TIMESTAMP_HEADER, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WEBHOOK_SIGNATURE_HEADER, security.SignWebhookPayload([]byte(delivery.Secret), timestamp, payload))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, WEBHOOK_ERROR_BODY_LIMIT))
+		return res.StatusCode, fmt.Errorf("%s %s", res.Status, body)
+	}
+
+	return res.StatusCode, nil
+}
+
+/*
+ * Writes an attempt to the delivery log and, updates the delivery. Failed deliveries are
+ * retried later or, moved to the dead letters after WEBHOOK_MAX_ATTEMPTS.
+ */
+func recordWebhookAttempt(ctx context.Context, delivery pendingWebhookDelivery, statusCode int, sendErr error, duration time.Duration, now time.Time, pool *utils.DatabasePool) error {
+	// Create transaction
+	success := false
+	tx, err := pool.Database.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	attempt := delivery.Attempts + 1
+	errorMessage := ""
+	if sendErr != nil {
+		errorMessage = sendErr.Error()
+	}
+
+	_, err = tx.ExecContext(ctx, "insert into webhook_delivery_log "+
+		"(id, delivery_id, attempt, status_code, error, duration_ms, attempt_time) values ($1, $2, $3, $4, $5, $6, $7);",
+		uuid.New().String(), delivery.Id, attempt, statusCode, errorMessage, duration.Milliseconds(), now.UTC())
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	if sendErr == nil {
+		_, err = tx.ExecContext(ctx, "update webhook_deliveries set status = $2, attempts = $3, delivered_time = $4 where id = $1;",
+			delivery.Id, WEBHOOK_DELIVERED, attempt, now.UTC())
+	} else if attempt >= WEBHOOK_MAX_ATTEMPTS {
+		_, err = tx.ExecContext(ctx, "update webhook_deliveries set status = $2, attempts = $3 where id = $1;",
+			delivery.Id, WEBHOOK_DEAD, attempt)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "insert into webhook_dead_letters "+
+				"(id, delivery_id, subscription_id, event, payload, attempts, last_error, creation_time) "+
+				"select $1, id, subscription_id, event, payload, attempts, $3, $4 from webhook_deliveries where id = $2 "+
+				"on conflict (delivery_id) do nothing;",
+				uuid.New().String(), delivery.Id, errorMessage, now.UTC())
+		}
+	} else {
+		_, err = tx.ExecContext(ctx, "update webhook_deliveries set attempts = $2, next_attempt_time = $3 where id = $1;",
+			delivery.Id, attempt, now.Add(webhookBackoff(attempt)).UTC())
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	success = true
+	return nil
+}
+
+/*
+ * Sends the deliveries that are due.
+ *
+ * @return the number of deliveries that were sent successfully
+ */
+func DeliverWebhooks(ctx context.Context, client *http.Client, pool *utils.DatabasePool) (int, error) {
+	deliveries, err := getDueWebhookDeliveries(ctx, time.Now(), pool)
+	if err != nil {
+		return 0, err
+	}
+
+	ret := 0
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return ret, ctx.Err()
+		}
+
+		start := time.Now()
+		statusCode, sendErr := sendWebhook(ctx, client, delivery, start)
+		if ctx.Err() != nil {
+			// Shutting down is not the receiver's fault
+			return ret, ctx.Err()
+		}
+
+		err = recordWebhookAttempt(ctx, delivery, statusCode, sendErr, time.Since(start), time.Now(), pool)
+		if err != nil {
+			logging.FromContext(ctx).Errorf("Cannot record webhook delivery %s - %s", delivery.Id, err)
+			continue
+		}
+
+		if sendErr != nil {
+			logging.FromContext(ctx).Warnf("Webhook delivery %s failed (attempt %d) - %s", delivery.Id, delivery.Attempts+1, sendErr)
+		} else {
+			ret++
+		}
+	}
+
+	return ret, nil
+}
+
+/*
+ * Runs the webhook deliveries until the context is done, only one replica sends them at a
+ * time. See utils/leader.go
+ */
+func StartWebhookDaemon(ctx context.Context, pool *utils.DatabasePool) {
+	logging.FromContext(ctx).Info("Started webhook daemon")
+	client := &http.Client{Timeout: WEBHOOK_TIMEOUT}
+	utils.RunAsLeader(ctx, pool, utils.WEBHOOK_LOCK, WEBHOOK_POLL_TIME, func(ctx context.Context) {
+		_, err := DeliverWebhooks(ctx, client, pool)
+		if err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).Error(err)
+		}
+	})
+	logging.FromContext(ctx).Info("Stopped webhook daemon")
+}
@@ -0,0 +1,431 @@
+package model
+
+import (
+	"arcio/attendance-system/logging"
+	"arcio/attendance-system/utils"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+/*
+ * This file manages webhook subscriptions, other systems subscribe to events and, are sent
+ * them as signed JSON. Events about changes made in a transaction are queued in it, the others
+ * are queued with utils.WriteLater so that handlers are not blocked. The webhook daemon sends
+ * them, see webhook_daemon.go
+ */
+
+// Webhook events
+const (
+	EVENT_ATTENDANCE_MARKED    = "attendance.marked"    // AttendanceMarkedEvent
+	EVENT_ATTENDANCE_CORRECTED = "attendance.corrected" // AttendanceCorrection
+	EVENT_LESSON_CREATED       = "lesson.created"       // ActualLesson
+	EVENT_LESSON_CANCELLED     = "lesson.cancelled"     // LessonCancelledEvent
+	EVENT_STUDENT_AT_RISK      = "student.at-risk"      // AttendanceAlert
+)
+
+var WEBHOOK_EVENTS = []string{EVENT_ATTENDANCE_MARKED,
+	EVENT_ATTENDANCE_CORRECTED,
+	EVENT_LESSON_CREATED,
+	EVENT_LESSON_CANCELLED,
+	EVENT_STUDENT_AT_RISK}
+
+// Webhook delivery statuses
+const (
+	WEBHOOK_PENDING   = "pending"
+	WEBHOOK_DELIVERED = "delivered"
+	WEBHOOK_DEAD      = "dead" // Ran out of attempts, see webhook_dead_letters
+)
+
+const WEBHOOK_SECRET_BYTES = 32
+
+// The most deliveries that are listed for a webhook
+const WEBHOOK_DELIVERY_LIMIT = 100
+
+var ErrWebhookNotFound = errors.New("Cannot find webhook with matching id")
+var ErrDeadLetterNotFound = errors.New("Cannot find dead letter with matching id")
+
+func isWebhookEvent(event string) bool {
+	for _, e := range WEBHOOK_EVENTS {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Checks a webhook, the url must be http or, https and, it must subscribe to at least one
+ * event. The events are sorted and, duplicates are removed.
+ */
+func validateWebhook(webhook *Webhook) error {
+	webhook.Url = strings.TrimSpace(webhook.Url)
+	parsed, err := url.Parse(webhook.Url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("The webhook url must be a http or, https url")
+	}
+
+	seen := make(map[string]bool)
+	events := make([]string, 0, len(webhook.Events))
+	for _, event := range webhook.Events {
+		if !isWebhookEvent(event) {
+			return errors.New("Invalid webhook event " + event)
+		}
+
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+
+	if len(events) == 0 {
+		return errors.New("The webhook must subscribe to at least one event")
+	}
+
+	sort.Strings(events)
+	webhook.Events = events
+	webhook.Description = strings.TrimSpace(webhook.Description)
+	return nil
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, WEBHOOK_SECRET_BYTES)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(secret), nil
+}
+
+/*
+ * Creates an active webhook with a new secret, the secret is only returned here.
+ */
+func CreateWebhook(ctx context.Context, webhook *Webhook, createdBy string, pool *utils.DatabasePool) error {
+	err := validateWebhook(webhook)
+	if err != nil {
+		return err
+	}
+
+	webhook.Secret, err = newWebhookSecret()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	webhook.Id = uuid.New().String()
+	webhook.Active = true
+	webhook.CreatedBy = createdBy
+	webhook.CreationTime = time.Now()
+	webhook.EditTime = webhook.CreationTime
+
+	_, err = pool.Database.ExecContext(ctx, "insert into webhook_subscriptions "+
+		"(id, url, secret, events, description, active, created_by, creation_time, edit_time) "+
+		"values ($1, $2, $3, $4, $5, $6, nullif($7, '')::uuid, $8, $9);",
+		webhook.Id, webhook.Url, webhook.Secret, pq.Array(webhook.Events), webhook.Description, webhook.Active,
+		webhook.CreatedBy, webhook.CreationTime, webhook.EditTime)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	return nil
+}
+
+const WEBHOOK_COLUMNS = "id, url, events, description, active, coalesce(created_by::text, ''), creation_time, edit_time"
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (Webhook, error) {
+	var webhook Webhook
+	err := row.Scan(&webhook.Id, &webhook.Url, pq.Array(&webhook.Events), &webhook.Description, &webhook.Active,
+		&webhook.CreatedBy, &webhook.CreationTime, &webhook.EditTime)
+	return webhook, err
+}
+
+/*
+ * Gets the webhooks, oldest first. Secrets are not returned.
+ */
+func GetWebhooks(ctx context.Context, pool *utils.DatabasePool) ([]Webhook, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select "+WEBHOOK_COLUMNS+" from webhook_subscriptions order by creation_time asc;")
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]Webhook, 0)
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret = append(ret, webhook)
+	}
+
+	return ret, nil
+}
+
+func GetWebhook(ctx context.Context, webhookId string, pool *utils.DatabasePool) (Webhook, error) {
+	if _, err := uuid.Parse(webhookId); err != nil {
+		return Webhook{}, ErrWebhookNotFound
+	}
+
+	webhook, err := scanWebhook(pool.Database.QueryRowContext(ctx, "select "+WEBHOOK_COLUMNS+" from webhook_subscriptions where id = $1;", webhookId))
+	if err == sql.ErrNoRows {
+		return Webhook{}, ErrWebhookNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return Webhook{}, err
+	}
+
+	return webhook, nil
+}
+
+/*
+ * Updates a webhook's url, events, description and, whether it is active. Deliveries for
+ * inactive webhooks are kept until it is active again.
+ */
+func UpdateWebhook(ctx context.Context, webhook *Webhook, pool *utils.DatabasePool) error {
+	if _, err := uuid.Parse(webhook.Id); err != nil {
+		return ErrWebhookNotFound
+	}
+
+	err := validateWebhook(webhook)
+	if err != nil {
+		return err
+	}
+
+	webhook.EditTime = time.Now()
+	err = pool.Database.QueryRowContext(ctx, "update webhook_subscriptions set url = $2, events = $3, description = $4, active = $5, "+
+		"edit_time = $6 where id = $1 returning coalesce(created_by::text, ''), creation_time;",
+		webhook.Id, webhook.Url, pq.Array(webhook.Events), webhook.Description, webhook.Active, webhook.EditTime).Scan(
+		&webhook.CreatedBy, &webhook.CreationTime)
+	if err == sql.ErrNoRows {
+		return ErrWebhookNotFound
+	} else if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Deletes a webhook and, its deliveries.
+ */
+func DeleteWebhook(ctx context.Context, webhookId string, pool *utils.DatabasePool) error {
+	if _, err := uuid.Parse(webhookId); err != nil {
+		return ErrWebhookNotFound
+	}
+
+	res, err := pool.Database.ExecContext(ctx, "delete from webhook_subscriptions where id = $1;", webhookId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	} else if count == 0 {
+		return ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+const queueWebhookEventSql = "insert into webhook_deliveries " +
+	"(id, subscription_id, event_id, event, payload, status, attempts, next_attempt_time, creation_time) " +
+	"select gen_random_uuid(), id, $1, $2, $3, $4, 0, $5, $5 from webhook_subscriptions " +
+	"where active and $2 = any(events);"
+
+func newWebhookEvent(event string, data interface{}) (WebhookEvent, string, error) {
+	body := WebhookEvent{Id: uuid.New().String(), Event: event, CreationTime: time.Now(), Data: data}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return WebhookEvent{}, "", err
+	}
+	return body, string(payload), nil
+}
+
+/*
+ * Queues an event for every active webhook that subscribes to it. The deliveries are written
+ * by the writer thread so this does not block, see utils.WriteLater
+ *
+ * @param event the event, see WEBHOOK_EVENTS
+ * @param data  the event's data, it is sent as JSON
+ */
+func QueueWebhookEvent(event string, data interface{}, pool *utils.DatabasePool) {
+	body, payload, err := newWebhookEvent(event, data)
+	if err != nil {
+		logging.Default().Error(err)
+		return
+	}
+
+	utils.WriteLater(pool, func(database *sql.DB) {
+		_, err := database.Exec(queueWebhookEventSql, body.Id, event, payload, WEBHOOK_PENDING, body.CreationTime.UTC())
+		if err != nil {
+			logging.Default().Error(err)
+		}
+	})
+}
+
+/*
+ * Queues an event in a transaction, the deliveries are only sent if the transaction commits.
+ * This is for events about changes made in the same transaction.
+ *
+ * @see QueueWebhookEvent
+ */
+func queueWebhookEventTx(ctx context.Context, tx *sql.Tx, event string, data interface{}) error {
+	body, payload, err := newWebhookEvent(event, data)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, queueWebhookEventSql, body.Id, event, payload, WEBHOOK_PENDING, body.CreationTime.UTC())
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * Gets the latest deliveries for a webhook, newest first.
+ */
+func GetWebhookDeliveries(ctx context.Context, webhookId string, pool *utils.DatabasePool) ([]WebhookDelivery, error) {
+	if _, err := uuid.Parse(webhookId); err != nil {
+		return nil, ErrWebhookNotFound
+	}
+
+	rows, err := pool.Database.QueryContext(ctx, "select id, subscription_id, event_id, event, status, attempts, next_attempt_time, "+
+		"creation_time, delivered_time from webhook_deliveries where subscription_id = $1 "+
+		"order by creation_time desc limit $2;", webhookId, WEBHOOK_DELIVERY_LIMIT)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var deliveredTime sql.NullTime
+		err = rows.Scan(&delivery.Id, &delivery.WebhookId, &delivery.EventId, &delivery.Event, &delivery.Status,
+			&delivery.Attempts, &delivery.NextAttemptTime, &delivery.CreationTime, &deliveredTime)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		delivery.DeliveredTime = deliveredTime.Time
+		ret = append(ret, delivery)
+	}
+
+	return ret, nil
+}
+
+/*
+ * Gets the attempts to send a delivery, oldest first.
+ */
+func GetWebhookDeliveryLog(ctx context.Context, deliveryId string, pool *utils.DatabasePool) ([]WebhookAttempt, error) {
+	ret := make([]WebhookAttempt, 0)
+	if _, err := uuid.Parse(deliveryId); err != nil {
+		return ret, nil
+	}
+
+	rows, err := pool.Database.QueryContext(ctx, "select id, delivery_id, attempt, status_code, error, duration_ms, attempt_time "+
+		"from webhook_delivery_log where delivery_id = $1 order by attempt asc;", deliveryId)
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var attempt WebhookAttempt
+		err = rows.Scan(&attempt.Id, &attempt.DeliveryId, &attempt.Attempt, &attempt.StatusCode, &attempt.Error,
+			&attempt.Duration, &attempt.AttemptTime)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		ret = append(ret, attempt)
+	}
+
+	return ret, nil
+}
+
+/*
+ * Gets the deliveries that ran out of attempts, newest first.
+ */
+func GetWebhookDeadLetters(ctx context.Context, pool *utils.DatabasePool) ([]WebhookDeadLetter, error) {
+	rows, err := pool.Database.QueryContext(ctx, "select id, delivery_id, subscription_id, event, payload, attempts, last_error, "+
+		"creation_time from webhook_dead_letters order by creation_time desc;")
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]WebhookDeadLetter, 0)
+	for rows.Next() {
+		var deadLetter WebhookDeadLetter
+		var payload string
+		err = rows.Scan(&deadLetter.Id, &deadLetter.DeliveryId, &deadLetter.WebhookId, &deadLetter.Event, &payload,
+			&deadLetter.Attempts, &deadLetter.LastError, &deadLetter.CreationTime)
+		if err != nil {
+			logging.FromContext(ctx).Error(err)
+			return nil, err
+		}
+
+		deadLetter.Payload = json.RawMessage(payload)
+		ret = append(ret, deadLetter)
+	}
+
+	return ret, nil
+}
+
+/*
+ * Sends a dead letter again, its delivery is pending with no attempts and, the dead letter is
+ * removed. The delivery log is kept.
+ */
+func RetryWebhookDeadLetter(ctx context.Context, deadLetterId string, pool *utils.DatabasePool) error {
+	if _, err := uuid.Parse(deadLetterId); err != nil {
+		return ErrDeadLetterNotFound
+	}
+
+	res, err := pool.Database.ExecContext(ctx, "with dead as (delete from webhook_dead_letters where id = $1 returning delivery_id) "+
+		"update webhook_deliveries set status = $2, attempts = 0, next_attempt_time = $3 "+
+		"where id in (select delivery_id from dead);",
+		deadLetterId, WEBHOOK_PENDING, time.Now().UTC())
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		logging.FromContext(ctx).Error(err)
+		return err
+	} else if count == 0 {
+		return ErrDeadLetterNotFound
+	}
+
+	return nil
+}
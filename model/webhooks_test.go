@@ -0,0 +1,100 @@
+package model
+
+import (
+	"arcio/attendance-system/security"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestValidateWebhook(t *testing.T) {
+	webhook := Webhook{Url: " https://portal.example.com/hooks ",
+		Events: []string{EVENT_LESSON_CREATED, EVENT_ATTENDANCE_MARKED, EVENT_LESSON_CREATED}}
+	if err := validateWebhook(&webhook); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if webhook.Url != "https://portal.example.com/hooks" || len(webhook.Events) != 2 || webhook.Events[0] != EVENT_ATTENDANCE_MARKED {
+		t.Log("Expected the url and, events to be normalised", webhook)
+		t.Fail()
+	}
+
+	invalid := map[string]Webhook{
+		"no scheme":     {Url: "portal.example.com", Events: []string{EVENT_LESSON_CREATED}},
+		"ftp":           {Url: "ftp://portal.example.com", Events: []string{EVENT_LESSON_CREATED}},
+		"no events":     {Url: "https://portal.example.com"},
+		"invalid event": {Url: "https://portal.example.com", Events: []string{"lesson.deleted"}},
+	}
+
+	for name, webhook := range invalid {
+		webhook := webhook
+		if err := validateWebhook(&webhook); err == nil {
+			t.Log("Expected the webhook to be invalid:", name)
+			t.Fail()
+		}
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	if webhookBackoff(1) != WEBHOOK_BASE_BACKOFF {
+		t.Log("Expected the first retry to wait the base backoff", webhookBackoff(1))
+		t.Fail()
+	}
+
+	for attempts := 2; attempts < 20; attempts++ {
+		if webhookBackoff(attempts) < webhookBackoff(attempts-1) {
+			t.Logf("The backoff went down after %d attempts", attempts)
+			t.Fail()
+		}
+
+		if webhookBackoff(attempts) > WEBHOOK_MAX_BACKOFF {
+			t.Logf("The backoff went over the maximum after %d attempts", attempts)
+			t.Fail()
+		}
+	}
+
+	if webhookBackoff(3) != 4*WEBHOOK_BASE_BACKOFF {
+		t.Log("Expected the backoff to double", webhookBackoff(3))
+		t.Fail()
+	}
+}
+
+func TestSendWebhook(t *testing.T) {
+	secret := "secret"
+	payload := `{"event":"lesson.created"}`
+	status := http.StatusOK
+	valid := false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(WEBHOOK_TIMESTAMP_HEADER), 10, 64)
+		valid = security.CheckWebhookSignature([]byte(secret), timestamp, body, r.Header.Get(WEBHOOK_SIGNATURE_HEADER)) &&
+			r.Header.Get(WEBHOOK_EVENT_HEADER) == EVENT_LESSON_CREATED && r.Header.Get(WEBHOOK_DELIVERY_HEADER) == "delivery"
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	delivery := pendingWebhookDelivery{Id: "delivery", Url: server.URL, Secret: secret, Event: EVENT_LESSON_CREATED, Payload: payload}
+	code, err := sendWebhook(context.Background(), server.Client(), delivery, time.Now())
+	if err != nil || code != http.StatusOK {
+		t.Log("Expected the delivery to be sent", code, err)
+		t.Fail()
+	}
+
+	if !valid {
+		t.Log("Expected the delivery to be signed with the headers set")
+		t.Fail()
+	}
+
+	status = http.StatusInternalServerError
+	code, err = sendWebhook(context.Background(), server.Client(), delivery, time.Now())
+	if err == nil || code != http.StatusInternalServerError {
+		t.Log("Expected responses that are not 2xx to be errors", code, err)
+		t.Fail()
+	}
+}
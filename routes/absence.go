@@ -0,0 +1,190 @@
+/*
+ * absence.go contains handlers for endpoints under `/absence`.
+ * Students submit absence requests for lessons that they will miss, users with attendance
+ * permissions approve or, reject them. See model/absence_requests.go
+ */
+
+package routes
+
+import (
+	"arcio/attendance-system/middleware"
+	"arcio/attendance-system/model"
+	"arcio/attendance-system/security"
+	"arcio/attendance-system/utils"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+func addAbsenceRoutes(r *gin.Engine) {
+	absenceRoutes := r.Group("/absence")
+	absenceRoutes.Use(middleware.CheckAuth(NonceManager))
+	absenceRoutes.POST("/submit", middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ, security.PERMS_CAN_CREATE), SubmitAbsenceRequestHandler)
+	absenceRoutes.GET("/get", GetAbsenceRequestsHandler)
+	absenceRoutes.GET("/list", middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ_ALL), ListAbsenceRequestsHandler)
+	absenceRoutes.POST("/approve", middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ_ALL, security.PERMS_CAN_UPDATE), ApproveAbsenceRequestHandler)
+	absenceRoutes.POST("/reject", middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ_ALL, security.PERMS_CAN_UPDATE), RejectAbsenceRequestHandler)
+}
+
+type SubmitAbsenceRequestBody struct {
+	Reason     string                   `json:"reason"`
+	StartTime  string                   `json:"start-time,omitempty"`
+	EndTime    string                   `json:"end-time,omitempty"`
+	LessonIds  []string                 `json:"lesson-ids,omitempty"`
+	Attachment *model.AbsenceAttachment `json:"attachment,omitempty"`
+}
+
+type DecideAbsenceRequestBody struct {
+	RequestId      string `json:"request-id"`
+	DecisionReason string `json:"decision-reason,omitempty"`
+}
+
+/*
+ * Sends the response for an absence request error, requests that cannot be found are 404s.
+ */
+func absenceError(c *gin.Context, err error, status int) {
+	if err == model.ErrAbsenceRequestNotFound {
+		status = http.StatusNotFound
+	} else if status == http.StatusInternalServerError {
+		middleware.RequestLog(c).Error(err)
+		err = errors.New("issue with the absence requests")
+	}
+
+	c.Error(err)
+	c.JSON(status, gin.H{
+		"errors": c.Errors,
+	})
+}
+
+/*
+ * Submit an absence request for the lessons in a time range or, some lessons.
+ * Method: POST
+ * URL: `/absence/submit`
+ * Body Params: reason, start-time and, end-time (optional, RFC 3339), lesson-ids (optional),
+ *	attachment (optional, name, content-type, size and, url)
+ */
+func SubmitAbsenceRequestHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body SubmitAbsenceRequestBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	request := model.AbsenceRequest{UserId: claims.Uuid,
+		Reason:     body.Reason,
+		StartTime:  parseOptionalTime(c, time.RFC3339, body.StartTime, "start-time"),
+		EndTime:    parseOptionalTime(c, time.RFC3339, body.EndTime, "end-time"),
+		LessonIds:  body.LessonIds,
+		Attachment: body.Attachment}
+	if len(c.Errors) != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	err = model.SubmitAbsenceRequest(c.Request.Context(), &request, DatabasePool)
+	if err != nil {
+		absenceError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusCreated, request)
+}
+
+/*
+ * Get your absence requests, oldest first.
+ * Method: GET
+ * URL: `/absence/get`
+ */
+func GetAbsenceRequestsHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	requests, err := model.GetUserAbsenceRequests(c.Request.Context(), claims.Uuid, DatabasePool)
+	if err != nil {
+		absenceError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+/*
+ * List absence requests, oldest first.
+ * Method: GET
+ * URL: `/absence/list`
+ * Query Params: userId (optional, all of a student's requests) or,
+ *	status (optional, submitted by default)
+ */
+func ListAbsenceRequestsHandler(c *gin.Context) {
+	var requests []model.AbsenceRequest
+	var err error
+	if userId, exists := c.GetQuery("userId"); exists {
+		requests, err = model.GetUserAbsenceRequests(c.Request.Context(), userId, DatabasePool)
+	} else {
+		requests, err = model.GetAbsenceRequestsByStatus(c.Request.Context(), c.DefaultQuery("status", model.ABSENCE_SUBMITTED), DatabasePool)
+	}
+
+	if err != nil {
+		absenceError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+func decideAbsenceRequest(c *gin.Context, approve bool) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body DecideAbsenceRequestBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	request, err := model.DecideAbsenceRequest(c.Request.Context(), body.RequestId, approve, claims.Uuid, body.DecisionReason, DatabasePool)
+	if err != nil {
+		absenceError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, request)
+}
+
+/*
+ * Approve a submitted absence request, the lessons that it covers are marked as authorised
+ * absences.
+ * Method: POST
+ * URL: `/absence/approve`
+ * Body Params: request-id, decision-reason (optional)
+ */
+func ApproveAbsenceRequestHandler(c *gin.Context) {
+	decideAbsenceRequest(c, true)
+}
+
+/*
+ * Reject a submitted absence request.
+ * Method: POST
+ * URL: `/absence/reject`
+ * Body Params: request-id, decision-reason (optional)
+ */
+func RejectAbsenceRequestHandler(c *gin.Context) {
+	decideAbsenceRequest(c, false)
+}
@@ -0,0 +1,141 @@
+/*
+ * alerts.go contains handlers for endpoints under `/alerts`.
+ * Alerts are raised when a student starts breaking an at-risk rule, see model/at_risk.go.
+ */
+
+package routes
+
+import (
+	"arcio/attendance-system/middleware"
+	"arcio/attendance-system/model"
+	"arcio/attendance-system/security"
+	"arcio/attendance-system/utils"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+func addAlertRoutes(r *gin.Engine) {
+	alertRoutes := r.Group("/alerts")
+	alertRoutes.Use(middleware.CheckAuth(NonceManager))
+	alertRoutes.GET("/get", middleware.CheckPermissions(security.Module, Store.Permissions, security.PERMS_CAN_READ_ALL), GetAlertsHandler)
+	alertRoutes.GET("/at-risk", middleware.CheckPermissions(security.Module, Store.Permissions, security.PERMS_CAN_READ_ALL), GetAtRiskStudentsHandler)
+	alertRoutes.POST("/acknowledge", middleware.CheckPermissions(security.Module, Store.Permissions, security.PERMS_CAN_UPDATE), AcknowledgeAlertHandler)
+}
+
+type AcknowledgeAlertBody struct {
+	AlertId  string `json:"alert-id"`
+	ModuleId string `json:"module-id"`
+}
+
+/*
+ * Get the alerts for a module, newest first.
+ * Method: GET
+ * URL: `/alerts/get`
+ * Query Params: moduleId, unacknowledged (optional, true to leave out acknowledged alerts)
+ */
+func GetAlertsHandler(c *gin.Context) {
+	moduleId, exists := c.GetQuery("moduleId")
+	if !exists {
+		c.Error(errors.New("missing query parameter moduleId"))
+	}
+
+	onlyUnacknowledged := false
+	if c.Query("unacknowledged") != "" {
+		var err error
+		onlyUnacknowledged, err = strconv.ParseBool(c.Query("unacknowledged"))
+		if err != nil {
+			c.Error(errors.New("unacknowledged must be true or, false"))
+		}
+	}
+
+	if len(c.Errors) != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	alerts, err := model.GetAttendanceAlerts(c.Request.Context(), moduleId, onlyUnacknowledged, DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue getting alerts"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, alerts)
+}
+
+/*
+ * Get the students that are at risk in a module, lowest attendance first.
+ * Method: GET
+ * URL: `/alerts/at-risk`
+ * Query Params: moduleId
+ */
+func GetAtRiskStudentsHandler(c *gin.Context) {
+	moduleId, exists := c.GetQuery("moduleId")
+	if !exists {
+		c.Error(errors.New("missing query parameter moduleId"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	students, err := model.GetAtRiskStudents(c.Request.Context(), moduleId, DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue getting at-risk students"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, students)
+}
+
+/*
+ * Acknowledge an alert.
+ * Method: POST
+ * URL: `/alerts/acknowledge`
+ * Body Params: alert-id, module-id
+ */
+func AcknowledgeAlertHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body AcknowledgeAlertBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	alert, err := model.AcknowledgeAttendanceAlert(c.Request.Context(), body.AlertId, body.ModuleId, claims.Uuid, DatabasePool)
+	if err == model.ErrAlertNotFound {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue acknowledging alert"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, alert)
+}
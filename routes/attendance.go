@@ -10,40 +10,91 @@ import (
 	"arcio/attendance-system/model"
 	"arcio/attendance-system/security"
 	"arcio/attendance-system/utils"
+	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
-	"log"
+	"io"
 	"net/http"
+	"time"
 )
 
 func addAttendanceRoutes(rg *gin.Engine) {
 	attendanceRoutes := rg.Group("/attendance")
 	attendanceRoutes.Use(middleware.CheckAuth(NonceManager))
 
-	attendanceRoutes.POST("/mark", middleware.CheckPermissions(security.Attendance, DatabasePool, security.PERMS_CAN_READ, security.PERMS_CAN_CREATE), PostMarkAttendance)
-	attendanceRoutes.POST("/lecturer/mark", middleware.CheckPermissions(security.Attendance, DatabasePool, security.PERMS_CAN_READ_ALL, security.PERMS_CAN_CREATE), PostLecturerMarkAttendance)
+	attendanceRoutes.POST("/mark", middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ, security.PERMS_CAN_CREATE), PostMarkAttendance)
+	attendanceRoutes.POST("/lecturer/mark", middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ_ALL, security.PERMS_CAN_CREATE), PostLecturerMarkAttendance)
+	attendanceRoutes.GET("/register", middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ_ALL), GetLessonRegisterHandler)
+	attendanceRoutes.GET("/register/stream", middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ_ALL), GetLessonRegisterStreamHandler)
+	attendanceRoutes.POST("/register/mark", middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ_ALL, security.PERMS_CAN_CREATE), PostRegisterMarksHandler)
+	attendanceRoutes.PUT("/amend", middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ_ALL, security.PERMS_CAN_UPDATE), PutAmendAttendance)
+	attendanceRoutes.DELETE("/retract", middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ_ALL, security.PERMS_CAN_DELETE), DeleteRetractAttendance)
+	attendanceRoutes.GET("/corrections", middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ_ALL), GetCorrectionsHandler)
+	attendanceRoutes.POST("/session/open", middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ_ALL, security.PERMS_CAN_CREATE), PostOpenCheckinSession)
+	attendanceRoutes.GET("/session/code", middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ_ALL), GetCheckinCode)
+	attendanceRoutes.POST("/session/close", middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ_ALL, security.PERMS_CAN_UPDATE), PostCloseCheckinSession)
 
 }
 
 type PostMarkAttendanceBody struct {
 	LessonId string `json:"lesson-id"`
+	Code     string `json:"code"`
 }
 
 type LecturerMarkAttendance struct {
-	LessonId string `json:"lesson-id"`
-	UserId   string `json:"user-id"`
+	LessonId string                 `json:"lesson-id"`
+	UserId   string                 `json:"user-id"`
+	Status   model.AttendanceStatus `json:"status,omitempty"`
+	Reason   string                 `json:"reason,omitempty"`
+}
+
+type RegisterMarksBody struct {
+	LessonId       string                 `json:"lesson-id"`
+	ModuleId       string                 `json:"module-id"`
+	Marks          []model.RegisterMark   `json:"marks"`
+	UnmarkedStatus model.AttendanceStatus `json:"unmarked-status,omitempty"`
+}
+
+type AmendAttendanceBody struct {
+	LessonId         string                 `json:"lesson-id"`
+	ModuleId         string                 `json:"module-id"`
+	UserId           string                 `json:"user-id"`
+	Status           model.AttendanceStatus `json:"status"`
+	Reason           string                 `json:"reason,omitempty"`
+	CorrectionReason string                 `json:"correction-reason"`
+}
+
+type RetractAttendanceBody struct {
+	LessonId         string `json:"lesson-id"`
+	ModuleId         string `json:"module-id"`
+	UserId           string `json:"user-id"`
+	CorrectionReason string `json:"correction-reason"`
+}
+
+type OpenCheckinSessionBody struct {
+	LessonId   string `json:"lesson-id"`
+	ModuleId   string `json:"module-id"`
+	CodePeriod int    `json:"code-period,omitempty"` // Seconds
+}
+
+type CloseCheckinSessionBody struct {
+	SessionId string `json:"session-id"`
+	LessonId  string `json:"lesson-id"`
+	ModuleId  string `json:"module-id"`
 }
 
 /*
- * Mark the attendance for a user, for a lesson.
+ * Mark the attendance for a user, for a lesson, using the lesson's current check-in code.
  * Method: POST
  * URL: `/attendance/mark`
+ * Body Params: lesson-id, code
  */
 func PostMarkAttendance(c *gin.Context) {
 	claims := c.MustGet("claims").(security.Claims)
 
 	var body PostMarkAttendanceBody
 	if err := c.ShouldBindJSON(&body); err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
@@ -51,9 +102,9 @@ func PostMarkAttendance(c *gin.Context) {
 		return
 	}
 
-	err := model.RegisterAttendance(claims.Uuid, body.LessonId, DatabasePool)
+	err := model.RegisterAttendanceWithCode(c.Request.Context(), claims.Uuid, body.LessonId, body.Code, []byte(GlobalConfig.CheckinSecret), DatabasePool)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
@@ -69,14 +120,14 @@ func PostMarkAttendance(c *gin.Context) {
  * Mark the attendance for a user, for a lesson, by a lecturer.
  * Method: POST
  * URL: `/attendance/lecturer/mark`
- * Body Params: user-id, lesson-id
+ * Body Params: user-id, lesson-id, status (optional), reason (optional)
  */
 func PostLecturerMarkAttendance(c *gin.Context) {
 	claims := c.MustGet("claims").(security.Claims)
 
 	var body LecturerMarkAttendance
 	if err := c.ShouldBindJSON(&body); err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
@@ -84,9 +135,9 @@ func PostLecturerMarkAttendance(c *gin.Context) {
 		return
 	}
 
-	err := model.RegisterAttendance(body.UserId, body.LessonId, DatabasePool)
+	err := Store.Attendance.RegisterAttendance(c.Request.Context(), body.UserId, body.LessonId, body.Status, body.Reason, claims.Uuid)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
@@ -97,3 +148,429 @@ func PostLecturerMarkAttendance(c *gin.Context) {
 	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
 	c.Status(http.StatusCreated)
 }
+
+/*
+ * Sends the response for a register error, lessons and, marks that cannot be found are 404s.
+ */
+func registerError(c *gin.Context, err error, status int) {
+	if err == model.ErrLessonNotFound || err == model.ErrMarkNotFound {
+		status = http.StatusNotFound
+	} else if err == model.ErrPastMark {
+		status = http.StatusUnauthorized
+	} else if status == http.StatusInternalServerError {
+		middleware.RequestLog(c).Error(err)
+		err = errors.New("issue with the register")
+	}
+
+	c.Error(err)
+	c.JSON(status, gin.H{
+		"errors": c.Errors,
+	})
+}
+
+/*
+ * Get the register for a lesson, every student in the lesson's module group is listed with their
+ * status, register time and, who marked them. Students that have not been marked have an empty
+ * status. Lessons in other modules are 404s.
+ * Method: GET
+ * URL: `/attendance/register`
+ * Query Params: lessonId, moduleId
+ */
+func GetLessonRegisterHandler(c *gin.Context) {
+	lessonId, exists := c.GetQuery("lessonId")
+	if !exists {
+		c.Error(errors.New("missing query parameter lessonId"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	moduleId, exists := c.GetQuery("moduleId")
+	if !exists {
+		c.Error(errors.New("missing query parameter moduleId"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	register, err := model.GetLessonRegister(c.Request.Context(), lessonId, moduleId, DatabasePool)
+	if err != nil {
+		registerError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, register)
+}
+
+// How often a comment is sent on idle register streams so that proxies keep them open
+const REGISTER_STREAM_KEEPALIVE = 15 * time.Second
+
+/*
+ * Stream the register for a lesson as server-sent events. The first event is the whole register,
+ * each mark or, correction is then sent as it happens. Every event has the register's counts.
+ * Lessons in other modules are 404s.
+ * Method: GET
+ * URL: `/attendance/register/stream`
+ * Query Params: lessonId, moduleId
+ */
+func GetLessonRegisterStreamHandler(c *gin.Context) {
+	lessonId, exists := c.GetQuery("lessonId")
+	if !exists {
+		c.Error(errors.New("missing query parameter lessonId"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	moduleId, exists := c.GetQuery("moduleId")
+	if !exists {
+		c.Error(errors.New("missing query parameter moduleId"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	// Subscribe first so that marks made while the snapshot is read are not missed
+	events, unsubscribe := model.RegisterStream.Subscribe(lessonId)
+	defer unsubscribe()
+
+	snapshot, err := model.GetRegisterSnapshot(c.Request.Context(), lessonId, moduleId, DatabasePool)
+	if err != nil {
+		registerError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent(snapshot.Type, snapshot)
+	c.Writer.Flush()
+
+	keepalive := time.NewTicker(REGISTER_STREAM_KEEPALIVE)
+	defer keepalive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+
+			c.SSEvent(event.Type, event)
+		case <-keepalive.C:
+			c.SSEvent("ping", time.Now().Unix())
+		}
+
+		return true
+	})
+}
+
+/*
+ * Set the statuses for many students on a lesson's register at once, existing marks are changed.
+ * If unmarked-status is set then every student that has not been marked is given it, i.e: to mark
+ * everyone else absent. The register can only be marked while the lesson is happening, lessons
+ * in other modules are 404s.
+ * Method: POST
+ * URL: `/attendance/register/mark`
+ * Body Params: lesson-id, module-id, marks (list of user-id, status, reason (optional)),
+ *	unmarked-status (optional)
+ */
+func PostRegisterMarksHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body RegisterMarksBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if !requireModuleId(c, body.ModuleId) {
+		return
+	}
+
+	register, err := model.SetRegisterMarks(c.Request.Context(), body.LessonId, body.ModuleId, body.Marks, body.UnmarkedStatus, claims.Uuid, DatabasePool)
+	if err != nil {
+		registerError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+	c.JSON(http.StatusOK, register)
+}
+
+/*
+ * Whether the user can change the marks for a lesson after it has ended, their permissions in the
+ * lesson's module and, module group are used. Lessons in other modules are not found.
+ */
+func canMarkPast(ctx context.Context, claims security.Claims, lessonId string, moduleId string) (bool, error) {
+	moduleGroupId, err := model.GetLessonModuleGroup(ctx, lessonId, moduleId, DatabasePool)
+	if err != nil {
+		return false, err
+	}
+
+	perms, err := model.GetRepositoryPermissions(ctx, Store.Permissions, claims.Uuid, moduleId, moduleGroupId, security.Attendance)
+	if err != nil {
+		return false, err
+	}
+
+	return security.CheckPerms(perms, security.PERMS_ATTENDANCE_ALLOW_PAST_MARK), nil
+}
+
+/*
+ * Change the status of a student's mark for a lesson, the change is stored as a correction.
+ * Lessons that have ended need PERMS_ATTENDANCE_ALLOW_PAST_MARK, lessons in other modules are 404s.
+ * Method: PUT
+ * URL: `/attendance/amend`
+ * Body Params: lesson-id, module-id, user-id, status, reason (optional), correction-reason
+ */
+func PutAmendAttendance(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body AmendAttendanceBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if !requireModuleId(c, body.ModuleId) {
+		return
+	}
+
+	allowPast, err := canMarkPast(c.Request.Context(), claims, body.LessonId, body.ModuleId)
+	if err != nil {
+		registerError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	correction, err := model.AmendAttendance(c.Request.Context(), body.LessonId, body.UserId, body.Status, body.Reason, body.CorrectionReason,
+		claims.Uuid, allowPast, DatabasePool)
+	if err != nil {
+		registerError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+	c.JSON(http.StatusOK, correction)
+}
+
+/*
+ * Remove a student's mark for a lesson, the removal is stored as a correction. Lessons that have
+ * ended need PERMS_ATTENDANCE_ALLOW_PAST_MARK, lessons in other modules are 404s.
+ * Method: DELETE
+ * URL: `/attendance/retract`
+ * Body Params: lesson-id, module-id, user-id, correction-reason
+ */
+func DeleteRetractAttendance(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body RetractAttendanceBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if !requireModuleId(c, body.ModuleId) {
+		return
+	}
+
+	allowPast, err := canMarkPast(c.Request.Context(), claims, body.LessonId, body.ModuleId)
+	if err != nil {
+		registerError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	correction, err := model.RetractAttendance(c.Request.Context(), body.LessonId, body.UserId, body.CorrectionReason, claims.Uuid, allowPast, DatabasePool)
+	if err != nil {
+		registerError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+	c.JSON(http.StatusOK, correction)
+}
+
+/*
+ * Get the corrections to the marks for a lesson or, a student in a module, oldest first. Lessons
+ * in other modules are 404s.
+ * Method: GET
+ * URL: `/attendance/corrections`
+ * Query Params: moduleId, lessonId or, userId
+ */
+func GetCorrectionsHandler(c *gin.Context) {
+	moduleId, exists := c.GetQuery("moduleId")
+	if !exists {
+		c.Error(errors.New("missing query parameter moduleId"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	var corrections []model.AttendanceCorrection
+	var err error
+	if lessonId, exists := c.GetQuery("lessonId"); exists {
+		corrections, err = model.GetLessonCorrections(c.Request.Context(), lessonId, moduleId, DatabasePool)
+	} else if userId, exists := c.GetQuery("userId"); exists {
+		corrections, err = model.GetUserCorrections(c.Request.Context(), userId, moduleId, DatabasePool)
+	} else {
+		c.Error(errors.New("missing query parameter lessonId or, userId"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if err != nil {
+		registerError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, corrections)
+}
+
+/*
+ * Open a check-in session for a lesson, students must enter the session's rotating code to mark
+ * their attendance.
+ * Method: POST
+ * URL: `/attendance/session/open`
+ * Body Params: lesson-id, module-id, code-period (optional, seconds)
+ * A lesson that already has an open session is a 409.
+ */
+func PostOpenCheckinSession(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body OpenCheckinSessionBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if !requireModuleId(c, body.ModuleId) {
+		return
+	}
+
+	period := GlobalConfig.CheckinPeriod
+	if body.CodePeriod != 0 {
+		period = body.CodePeriod
+	}
+
+	session, err := model.OpenCheckinSession(c.Request.Context(), body.LessonId, body.ModuleId, claims.Uuid, time.Duration(period)*time.Second, DatabasePool)
+	if err == model.ErrCheckinSessionOpen {
+		c.Error(err)
+		c.JSON(http.StatusConflict, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+	c.JSON(http.StatusCreated, session)
+}
+
+/*
+ * Get the current check-in code for a lesson's open session, to be displayed as a QR or, short code.
+ * Lessons in other modules are 404s.
+ * Method: GET
+ * URL: `/attendance/session/code`
+ * Query Params: lessonId, moduleId
+ */
+func GetCheckinCode(c *gin.Context) {
+	lessonId, exists := c.GetQuery("lessonId")
+	if !exists {
+		c.Error(errors.New("missing query parameter lessonId"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	moduleId, exists := c.GetQuery("moduleId")
+	if !exists {
+		c.Error(errors.New("missing query parameter moduleId"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	session, err := model.GetOpenCheckinSession(c.Request.Context(), lessonId, moduleId, DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, model.GetCheckinCode(session, []byte(GlobalConfig.CheckinSecret)))
+}
+
+/*
+ * Close a check-in session before the lesson ends.
+ * Method: POST
+ * URL: `/attendance/session/close`
+ * Body Params: session-id, lesson-id, module-id
+ */
+func PostCloseCheckinSession(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body CloseCheckinSessionBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	err := model.CloseCheckinSession(c.Request.Context(), body.SessionId, body.LessonId, body.ModuleId, DatabasePool)
+	if err == model.ErrCheckinSessionNotFound {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
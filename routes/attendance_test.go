@@ -1,168 +1,337 @@
-package routes
+package routes_test
 
 import (
-	"arcio/attendance-system/config"
+	"arcio/attendance-system/harness"
 	"arcio/attendance-system/model"
-	"arcio/attendance-system/security"
-	"arcio/attendance-system/utils"
-	"bytes"
-	"fmt"
-	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
-	"log"
+	"context"
+	"encoding/json"
 	"net/http"
-	"net/http/httptest"
 	"testing"
-	"time"
 )
 
-var lesson model.ActualLesson
+/*
+ * Opens a check-in session for a lesson as the lecturer then gets its current code.
+ */
+func openCheckinSession(t *testing.T, h *harness.Harness, lessonId string) (model.CheckinSession, model.CheckinCodeRet) {
+	w, err := h.Request(http.MethodPost, "/attendance/session/open", "lecturer",
+		map[string]interface{}{"lesson-id": lessonId, "module-id": h.ModuleId("testing"), "code-period": 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusCreated {
+		t.Fatal("Expected Status Code Created, but got", w.Code, w.Body.String())
+	}
 
-//1. Looks for a user in the database.
-//2 .Gets a group lesson the user is in.
-//3. Creates an actual lesson for right now
-func init() {
-	userConfig, err := config.LoadConfig()
+	var session model.CheckinSession
+	err = json.Unmarshal(w.Body.Bytes(), &session)
 	if err != nil {
-		log.Println("Failed to load config from .env")
-		log.Println(err)
+		t.Fatal(err)
+	}
+
+	w, err = h.Request(http.MethodGet, "/attendance/session/code?lessonId="+lessonId+"&moduleId="+h.ModuleId("testing"), "lecturer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected Status Code OK, but got", w.Code, w.Body.String())
 	}
 
-	pooltmp, err := utils.InitDatabasePool(userConfig)
+	var code model.CheckinCodeRet
+	err = json.Unmarshal(w.Body.Bytes(), &code)
 	if err != nil {
-		log.Println("Failed to init database pool")
-		log.Println(err)
-		return
+		t.Fatal(err)
 	}
-	DatabasePool = pooltmp
 
-	userRes, err := pooltmp.Database.Query("SELECT id, external_id, firstname, " +
-		"surname, email, creation_time, edit_time FROM users LIMIT 1;")
+	return session, code
+}
+
+func TestMarkAttendance(t *testing.T) {
+	h := harness.NewPostgres(t)
+	h.Config.CheckinSecret = "checkin secret"
+
+	_, code := openCheckinSession(t, h, h.LessonId("current"))
+
+	w, err := h.Request(http.MethodPost, "/attendance/mark", "student",
+		map[string]string{"lesson-id": h.LessonId("current"), "code": code.Code})
 	if err != nil {
-		log.Println(err)
-		return
+		t.Fatal(err)
 	}
-	defer userRes.Close()
 
-	if userRes.Next() {
-		err = userRes.Scan(&user.InternalId, &user.ExternalId, &user.Fname, &user.Sname,
-			&user.Email, &user.CreationTime, &user.EditTime)
-		if err != nil {
-			log.Println(err)
-			return
-		}
+	if w.Code != http.StatusOK {
+		t.Error("Expected Status Code OK, but got", w.Code, w.Body.String())
 	}
 }
 
-func CreateLesson() {
-	lessonStmt, err := DatabasePool.Database.Prepare("select group_lessons.id from group_lessons " +
-		"inner join module_groups on group_lessons.module_group_id = module_groups.id " +
-		"inner join modules on modules.id = module_groups.module_id " +
-		"inner join module_users on module_users.module_id = modules.id " +
-		"inner join users on users.id = module_users.user_id " +
-		"where user_id=$1;")
+func TestLecturerMarkAttendance(t *testing.T) {
+	h := harness.NewMemory(t)
+
+	w, err := h.Request(http.MethodPost, "/attendance/lecturer/mark", "lecturer",
+		map[string]string{"user-id": h.UserId("student"), "lesson-id": h.LessonId("current")})
 	if err != nil {
-		log.Println(err)
-		return
+		t.Fatal(err)
 	}
-	defer lessonStmt.Close()
 
-	lessonRes, err := lessonStmt.Query(user.InternalId)
+	if w.Code != http.StatusCreated {
+		t.Fatal("Expected Status Code Created, but got", w.Code, w.Body.String())
+	}
+
+	// Marking the student again changes their mark
+	w, err = h.Request(http.MethodPost, "/attendance/lecturer/mark", "lecturer",
+		map[string]string{"user-id": h.UserId("student"), "lesson-id": h.LessonId("current"), "status": "late"})
 	if err != nil {
-		log.Println(err)
-		return
+		t.Fatal(err)
 	}
-	defer lessonRes.Close()
 
-	var groupLesson model.GroupLesson
-	if lessonRes.Next() {
-		lessonRes.Scan(&groupLesson.Id)
-	} else {
-		log.Println("Error, cannot find a group lesson for user")
+	if w.Code != http.StatusCreated {
+		t.Fatal("Expected Status Code Created, but got", w.Code, w.Body.String())
 	}
 
-	createActualStmt, err := DatabasePool.Database.Prepare("INSERT INTO actual_lessons " +
-		"(id, group_lesson_id, start_time, end_time, description, " +
-		" location, summary) VALUES ($1, $2, $3, $4, $5, $6, $7);")
+	marks, err := h.Store.Attendance.GetLessonMarks(context.Background(), h.LessonId("current"))
 	if err != nil {
-		log.Println(err)
+		t.Fatal(err)
+	}
+
+	if len(marks) != 1 || marks[0].Status != model.ATTENDANCE_LATE {
+		t.Error("Expected one late mark but got", marks)
 	}
-	defer createActualStmt.Close()
+}
 
-	lesson.Id = uuid.New().String()
-	lesson.GroupLessonId = groupLesson.Id
-	lesson.StartTime = time.Now().Add(time.Hour * -5)
-	lesson.EndTime = time.Now().Add(time.Hour * 5)
-	lesson.CreationTime = time.Now().Add(time.Hour * -5)
-	lesson.EditTime = time.Now()
-	lesson.Location = "Your mum's house"
-	lesson.Description = "Me teaching your mum"
-	lesson.Summary = "This is a summary"
+func TestMarkAttendanceBadCode(t *testing.T) {
+	h := harness.NewPostgres(t)
+	h.Config.CheckinSecret = "checkin secret"
 
-	_, err = createActualStmt.Exec(lesson.Id, lesson.GroupLessonId,
-		lesson.StartTime,
-		lesson.EndTime,
-		lesson.Description,
-		lesson.Location,
-		lesson.Summary)
+	openCheckinSession(t, h, h.LessonId("current"))
 
+	w, err := h.Request(http.MethodPost, "/attendance/mark", "student",
+		map[string]string{"lesson-id": h.LessonId("current"), "code": "not a code"})
 	if err != nil {
-		log.Println(err)
-		return
+		t.Fatal(err)
 	}
 
-	log.Println(lesson)
+	if w.Code != http.StatusBadRequest {
+		t.Error("Expected Status Code Bad Request, but got", w.Code, w.Body.String())
+	}
 }
 
-func TestMarkAttendance(t *testing.T) {
-	CreateLesson()
-	jsonData := []byte(`{
-    "lesson-id": "` + lesson.Id + `"
-  }`)
+func TestCheckinSessionLifecycle(t *testing.T) {
+	h := harness.NewPostgres(t)
+	h.Config.CheckinSecret = "checkin secret"
+
+	session, code := openCheckinSession(t, h, h.LessonId("current"))
+	if code.SessionId != session.Id || code.Code == "" {
+		t.Error("Unexpected check-in code", code)
+	}
+
+	// Only one session can be open
+	w, err := h.Request(http.MethodPost, "/attendance/session/open", "lecturer",
+		map[string]interface{}{"lesson-id": h.LessonId("current"), "module-id": h.ModuleId("testing")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusConflict {
+		t.Error("Expected Status Code Conflict, but got", w.Code, w.Body.String())
+	}
+
+	// The session is not in another module
+	err = h.Seed(harness.Fixture{Modules: []harness.FixtureModule{{Key: "other", Name: "Other Module", ExternalId: "CS2002"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = h.Request(http.MethodGet, "/attendance/session/code?lessonId="+h.LessonId("current")+"&moduleId="+h.ModuleId("other"), "lecturer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected Status Code Not Found, but got", w.Code, w.Body.String())
+	}
+
+	w, err = h.Request(http.MethodPost, "/attendance/session/close", "lecturer", map[string]string{"session-id": session.Id,
+		"lesson-id": h.LessonId("current"),
+		"module-id": h.ModuleId("other")})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	req, _ := http.NewRequest(http.MethodPost, "/attendance/mark", bytes.NewBuffer(jsonData))
-	w := httptest.NewRecorder()
-	router := gin.New()
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected Status Code Not Found, but got", w.Code, w.Body.String())
+	}
 
-	router.Use(func() gin.HandlerFunc {
-		return func(c *gin.Context) {
-			c.Set("claims", security.Claims{
-				Uuid: user.InternalId,
-			})
-		}
-	}())
+	// Close
+	w, err = h.Request(http.MethodPost, "/attendance/session/close", "lecturer", map[string]string{"session-id": session.Id,
+		"lesson-id": h.LessonId("current"),
+		"module-id": h.ModuleId("testing")})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	router.POST("/attendance/mark", PostMarkAttendance)
-	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("Expected Status Code OK, but got", w.Code, w.Body.String())
+	}
 
-	if w.Result().StatusCode != http.StatusOK {
-		t.Error("Expected Status Code OK, but got", w.Result().StatusCode)
+	// The code should no longer be available
+	w, err = h.Request(http.MethodGet, "/attendance/session/code?lessonId="+h.LessonId("current")+"&moduleId="+h.ModuleId("testing"), "lecturer", nil)
+	if err != nil {
+		t.Fatal(err)
 	}
+
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected Status Code Not Found, but got", w.Code)
+	}
+
+	// Sessions cannot be opened for lessons in another module
+	w, err = h.Request(http.MethodPost, "/attendance/session/open", "lecturer",
+		map[string]interface{}{"lesson-id": h.LessonId("current"), "module-id": h.ModuleId("other")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusBadRequest {
+		t.Error("Expected Status Code Bad Request, but got", w.Code, w.Body.String())
+	}
+
+	// A new session can be opened once the last one is closed
+	openCheckinSession(t, h, h.LessonId("current"))
 }
 
-func TestLecturerMarkAttendance(t *testing.T) {
-	CreateLesson()
-	testJson := []byte(fmt.Sprintf(`{
-		"user-id":   "%s",
-		"lesson-id": "%s"
-		}`, user.InternalId, lesson.Id))
-
-	req, _ := http.NewRequest(http.MethodPost, "/attendance/lecturer/mark", bytes.NewBuffer(testJson))
-	w := httptest.NewRecorder()
-	router := gin.New()
-
-	router.Use(func() gin.HandlerFunc {
-		return func(c *gin.Context) {
-			c.Set("claims", security.Claims{
-				Uuid: user.InternalId,
-			})
-		}
-	}())
-
-	router.POST("/attendance/lecturer/mark", PostLecturerMarkAttendance)
-	router.ServeHTTP(w, req)
-
-	if w.Result().StatusCode != http.StatusCreated {
-		t.Error("Expected Status Code Created, but got", w.Result().StatusCode)
+func TestLessonRegisterInOtherModule(t *testing.T) {
+	h := harness.NewPostgres(t)
+
+	err := h.Seed(harness.Fixture{Modules: []harness.FixtureModule{{Key: "other", Name: "Other Module", ExternalId: "CS2002"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := h.Request(http.MethodGet, "/attendance/register?lessonId="+h.LessonId("current")+"&moduleId="+h.ModuleId("other"), "lecturer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected Status Code Not Found, but got", w.Code, w.Body.String())
+	}
+
+	w, err = h.Request(http.MethodPost, "/attendance/register/mark", "lecturer", map[string]interface{}{
+		"lesson-id": h.LessonId("current"),
+		"module-id": h.ModuleId("other"),
+		"marks":     []map[string]string{{"user-id": h.UserId("student"), "status": "present"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected Status Code Not Found, but got", w.Code, w.Body.String())
+	}
+
+	w, err = h.Request(http.MethodGet, "/attendance/register?lessonId="+h.LessonId("current")+"&moduleId="+h.ModuleId("testing"), "lecturer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusOK {
+		t.Error("Expected Status Code OK, but got", w.Code, w.Body.String())
+	}
+}
+
+func TestCorrectionsInOtherModule(t *testing.T) {
+	h := harness.NewPostgres(t)
+
+	err := h.Seed(harness.Fixture{Modules: []harness.FixtureModule{{Key: "other", Name: "Other Module", ExternalId: "CS2002"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := h.Request(http.MethodPost, "/attendance/lecturer/mark", "lecturer",
+		map[string]string{"user-id": h.UserId("student"), "lesson-id": h.LessonId("current")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusCreated {
+		t.Fatal("Expected Status Code Created, but got", w.Code, w.Body.String())
+	}
+
+	amend := map[string]string{"lesson-id": h.LessonId("current"),
+		"module-id":         h.ModuleId("other"),
+		"user-id":           h.UserId("student"),
+		"status":            "late",
+		"correction-reason": "Arrived after the register"}
+	w, err = h.Request(http.MethodPut, "/attendance/amend", "lecturer", amend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected Status Code Not Found, but got", w.Code, w.Body.String())
+	}
+
+	amend["module-id"] = h.ModuleId("testing")
+	w, err = h.Request(http.MethodPut, "/attendance/amend", "lecturer", amend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusOK {
+		t.Error("Expected Status Code OK, but got", w.Code, w.Body.String())
+	}
+
+	w, err = h.Request(http.MethodGet, "/attendance/corrections?lessonId="+h.LessonId("current")+"&moduleId="+h.ModuleId("other"), "lecturer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected Status Code Not Found, but got", w.Code, w.Body.String())
+	}
+
+	w, err = h.Request(http.MethodGet, "/attendance/corrections?userId="+h.UserId("student")+"&moduleId="+h.ModuleId("other"), "lecturer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var corrections []model.AttendanceCorrection
+	err = json.Unmarshal(w.Body.Bytes(), &corrections)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(corrections) != 0 {
+		t.Error("Expected no corrections in the other module but got", corrections)
+	}
+
+	w, err = h.Request(http.MethodGet, "/attendance/corrections?lessonId="+h.LessonId("current")+"&moduleId="+h.ModuleId("testing"), "lecturer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(w.Body.Bytes(), &corrections)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(corrections) != 1 {
+		t.Error("Expected the amendment but got", corrections)
+	}
+}
+
+func TestLessonRegisterStreamInOtherModule(t *testing.T) {
+	h := harness.NewPostgres(t)
+
+	err := h.Seed(harness.Fixture{Modules: []harness.FixtureModule{{Key: "other", Name: "Other Module", ExternalId: "CS2002"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := h.Request(http.MethodGet, "/attendance/register/stream?lessonId="+h.LessonId("current")+"&moduleId="+h.ModuleId("other"), "lecturer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected Status Code Not Found, but got", w.Code, w.Body.String())
 	}
 }
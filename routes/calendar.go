@@ -0,0 +1,416 @@
+/*
+ * calendar.go contains handlers for endpoints under `/calendar`.
+ * The academic calendar has academic years, terms, teaching weeks and, closures, see
+ * model/academic_calendar.go. Anyone can read it, changing it needs global permissions.
+ */
+
+package routes
+
+import (
+	"arcio/attendance-system/middleware"
+	"arcio/attendance-system/model"
+	"arcio/attendance-system/security"
+	"arcio/attendance-system/utils"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func addCalendarRoutes(r *gin.Engine) {
+	calendarRoutes := r.Group("/calendar")
+	calendarRoutes.Use(middleware.CheckAuth(NonceManager))
+	calendarRoutes.GET("/get", GetAcademicYearsHandler)
+	calendarRoutes.GET("/weeks", GetTeachingWeeksHandler)
+	calendarRoutes.GET("/closures", GetClosuresHandler)
+	calendarRoutes.POST("/create-year", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_CREATE), CreateAcademicYearHandler)
+	calendarRoutes.DELETE("/delete-year", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_DELETE), DeleteAcademicYearHandler)
+	calendarRoutes.POST("/create-term", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_CREATE), CreateTermHandler)
+	calendarRoutes.DELETE("/delete-term", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_DELETE), DeleteTermHandler)
+	calendarRoutes.POST("/create-closure", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_CREATE), CreateClosureHandler)
+	calendarRoutes.DELETE("/delete-closure", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_DELETE), DeleteClosureHandler)
+}
+
+type AcademicYearBody struct {
+	Name      string `json:"name"`
+	StartDate string `json:"start-date"`
+	EndDate   string `json:"end-date"`
+}
+
+type DeleteAcademicYearBody struct {
+	AcademicYearId string `json:"academic-year-id"`
+}
+
+type TermBody struct {
+	AcademicYearId string `json:"academic-year-id"`
+	Name           string `json:"name"`
+	StartDate      string `json:"start-date"`
+	EndDate        string `json:"end-date"`
+	FirstWeek      int    `json:"first-week,omitempty"`
+}
+
+type DeleteTermBody struct {
+	TermId string `json:"term-id"`
+}
+
+type ClosureBody struct {
+	StartDate string `json:"start-date"`
+	EndDate   string `json:"end-date"`
+	Reason    string `json:"reason"`
+}
+
+type DeleteClosureBody struct {
+	ClosureId string `json:"closure-id"`
+}
+
+/*
+ * Sends the response for an academic calendar error, things that cannot be found are 404s.
+ */
+func calendarError(c *gin.Context, err error, status int) {
+	if err == model.ErrAcademicYearNotFound || err == model.ErrTermNotFound ||
+		err == model.ErrTeachingWeekNotFound || err == model.ErrClosureNotFound {
+		status = http.StatusNotFound
+	} else if status == http.StatusInternalServerError {
+		middleware.RequestLog(c).Error(err)
+		err = errors.New("issue with the academic calendar")
+	}
+
+	c.Error(err)
+	c.JSON(status, gin.H{
+		"errors": c.Errors,
+	})
+}
+
+/*
+ * Reads a term or, teaching week from the query parameters and, gets its dates. ok is false
+ * if neither are set, see model.GetCalendarRange.
+ * Query Params: yearId (optional, the current year by default), term (id or, name), week
+ */
+func getCalendarRange(c *gin.Context) (time.Time, time.Time, bool, error) {
+	term := c.Query("term")
+	week := 0
+	if c.Query("week") != "" {
+		var err error
+		week, err = strconv.Atoi(c.Query("week"))
+		if err != nil {
+			return time.Time{}, time.Time{}, false, errors.New("week must be a number")
+		}
+	}
+
+	if term == "" && week == 0 {
+		return time.Time{}, time.Time{}, false, nil
+	}
+
+	if term != "" && week != 0 {
+		return time.Time{}, time.Time{}, false, errors.New("only one of term and, week can be set")
+	}
+
+	from, to, err := model.GetCalendarRange(c.Request.Context(), c.Query("yearId"), term, week, time.Now(), DatabasePool)
+	return from, to, err == nil, err
+}
+
+/*
+ * Get the academic years and, their terms, newest first.
+ * Method: GET
+ * URL: `/calendar/get`
+ */
+func GetAcademicYearsHandler(c *gin.Context) {
+	years, err := model.GetAcademicYears(c.Request.Context(), DatabasePool)
+	if err != nil {
+		calendarError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, years)
+}
+
+/*
+ * Get the teaching weeks of an academic year.
+ * Method: GET
+ * URL: `/calendar/weeks`
+ * Query Params: yearId (optional, the current year by default)
+ */
+func GetTeachingWeeksHandler(c *gin.Context) {
+	var year model.AcademicYear
+	var err error
+	if c.Query("yearId") == "" {
+		year, err = model.GetCurrentAcademicYear(c.Request.Context(), time.Now(), DatabasePool)
+	} else {
+		year, err = model.GetAcademicYear(c.Request.Context(), c.Query("yearId"), DatabasePool)
+	}
+
+	if err != nil {
+		calendarError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, year.TeachingWeeks())
+}
+
+/*
+ * Get the closures.
+ * Method: GET
+ * URL: `/calendar/closures`
+ * Query Params: from (optional), to (optional)
+ */
+func GetClosuresHandler(c *gin.Context) {
+	from := parseOptionalTime(c, DATE_FORMAT, c.Query("from"), "from")
+	to := parseOptionalTime(c, DATE_FORMAT, c.Query("to"), "to")
+	if len(c.Errors) != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	closures, err := model.GetClosures(c.Request.Context(), from, to, DatabasePool)
+	if err != nil {
+		calendarError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, closures)
+}
+
+/*
+ * Create an academic year.
+ * Method: POST
+ * URL: `/calendar/create-year`
+ * Body Params: name, start-date, end-date
+ */
+func CreateAcademicYearHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body AcademicYearBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	year := model.AcademicYear{Name: body.Name,
+		StartDate: parseOptionalTime(c, DATE_FORMAT, body.StartDate, "start-date"),
+		EndDate:   parseOptionalTime(c, DATE_FORMAT, body.EndDate, "end-date")}
+	if year.StartDate.IsZero() || year.EndDate.IsZero() {
+		c.Error(errors.New("start-date and, end-date must be set"))
+	}
+
+	if len(c.Errors) != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	err = model.CreateAcademicYear(c.Request.Context(), &year, DatabasePool)
+	if err != nil {
+		calendarError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusCreated, year)
+}
+
+/*
+ * Delete an academic year and, its terms.
+ * Method: DELETE
+ * URL: `/calendar/delete-year`
+ * Body Params: academic-year-id
+ */
+func DeleteAcademicYearHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body DeleteAcademicYearBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	err = model.DeleteAcademicYear(c.Request.Context(), body.AcademicYearId, DatabasePool)
+	if err != nil {
+		calendarError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+/*
+ * Add a term to an academic year.
+ * Method: POST
+ * URL: `/calendar/create-term`
+ * Body Params: academic-year-id, name, start-date, end-date,
+ *	first-week (optional, carries on from the earlier terms by default)
+ */
+func CreateTermHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body TermBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if body.FirstWeek < 0 {
+		c.Error(errors.New("first-week must be positive"))
+	}
+
+	term := model.Term{AcademicYearId: body.AcademicYearId,
+		Name:      body.Name,
+		StartDate: parseOptionalTime(c, DATE_FORMAT, body.StartDate, "start-date"),
+		EndDate:   parseOptionalTime(c, DATE_FORMAT, body.EndDate, "end-date"),
+		FirstWeek: body.FirstWeek}
+	if term.StartDate.IsZero() || term.EndDate.IsZero() {
+		c.Error(errors.New("start-date and, end-date must be set"))
+	}
+
+	if len(c.Errors) != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	err = model.CreateTerm(c.Request.Context(), &term, DatabasePool)
+	if err != nil {
+		calendarError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusCreated, term)
+}
+
+/*
+ * Delete a term.
+ * Method: DELETE
+ * URL: `/calendar/delete-term`
+ * Body Params: term-id
+ */
+func DeleteTermHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body DeleteTermBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	err = model.DeleteTerm(c.Request.Context(), body.TermId, DatabasePool)
+	if err != nil {
+		calendarError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+/*
+ * Close the institution for a range of dates, repeating lessons do not happen on them.
+ * Lessons that have already been spawned are not cancelled.
+ * Method: POST
+ * URL: `/calendar/create-closure`
+ * Body Params: start-date, end-date (inclusive), reason
+ */
+func CreateClosureHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body ClosureBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	closure := model.Closure{Reason: body.Reason,
+		StartDate: parseOptionalTime(c, DATE_FORMAT, body.StartDate, "start-date"),
+		EndDate:   parseOptionalTime(c, DATE_FORMAT, body.EndDate, "end-date")}
+	if closure.StartDate.IsZero() || closure.EndDate.IsZero() {
+		c.Error(errors.New("start-date and, end-date must be set"))
+	}
+
+	if len(c.Errors) != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	err = model.CreateClosure(c.Request.Context(), &closure, DatabasePool)
+	if err != nil {
+		calendarError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusCreated, closure)
+}
+
+/*
+ * Delete a closure.
+ * Method: DELETE
+ * URL: `/calendar/delete-closure`
+ * Body Params: closure-id
+ */
+func DeleteClosureHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body DeleteClosureBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	err = model.DeleteClosure(c.Request.Context(), body.ClosureId, DatabasePool)
+	if err != nil {
+		calendarError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
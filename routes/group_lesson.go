@@ -0,0 +1,226 @@
+/*
+ * group_lesson.go contains handlers for endpoints under `/module/group/lesson`.
+ * A group lesson is the template that a module group's lessons are created from.
+ */
+
+package routes
+
+import (
+	"arcio/attendance-system/middleware"
+	"arcio/attendance-system/model"
+	"arcio/attendance-system/security"
+	"arcio/attendance-system/utils"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func addGroupLessonRoutes(r *gin.Engine) {
+	groupLessonRoutes := r.Group("/module/group/lesson")
+	groupLessonRoutes.Use(middleware.CheckAuth(NonceManager))
+	groupLessonRoutes.POST("/add", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_CREATE), CreateGroupLessonHandler)
+	groupLessonRoutes.GET("/get", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_READ), GetGroupLessonsHandler)
+	groupLessonRoutes.PUT("/update", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_UPDATE), UpdateGroupLessonHandler)
+	groupLessonRoutes.DELETE("/delete", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_DELETE), DeleteGroupLessonHandler)
+}
+
+type UpdateGroupLessonBody struct {
+	GroupLessonId      string `json:"group-lesson-id"`
+	ModuleId           string `json:"module-id"`
+	ModuleGroupId      string `json:"module-group-id"`
+	Name               string `json:"name"`
+	AttendanceRequired bool   `json:"attendance-required"`
+	Summary            string `json:"summary"`
+	Description        string `json:"description"`
+	Location           string `json:"location"`
+	RoomId             string `json:"room-id"`
+}
+
+type DeleteGroupLessonBody struct {
+	GroupLessonId string `json:"group-lesson-id"`
+	ModuleId      string `json:"module-id"`
+	ModuleGroupId string `json:"module-group-id"`
+	Cascade       bool   `json:"cascade,omitempty"`
+}
+
+/*
+ * Create a new group lesson.
+ * Method: POST
+ * URL: `/module/group/lesson/add`
+ * Body Params: module-group-id, name, attendance-required, summary, description, location,
+ *	room-id (optional)
+ */
+func CreateGroupLessonHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var groupLesson model.GroupLesson
+	err := c.ShouldBindJSON(&groupLesson)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if groupLesson.Name == "" {
+		c.Error(errors.New("The group lesson name cannot be empty"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	err = Store.Lessons.CreateGroupLesson(c.Request.Context(), &groupLesson)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusCreated, groupLesson)
+}
+
+/*
+ * Get the group lessons for a module group.
+ * Method: GET
+ * URL: `/module/group/lesson/get`
+ * Query Params: moduleGroupId
+ */
+func GetGroupLessonsHandler(c *gin.Context) {
+	moduleGroupId, exists := c.GetQuery("moduleGroupId")
+	if !exists {
+		c.Error(errors.New("missing query parameter moduleGroupId"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	groupLessons, err := model.GetGroupLessons(c.Request.Context(), moduleGroupId, DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue getting group lessons"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, groupLessons)
+}
+
+/*
+ * Update a group lesson, lessons that have not started yet are updated to match.
+ * Method: PUT
+ * URL: `/module/group/lesson/update`
+ * Body Params: group-lesson-id, module-id, module-group-id, name, attendance-required, summary, description, location,
+ *	room-id (optional, the room is removed if it is not set)
+ */
+func UpdateGroupLessonHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body UpdateGroupLessonBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if !requireModuleId(c, body.ModuleId) {
+		return
+	}
+
+	groupLesson := model.GroupLesson{Id: body.GroupLessonId,
+		ModuleGroupId:      body.ModuleGroupId,
+		Name:               body.Name,
+		AttendanceRequired: body.AttendanceRequired,
+		Summary:            body.Summary,
+		Description:        body.Description,
+		Location:           body.Location,
+		RoomId:             body.RoomId}
+
+	err = model.UpdateGroupLesson(c.Request.Context(), body.ModuleId, &groupLesson, DatabasePool)
+	if err == model.ErrGroupLessonNotFound {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, groupLesson)
+}
+
+/*
+ * Delete a group lesson. Lessons and, their attendance are only deleted if cascade is set,
+ * otherwise a group lesson with lessons is not deleted.
+ * Method: DELETE
+ * URL: `/module/group/lesson/delete`
+ * Body Params: group-lesson-id, module-id, module-group-id, cascade (optional)
+ */
+func DeleteGroupLessonHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body DeleteGroupLessonBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if !requireModuleId(c, body.ModuleId) {
+		return
+	}
+
+	err = model.DeleteGroupLesson(c.Request.Context(), body.GroupLessonId, body.ModuleId, body.ModuleGroupId, body.Cascade, DatabasePool)
+	if err == model.ErrGroupLessonNotFound {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	} else if err == model.ErrHasLessons {
+		c.Error(err)
+		c.JSON(http.StatusConflict, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue deleting group lesson"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
@@ -0,0 +1,86 @@
+package routes_test
+
+import (
+	"arcio/attendance-system/harness"
+	"arcio/attendance-system/model"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestGroupLessonCrud(t *testing.T) {
+	h := harness.NewPostgres(t)
+	moduleId, groupId := h.ModuleId("testing"), h.GroupId("lab-a")
+
+	// Create
+	w, err := h.Request(http.MethodPost, "/module/group/lesson/add", "admin", map[string]interface{}{
+		"module-id":           moduleId,
+		"module-group-id":     groupId,
+		"name":                "test lesson",
+		"attendance-required": true,
+		"summary":             "test summary",
+		"description":         "test description",
+		"location":            "test location"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusCreated {
+		t.Fatal("Expected status code", http.StatusCreated, "but got", w.Code, w.Body.String())
+	}
+
+	var groupLesson model.GroupLesson
+	err = json.Unmarshal(w.Body.Bytes(), &groupLesson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Get
+	w, err = h.Request(http.MethodGet, "/module/group/lesson/get?moduleId="+moduleId+"&moduleGroupId="+groupId, "admin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var groupLessons []model.GroupLesson
+	err = json.Unmarshal(w.Body.Bytes(), &groupLessons)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, lesson := range groupLessons {
+		found = found || lesson.Id == groupLesson.Id
+	}
+
+	if !found {
+		t.Error("Expected the created group lesson but got", groupLessons)
+	}
+
+	// Update
+	w, err = h.Request(http.MethodPut, "/module/group/lesson/update", "admin", map[string]string{
+		"group-lesson-id": groupLesson.Id,
+		"module-id":       moduleId,
+		"module-group-id": groupId,
+		"name":            "test lesson renamed",
+		"location":        "another location"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusOK {
+		t.Error("Expected status code", http.StatusOK, "but got", w.Code, w.Body.String())
+	}
+
+	// Delete
+	w, err = h.Request(http.MethodDelete, "/module/group/lesson/delete", "admin", map[string]string{
+		"group-lesson-id": groupLesson.Id,
+		"module-id":       moduleId,
+		"module-group-id": groupId})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusOK {
+		t.Error("Expected status code", http.StatusOK, "but got", w.Code, w.Body.String())
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"log"
 	"net/http"
 	"time"
 )
@@ -22,15 +21,128 @@ const (
 func addLessonRoutes(r *gin.Engine) {
 	lessonRoutes := r.Group("/lesson")
 	lessonRoutes.Use(middleware.CheckAuth(NonceManager))
-	lessonRoutes.POST("/create-one-off", middleware.CheckPermissions(security.ModuleGroup, DatabasePool, security.PERMS_CAN_CREATE), CreateIndividualLessonHandler)
-	lessonRoutes.POST("/create-repeating", middleware.CheckPermissions(security.ModuleGroup, DatabasePool, security.PERMS_CAN_CREATE), CreateRepeatingLessonHandler)
+	lessonRoutes.POST("/create-one-off", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_CREATE), CreateIndividualLessonHandler)
+	lessonRoutes.POST("/create-repeating", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_CREATE), CreateRepeatingLessonHandler)
+	lessonRoutes.PUT("/update", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_UPDATE), UpdateLessonHandler)
+	lessonRoutes.POST("/cancel", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_UPDATE), CancelLessonHandler)
+	lessonRoutes.POST("/repeating/cancel-occurrence", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_UPDATE), CancelOccurrenceHandler)
+	lessonRoutes.POST("/repeating/reschedule-occurrence", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_UPDATE), RescheduleOccurrenceHandler)
+	lessonRoutes.PUT("/repeating/update-from", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_UPDATE), UpdateRepeatingLessonFromHandler)
+}
+
+type UpdateLessonBody struct {
+	LessonId      string `json:"lesson-id"`
+	ModuleId      string `json:"module-id"`
+	ModuleGroupId string `json:"module-group-id"`
+	StartTime     string `json:"start-time,omitempty"`
+	EndTime       string `json:"end-time,omitempty"`
+	Summary       string `json:"summary,omitempty"`
+	Description   string `json:"description,omitempty"`
+	Location      string `json:"location,omitempty"`
+	RoomId        string `json:"room-id,omitempty"`
+}
+
+type CancelLessonBody struct {
+	LessonId      string `json:"lesson-id"`
+	ModuleId      string `json:"module-id"`
+	ModuleGroupId string `json:"module-group-id"`
+}
+
+type OccurrenceBody struct {
+	RepeatingLessonId string `json:"repeating-lesson-id"`
+	ModuleId          string `json:"module-id"`
+	ModuleGroupId     string `json:"module-group-id"`
+	OccurrenceStart   string `json:"occurrence-start"`
+	StartTime         string `json:"start-time,omitempty"`
+	EndTime           string `json:"end-time,omitempty"`
+}
+
+type UpdateRepeatingLessonFromBody struct {
+	RepeatingLessonId string `json:"repeating-lesson-id"`
+	ModuleId          string `json:"module-id"`
+	ModuleGroupId     string `json:"module-group-id"`
+	From              string `json:"from"`
+	StopRepeating     string `json:"stop-repeating,omitempty"`
+	StartTime         string `json:"start-time,omitempty"`
+	EndTime           string `json:"end-time,omitempty"`
+	RepeatEvery       int    `json:"repeat-every,omitempty"`
+	RRule             string `json:"rrule,omitempty"`
+}
+
+// Parses an optional list of times from a JSON body
+func parseTimeList(c *gin.Context, body map[string]interface{}, name string, layout string) []time.Time {
+	ret := make([]time.Time, 0)
+	if _, present := body[name]; !present {
+		return ret
+	}
+
+	values, ok := body[name].([]interface{})
+	if !ok {
+		c.Error(errors.New(name + " must be a list"))
+		return ret
+	}
+
+	for _, value := range values {
+		str, _ := value.(string)
+		t, err := time.Parse(layout, str)
+		if err != nil {
+			c.Error(errors.New(name + " time format error"))
+			return ret
+		}
+
+		ret = append(ret, t)
+	}
+
+	return ret
+}
+
+/*
+ * Sends the response if a lesson cannot be booked, clashes with other bookings are 409s with
+ * the conflicts, see model/clashes.go. false is returned for other errors.
+ */
+func lessonBookingError(c *gin.Context, err error) bool {
+	var clash *model.ClashError
+	if errors.As(err, &clash) {
+		c.Error(err)
+		c.JSON(http.StatusConflict, gin.H{
+			"errors":    c.Errors,
+			"conflicts": clash.Conflicts,
+		})
+		return true
+	}
+
+	if err == model.ErrRoomNotFound {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return true
+	}
+
+	return false
+}
+
+// Parses an optional time, a zero time is returned if it is not set
+func parseOptionalTime(c *gin.Context, layout string, value string, name string) time.Time {
+	var ret time.Time
+	if value == "" {
+		return ret
+	}
+
+	ret, err := time.Parse(layout, value)
+	if err != nil {
+		c.Error(errors.New(name + " time format error"))
+	}
+
+	return ret
 }
 
 /*
  * Create single lesson.
  * Method: POST
  * URL: `/lesson/create-one-off`
- * Body Params: group-id, start-time, end-time
+ * Body Params: group-id, start-time, end-time, room-id (optional, the group lesson's room by default)
+ * The lesson is not created if it clashes with other bookings, see lessonBookingError.
  */
 func CreateIndividualLessonHandler(c *gin.Context) {
 	claims := c.MustGet("claims").(security.Claims)
@@ -39,7 +151,7 @@ func CreateIndividualLessonHandler(c *gin.Context) {
 	var body map[string]string
 	err := c.ShouldBindJSON(&body)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
@@ -86,11 +198,14 @@ func CreateIndividualLessonHandler(c *gin.Context) {
 		StartTime:     sTime,
 		EndTime:       eTime,
 		GroupLessonId: body["group-lesson-id"],
+		RoomId:        body["room-id"],
 	}
 
-	err = model.CreateActualLesson(lesson, DatabasePool)
-	if err != nil {
-		log.Println(err)
+	err = Store.Lessons.CreateLesson(c.Request.Context(), &lesson)
+	if lessonBookingError(c, err) {
+		return
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
 		c.Error(errors.New("issue creating lesson"))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": c.Errors,
@@ -110,19 +225,24 @@ func CreateIndividualLessonHandler(c *gin.Context) {
  * Method: POST
  * URL: `/lesson/create-repeating`
  * Body Params: group-lesson-id, start-repeating, stop-repeating,
- *	start-time, end-time, repeat-every
+ *	start-time, end-time, repeat-every (optional if rrule is set, in seconds), rrule (optional),
+ *	rdates (optional, list of YYYY-MM-DD HH:MM), exdates (optional, list of YYYY-MM-DD HH:MM),
+ *	timezone (optional, defaults to the TIMEZONE config)
+ * Lessons repeat at most daily so repeat-every is a whole number of days, series cannot have
+ * model.RECURRENCE_LIMIT or, more occurrences. The lessons are booked into the group lesson's room,
+ * the series is not created if any of its occurrences clash with other bookings, see lessonBookingError.
  */
 func CreateRepeatingLessonHandler(c *gin.Context) {
 	claims := c.MustGet("claims").(security.Claims)
 	requiredParams := []string{"group-lesson-id", "start-repeating", "stop-repeating",
-		"start-time", "end-time", "repeat-every"}
+		"start-time", "end-time"}
 
 	// Interface used instead of corresponding struct to
 	// give more control over how values are provided in json
 	var body map[string]interface{}
 	err := c.ShouldBindJSON(&body)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
@@ -130,6 +250,11 @@ func CreateRepeatingLessonHandler(c *gin.Context) {
 		return
 	}
 
+	// A series repeats every period unless it has an RRULE
+	if _, present := body["rrule"]; !present {
+		requiredParams = append(requiredParams, "repeat-every")
+	}
+
 	// Check all required parameters are passed in body
 	for _, value := range requiredParams {
 		if _, present := body[value]; !present {
@@ -166,12 +291,35 @@ func CreateRepeatingLessonHandler(c *gin.Context) {
 	}
 
 	// Parse from seconds
-	repeatInterval, err := time.ParseDuration(fmt.Sprintf("%ds",
-		int(body["repeat-every"].(float64))))
-	if err != nil {
-		c.Error(errors.New("repeat-every format error"))
+	var repeatInterval time.Duration
+	if _, present := body["repeat-every"]; present {
+		seconds, ok := body["repeat-every"].(float64)
+		repeatInterval, err = time.ParseDuration(fmt.Sprintf("%ds", int(seconds)))
+		if !ok || err != nil || repeatInterval <= 0 {
+			c.Error(errors.New("repeat-every format error"))
+		} else if repeatInterval%model.DAY != 0 {
+			c.Error(errors.New("repeat-every must be a whole number of days"))
+		}
+	}
+
+	rrule, ok := body["rrule"].(string)
+	if _, present := body["rrule"]; present && !ok {
+		c.Error(errors.New("rrule format error"))
+	} else if _, err := model.ParseRecurrenceRule(rrule); present && err != nil {
+		c.Error(err)
+	}
+
+	timezone := model.DefaultTimezone
+	if _, present := body["timezone"]; present {
+		timezone, ok = body["timezone"].(string)
+		if _, err := time.LoadLocation(timezone); !ok || timezone == "" || err != nil {
+			c.Error(errors.New("timezone must be an IANA time zone, for example Europe/London"))
+		}
 	}
 
+	rdates := parseTimeList(c, body, "rdates", DATE_FORMAT+" "+TIME_FORMAT)
+	exdates := parseTimeList(c, body, "exdates", DATE_FORMAT+" "+TIME_FORMAT)
+
 	if sRepeating.After(eRepeating) {
 		c.Error(errors.New("start-repeating must be earlier than stop-repeating"))
 	}
@@ -197,9 +345,21 @@ func CreateRepeatingLessonHandler(c *gin.Context) {
 		CreationTime:   time.Now(),
 		EditTime:       time.Now(),
 		RepeatEvery:    repeatInterval,
+		RRule:          rrule,
+		RDates:         rdates,
+		Exceptions:     exdates,
+		Timezone:       timezone,
 	}
 
-	if err = model.CreateRepeatingLesson(lesson, DatabasePool); err != nil {
+	if err = model.CreateRepeatingLesson(c.Request.Context(), lesson, DatabasePool); lessonBookingError(c, err) {
+		return
+	} else if err == model.ErrTooManyOccurrences {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	} else if err != nil {
 		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": c.Errors,
@@ -213,3 +373,290 @@ func CreateRepeatingLessonHandler(c *gin.Context) {
 		"success": true,
 	})
 }
+
+/*
+ * Edit or, move a single lesson. Fields that are not set are not changed.
+ * Method: PUT
+ * URL: `/lesson/update`
+ * Body Params: lesson-id, module-id, module-group-id, start-time (optional), end-time (optional),
+ *	summary (optional), description (optional), location (optional), room-id (optional)
+ * Moving the lesson is refused if it clashes with other bookings, see lessonBookingError.
+ */
+func UpdateLessonHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body UpdateLessonBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if !requireModuleId(c, body.ModuleId) {
+		return
+	}
+
+	lesson := model.ActualLesson{Id: body.LessonId,
+		StartTime:   parseOptionalTime(c, time.RFC3339, body.StartTime, "start-time"),
+		EndTime:     parseOptionalTime(c, time.RFC3339, body.EndTime, "end-time"),
+		Summary:     body.Summary,
+		Description: body.Description,
+		Location:    body.Location,
+		RoomId:      body.RoomId}
+
+	if len(c.Errors) != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	lesson, err = model.UpdateActualLesson(c.Request.Context(), lesson, body.ModuleId, body.ModuleGroupId, DatabasePool)
+	if lessonBookingError(c, err) {
+		return
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, lesson)
+}
+
+/*
+ * Cancel a single lesson, it stays in timetables as cancelled.
+ * Method: POST
+ * URL: `/lesson/cancel`
+ * Body Params: lesson-id, module-id, module-group-id
+ */
+func CancelLessonHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body CancelLessonBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if !requireModuleId(c, body.ModuleId) {
+		return
+	}
+
+	err = model.CancelActualLesson(c.Request.Context(), body.LessonId, body.ModuleId, body.ModuleGroupId, DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+/*
+ * Cancel a single occurrence of a repeating lesson, i.e: a bank holiday.
+ * Method: POST
+ * URL: `/lesson/repeating/cancel-occurrence`
+ * Body Params: repeating-lesson-id, module-id, module-group-id, occurrence-start
+ */
+func CancelOccurrenceHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body OccurrenceBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if !requireModuleId(c, body.ModuleId) {
+		return
+	}
+
+	occurrenceStart, err := time.Parse(time.RFC3339, body.OccurrenceStart)
+	if err != nil {
+		c.Error(errors.New("occurrence-start date format error"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	err = model.CancelRepeatingLessonOccurrence(c.Request.Context(), body.RepeatingLessonId, body.ModuleId, body.ModuleGroupId, occurrenceStart, DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+/*
+ * Move a single occurrence of a repeating lesson to a new time.
+ * Method: POST
+ * URL: `/lesson/repeating/reschedule-occurrence`
+ * Body Params: repeating-lesson-id, module-id, module-group-id, occurrence-start, start-time, end-time
+ * The occurrence is not moved if it clashes with other bookings, see lessonBookingError.
+ */
+func RescheduleOccurrenceHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body OccurrenceBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if !requireModuleId(c, body.ModuleId) {
+		return
+	}
+
+	occurrenceStart, err := time.Parse(time.RFC3339, body.OccurrenceStart)
+	if err != nil {
+		c.Error(errors.New("occurrence-start date format error"))
+	}
+
+	sTime, err := time.Parse(time.RFC3339, body.StartTime)
+	if err != nil {
+		c.Error(errors.New("start-time date format error"))
+	}
+
+	eTime, err := time.Parse(time.RFC3339, body.EndTime)
+	if err != nil {
+		c.Error(errors.New("end-time date format error"))
+	}
+
+	if len(c.Errors) != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	lesson, err := model.RescheduleRepeatingLessonOccurrence(c.Request.Context(), body.RepeatingLessonId, body.ModuleId, body.ModuleGroupId, occurrenceStart, sTime, eTime, DatabasePool)
+	if lessonBookingError(c, err) {
+		return
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, lesson)
+}
+
+/*
+ * Edit a repeating lesson from a date onwards, earlier occurrences are not changed.
+ * Fields that are not set are not changed.
+ * Method: PUT
+ * URL: `/lesson/repeating/update-from`
+ * Body Params: repeating-lesson-id, module-id, module-group-id, from, stop-repeating (optional),
+ *	start-time (optional), end-time (optional), repeat-every (optional), rrule (optional)
+ * The series is not changed if the new occurrences clash with other bookings, see lessonBookingError.
+ */
+func UpdateRepeatingLessonFromHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body UpdateRepeatingLessonFromBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if !requireModuleId(c, body.ModuleId) {
+		return
+	}
+
+	from, err := time.Parse(DATE_FORMAT, body.From)
+	if err != nil {
+		c.Error(errors.New("from time format error"))
+	}
+
+	if body.RepeatEvery < 0 {
+		c.Error(errors.New("repeat-every format error"))
+	} else if time.Duration(body.RepeatEvery)*time.Second%model.DAY != 0 {
+		c.Error(errors.New("repeat-every must be a whole number of days"))
+	}
+
+	if body.RepeatEvery != 0 && body.RRule != "" {
+		c.Error(errors.New("only one of repeat-every and, rrule can be set"))
+	}
+
+	update := model.RepeatingLesson{
+		StopRepeating: parseOptionalTime(c, DATE_FORMAT, body.StopRepeating, "stop-repeating"),
+		StartTime:     parseOptionalTime(c, TIME_FORMAT, body.StartTime, "start-time"),
+		EndTime:       parseOptionalTime(c, TIME_FORMAT, body.EndTime, "end-time"),
+		RepeatEvery:   time.Duration(body.RepeatEvery) * time.Second,
+		RRule:         body.RRule,
+	}
+
+	if len(c.Errors) != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	lesson, err := model.UpdateRepeatingLessonFrom(c.Request.Context(), body.RepeatingLessonId, body.ModuleId, body.ModuleGroupId, from, update, DatabasePool)
+	if lessonBookingError(c, err) {
+		return
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, lesson)
+}
@@ -6,11 +6,11 @@
 package routes
 
 import (
+	"arcio/attendance-system/middleware"
 	"arcio/attendance-system/model"
 	"arcio/attendance-system/security"
 	"errors"
 	"github.com/gin-gonic/gin"
-	"log"
 	"net/http"
 )
 
@@ -27,15 +27,15 @@ type LoginScreen struct {
 func LoginScreenHandler(c *gin.Context) {
 	claims := c.MustGet("claims").(security.Claims)
 
-	courses, err := model.GetUsersModules(claims.Uuid, DatabasePool)
+	courses, err := model.GetUsersModules(c.Request.Context(), claims.Uuid, DatabasePool)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(errors.New("issue getting courses"))
 	}
 
-	lessons, err := model.GetLessons(claims.Uuid, DatabasePool)
+	lessons, err := model.GetLessons(c.Request.Context(), claims.Uuid, DatabasePool)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(errors.New("issues getting lessons"))
 	}
 
@@ -12,19 +12,38 @@ import (
 	"arcio/attendance-system/utils"
 	"errors"
 	"github.com/gin-gonic/gin"
-	"log"
 	"net/http"
+	"strings"
 )
 
 func addModuleRoutes(r *gin.Engine) {
 	moduleRoutes := r.Group("/module")
 	moduleRoutes.Use(middleware.CheckAuth(NonceManager))
-	moduleRoutes.POST("/add", middleware.CheckPermissions(security.Global, DatabasePool, security.PERMS_CAN_CREATE), CreateModuleHandler)
-	moduleRoutes.GET("/get", middleware.CheckPermissions(security.Global, DatabasePool, security.PERMS_CAN_READ), GetModulesHandler)
-	moduleRoutes.GET("/get-users", middleware.CheckPermissions(security.Module, DatabasePool, security.PERMS_CAN_READ_ALL), GetModuleUsersHandler)
-	moduleRoutes.POST("/add-user", middleware.CheckPermissions(security.Module, DatabasePool, security.PERMS_CAN_UPDATE), AddUserToModuleHandler)
-	moduleRoutes.POST("/add-users", middleware.CheckPermissions(security.Module, DatabasePool, security.PERMS_CAN_UPDATE), AddUsersToModuleHandler)
-	moduleRoutes.DELETE("/rm-user", middleware.CheckPermissions(security.Module, DatabasePool, security.PERMS_CAN_UPDATE), RemoveUserFromModuleHandler)
+	moduleRoutes.POST("/add", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_CREATE), CreateModuleHandler)
+	moduleRoutes.GET("/get", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_READ), GetModulesHandler)
+	moduleRoutes.GET("/get-users", middleware.CheckPermissions(security.Module, Store.Permissions, security.PERMS_CAN_READ_ALL), GetModuleUsersHandler)
+	moduleRoutes.POST("/add-user", middleware.CheckPermissions(security.Module, Store.Permissions, security.PERMS_CAN_UPDATE), AddUserToModuleHandler)
+	moduleRoutes.POST("/add-users", middleware.CheckPermissions(security.Module, Store.Permissions, security.PERMS_CAN_UPDATE), AddUsersToModuleHandler)
+	moduleRoutes.DELETE("/rm-user", middleware.CheckPermissions(security.Module, Store.Permissions, security.PERMS_CAN_UPDATE), RemoveUserFromModuleHandler)
+	moduleRoutes.PUT("/update", middleware.CheckPermissions(security.Module, Store.Permissions, security.PERMS_CAN_UPDATE), UpdateModuleHandler)
+	moduleRoutes.DELETE("/delete", middleware.CheckPermissions(security.Module, Store.Permissions, security.PERMS_CAN_DELETE), DeleteModuleHandler)
+	moduleRoutes.POST("/import-roster", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_CREATE, security.PERMS_CAN_UPDATE), ImportRosterHandler)
+}
+
+type ImportRosterBody struct {
+	Csv    string `json:"csv"`
+	DryRun bool   `json:"dry-run,omitempty"`
+}
+
+type UpdateModuleBody struct {
+	ModuleId   string `json:"module-id"`
+	Name       string `json:"name"`
+	ExternalId string `json:"external-id"`
+}
+
+type DeleteModuleBody struct {
+	ModuleId string `json:"module-id"`
+	Cascade  bool   `json:"cascade,omitempty"`
 }
 
 /*
@@ -40,7 +59,7 @@ func CreateModuleHandler(c *gin.Context) {
 	err := c.ShouldBindJSON(&newModule)
 
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
@@ -48,9 +67,9 @@ func CreateModuleHandler(c *gin.Context) {
 		return
 	}
 
-	err = model.CreateModule(&newModule, DatabasePool)
+	err = Store.Modules.CreateModule(c.Request.Context(), &newModule)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": c.Errors,
@@ -69,9 +88,9 @@ func CreateModuleHandler(c *gin.Context) {
  * URL: `/module/get`
  */
 func GetModulesHandler(c *gin.Context) {
-	modules, err := model.GetAllModules(DatabasePool)
+	modules, err := Store.Modules.GetModules(c.Request.Context())
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 	}
 
@@ -101,9 +120,9 @@ func GetModuleUsersHandler(c *gin.Context) {
 		return
 	}
 
-	moduleUsers, err := model.GetModuleStudents(moduleId, DatabasePool)
+	moduleUsers, err := model.GetModuleStudents(c.Request.Context(), moduleId, DatabasePool)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 	}
 
@@ -129,7 +148,7 @@ func AddUserToModuleHandler(c *gin.Context) {
 	err := c.ShouldBindJSON(&input)
 
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
@@ -137,9 +156,9 @@ func AddUserToModuleHandler(c *gin.Context) {
 		return
 	}
 
-	err = model.AddUserToModule(input.UserId, input.ModuleId, DatabasePool)
+	err = Store.Modules.AddUser(c.Request.Context(), input.UserId, input.ModuleId)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(errors.New("issue adding user to module"))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": c.Errors,
@@ -166,7 +185,7 @@ func AddUsersToModuleHandler(c *gin.Context) {
 	var body model.ModuleUserBulkEdit
 	err := c.ShouldBindJSON(&body)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
@@ -174,9 +193,9 @@ func AddUsersToModuleHandler(c *gin.Context) {
 		return
 	}
 
-	err = model.AddUsersToModule(body.Users, body.ModuleId, DatabasePool)
+	err = model.AddUsersToModule(c.Request.Context(), body.Users, body.ModuleId, DatabasePool)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(errors.New("issue adding users to module"))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": c.Errors,
@@ -203,7 +222,7 @@ func RemoveUserFromModuleHandler(c *gin.Context) {
 	var body model.ModuleUserEdit
 	err := c.ShouldBindJSON(&body)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
@@ -211,9 +230,9 @@ func RemoveUserFromModuleHandler(c *gin.Context) {
 		return
 	}
 
-	err = model.RemoveUserFromModule(body.UserId, body.ModuleId, DatabasePool)
+	err = Store.Modules.RemoveUser(c.Request.Context(), body.UserId, body.ModuleId)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(errors.New("issue adding user to module"))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": c.Errors,
@@ -227,3 +246,126 @@ func RemoveUserFromModuleHandler(c *gin.Context) {
 		"success": true,
 	})
 }
+
+/*
+ * Update a module's name and, external id.
+ * Method: PUT
+ * URL: `/module/update`
+ * Body Params: module-id, name, external-id
+ */
+func UpdateModuleHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body UpdateModuleBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	module := model.Module{Id: body.ModuleId, Name: body.Name, ExternalId: body.ExternalId}
+	err = model.UpdateModule(c.Request.Context(), &module, DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, module)
+}
+
+/*
+ * Delete a module, its groups and, members. Lessons and, their attendance are only deleted if
+ * cascade is set, otherwise a module with lessons is not deleted.
+ * Method: DELETE
+ * URL: `/module/delete`
+ * Body Params: module-id, cascade (optional)
+ */
+func DeleteModuleHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body DeleteModuleBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	err = model.DeleteModule(c.Request.Context(), body.ModuleId, body.Cascade, DatabasePool)
+	if err == model.ErrHasLessons {
+		c.Error(err)
+		c.JSON(http.StatusConflict, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue deleting module"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+/*
+ * Import modules, module groups and, memberships from a roster CSV keyed by external ids.
+ * Nothing is applied on a dry run or, if any row has an error.
+ * Method: POST
+ * URL: `/module/import-roster`
+ * Body Params: csv, dry-run (optional)
+ */
+func ImportRosterHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body ImportRosterBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	result, err := model.ImportRosterCsv(c.Request.Context(), strings.NewReader(body.Csv), body.DryRun, DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if len(result.Errors) != 0 {
+		c.JSON(http.StatusUnprocessableEntity, result)
+		return
+	}
+
+	if result.Applied {
+		utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
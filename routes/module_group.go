@@ -7,19 +7,33 @@ import (
 	"arcio/attendance-system/utils"
 	"errors"
 	"github.com/gin-gonic/gin"
-	"log"
 	"net/http"
 )
 
 func addModuleGroupRoutes(r *gin.Engine) {
 	moduleGroupRoutes := r.Group("/module/group")
 	moduleGroupRoutes.Use(middleware.CheckAuth(NonceManager))
-	moduleGroupRoutes.POST("/add", middleware.CheckPermissions(security.Module, DatabasePool, security.PERMS_CAN_CREATE), CreateModuleGroupHandler)
-	moduleGroupRoutes.POST("/add-user", middleware.CheckPermissions(security.ModuleGroup, DatabasePool, security.PERMS_CAN_UPDATE), AddUserToModuleGroupHandler)
-	moduleGroupRoutes.POST("/add-users", middleware.CheckPermissions(security.ModuleGroup, DatabasePool, security.PERMS_CAN_UPDATE), AddUsersToModuleGroupHandler)
-	moduleGroupRoutes.GET("/get", middleware.CheckPermissions(security.Module, DatabasePool, security.PERMS_CAN_READ), GetGroupsForModuleHandler)
-	moduleGroupRoutes.DELETE("/rm-user", middleware.CheckPermissions(security.ModuleGroup, DatabasePool, security.PERMS_CAN_UPDATE), RemoveFromModuleGroupHandler)
-	moduleGroupRoutes.GET("/users", middleware.CheckPermissions(security.ModuleGroup, DatabasePool, security.PERMS_CAN_READ_ALL), GetModuleGroupUsersHandler)
+	moduleGroupRoutes.POST("/add", middleware.CheckPermissions(security.Module, Store.Permissions, security.PERMS_CAN_CREATE), CreateModuleGroupHandler)
+	moduleGroupRoutes.POST("/add-user", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_UPDATE), AddUserToModuleGroupHandler)
+	moduleGroupRoutes.POST("/add-users", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_UPDATE), AddUsersToModuleGroupHandler)
+	moduleGroupRoutes.GET("/get", middleware.CheckPermissions(security.Module, Store.Permissions, security.PERMS_CAN_READ), GetGroupsForModuleHandler)
+	moduleGroupRoutes.DELETE("/rm-user", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_UPDATE), RemoveFromModuleGroupHandler)
+	moduleGroupRoutes.GET("/users", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_READ_ALL), GetModuleGroupUsersHandler)
+	moduleGroupRoutes.GET("/clashes", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_READ_ALL), GetModuleGroupClashesHandler)
+	moduleGroupRoutes.PUT("/update", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_UPDATE), UpdateModuleGroupHandler)
+	moduleGroupRoutes.DELETE("/delete", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_DELETE), DeleteModuleGroupHandler)
+}
+
+type UpdateModuleGroupBody struct {
+	ModuleId      string `json:"module-id"`
+	ModuleGroupId string `json:"module-group-id"`
+	Name          string `json:"name"`
+}
+
+type DeleteModuleGroupBody struct {
+	ModuleId      string `json:"module-id"`
+	ModuleGroupId string `json:"module-group-id"`
+	Cascade       bool   `json:"cascade,omitempty"`
 }
 
 /*
@@ -34,7 +48,7 @@ func CreateModuleGroupHandler(c *gin.Context) {
 	var newModuleGroup model.ModuleGroup
 	err := c.ShouldBindJSON(&newModuleGroup)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
@@ -42,9 +56,9 @@ func CreateModuleGroupHandler(c *gin.Context) {
 		return
 	}
 
-	err = model.CreateModuleGroup(&newModuleGroup, DatabasePool)
+	err = Store.ModuleGroups.CreateGroup(c.Request.Context(), &newModuleGroup)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(errors.New("issue creating module group"))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": c.Errors,
@@ -58,18 +72,44 @@ func CreateModuleGroupHandler(c *gin.Context) {
 }
 
 /*
- * Add user to module group.
- * Method: POST
- * URL: `module/group/add-user`
- * Body Params: module-group-id, user-id
+ * Refuses requests without a module id, the module group is checked against the module so that
+ * permissions in one module cannot be used on another module's groups.
  */
-func AddUserToModuleGroupHandler(c *gin.Context) {
+func requireModuleId(c *gin.Context, moduleId string) bool {
+	if moduleId == "" {
+		c.Error(errors.New("missing body parameter: module-id"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return false
+	}
+	return true
+}
+
+/*
+ * Checks the on-clash body parameter, it is ON_CLASH_WARN when it is not set.
+ */
+func parseOnClash(onClash string) (string, error) {
+	if onClash == "" {
+		return model.ON_CLASH_WARN, nil
+	}
+
+	if onClash != model.ON_CLASH_WARN && onClash != model.ON_CLASH_REFUSE {
+		return "", errors.New("on-clash must be " + model.ON_CLASH_WARN + " or, " + model.ON_CLASH_REFUSE)
+	}
+
+	return onClash, nil
+}
+
+/*
+ * Adds users to a module group, clashes with their timetables are returned as warnings or,
+ * refused with a 409. See model.AddUsersToModuleGroupChecked.
+ */
+func addUsersToModuleGroup(c *gin.Context, userIds []string, moduleGroupId string, onClash string, errorMessage string) {
 	claims := c.MustGet("claims").(security.Claims)
 
-	var body model.ModuleGroupEdit
-	err := c.ShouldBindJSON(&body)
+	onClash, err := parseOnClash(onClash)
 	if err != nil {
-		log.Println(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
@@ -77,10 +117,18 @@ func AddUserToModuleGroupHandler(c *gin.Context) {
 		return
 	}
 
-	err = model.AddUserToModuleGroup(body.UserId, body.ModuleGroupId, DatabasePool)
-	if err != nil {
-		log.Println(err)
-		c.Error(errors.New("failed to add user to module group"))
+	clashes, err := model.AddUsersToModuleGroupChecked(c.Request.Context(), userIds, moduleGroupId, onClash, DatabasePool)
+	var clashErr *model.MembershipClashError
+	if errors.As(err, &clashErr) {
+		c.Error(err)
+		c.JSON(http.StatusConflict, gin.H{
+			"errors":  c.Errors,
+			"clashes": clashErr.Students,
+		})
+		return
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New(errorMessage))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": c.Errors,
 		})
@@ -89,44 +137,57 @@ func AddUserToModuleGroupHandler(c *gin.Context) {
 
 	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
 
-	c.JSON(http.StatusCreated, gin.H{
+	// Warnings are only sent when there are clashes
+	ret := gin.H{
 		"success": true,
-	})
+	}
+	if len(clashes) != 0 {
+		ret["clashes"] = clashes
+	}
+
+	c.JSON(http.StatusCreated, ret)
 }
 
 /*
- * Add multiple users to module group.
- * Method: GET
- * URL: `/module/group/add-users`
- * Body params: module-group-id, user-ids
+ * Add user to module group.
+ * Method: POST
+ * URL: `module/group/add-user`
+ * Body Params: module-group-id, user-id, on-clash (optional, warn or, refuse, defaults to warn)
  */
-func AddUsersToModuleGroupHandler(c *gin.Context) {
-	claims := c.MustGet("claims").(security.Claims)
-
-	var body model.ModuleGroupBulkEdit
+func AddUserToModuleGroupHandler(c *gin.Context) {
+	var body model.ModuleGroupEdit
 	err := c.ShouldBindJSON(&body)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
 		})
+		return
 	}
 
-	err = model.AddUsersToModuleGroup(body.UserIds, body.ModuleGroupId, DatabasePool)
+	addUsersToModuleGroup(c, []string{body.UserId}, body.ModuleGroupId, body.OnClash, "failed to add user to module group")
+}
+
+/*
+ * Add multiple users to module group.
+ * Method: GET
+ * URL: `/module/group/add-users`
+ * Body params: module-group-id, user-ids, on-clash (optional, warn or, refuse, defaults to warn)
+ */
+func AddUsersToModuleGroupHandler(c *gin.Context) {
+	var body model.ModuleGroupBulkEdit
+	err := c.ShouldBindJSON(&body)
 	if err != nil {
-		log.Println(err)
-		c.Error(errors.New("issue adding users to module group"))
-		c.JSON(http.StatusInternalServerError, gin.H{
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
 		})
 		return
 	}
 
-	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
-	c.JSON(http.StatusCreated, gin.H{
-		"success": true,
-	})
+	addUsersToModuleGroup(c, body.UserIds, body.ModuleGroupId, body.OnClash, "issue adding users to module group")
 
 }
 
@@ -146,9 +207,9 @@ func GetGroupsForModuleHandler(c *gin.Context) {
 		return
 	}
 
-	moduleGroups, err := model.GetGroupsForModule(moduleId, DatabasePool)
+	moduleGroups, err := Store.ModuleGroups.GetGroups(c.Request.Context(), moduleId)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(errors.New("issue getting module groups"))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": c.Errors,
@@ -171,7 +232,7 @@ func RemoveFromModuleGroupHandler(c *gin.Context) {
 	var body model.ModuleGroupEdit
 	err := c.ShouldBindJSON(&body)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
@@ -179,9 +240,9 @@ func RemoveFromModuleGroupHandler(c *gin.Context) {
 		return
 	}
 
-	err = model.RemoveUserFromModuleGroup(body.UserId, body.ModuleGroupId, DatabasePool)
+	err = model.RemoveUserFromModuleGroup(c.Request.Context(), body.UserId, body.ModuleGroupId, DatabasePool)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(errors.New("Issue removing user from module"))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": c.Errors,
@@ -211,9 +272,9 @@ func GetModuleGroupUsersHandler(c *gin.Context) {
 		return
 	}
 
-	users, err := model.GetModuleGroupStudents(moduleGroupId, DatabasePool)
+	users, err := model.GetModuleGroupStudents(c.Request.Context(), moduleGroupId, DatabasePool)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(errors.New("Cannot get module groups"))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": c.Errors,
@@ -223,3 +284,132 @@ func GetModuleGroupUsersHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, users)
 }
+
+/*
+ * Rename a module group.
+ * Method: PUT
+ * URL: `/module/group/update`
+ * Body Params: module-id, module-group-id, name
+ */
+func UpdateModuleGroupHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body UpdateModuleGroupBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if !requireModuleId(c, body.ModuleId) {
+		return
+	}
+
+	moduleGroup := model.ModuleGroup{Id: body.ModuleGroupId, ModuleId: body.ModuleId, Name: body.Name}
+	err = model.UpdateModuleGroup(c.Request.Context(), &moduleGroup, DatabasePool)
+	if err == model.ErrModuleGroupNotFound {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, moduleGroup)
+}
+
+/*
+ * Delete a module group and, remove its members. Lessons and, their attendance are only deleted if
+ * cascade is set, otherwise a group with lessons is not deleted.
+ * Method: DELETE
+ * URL: `/module/group/delete`
+ * Body Params: module-id, module-group-id, cascade (optional)
+ */
+func DeleteModuleGroupHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body DeleteModuleGroupBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if !requireModuleId(c, body.ModuleId) {
+		return
+	}
+
+	err = model.DeleteModuleGroup(c.Request.Context(), body.ModuleId, body.ModuleGroupId, body.Cascade, DatabasePool)
+	if err == model.ErrModuleGroupNotFound {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	} else if err == model.ErrHasLessons {
+		c.Error(err)
+		c.JSON(http.StatusConflict, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue deleting module group"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+/*
+ * Get the students in a module group whose other lessons clash with the group's lessons.
+ * Method: GET
+ * URL: `/module/group/clashes`
+ * Query Params: moduleGroupId
+ */
+func GetModuleGroupClashesHandler(c *gin.Context) {
+	moduleGroupId, exists := c.GetQuery("moduleGroupId")
+	if !exists {
+		c.Error(errors.New("missing required query parameter moduleGroupId"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	clashes, err := model.GetModuleGroupTimetableClashes(c.Request.Context(), moduleGroupId, DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue getting timetable clashes"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, clashes)
+}
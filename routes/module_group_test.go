@@ -1,119 +1,24 @@
-package routes
+package routes_test
 
 import (
-	"arcio/attendance-system/config"
+	"arcio/attendance-system/harness"
 	"arcio/attendance-system/model"
-	"arcio/attendance-system/security"
-	"arcio/attendance-system/utils"
-	"bytes"
 	"encoding/json"
-	"fmt"
-	"github.com/gin-gonic/gin"
-	"log"
 	"net/http"
-	"net/http/httptest"
 	"testing"
 )
 
-func init() {
-	log.SetFlags(log.Llongfile | log.Ldate | log.Ltime | log.Lmicroseconds)
-	userConfig, err := config.LoadConfig()
-	if err != nil {
-		log.Println("Failed to load config from .env")
-		log.Println(err)
-	}
-
-	pooltmp, err := utils.InitDatabasePool(userConfig)
-	if err != nil {
-		log.Println("Failed to init database pool")
-		log.Println(err)
-		return
-	}
-	DatabasePool = pooltmp
-
-	userRes, err := pooltmp.Database.Query("SELECT id, external_id, firstname, " +
-		"surname, email, creation_time, edit_time FROM users LIMIT 1;")
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	defer userRes.Close()
-
-	if userRes.Next() {
-		userRes.Scan(&user.InternalId, &user.ExternalId, &user.Fname, &user.Sname,
-			&user.Email, &user.CreationTime, &user.EditTime)
-	}
-}
-
-func TearDownModuleGroupTest() {
-
-	queries := []string{
-		"DELETE FROM module_user_groups WHERE module_group_id in (SELECT id FROM module_groups WHERE name = 'test module group');",
-		"DELETE FROM module_users WHERE module_id in (SELECT id FROM modules WHERE external_id = '1234567890' AND name = 'test module');",
-		"DELETE FROM module_groups WHERE module_id in (SELECT id FROM modules WHERE external_id = '1234567890' AND name = 'test module');",
-		"DELETE FROM modules WHERE external_id = '1234567890' and name = 'test module';",
-	}
-
-	for _, query := range queries {
-		stmt, err := DatabasePool.Database.Prepare(query)
-		if err != nil {
-			log.Println(err)
-		}
-		defer stmt.Close()
-
-		_, err = stmt.Exec()
-		if err != nil {
-			log.Println(err)
-		}
-	}
-}
-
 func TestCreateModuleGroup(t *testing.T) {
-	t.Cleanup(TearDownModuleGroupTest)
+	h := harness.NewMemory(t)
 
-	// Create module
-	newModule := model.Module{
-		ExternalId: "1234567890",
-		Name:       "test module",
-	}
-	err := model.CreateModule(&newModule, DatabasePool)
+	w, err := h.Request(http.MethodPost, "/module/group/add", "lecturer",
+		map[string]string{"name": "test module group", "module-id": h.ModuleId("testing")})
 	if err != nil {
-		t.Fatal("Failed to create test module")
+		t.Fatal(err)
 	}
 
-	// Create module group
-
-	testJson := []byte(fmt.Sprintf(`{
-		"name":    "test module group",
-		"module-id":  "%s"
-	}`, newModule.Id))
-
-	req, _ := http.NewRequest(http.MethodPost, "/module/group/add", bytes.NewBuffer(testJson))
-	w := httptest.NewRecorder()
-	router := gin.New()
-
-	router.Use(func() gin.HandlerFunc {
-		return func(c *gin.Context) {
-			c.Set("claims", security.Claims{
-				Uuid: user.InternalId,
-			})
-		}
-	}())
-
-	router.POST("/module/group/add", CreateModuleGroupHandler)
-	router.ServeHTTP(w, req)
-
-	if w.Result().StatusCode != http.StatusCreated {
-		t.Error("Expected status code", http.StatusCreated, "but got", w.Result().StatusCode)
-
-		var body map[string]interface{}
-		err := json.Unmarshal(w.Body.Bytes(), &body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if val, ok := body["errors"]; ok {
-			t.Fatal("Endpoint returned errors", val)
-		}
+	if w.Code != http.StatusCreated {
+		t.Fatal("Expected status code", http.StatusCreated, "but got", w.Code, w.Body.String())
 	}
 
 	// Check can parse to module group
@@ -124,69 +29,30 @@ func TestCreateModuleGroup(t *testing.T) {
 	}
 
 	// Check no fields are empty
-	var moduleGroupMap map[string]string
+	var moduleGroupMap map[string]interface{}
 	err = json.Unmarshal(w.Body.Bytes(), &moduleGroupMap)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	for k, v := range moduleGroupMap {
-		if v == "" {
+		if v == nil || v == "" {
 			t.Error("Field", k, "is empty in module group")
 		}
 	}
 }
 
 func TestAddUserToModuleGroup(t *testing.T) {
-	t.Cleanup(TearDownModuleGroupTest)
+	h := harness.NewPostgres(t)
 
-	// Create module
-	newModule := model.Module{
-		ExternalId: "1234567890",
-		Name:       "test module",
-	}
-	err := model.CreateModule(&newModule, DatabasePool)
-	if err != nil {
-		t.Fatal("Failed to create test module")
-	}
-
-	// Create Module Group
-	newGroup := model.ModuleGroup{
-		Name:     "test module group",
-		ModuleId: newModule.Id,
-	}
-	err = model.CreateModuleGroup(&newGroup, DatabasePool)
+	w, err := h.Request(http.MethodPost, "/module/group/add-user", "lecturer", map[string]string{
+		"module-id":       h.ModuleId("testing"),
+		"module-group-id": h.GroupId("lab-a"),
+		"user-id":         h.UserId("other-student")})
 	if err != nil {
-		t.Fatal("Failed to create module group")
-	}
-
-	// Add user to module
-	err = model.AddUserToModule(user.InternalId, newModule.Id, DatabasePool)
-	if err != nil {
-		t.Fatal("Failed to add user to module")
+		t.Fatal(err)
 	}
 
-	// Add user to module group
-	testJson := []byte(fmt.Sprintf(`{
-		"module-group-id":    "%s",
-		"user-id":            "%s"
-	}`, newGroup.Id, user.InternalId))
-
-	req, _ := http.NewRequest(http.MethodPost, "/module/group/add-user", bytes.NewBuffer(testJson))
-	w := httptest.NewRecorder()
-	router := gin.New()
-
-	router.Use(func() gin.HandlerFunc {
-		return func(c *gin.Context) {
-			c.Set("claims", security.Claims{
-				Uuid: user.InternalId,
-			})
-		}
-	}())
-
-	router.POST("/module/group/add-user", AddUserToModuleGroupHandler)
-	router.ServeHTTP(w, req)
-
 	var body map[string]bool
 	err = json.Unmarshal(w.Body.Bytes(), &body)
 	if err != nil {
@@ -194,37 +60,17 @@ func TestAddUserToModuleGroup(t *testing.T) {
 	}
 
 	if body["success"] != true {
-		t.Error("Expected status to be true but got", body["success"])
+		t.Error("Expected status to be true but got", body["success"], w.Body.String())
 	}
 }
 
 func TestGetGroupsForModule(t *testing.T) {
-	t.Cleanup(TearDownModuleGroupTest)
+	h := harness.NewMemory(t)
 
-	// Create module
-	newModule := model.Module{
-		ExternalId: "1234567890",
-		Name:       "test module",
-	}
-	err := model.CreateModule(&newModule, DatabasePool)
+	w, err := h.Request(http.MethodGet, "/module/group/get?moduleId="+h.ModuleId("testing"), "lecturer", nil)
 	if err != nil {
-		t.Fatal("Failed to create test module")
-	}
-
-	// Create Module Group
-	newGroup := model.ModuleGroup{
-		Name:     "test module group",
-		ModuleId: newModule.Id,
-	}
-	err = model.CreateModuleGroup(&newGroup, DatabasePool)
-	if err != nil {
-		t.Fatal("Failed to create module group")
+		t.Fatal(err)
 	}
-	req, _ := http.NewRequest(http.MethodGet, "/module/group/get?moduleId="+newModule.Id, nil)
-	w := httptest.NewRecorder()
-	router := gin.New()
-	router.GET("/module/group/get", GetGroupsForModuleHandler)
-	router.ServeHTTP(w, req)
 
 	// Check can parse to array of modules groups
 	var body []model.ModuleGroup
@@ -233,8 +79,12 @@ func TestGetGroupsForModule(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	if len(body) != 1 || body[0].Id != h.GroupId("lab-a") {
+		t.Error("Expected the fixture module group but got", body)
+	}
+
 	// Check no fields are empty
-	var groupMap []map[string]string
+	var groupMap []map[string]interface{}
 	err = json.Unmarshal(w.Body.Bytes(), &groupMap)
 	if err != nil {
 		t.Fatal(err)
@@ -242,7 +92,7 @@ func TestGetGroupsForModule(t *testing.T) {
 
 	for _, group := range groupMap {
 		for k, v := range group {
-			if v == "" {
+			if v == nil || v == "" {
 				t.Error("Field", k, "empty in module group.")
 			}
 		}
@@ -250,55 +100,16 @@ func TestGetGroupsForModule(t *testing.T) {
 }
 
 func TestAddUsersToModuleGroup(t *testing.T) {
-	t.Cleanup(TearDownModuleGroupTest)
+	h := harness.NewPostgres(t)
 
-	// Create module
-	newModule := model.Module{
-		ExternalId: "1234567890",
-		Name:       "test module",
-	}
-	err := model.CreateModule(&newModule, DatabasePool)
+	w, err := h.Request(http.MethodPost, "/module/group/add-users", "lecturer", map[string]interface{}{
+		"module-id":       h.ModuleId("testing"),
+		"module-group-id": h.GroupId("lab-a"),
+		"user-ids":        []string{h.UserId("other-student")}})
 	if err != nil {
-		t.Fatal("Failed to create test module")
-	}
-
-	// Create Module Group
-	newGroup := model.ModuleGroup{
-		Name:     "test module group",
-		ModuleId: newModule.Id,
-	}
-	err = model.CreateModuleGroup(&newGroup, DatabasePool)
-	if err != nil {
-		t.Fatal("Failed to create module group")
-	}
-
-	// Add user to module
-	err = model.AddUserToModule(user.InternalId, newModule.Id, DatabasePool)
-	if err != nil {
-		t.Fatal("Failed to add user to module")
+		t.Fatal(err)
 	}
 
-	// Add user to module group
-	testJson := []byte(fmt.Sprintf(`{
-		"module-group-id":     "%s",
-		"user-ids":            ["%s"]
-	}`, newGroup.Id, user.InternalId))
-
-	req, _ := http.NewRequest(http.MethodPost, "/module/group/add-users", bytes.NewBuffer(testJson))
-	w := httptest.NewRecorder()
-	router := gin.New()
-
-	router.Use(func() gin.HandlerFunc {
-		return func(c *gin.Context) {
-			c.Set("claims", security.Claims{
-				Uuid: user.InternalId,
-			})
-		}
-	}())
-
-	router.POST("/module/group/add-users", AddUsersToModuleGroupHandler)
-	router.ServeHTTP(w, req)
-
 	var body map[string]bool
 	err = json.Unmarshal(w.Body.Bytes(), &body)
 	if err != nil {
@@ -306,66 +117,21 @@ func TestAddUsersToModuleGroup(t *testing.T) {
 	}
 
 	if body["success"] != true {
-		t.Error("Expected status to be true but got", body["success"])
+		t.Error("Expected status to be true but got", body["success"], w.Body.String())
 	}
 }
 
 func TestRemoveFromModuleGroup(t *testing.T) {
-	t.Cleanup(TearDownModuleGroupTest)
-
-	// Create module
-	newModule := model.Module{
-		ExternalId: "1234567890",
-		Name:       "test module",
-	}
-	err := model.CreateModule(&newModule, DatabasePool)
-	if err != nil {
-		t.Fatal("Failed to create test module")
-	}
+	h := harness.NewPostgres(t)
 
-	// Create Module Group
-	newGroup := model.ModuleGroup{
-		Name:     "test module group",
-		ModuleId: newModule.Id,
-	}
-	err = model.CreateModuleGroup(&newGroup, DatabasePool)
+	w, err := h.Request(http.MethodDelete, "/module/group/rm-user", "lecturer", map[string]string{
+		"module-id":       h.ModuleId("testing"),
+		"module-group-id": h.GroupId("lab-a"),
+		"user-id":         h.UserId("student")})
 	if err != nil {
-		t.Fatal("Failed to create module group")
-	}
-
-	// Add user to module
-	err = model.AddUserToModule(user.InternalId, newModule.Id, DatabasePool)
-	if err != nil {
-		t.Fatal("Failed to add user to module")
-	}
-
-	// Add user to module group
-	err = model.AddUserToModuleGroup(user.InternalId, newGroup.Id, DatabasePool)
-	if err != nil {
-		t.Fatal("Failed to add user to module group")
+		t.Fatal(err)
 	}
 
-	// Remove user from module group
-	testJson := []byte(fmt.Sprintf(`{
-			"module-group-id":    "%s",
-			"user-id":            "%s"
-		}`, newGroup.Id, user.InternalId))
-
-	req, _ := http.NewRequest(http.MethodDelete, "/module/group/rm-user", bytes.NewBuffer(testJson))
-	w := httptest.NewRecorder()
-	router := gin.New()
-
-	router.Use(func() gin.HandlerFunc {
-		return func(c *gin.Context) {
-			c.Set("claims", security.Claims{
-				Uuid: user.InternalId,
-			})
-		}
-	}())
-
-	router.DELETE("/module/group/rm-user", RemoveFromModuleGroupHandler)
-	router.ServeHTTP(w, req)
-
 	var body map[string]bool
 	err = json.Unmarshal(w.Body.Bytes(), &body)
 	if err != nil {
@@ -373,59 +139,23 @@ func TestRemoveFromModuleGroup(t *testing.T) {
 	}
 
 	if body["success"] != true {
-		t.Error("Expected status to be true but got", body["success"])
+		t.Error("Expected status to be true but got", body["success"], w.Body.String())
 	}
 }
 
 func TestGetModuleGroupStudents(t *testing.T) {
-	t.Cleanup(TearDownModuleGroupTest)
-
-	// Create module
-	newModule := model.Module{
-		ExternalId: "1234567890",
-		Name:       "test module",
-	}
-	err := model.CreateModule(&newModule, DatabasePool)
-	if err != nil {
-		t.Fatal("Failed to create test module")
-	}
-
-	// Create Module Group
-	newGroup := model.ModuleGroup{
-		Name:     "test module group",
-		ModuleId: newModule.Id,
-	}
-	err = model.CreateModuleGroup(&newGroup, DatabasePool)
-	if err != nil {
-		t.Fatal("Failed to create module group")
-	}
+	h := harness.NewPostgres(t)
 
-	// Add user to module
-	err = model.AddUserToModule(user.InternalId, newModule.Id, DatabasePool)
+	w, err := h.Request(http.MethodGet, "/module/group/users?moduleId="+h.ModuleId("testing")+"&moduleGroupId="+h.GroupId("lab-a"), "lecturer", nil)
 	if err != nil {
-		t.Fatal("Failed to add user to module")
-	}
-
-	// Add user to module group
-	err = model.AddUserToModuleGroup(user.InternalId, newGroup.Id, DatabasePool)
-	if err != nil {
-		t.Fatal("Failed to add user to module group")
+		t.Fatal(err)
 	}
 
-	req, _ := http.NewRequest(http.MethodGet, "/module/group/users?moduleGroupId="+newGroup.Id, nil)
-	w := httptest.NewRecorder()
-	router := gin.New()
-	router.GET("/module/group/users", GetModuleGroupUsersHandler)
-	router.ServeHTTP(w, req)
-
 	// Check can parse to user
 	var body model.ModuleGroupRet
-	log.Println("Testinggg")
 	err = json.Unmarshal(w.Body.Bytes(), &body)
-	log.Println(w.Body.String())
-	log.Println(body)
 	if err != nil {
-		t.Fatal(err)
+		t.Fatal(err, w.Body.String())
 	}
 
 	if len(body.UserAttendance) == 0 {
@@ -1,111 +1,34 @@
-package routes
+package routes_test
 
 import (
-	"arcio/attendance-system/config"
+	"arcio/attendance-system/harness"
 	"arcio/attendance-system/model"
-	"arcio/attendance-system/security"
-	"arcio/attendance-system/utils"
-	"bytes"
 	"encoding/json"
-	"fmt"
-	"github.com/gin-gonic/gin"
-	"log"
 	"net/http"
-	"net/http/httptest"
 	"testing"
 )
 
-var testModuleId string
+// A module without users for the tests that add them
+var emptyModule = harness.Fixture{Modules: []harness.FixtureModule{{Key: "empty",
+	Name:       "test module",
+	ExternalId: "1234567890"}}}
 
-func init() {
-	userConfig, err := config.LoadConfig()
-	if err != nil {
-		log.Println("Failed to load config from .env")
-		log.Println(err)
-	}
-
-	pooltmp, err := utils.InitDatabasePool(userConfig)
-	if err != nil {
-		log.Println("Failed to init database pool")
-		log.Println(err)
-		return
-	}
-	DatabasePool = pooltmp
-
-	stmt, err := pooltmp.Database.Prepare("SELECT id, external_id, firstname, " +
-		"surname, email, creation_time, edit_time FROM users LIMIT 1;")
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	defer stmt.Close()
-
-	userRes, err := stmt.Query()
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	defer userRes.Close()
-
-	if userRes.Next() {
-		userRes.Scan(&user.InternalId, &user.ExternalId, &user.Fname, &user.Sname,
-			&user.Email, &user.CreationTime, &user.EditTime)
-	}
+func TestCreateModule(t *testing.T) {
+	h := harness.NewMemory(t)
 
-	stmt, err = pooltmp.Database.Prepare("SELECT id FROM modules LIMIT 1;")
+	w, err := h.Request(http.MethodPost, "/module/add", "admin",
+		map[string]string{"name": "test module", "external-id": "1234567890"})
 	if err != nil {
-		log.Println(err)
+		t.Fatal(err)
 	}
-	defer stmt.Close()
 
-	moduleRes, err := stmt.Query()
-	if err != nil {
-		log.Println(err)
-	}
-	defer moduleRes.Close()
-	if moduleRes.Next() {
-		moduleRes.Scan(&testModuleId)
-	}
-}
-
-func TestCreateModule(t *testing.T) {
-	t.Cleanup(TearDownModuleTest)
-	jsonData := []byte(`{
-		"name":        "test module",
-		"external-id": "1234567890"
-	}`)
-
-	req, _ := http.NewRequest(http.MethodPost, "/module/add", bytes.NewBuffer(jsonData))
-	w := httptest.NewRecorder()
-	router := gin.New()
-
-	router.Use(func() gin.HandlerFunc {
-		return func(c *gin.Context) {
-			c.Set("claims", security.Claims{
-				Uuid: user.InternalId,
-			})
-		}
-	}())
-
-	router.POST("/module/add", CreateModuleHandler)
-	router.ServeHTTP(w, req)
-
-	if w.Result().StatusCode != http.StatusCreated {
-		t.Error("Expected status code", http.StatusCreated, "but got", w.Result().StatusCode)
-
-		var body map[string]interface{}
-		err := json.Unmarshal(w.Body.Bytes(), &body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if val, ok := body["errors"]; ok {
-			t.Fatal("Endpoint returned errors", val)
-		}
+	if w.Code != http.StatusCreated {
+		t.Fatal("Expected status code", http.StatusCreated, "but got", w.Code, w.Body.String())
 	}
 
 	// Check can be parsed to module
 	var body model.Module
-	err := json.Unmarshal(w.Body.Bytes(), &body)
+	err = json.Unmarshal(w.Body.Bytes(), &body)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -124,60 +47,36 @@ func TestCreateModule(t *testing.T) {
 	}
 }
 
-func TearDownModuleTest() {
-
-	queries := []string{
-		"delete from module_users where module_id in (select id from modules where external_id = '1234567890' and name = 'test module');",
-		"DELETE FROM modules WHERE external_id = '1234567890' and name = 'test module';",
-	}
-
-	for _, query := range queries {
-		stmt, err := DatabasePool.Database.Prepare(query)
-		if err != nil {
-			log.Println(err)
-		}
-		defer stmt.Close()
+func TestGetModule(t *testing.T) {
+	h := harness.NewMemory(t)
 
-		_, err = stmt.Exec()
-		if err != nil {
-			log.Println(err)
-		}
+	w, err := h.Request(http.MethodGet, "/module/get", "admin", nil)
+	if err != nil {
+		t.Fatal(err)
 	}
-}
 
-func TestGetModule(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, "/module/get", nil)
-	w := httptest.NewRecorder()
-	router := gin.New()
-	router.GET("/module/get", GetModulesHandler)
-	router.ServeHTTP(w, req)
-
-	if w.Result().StatusCode != http.StatusOK {
-		t.Error("Expected status code", http.StatusOK, "but got", w.Result().StatusCode)
-
-		var body map[string]interface{}
-		err := json.Unmarshal(w.Body.Bytes(), &body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if val, ok := body["errors"]; ok {
-			t.Fatal("Endpoint returned errors", val)
-		}
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected status code", http.StatusOK, "but got", w.Code, w.Body.String())
 	}
 
 	// Check if can be parsed to array of modules
 	var body []model.Module
-	err := json.Unmarshal(w.Body.Bytes(), &body)
+	err = json.Unmarshal(w.Body.Bytes(), &body)
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	if len(body) != 1 || body[0].Id != h.ModuleId("testing") {
+		t.Error("Expected the fixture module but got", body)
+	}
+
 	// Check no fields are empty
 	var moduleMap []map[string]string
 	err = json.Unmarshal(w.Body.Bytes(), &moduleMap)
 	if err != nil {
 		t.Fatal(err)
 	}
+
 	for _, module := range moduleMap {
 		for k, v := range module {
 			if v == "" {
@@ -188,32 +87,28 @@ func TestGetModule(t *testing.T) {
 }
 
 func TestGetModuleUsers(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, "/module/get-users?moduleId="+testModuleId, nil)
-	w := httptest.NewRecorder()
-	router := gin.New()
-	router.GET("/module/get-users", GetModuleUsersHandler)
-	router.ServeHTTP(w, req)
-
-	if w.Result().StatusCode != http.StatusOK {
-		t.Error("Expected status code", http.StatusOK, "but got", w.Result().StatusCode)
-
-		var body map[string]interface{}
-		err := json.Unmarshal(w.Body.Bytes(), &body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if val, ok := body["errors"]; ok {
-			t.Fatal("Endpoint returned errors", val)
-		}
+	h := harness.NewPostgres(t)
+
+	w, err := h.Request(http.MethodGet, "/module/get-users?moduleId="+h.ModuleId("testing"), "lecturer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected status code", http.StatusOK, "but got", w.Code, w.Body.String())
 	}
 
 	// Check can parse to UserAttendanceRet
 	var body []model.UserAttendanceRet
-	err := json.Unmarshal(w.Body.Bytes(), &body)
+	err = json.Unmarshal(w.Body.Bytes(), &body)
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	if len(body) == 0 {
+		t.Error("Expected the module's users")
+	}
+
 	// Check no fields are empty
 	var userMap []map[string]interface{}
 	err = json.Unmarshal(w.Body.Bytes(), &userMap)
@@ -241,57 +136,19 @@ func TestGetModuleUsers(t *testing.T) {
 }
 
 func TestAddUserToModule(t *testing.T) {
-	t.Cleanup(TearDownModuleTest)
-	// Create module to add user too
-	setupData := []byte(`{
-		"name":        "test module",
-		"external-id": "1234567890"
-	}`)
-
-	setupReq, _ := http.NewRequest(http.MethodPost, "/module/add", bytes.NewBuffer(setupData))
-	setupWriter := httptest.NewRecorder()
-	router := gin.New()
-
-	router.Use(func() gin.HandlerFunc {
-		return func(c *gin.Context) {
-			c.Set("claims", security.Claims{
-				Uuid: user.InternalId,
-			})
-		}
-	}())
-
-	router.POST("/module/add", CreateModuleHandler)
-	router.POST("/module/add-user", AddUserToModuleHandler)
-	router.ServeHTTP(setupWriter, setupReq)
-	if setupWriter.Result().StatusCode != http.StatusCreated {
-		t.Fatal("failed to setup test")
+	h := harness.NewMemory(t)
+	if err := h.Seed(emptyModule); err != nil {
+		t.Fatal(err)
 	}
-	var setupRes map[string]string
-	err := json.Unmarshal(setupWriter.Body.Bytes(), &setupRes)
+
+	w, err := h.Request(http.MethodPost, "/module/add-user", "admin",
+		map[string]string{"user-id": h.UserId("student"), "module-id": h.ModuleId("empty")})
 	if err != nil {
-		t.Fatal(err, "issue parsing setup response")
+		t.Fatal(err)
 	}
 
-	testJson := []byte(fmt.Sprintf(`{
-		"user-id":   "%s",
-		"module-id": "%s"
-		}`, user.InternalId, setupRes["id"]))
-	req, _ := http.NewRequest(http.MethodPost, "/module/add-user", bytes.NewBuffer(testJson))
-	w := httptest.NewRecorder()
-
-	router.ServeHTTP(w, req)
-
-	if w.Result().StatusCode != http.StatusCreated {
-		t.Error("Expected status code", http.StatusCreated, "but got", w.Result().StatusCode)
-
-		var body map[string]interface{}
-		err := json.Unmarshal(w.Body.Bytes(), &body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if val, ok := body["errors"]; ok {
-			t.Fatal("Endpoint returned errors", val)
-		}
+	if w.Code != http.StatusCreated {
+		t.Fatal("Expected status code", http.StatusCreated, "but got", w.Code, w.Body.String())
 	}
 
 	var body map[string]bool
@@ -306,48 +163,20 @@ func TestAddUserToModule(t *testing.T) {
 }
 
 func TestAddUsersToModule(t *testing.T) {
-	t.Cleanup(TearDownModuleTest)
-
-	// Create new module
-	newModule := model.Module{
-		ExternalId: "1234567890",
-		Name:       "test module",
+	h := harness.NewPostgres(t)
+	if err := h.Seed(emptyModule); err != nil {
+		t.Fatal(err)
 	}
-	err := model.CreateModule(&newModule, DatabasePool)
+
+	w, err := h.Request(http.MethodPost, "/module/add-users", "admin", map[string]interface{}{
+		"user-ids":  []string{h.UserId("student"), h.UserId("other-student")},
+		"module-id": h.ModuleId("empty")})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	testJson := []byte(fmt.Sprintf(`{
-		"user-ids":   ["%s"],
-		"module-id": "%s"
-		}`, user.InternalId, newModule.Id))
-	req, _ := http.NewRequest(http.MethodPost, "/module/add-users", bytes.NewBuffer(testJson))
-	w := httptest.NewRecorder()
-	router := gin.New()
-
-	router.Use(func() gin.HandlerFunc {
-		return func(c *gin.Context) {
-			c.Set("claims", security.Claims{
-				Uuid: user.InternalId,
-			})
-		}
-	}())
-
-	router.POST("/module/add-users", AddUsersToModuleHandler)
-	router.ServeHTTP(w, req)
-
-	if w.Result().StatusCode != http.StatusCreated {
-		t.Error("Expected status code", http.StatusCreated, "but got", w.Result().StatusCode)
-
-		var body map[string]interface{}
-		err := json.Unmarshal(w.Body.Bytes(), &body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if val, ok := body["errors"]; ok {
-			t.Fatal("Endpoint returned errors", val)
-		}
+	if w.Code != http.StatusCreated {
+		t.Fatal("Expected status code", http.StatusCreated, "but got", w.Code, w.Body.String())
 	}
 
 	var body map[string]bool
@@ -362,55 +191,16 @@ func TestAddUsersToModule(t *testing.T) {
 }
 
 func TestRemoveUserFromModule(t *testing.T) {
-	t.Cleanup(TearDownModuleTest)
+	h := harness.NewMemory(t)
 
-	// Create new module
-	newModule := model.Module{
-		ExternalId: "1234567890",
-		Name:       "test module",
-	}
-	err := model.CreateModule(&newModule, DatabasePool)
+	w, err := h.Request(http.MethodDelete, "/module/rm-user", "admin",
+		map[string]string{"user-id": h.UserId("other-student"), "module-id": h.ModuleId("testing")})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// Add user to module
-	err = model.AddUserToModule(user.InternalId, newModule.Id, DatabasePool)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	testJson := []byte(fmt.Sprintf(`{
-		"user-id":    "%s",
-		"module-id":  "%s"
-	}`, user.InternalId, newModule.Id))
-
-	req, _ := http.NewRequest(http.MethodDelete, "/module/rm-user", bytes.NewBuffer(testJson))
-	w := httptest.NewRecorder()
-	router := gin.New()
-
-	router.Use(func() gin.HandlerFunc {
-		return func(c *gin.Context) {
-			c.Set("claims", security.Claims{
-				Uuid: user.InternalId,
-			})
-		}
-	}())
-
-	router.DELETE("/module/rm-user", RemoveUserFromModuleHandler)
-	router.ServeHTTP(w, req)
-
-	if w.Result().StatusCode != http.StatusOK {
-		t.Error("Expected status code", http.StatusOK, "but got", w.Result().StatusCode)
-
-		var body map[string]interface{}
-		err := json.Unmarshal(w.Body.Bytes(), &body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if val, ok := body["errors"]; ok {
-			t.Fatal("Endpoint returned errors", val)
-		}
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected status code", http.StatusOK, "but got", w.Code, w.Body.String())
 	}
 
 	var body map[string]bool
@@ -0,0 +1,183 @@
+/*
+ * report.go contains handlers for endpoints under `/report`.
+ * Reports are attendance spreadsheets with one row per student and, one column per lesson.
+ */
+
+package routes
+
+import (
+	"arcio/attendance-system/middleware"
+	"arcio/attendance-system/model"
+	"arcio/attendance-system/security"
+	"arcio/attendance-system/utils"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+const (
+	REPORT_FORMAT_CSV  = "csv"
+	REPORT_FORMAT_XLSX = "xlsx"
+)
+
+func addReportRoutes(r *gin.Engine) {
+	reportRoutes := r.Group("/report")
+	reportRoutes.Use(middleware.CheckAuth(NonceManager))
+	reportRoutes.GET("/module", middleware.CheckPermissions(security.Module, Store.Permissions, security.PERMS_CAN_READ_ALL), ModuleReportHandler)
+	reportRoutes.GET("/module-group", middleware.CheckPermissions(security.ModuleGroup, Store.Permissions, security.PERMS_CAN_READ_ALL), ModuleGroupReportHandler)
+	reportRoutes.GET("/student", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_READ_ALL), StudentReportHandler)
+}
+
+/*
+ * Reads the report query parameters, the error response is sent if they are invalid.
+ * from and, to are dates and, to is inclusive, or term or, week with an optional yearId
+ * select a range from the academic calendar.
+ */
+func getReportParams(c *gin.Context, idParam string) (string, time.Time, time.Time, string, bool) {
+	id, exists := c.GetQuery(idParam)
+	if !exists {
+		c.Error(errors.New("missing query parameter " + idParam))
+	}
+
+	var from time.Time
+	var err error
+	if c.Query("from") != "" {
+		from, err = time.Parse(DATE_FORMAT, c.Query("from"))
+		if err != nil {
+			c.Error(errors.New("from date format error"))
+		}
+	}
+
+	to := time.Now()
+	if c.Query("to") != "" {
+		to, err = time.Parse(DATE_FORMAT, c.Query("to"))
+		if err != nil {
+			c.Error(errors.New("to date format error"))
+		}
+	}
+	to = to.Truncate(24 * time.Hour).Add(24 * time.Hour)
+
+	// A term or, teaching week can be given instead of dates
+	calendarFrom, calendarTo, ok, err := getCalendarRange(c)
+	if err != nil {
+		c.Error(err)
+	} else if ok && (c.Query("from") != "" || c.Query("to") != "") {
+		c.Error(errors.New("from and, to cannot be set with a term or, week"))
+	} else if ok {
+		from, to = calendarFrom, calendarTo
+	}
+
+	format := c.DefaultQuery("format", REPORT_FORMAT_CSV)
+	if format != REPORT_FORMAT_CSV && format != REPORT_FORMAT_XLSX {
+		c.Error(errors.New("format must be csv or, xlsx"))
+	}
+
+	if len(c.Errors) != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return "", from, to, format, false
+	}
+
+	return id, from, to, format, true
+}
+
+/*
+ * Streams a report as a download.
+ */
+func sendReport(c *gin.Context, report model.AttendanceReport, format string) {
+	filename := fmt.Sprintf("attendance-%s.%s", time.Now().Format("2006-01-02"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+
+	var err error
+	if format == REPORT_FORMAT_XLSX {
+		c.Header("Content-Type", utils.XLSX_CONTENT_TYPE)
+		c.Status(http.StatusOK)
+		err = model.ExportReportAsXlsx(report, c.Writer)
+	} else {
+		c.Header("Content-Type", "text/csv")
+		c.Status(http.StatusOK)
+		err = model.ExportReportAsCsv(report, c.Writer)
+	}
+
+	// The headers have been sent so the error cannot be returned
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+	}
+}
+
+func reportError(c *gin.Context, err error) {
+	middleware.RequestLog(c).Error(err)
+	c.Error(errors.New("issue getting report"))
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"errors": c.Errors,
+	})
+}
+
+/*
+ * Get the attendance report for a module.
+ * Method: GET
+ * URL: `/report/module`
+ * Query Params: moduleId, from (optional), to (optional), term (optional),
+ *	week (optional), yearId (optional), format (csv or, xlsx)
+ */
+func ModuleReportHandler(c *gin.Context) {
+	moduleId, from, to, format, ok := getReportParams(c, "moduleId")
+	if !ok {
+		return
+	}
+
+	report, err := model.GetModuleReport(c.Request.Context(), moduleId, from, to, DatabasePool)
+	if err != nil {
+		reportError(c, err)
+		return
+	}
+
+	sendReport(c, report, format)
+}
+
+/*
+ * Get the attendance report for a module group.
+ * Method: GET
+ * URL: `/report/module-group`
+ * Query Params: moduleId, moduleGroupId, from (optional), to (optional), term (optional),
+ *	week (optional), yearId (optional), format (csv or, xlsx)
+ */
+func ModuleGroupReportHandler(c *gin.Context) {
+	moduleGroupId, from, to, format, ok := getReportParams(c, "moduleGroupId")
+	if !ok {
+		return
+	}
+
+	report, err := model.GetModuleGroupReport(c.Request.Context(), moduleGroupId, from, to, DatabasePool)
+	if err != nil {
+		reportError(c, err)
+		return
+	}
+
+	sendReport(c, report, format)
+}
+
+/*
+ * Get the attendance report for a student across all of their modules.
+ * Method: GET
+ * URL: `/report/student`
+ * Query Params: userId, from (optional), to (optional), term (optional),
+ *	week (optional), yearId (optional), format (csv or, xlsx)
+ */
+func StudentReportHandler(c *gin.Context) {
+	userId, from, to, format, ok := getReportParams(c, "userId")
+	if !ok {
+		return
+	}
+
+	report, err := model.GetStudentReport(c.Request.Context(), userId, from, to, DatabasePool)
+	if err != nil {
+		reportError(c, err)
+		return
+	}
+
+	sendReport(c, report, format)
+}
@@ -0,0 +1,450 @@
+/*
+ * roles.go contains handlers for endpoints under `/roles`.
+ * Roles are created globally and, assigned to users at a layer. Users cannot create,
+ * update, assign or, revoke a role with permissions that they do not hold themselves.
+ */
+
+package routes
+
+import (
+	"arcio/attendance-system/middleware"
+	"arcio/attendance-system/model"
+	"arcio/attendance-system/security"
+	"arcio/attendance-system/utils"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const PRIVELLEDGE_ESCALATION_MSG = "You cannot manage a role with permissions that you do not have"
+
+func addRoleRoutes(r *gin.Engine) {
+	roleRoutes := r.Group("/roles")
+	roleRoutes.Use(middleware.CheckAuth(NonceManager))
+	roleRoutes.POST("/create", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_MANAGE_ROLES), CreateRoleHandler)
+	roleRoutes.GET("/get", middleware.CheckPermissions(security.Module, Store.Permissions, security.PERMS_MANAGE_ROLES), GetRolesHandler)
+	roleRoutes.PUT("/update", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_MANAGE_ROLES), UpdateRoleHandler)
+	roleRoutes.DELETE("/delete", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_MANAGE_ROLES), DeleteRoleHandler)
+	roleRoutes.GET("/explain", middleware.CheckPermissions(security.Module, Store.Permissions, security.PERMS_MANAGE_ROLES), ExplainPermissionsHandler)
+
+	// i.e: `/roles/module-group/assign`
+	for _, l := range []security.Layer{security.Global, security.Module, security.ModuleGroup, security.Attendance} {
+		layerRoutes := roleRoutes.Group("/" + l.String())
+		layerRoutes.GET("/get-user", middleware.CheckPermissions(roleManagementLayer(l), Store.Permissions, security.PERMS_MANAGE_ROLES), GetUserRolesHandler(l))
+		layerRoutes.POST("/assign", middleware.CheckPermissions(roleManagementLayer(l), Store.Permissions, security.PERMS_MANAGE_ROLES), AssignRoleHandler(l))
+		layerRoutes.DELETE("/revoke", middleware.CheckPermissions(roleManagementLayer(l), Store.Permissions, security.PERMS_MANAGE_ROLES), RevokeRoleHandler(l))
+	}
+}
+
+type RoleBody struct {
+	RoleId    string             `json:"role-id,omitempty"`
+	Name      string             `json:"name"`
+	Overrides security.Overrides `json:"overrides"`
+}
+
+type DeleteRoleBody struct {
+	RoleId string `json:"role-id"`
+}
+
+type RoleAssignmentBody struct {
+	RoleId        string `json:"role-id"`
+	UserId        string `json:"user-id"`
+	ModuleId      string `json:"module-id,omitempty"`
+	ModuleGroupId string `json:"module-group-id,omitempty"`
+}
+
+/*
+ * Gets the layer that the user's permissions are checked at to manage roles at a layer. Attendance
+ * roles are stored by user only so, they apply in every module and, need global permissions.
+ */
+func roleManagementLayer(l security.Layer) security.Layer {
+	if l == security.Attendance {
+		return security.Global
+	}
+	return l
+}
+
+/*
+ * Checks that the user holds all of the permissions in a role at a layer, the error
+ * response is sent if they do not.
+ */
+func checkRoleNotEscalated(c *gin.Context, overrides security.Overrides, moduleId string, moduleGroupId string, l security.Layer) bool {
+	claims := c.MustGet("claims").(security.Claims)
+
+	perms, err := model.GetRepositoryPermissions(c.Request.Context(), Store.Permissions, claims.Uuid, moduleId, moduleGroupId, l)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue getting permissions"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return false
+	}
+
+	if security.IsPrivelledgeEscalated(perms, overrides) {
+		middleware.RequestLog(c).Warnf("User %s tried to manage a role with permissions %d but has %d", claims.Uuid, overrides, perms)
+		c.Error(errors.New(PRIVELLEDGE_ESCALATION_MSG))
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errors": c.Errors,
+		})
+		return false
+	}
+
+	return true
+}
+
+/*
+ * Create a role.
+ * Method: POST
+ * URL: `/roles/create`
+ * Body Params: name, overrides
+ */
+func CreateRoleHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body RoleBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if !checkRoleNotEscalated(c, body.Overrides, "", "", security.Global) {
+		return
+	}
+
+	role := model.Role{Name: body.Name, Overrides: body.Overrides}
+	err = Store.Permissions.CreateRole(c.Request.Context(), &role)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusCreated, role)
+}
+
+/*
+ * Get all roles.
+ * Method: GET
+ * URL: `/roles/get`
+ * Query Params: moduleId (optional)
+ */
+func GetRolesHandler(c *gin.Context) {
+	roles, err := model.GetRoles(c.Request.Context(), DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue getting roles"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, roles)
+}
+
+/*
+ * Update a role's name and, permissions.
+ * Method: PUT
+ * URL: `/roles/update`
+ * Body Params: role-id, name, overrides
+ */
+func UpdateRoleHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body RoleBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	old, err := model.GetRole(c.Request.Context(), body.RoleId, DatabasePool)
+	if err == model.ErrRoleNotFound {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue getting role"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	// The user must hold the old and, the new permissions
+	if !checkRoleNotEscalated(c, old.Overrides|body.Overrides, "", "", security.Global) {
+		return
+	}
+
+	role := model.Role{Id: body.RoleId, Name: body.Name, Overrides: body.Overrides}
+	err = model.UpdateRole(c.Request.Context(), &role, DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, role)
+}
+
+/*
+ * Delete a role, it is revoked from everyone that has it.
+ * Method: DELETE
+ * URL: `/roles/delete`
+ * Body Params: role-id
+ */
+func DeleteRoleHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body DeleteRoleBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	role, err := model.GetRole(c.Request.Context(), body.RoleId, DatabasePool)
+	if err == model.ErrRoleNotFound {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue getting role"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	if !checkRoleNotEscalated(c, role.Overrides, "", "", security.Global) {
+		return
+	}
+
+	err = model.DeleteRole(c.Request.Context(), body.RoleId, DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue deleting role"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+/*
+ * Get the roles a user has at a layer.
+ * Method: GET
+ * URL: `/roles/<layer>/get-user`
+ * Query Params: userId, moduleId (module and, module-group layers), moduleGroupId (module-group layer)
+ */
+func GetUserRolesHandler(l security.Layer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, exists := c.GetQuery("userId")
+		if !exists {
+			c.Error(errors.New("missing query parameter userId"))
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": c.Errors,
+			})
+			return
+		}
+
+		roles, err := model.GetUserRoles(c.Request.Context(), userId, c.Query("moduleId"), c.Query("moduleGroupId"), l, DatabasePool)
+		if err != nil {
+			middleware.RequestLog(c).Error(err)
+			c.Error(errors.New("issue getting roles"))
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": c.Errors,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, roles)
+	}
+}
+
+// Gets the role for an assignment and, checks the user can assign it
+func getAssignableRole(c *gin.Context, body RoleAssignmentBody, l security.Layer) bool {
+	role, err := model.GetRole(c.Request.Context(), body.RoleId, DatabasePool)
+	if err == model.ErrRoleNotFound {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"errors": c.Errors,
+		})
+		return false
+	} else if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue getting role"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return false
+	}
+
+	if roleManagementLayer(l) == security.Global {
+		// Module ids do not scope global roles, see roleManagementLayer
+		return checkRoleNotEscalated(c, role.Overrides, "", "", security.Global)
+	}
+	return checkRoleNotEscalated(c, role.Overrides, body.ModuleId, body.ModuleGroupId, l)
+}
+
+/*
+ * Assign a role to a user at a layer, attendance roles need global permissions, see
+ * roleManagementLayer.
+ * Method: POST
+ * URL: `/roles/<layer>/assign`
+ * Body Params: role-id, user-id, module-id (module and, module-group layers), module-group-id (module-group layer)
+ */
+func AssignRoleHandler(l security.Layer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := c.MustGet("claims").(security.Claims)
+
+		var body RoleAssignmentBody
+		err := c.ShouldBindJSON(&body)
+		if err != nil {
+			middleware.RequestLog(c).Error(err)
+			c.Error(err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": c.Errors,
+			})
+			return
+		}
+
+		if !getAssignableRole(c, body, l) {
+			return
+		}
+
+		err = Store.Permissions.AssignRole(c.Request.Context(), body.RoleId, body.UserId, body.ModuleId, body.ModuleGroupId, l)
+		if err != nil {
+			middleware.RequestLog(c).Error(err)
+			c.Error(err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": c.Errors,
+			})
+			return
+		}
+
+		utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	}
+}
+
+/*
+ * Revoke a role from a user at a layer, attendance roles need global permissions, see
+ * roleManagementLayer.
+ * Method: DELETE
+ * URL: `/roles/<layer>/revoke`
+ * Body Params: role-id, user-id, module-id (module and, module-group layers), module-group-id (module-group layer)
+ */
+func RevokeRoleHandler(l security.Layer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := c.MustGet("claims").(security.Claims)
+
+		var body RoleAssignmentBody
+		err := c.ShouldBindJSON(&body)
+		if err != nil {
+			middleware.RequestLog(c).Error(err)
+			c.Error(err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": c.Errors,
+			})
+			return
+		}
+
+		if !getAssignableRole(c, body, l) {
+			return
+		}
+
+		err = model.RevokeRole(c.Request.Context(), body.RoleId, body.UserId, body.ModuleId, body.ModuleGroupId, l, DatabasePool)
+		if err != nil {
+			middleware.RequestLog(c).Error(err)
+			c.Error(err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": c.Errors,
+			})
+			return
+		}
+
+		utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	}
+}
+
+/*
+ * Explain a user's effective permissions at a layer, each layer's overrides and, the
+ * result are returned with the flags named.
+ * Method: GET
+ * URL: `/roles/explain`
+ * Query Params: userId, layer, moduleId (optional), moduleGroupId (optional)
+ */
+func ExplainPermissionsHandler(c *gin.Context) {
+	userId, exists := c.GetQuery("userId")
+	if !exists {
+		c.Error(errors.New("missing query parameter userId"))
+	}
+
+	layer, err := security.ParseLayer(c.Query("layer"))
+	if err != nil {
+		c.Error(err)
+	}
+
+	if len(c.Errors) != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	explanation, err := model.ExplainPermissions(c.Request.Context(), userId, c.Query("moduleId"), c.Query("moduleGroupId"), layer, DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue getting permissions"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, explanation)
+}
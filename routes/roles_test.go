@@ -0,0 +1,113 @@
+package routes_test
+
+import (
+	"arcio/attendance-system/harness"
+	"arcio/attendance-system/model"
+	"arcio/attendance-system/security"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestCreateRolePrivelledgeEscalation(t *testing.T) {
+	h := harness.NewMemory(t)
+
+	// A user that can only manage roles
+	err := h.Seed(harness.Fixture{Users: []harness.FixtureUser{{Key: "role-manager",
+		ExternalId: "a3000002",
+		Fname:      "Rob",
+		Sname:      "Roles",
+		Email:      "rob@example.com"}},
+		Roles: []harness.FixtureRole{{Key: "role-manager",
+			Name:        "Role Manager",
+			Flags:       []string{"PERMS_MANAGE_ROLES"},
+			Assignments: []harness.FixtureAssignment{{User: "role-manager", Layer: security.Global}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	perms, err := model.GetRepositoryPermissions(context.Background(), h.Store.Permissions, h.UserId("role-manager"), "", "", security.Global)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing := security.Overrides(security.PERMS_VALID_MASK) &^ perms
+	if missing == 0 {
+		t.Fatal("Expected the role manager to be missing permissions")
+	}
+
+	body := map[string]interface{}{"name": "test role", "overrides": missing}
+	w, err := h.Request(http.MethodPost, "/roles/create", "role-manager", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusUnauthorized {
+		t.Error("Expected status code", http.StatusUnauthorized, "but got", w.Code, w.Body.String())
+	}
+}
+
+func TestExplainPermissions(t *testing.T) {
+	h := harness.NewPostgres(t)
+
+	w, err := h.Request(http.MethodGet, "/roles/explain?layer=global&userId="+h.UserId("lecturer"), "admin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected status code", http.StatusOK, "but got", w.Code, w.Body.String())
+	}
+
+	var body model.PermissionsExplanation
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body.Layers) != 1 || body.Layers[0].Layer != security.Global {
+		t.Error("Expected only the global layer but got", body.Layers)
+	}
+
+	// Bad layer
+	w, err = h.Request(http.MethodGet, "/roles/explain?layer=beans&userId="+h.UserId("lecturer"), "admin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusBadRequest {
+		t.Error("Expected status code", http.StatusBadRequest, "but got", w.Code)
+	}
+}
+
+// Attendance roles apply in every module so, module permissions cannot assign them
+func TestAssignAttendanceRoleNeedsGlobal(t *testing.T) {
+	h := harness.NewMemory(t)
+
+	err := h.Seed(harness.Fixture{Roles: []harness.FixtureRole{{Key: "module-role-manager",
+		Name:        "Module Role Manager",
+		Flags:       []string{"PERMS_MANAGE_ROLES", "PERMS_READ_SELF", "PERMS_CREATE_SELF", "PERMS_UPDATE_SELF"},
+		Assignments: []harness.FixtureAssignment{{User: "lecturer", Layer: security.Module, Module: "testing"}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := map[string]string{"role-id": h.Seeded.Roles["lecturer"].Id,
+		"user-id":   h.UserId("student"),
+		"module-id": h.ModuleId("testing")}
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		target := "/roles/attendance/assign"
+		if method == http.MethodDelete {
+			target = "/roles/attendance/revoke"
+		}
+
+		w, err := h.Request(method, target, "lecturer", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if w.Code != http.StatusUnauthorized {
+			t.Error("Expected status code", http.StatusUnauthorized, "for", target, "but got", w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,197 @@
+/*
+ * rooms.go contains handlers for endpoints under `/room`.
+ * Lessons are booked into rooms by id, see model/clashes.go for how bookings are checked.
+ * Anyone can read the rooms, changing them needs global permissions.
+ */
+
+package routes
+
+import (
+	"arcio/attendance-system/middleware"
+	"arcio/attendance-system/model"
+	"arcio/attendance-system/security"
+	"arcio/attendance-system/utils"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func addRoomRoutes(r *gin.Engine) {
+	roomRoutes := r.Group("/room")
+	roomRoutes.Use(middleware.CheckAuth(NonceManager))
+	roomRoutes.GET("/get", GetRoomsHandler)
+	roomRoutes.POST("/create", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_CREATE), CreateRoomHandler)
+	roomRoutes.PUT("/update", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_UPDATE), UpdateRoomHandler)
+	roomRoutes.DELETE("/delete", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_DELETE), DeleteRoomHandler)
+}
+
+type RoomBody struct {
+	RoomId   string   `json:"room-id"`
+	Name     string   `json:"name"`
+	Capacity int      `json:"capacity"`
+	Features []string `json:"features"`
+}
+
+type DeleteRoomBody struct {
+	RoomId string `json:"room-id"`
+}
+
+/*
+ * Sends the response for a room error, rooms that cannot be found are 404s.
+ */
+func roomError(c *gin.Context, err error, status int) {
+	if err == model.ErrRoomNotFound {
+		status = http.StatusNotFound
+	} else if status == http.StatusInternalServerError {
+		middleware.RequestLog(c).Error(err)
+		err = errors.New("issue with the rooms")
+	}
+
+	c.Error(err)
+	c.JSON(status, gin.H{
+		"errors": c.Errors,
+	})
+}
+
+/*
+ * Get the rooms, optionally only those that are big enough or, have some features.
+ * Method: GET
+ * URL: `/room/get`
+ * Query Params: roomId (optional), capacity (optional, the minimum capacity),
+ *	features (optional, comma separated, rooms must have all of them)
+ */
+func GetRoomsHandler(c *gin.Context) {
+	if c.Query("roomId") != "" {
+		room, err := model.GetRoom(c.Request.Context(), c.Query("roomId"), DatabasePool)
+		if err != nil {
+			roomError(c, err, http.StatusInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, room)
+		return
+	}
+
+	capacity := 0
+	if c.Query("capacity") != "" {
+		var err error
+		capacity, err = strconv.Atoi(c.Query("capacity"))
+		if err != nil {
+			roomError(c, errors.New("capacity must be a number"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	features := make([]string, 0)
+	if c.Query("features") != "" {
+		features = strings.Split(c.Query("features"), ",")
+	}
+
+	rooms, err := model.GetRooms(c.Request.Context(), capacity, features, DatabasePool)
+	if err != nil {
+		roomError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, rooms)
+}
+
+/*
+ * Create a room.
+ * Method: POST
+ * URL: `/room/create`
+ * Body Params: name, capacity, features (optional, list of strings)
+ */
+func CreateRoomHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body RoomBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	room := model.Room{Name: body.Name, Capacity: body.Capacity, Features: body.Features}
+	err = model.CreateRoom(c.Request.Context(), &room, DatabasePool)
+	if err != nil {
+		roomError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusCreated, room)
+}
+
+/*
+ * Update a room, the features are replaced. Lessons already booked into the room are not
+ * checked against the new capacity.
+ * Method: PUT
+ * URL: `/room/update`
+ * Body Params: room-id, name, capacity, features (optional, list of strings)
+ */
+func UpdateRoomHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body RoomBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	room := model.Room{Id: body.RoomId, Name: body.Name, Capacity: body.Capacity, Features: body.Features}
+	err = model.UpdateRoom(c.Request.Context(), &room, DatabasePool)
+	if err != nil {
+		roomError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, room)
+}
+
+/*
+ * Delete a room, lessons that were booked into it are left without a room.
+ * Method: DELETE
+ * URL: `/room/delete`
+ * Body Params: room-id
+ */
+func DeleteRoomHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body DeleteRoomBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	err = model.DeleteRoom(c.Request.Context(), body.RoomId, DatabasePool)
+	if err != nil {
+		roomError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
@@ -7,29 +7,54 @@ package routes
 
 import (
 	"arcio/attendance-system/config"
+	"arcio/attendance-system/logging"
 	"arcio/attendance-system/middleware"
+	"arcio/attendance-system/model"
 	"arcio/attendance-system/security"
 	"arcio/attendance-system/utils"
 	"github.com/easonlin404/limit"
 	"github.com/gin-gonic/gin"
+	"time"
 )
 
 var DatabasePool *utils.DatabasePool
+
+// The repositories for handlers that do not need Postgres, see model/repository.go
+var Store model.Repositories
 var GlobalConfig *config.Config
 var NonceManager *security.NonceManager
 
+/*
+ * Deadlines for routes that should not use REQUEST_TIMEOUT, 0 is no deadline. Streams stay open
+ * until the client goes away and, reports and, imports can read a lot of rows.
+ */
+var RouteTimeouts = map[string]time.Duration{
+	"/attendance/register/stream": 0,
+	"/module/import-roster":       2 * time.Minute,
+	"/report/module":              2 * time.Minute,
+	"/report/module-group":        2 * time.Minute,
+	"/report/student":             2 * time.Minute,
+	"/timetable/ical":             time.Minute,
+}
+
 /*
  * Initialises the router and its routes & groups, and the nonce
  */
 func InitRouter() *gin.Engine {
+	if Store.Permissions == nil {
+		Store = model.NewPostgresRepositories(DatabasePool)
+	}
+
 	router := gin.New()
 
-	//Setups recovery to catch `fatals`, and initialises logger.
-	router.Use(gin.Recovery())
-	router.Use(gin.Logger())
+	// Gives each request an id and, logs it, then sets up recovery to catch `fatals`.
+	// See middleware/request_log.go
+	router.Use(middleware.RequestLogger(logging.Default()))
+	router.Use(gin.RecoveryWithWriter(logging.Default().Writer(logging.ERROR)))
 
 	router.Use(middleware.CheckPreflight())
 	router.Use(limit.Limit(1000))
+	router.Use(middleware.Deadline(requestTimeout(), RouteTimeouts))
 
 	router.GET("/get-nonce", GetNonceHandler)
 	router.GET("/status", StatusHandler)
@@ -42,6 +67,22 @@ func InitRouter() *gin.Engine {
 	addTimetableRoutes(router)
 	addAttendanceRoutes(router)
 	addLessonRoutes(router)
+	addGroupLessonRoutes(router)
+	addRoleRoutes(router)
+	addReportRoutes(router)
+	addCalendarRoutes(router)
+	addRoomRoutes(router)
+	addAlertRoutes(router)
+	addAbsenceRoutes(router)
+	addWebhookRoutes(router)
 
 	return router
 }
+
+// The default deadline for requests, see config.DEFAULT_REQUEST_TIMEOUT
+func requestTimeout() time.Duration {
+	if GlobalConfig == nil {
+		return config.DEFAULT_REQUEST_TIMEOUT * time.Second
+	}
+	return time.Duration(GlobalConfig.RequestTimeout) * time.Second
+}
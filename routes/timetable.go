@@ -7,7 +7,6 @@ import (
 	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
-	"log"
 	"net/http"
 	"time"
 )
@@ -21,19 +20,22 @@ func addTimetableRoutes(r *gin.Engine) {
 	timetableRoutes.GET("/get-timetable-jwt", middleware.CheckAuth(NonceManager), CalenderJwtHandler)
 	timetableRoutes.GET("/upcoming-lessons", middleware.CheckAuth(NonceManager), UpcomingLessonsHandler)
 	timetableRoutes.GET("/happening-now", middleware.CheckAuth(NonceManager), GetActiveLessonsHandler)
+	timetableRoutes.GET("/week", middleware.CheckAuth(NonceManager), TeachingWeekLessonsHandler)
+	timetableRoutes.GET("/clashes", middleware.CheckAuth(NonceManager), TimetableClashesHandler)
 }
 
 /*
- * Provide lesson that student can import to their calendar.
+ * Provide lesson that student can import to their calendar, repeating lessons are sent as
+ * one event with an RRULE.
  * Method: GET
  * URL: `/timetable/ical`
  */
 func CalenderExportHandler(c *gin.Context) {
 	claims := c.MustGet("claims").(security.Claims)
 
-	lessons, err := model.GetLessons(claims.Uuid, DatabasePool)
+	icalLessons, err := model.GetIcalTimetable(c.Request.Context(), claims.Uuid, DatabasePool)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(errors.New("issue getting lessons"))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": c.Errors,
@@ -41,7 +43,6 @@ func CalenderExportHandler(c *gin.Context) {
 		return
 	}
 
-	icalLessons := model.ExportLessonsAsIcal(lessons)
 	c.String(http.StatusOK, icalLessons)
 }
 
@@ -66,7 +67,7 @@ func CalenderJwtHandler(c *gin.Context) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS512, nclaims)
 	signedToken, err := token.SignedString([]byte(GlobalConfig.JwtIcalSecret))
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(errors.New("cannot get jwt token"))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": c.Errors,
@@ -85,9 +86,9 @@ func CalenderJwtHandler(c *gin.Context) {
 func UpcomingLessonsHandler(c *gin.Context) {
 	claims := c.MustGet("claims").(security.Claims)
 
-	lessons, err := model.GetLessons(claims.Uuid, DatabasePool)
+	lessons, err := model.GetLessons(c.Request.Context(), claims.Uuid, DatabasePool)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
@@ -95,9 +96,9 @@ func UpcomingLessonsHandler(c *gin.Context) {
 		return
 	}
 
-	lessonDetail, err := model.GetLessonsDetails(lessons, DatabasePool)
+	lessonDetail, err := model.GetLessonsDetails(c.Request.Context(), lessons, DatabasePool)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errors": c.Errors,
@@ -114,9 +115,9 @@ func UpcomingLessonsHandler(c *gin.Context) {
  * URL: `/timetable/happening-now`
  */
 func GetActiveLessonsHandler(c *gin.Context) {
-	activeLessons, err := model.GetAllCurrentLessons(DatabasePool)
+	activeLessons, err := model.GetAllCurrentLessons(c.Request.Context(), DatabasePool)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(errors.New("issue getting active lessons"))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errors": c.Errors,
@@ -125,3 +126,70 @@ func GetActiveLessonsHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, activeLessons)
 }
+
+/*
+ * Returns the user's lessons in a teaching week, see `/calendar/weeks`.
+ * Method: GET
+ * URL: `/timetable/week`
+ * Query Params: week, yearId (optional, the current year by default)
+ */
+func TeachingWeekLessonsHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	if c.Query("week") == "" || c.Query("term") != "" {
+		c.Error(errors.New("missing query parameter week"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	from, to, _, err := getCalendarRange(c)
+	if err != nil {
+		calendarError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	lessons, err := model.GetLessonsBetween(c.Request.Context(), claims.Uuid, from, to, DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue getting lessons"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	lessonDetail, err := model.GetLessonsDetails(c.Request.Context(), lessons, DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue getting lessons"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, lessonDetail)
+}
+
+/*
+ * Returns the pairs of upcoming lessons that overlap in the user's timetable
+ * Method: GET
+ * URL: `/timetable/clashes`
+ */
+func TimetableClashesHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	clashes, err := model.GetUserTimetableClashes(c.Request.Context(), claims.Uuid, DatabasePool)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(errors.New("issue getting timetable clashes"))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, clashes)
+}
@@ -1,185 +1,102 @@
-package routes
+package routes_test
 
 import (
-	"arcio/attendance-system/config"
+	"arcio/attendance-system/harness"
 	"arcio/attendance-system/model"
 	"arcio/attendance-system/security"
-	"arcio/attendance-system/utils"
 	"encoding/json"
-	"github.com/gin-gonic/gin"
-	"log"
 	"net/http"
-	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 )
 
-func init() {
-	userConfig, err := config.LoadConfig()
-	if err != nil {
-		log.Println("Failed to load config from .env")
-		log.Println(err)
-	}
-	GlobalConfig = &userConfig
+func TestCalenderExportHandler(t *testing.T) {
+	h := harness.NewPostgres(t)
+	h.Config.JwtIcalSecret = "ical secret"
 
-	pooltmp, err := utils.InitDatabasePool(userConfig)
+	w, err := h.Request(http.MethodGet, "/timetable/get-timetable-jwt", "student", nil)
 	if err != nil {
-		log.Println("Failed to init database pool")
-		log.Println(err)
-		return
+		t.Fatal(err)
 	}
-	DatabasePool = pooltmp
 
-	stmt, err := pooltmp.Database.Prepare("SELECT id, external_id, firstname, " +
-		"surname, email, creation_time, edit_time FROM users LIMIT 1;")
-	if err != nil {
-		log.Println(err)
-		return
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected status code", http.StatusOK, "but got", w.Code, w.Body.String())
 	}
-	defer stmt.Close()
 
-	userRes, err := stmt.Query()
+	w, err = h.Request(http.MethodGet, "/timetable/ical?ical-auth="+url.QueryEscape(w.Body.String()), "", nil)
 	if err != nil {
-		log.Println(err)
-		return
+		t.Fatal(err)
 	}
-	defer userRes.Close()
 
-	if userRes.Next() {
-		userRes.Scan(&user.InternalId, &user.ExternalId, &user.Fname, &user.Sname,
-			&user.Email, &user.CreationTime, &user.EditTime)
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected status code", http.StatusOK, "but got", w.Code, w.Body.String())
 	}
-}
-
-func TestCalenderExportHandler(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, "/timetable/ical", nil)
-	w := httptest.NewRecorder()
-	router := gin.New()
-
-	router.Use(func() gin.HandlerFunc {
-		return func(c *gin.Context) {
-			c.Set("claims", security.Claims{
-				Uuid: user.InternalId,
-			})
-		}
-	}())
 
-	router.GET("/timetable/ical", CalenderExportHandler)
-	router.ServeHTTP(w, req)
-
-	if w.Result().StatusCode != http.StatusOK {
-		t.Error("Expected status code", http.StatusOK, "but got", w.Result().StatusCode)
-
-		var body map[string]interface{}
-		err := json.Unmarshal(w.Body.Bytes(), &body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if val, ok := body["errors"]; ok {
-			t.Fatal("Endpoint returned errors", val)
-		}
+	if !strings.Contains(w.Body.String(), "BEGIN:VCALENDAR") || !strings.Contains(w.Body.String(), "Unit tests") {
+		t.Error("Expected the student's lessons in the calendar but got", w.Body.String())
 	}
-
-	// Body is just a string so isn't being checked
 }
 
 func TestCalenderJwt(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, "/timetable/get-timetable-jwt", nil)
-	w := httptest.NewRecorder()
-	router := gin.New()
-
-	router.Use(func() gin.HandlerFunc {
-		return func(c *gin.Context) {
-			c.Set("claims", security.Claims{
-				Uuid:      user.InternalId,
-				Firstname: user.Fname,
-				Surname:   user.Sname,
-			})
-		}
-	}())
-
-	router.GET("/timetable/get-timetable-jwt", CalenderJwtHandler)
-	router.ServeHTTP(w, req)
+	h := harness.NewPostgres(t)
+	h.Config.JwtIcalSecret = "ical secret"
 
-	if w.Result().StatusCode != http.StatusOK {
-		t.Error("Expected status code", http.StatusOK, "but got", w.Result().StatusCode)
+	w, err := h.Request(http.MethodGet, "/timetable/get-timetable-jwt", "student", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		var body map[string]interface{}
-		err := json.Unmarshal(w.Body.Bytes(), &body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if val, ok := body["errors"]; ok {
-			t.Fatal("Endpoint returned errors", val)
-		}
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected status code", http.StatusOK, "but got", w.Code, w.Body.String())
 	}
 
 	token := w.Body.String()
-	_, err := security.CheckIcalJwt(token, *GlobalConfig)
+	claims, err := security.CheckIcalJwt(token, h.Config)
 	if err != nil {
 		t.Error(err)
 		t.Error("Returned invalid jwt token")
 	}
+
+	if claims.Uuid != h.UserId("student") {
+		t.Error("Expected a token for the student but got", claims.Uuid)
+	}
 }
 
 func TestUpcomingLessons(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, "/timetable/upcoming-lessons", nil)
-	w := httptest.NewRecorder()
-	router := gin.New()
-
-	router.Use(func() gin.HandlerFunc {
-		return func(c *gin.Context) {
-			c.Set("claims", security.Claims{
-				Uuid:      user.InternalId,
-				Firstname: user.Fname,
-				Surname:   user.Sname,
-			})
-		}
-	}())
+	h := harness.NewPostgres(t)
 
-	router.GET("/timetable/upcoming-lessons", UpcomingLessonsHandler)
-	router.ServeHTTP(w, req)
-
-	if w.Result().StatusCode != http.StatusOK {
-		t.Error("Expected status code", http.StatusOK, "but got", w.Result().StatusCode)
+	w, err := h.Request(http.MethodGet, "/timetable/upcoming-lessons", "student", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		var body map[string]interface{}
-		err := json.Unmarshal(w.Body.Bytes(), &body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if val, ok := body["errors"]; ok {
-			t.Fatal("Endpoint returned errors", val)
-		}
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected status code", http.StatusOK, "but got", w.Code, w.Body.String())
 	}
 }
 
 func TestGetActiveLessons(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, "/timetable/happening-now", nil)
-	w := httptest.NewRecorder()
-	router := gin.New()
-
-	router.GET("/timetable/happening-now", GetActiveLessonsHandler)
-	router.ServeHTTP(w, req)
+	h := harness.NewPostgres(t)
 
-	if w.Result().StatusCode != http.StatusOK {
-		t.Error("Expected status code", http.StatusOK, "but got", w.Result().StatusCode)
+	w, err := h.Request(http.MethodGet, "/timetable/happening-now", "student", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		var body map[string]interface{}
-		err := json.Unmarshal(w.Body.Bytes(), &body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if val, ok := body["errors"]; ok {
-			t.Fatal("Endpoint returned errors", val)
-		}
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected status code", http.StatusOK, "but got", w.Code, w.Body.String())
 	}
 
 	// Check can parse to lessons
 	var body []model.ActualLesson
-	err := json.Unmarshal(w.Body.Bytes(), &body)
+	err = json.Unmarshal(w.Body.Bytes(), &body)
 	if err != nil {
-		t.Fatal("failed to parse response to lesson")
-		t.Fatal(err)
+		t.Fatal("failed to parse response to lesson", err)
+	}
+
+	if len(body) != 1 || body[0].Id != h.LessonId("current") {
+		t.Error("Expected the current lesson but got", body)
 	}
 
 	// Check no field are empty
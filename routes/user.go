@@ -7,20 +7,18 @@ package routes
 
 import (
 	"arcio/attendance-system/middleware"
-	"arcio/attendance-system/model"
 	"arcio/attendance-system/security"
 	"errors"
 	"github.com/gin-gonic/gin"
-	"log"
 	"net/http"
 )
 
 func addUserRoutes(rg *gin.Engine) {
 	userRoutes := rg.Group("/user")
 	userRoutes.Use(middleware.CheckAuth(NonceManager))
-	userRoutes.GET("/get", middleware.CheckPermissions(security.Global, DatabasePool, security.PERMS_CAN_READ), GetUsersHandler)
-	userRoutes.GET("/get-modules", middleware.CheckPermissions(security.Global, DatabasePool, security.PERMS_CAN_READ), GetUserModulesHandler)
-	userRoutes.GET("/module-percentage", middleware.CheckPermissions(security.Module, DatabasePool, security.PERMS_CAN_READ), middleware.CheckPermissions(security.Attendance, DatabasePool, security.PERMS_CAN_READ), GetUserAttendance)
+	userRoutes.GET("/get", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_READ), GetUsersHandler)
+	userRoutes.GET("/get-modules", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_READ), GetUserModulesHandler)
+	userRoutes.GET("/module-percentage", middleware.CheckPermissions(security.Module, Store.Permissions, security.PERMS_CAN_READ), middleware.CheckPermissions(security.Attendance, Store.Permissions, security.PERMS_CAN_READ), GetUserAttendance)
 }
 
 /*
@@ -29,10 +27,10 @@ func addUserRoutes(rg *gin.Engine) {
  * URL: `/user/get`
  */
 func GetUsersHandler(c *gin.Context) {
-	users, err := model.GetUsers(DatabasePool)
+	users, err := Store.Users.GetUsers(c.Request.Context())
 	if err != nil {
 		c.Error(errors.New("issue getting users from database"))
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 	}
 
 	if len(c.Errors) != 0 {
@@ -53,9 +51,9 @@ func GetUsersHandler(c *gin.Context) {
 func GetUserModulesHandler(c *gin.Context) {
 	claims := c.MustGet("claims").(security.Claims)
 
-	modules, err := model.GetModulesForUser(claims.Uuid, DatabasePool)
+	modules, err := Store.Modules.GetUserModules(c.Request.Context(), claims.Uuid)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(errors.New("cannot get modules for user"))
 	}
 
@@ -77,9 +75,9 @@ func GetUserModulesHandler(c *gin.Context) {
 func GetUserAttendance(c *gin.Context) {
 	claims := c.MustGet("claims").(security.Claims)
 
-	attendance, err := model.GetStudentAttendancePercentages(claims.Uuid, DatabasePool)
+	attendance, err := Store.Attendance.GetStudentAttendance(c.Request.Context(), claims.Uuid)
 	if err != nil {
-		log.Println(err)
+		middleware.RequestLog(c).Error(err)
 		c.Error(errors.New("unable to get student attendance"))
 	}
 
@@ -1,91 +1,42 @@
-package routes
+package routes_test
 
 import (
-	"arcio/attendance-system/config"
+	"arcio/attendance-system/harness"
 	"arcio/attendance-system/model"
-	"arcio/attendance-system/security"
-	"arcio/attendance-system/utils"
 	"encoding/json"
-	"github.com/gin-gonic/gin"
-	"log"
 	"net/http"
-	"net/http/httptest"
 	"testing"
 )
 
-func init() {
-
-	userConfig, err := config.LoadConfig()
-
-	if err != nil {
-		log.Println("Failed to load config from env")
-		log.Println(err)
-		return
-	}
-
-	pooltmp, err := utils.InitDatabasePool(userConfig)
-
-	if err != nil {
-		log.Println("Failed to init database pool")
-		log.Println(err)
-		return
-	}
-
-	DatabasePool = pooltmp
-
-	stmt, err := pooltmp.Database.Prepare("SELECT id, external_id, firstname, " +
-		"surname, email, creation_time, edit_time FROM users LIMIT 1;")
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	defer stmt.Close()
+func TestGetUsers(t *testing.T) {
+	h := harness.NewMemory(t)
 
-	userRes, err := stmt.Query()
+	w, err := h.Request(http.MethodGet, "/user/get", "admin", nil)
 	if err != nil {
-		log.Println(err)
-		return
-	}
-	defer userRes.Close()
-
-	if userRes.Next() {
-		userRes.Scan(&user.InternalId, &user.ExternalId, &user.Fname, &user.Sname,
-			&user.Email, &user.CreationTime, &user.EditTime)
+		t.Fatal(err)
 	}
-}
 
-func TestGetUsers(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, "/user/get", nil)
-	w := httptest.NewRecorder()
-	router := gin.New()
-	router.GET("/user/get", GetUsersHandler)
-	router.ServeHTTP(w, req)
-
-	if w.Result().StatusCode != http.StatusOK {
-		t.Error("Expected status code", http.StatusOK, "but got", w.Result().StatusCode)
-
-		var body map[string]interface{}
-		err := json.Unmarshal(w.Body.Bytes(), &body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if val, ok := body["errors"]; ok {
-			t.Fatal("Endpoint returned errors", val)
-		}
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected status code", http.StatusOK, "but got", w.Code, w.Body.String())
 	}
 
 	var body []model.User
-	err := json.Unmarshal(w.Body.Bytes(), &body)
+	err = json.Unmarshal(w.Body.Bytes(), &body)
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	if len(body) != 4 {
+		t.Error("Expected the fixture users but got", body)
+	}
+
 	// Check no fields are empty in users
 	var userMap []map[string]interface{}
 	err = json.Unmarshal(w.Body.Bytes(), &userMap)
 	if err != nil {
 		t.Fatal(err)
 	}
+
 	for _, user := range userMap {
 		for k, v := range user {
 			if v == nil || v == "" {
@@ -96,41 +47,28 @@ func TestGetUsers(t *testing.T) {
 }
 
 func TestGetUserModules(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, "/user/get-modules", nil)
-	w := httptest.NewRecorder()
-	router := gin.New()
-
-	router.Use(func() gin.HandlerFunc {
-		return func(c *gin.Context) {
-			c.Set("claims", security.Claims{
-				Uuid: user.InternalId,
-			})
-		}
-	}())
-
-	router.GET("/user/get-modules", GetUserModulesHandler)
-	router.ServeHTTP(w, req)
+	h := harness.NewMemory(t)
 
-	if w.Result().StatusCode != http.StatusOK {
-		t.Error("Expected status code", http.StatusOK, "but got", w.Result().StatusCode)
+	w, err := h.Request(http.MethodGet, "/user/get-modules", "student", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		var body map[string]interface{}
-		err := json.Unmarshal(w.Body.Bytes(), &body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if val, ok := body["errors"]; ok {
-			t.Fatal("Endpoint returned errors", val)
-		}
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected status code", http.StatusOK, "but got", w.Code, w.Body.String())
 	}
 
 	// Check can parse to module
 	var body []model.Module
-	err := json.Unmarshal(w.Body.Bytes(), &body)
+	err = json.Unmarshal(w.Body.Bytes(), &body)
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	if len(body) != 1 || body[0].Id != h.ModuleId("testing") {
+		t.Error("Expected the student's module but got", body)
+	}
+
 	// Check no fields are empty
 	var moduleMap []map[string]interface{}
 	err = json.Unmarshal(w.Body.Bytes(), &moduleMap)
@@ -148,37 +86,20 @@ func TestGetUserModules(t *testing.T) {
 }
 
 func TestGetUserAttendance(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, "/user/module-percentage", nil)
-	w := httptest.NewRecorder()
-	router := gin.New()
-
-	router.Use(func() gin.HandlerFunc {
-		return func(c *gin.Context) {
-			c.Set("claims", security.Claims{
-				Uuid: user.InternalId,
-			})
-		}
-	}())
+	h := harness.NewMemory(t)
 
-	router.GET("/user/module-percentage", GetUserAttendance)
-	router.ServeHTTP(w, req)
-
-	if w.Result().StatusCode != http.StatusOK {
-		t.Error("Expected status code", http.StatusOK, "but got", w.Result().StatusCode)
+	w, err := h.Request(http.MethodGet, "/user/module-percentage", "student", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		var body map[string]interface{}
-		err := json.Unmarshal(w.Body.Bytes(), &body)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if val, ok := body["errors"]; ok {
-			t.Fatal("Endpoint returned errors", val)
-		}
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected status code", http.StatusOK, "but got", w.Code, w.Body.String())
 	}
 
 	// Check can parse to AttendanceRet
 	var body model.AttendanceRet
-	err := json.Unmarshal(w.Body.Bytes(), &body)
+	err = json.Unmarshal(w.Body.Bytes(), &body)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -192,7 +113,6 @@ func TestGetUserAttendance(t *testing.T) {
 
 	// Force go to treat as map
 	moduleAttendance := attendanceMap["module-attendance"].(map[string]interface{})
-
 	for _, att := range moduleAttendance {
 		// Force got to treat as map
 		newAtt := att.(map[string]interface{})
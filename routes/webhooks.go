@@ -0,0 +1,275 @@
+/*
+ * webhooks.go contains handlers for endpoints under `/webhook`.
+ * Other systems subscribe to events and, are sent them as signed JSON, see model/webhooks.go.
+ * Managing webhooks needs global permissions.
+ */
+
+package routes
+
+import (
+	"arcio/attendance-system/middleware"
+	"arcio/attendance-system/model"
+	"arcio/attendance-system/security"
+	"arcio/attendance-system/utils"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func addWebhookRoutes(r *gin.Engine) {
+	webhookRoutes := r.Group("/webhook")
+	webhookRoutes.Use(middleware.CheckAuth(NonceManager))
+	webhookRoutes.GET("/get", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_READ_ALL), GetWebhooksHandler)
+	webhookRoutes.POST("/create", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_CREATE), CreateWebhookHandler)
+	webhookRoutes.PUT("/update", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_UPDATE), UpdateWebhookHandler)
+	webhookRoutes.DELETE("/delete", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_DELETE), DeleteWebhookHandler)
+	webhookRoutes.GET("/deliveries", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_READ_ALL), GetWebhookDeliveriesHandler)
+	webhookRoutes.GET("/log", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_READ_ALL), GetWebhookDeliveryLogHandler)
+	webhookRoutes.GET("/dead-letters", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_READ_ALL), GetWebhookDeadLettersHandler)
+	webhookRoutes.POST("/retry", middleware.CheckPermissions(security.Global, Store.Permissions, security.PERMS_CAN_UPDATE), RetryWebhookDeadLetterHandler)
+}
+
+type WebhookBody struct {
+	WebhookId   string   `json:"webhook-id"`
+	Url         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description string   `json:"description,omitempty"`
+	Active      *bool    `json:"active,omitempty"`
+}
+
+type DeleteWebhookBody struct {
+	WebhookId string `json:"webhook-id"`
+}
+
+type RetryWebhookBody struct {
+	DeadLetterId string `json:"dead-letter-id"`
+}
+
+/*
+ * Sends the response for a webhook error, webhooks and, dead letters that cannot be found are
+ * 404s.
+ */
+func webhookError(c *gin.Context, err error, status int) {
+	if err == model.ErrWebhookNotFound || err == model.ErrDeadLetterNotFound {
+		status = http.StatusNotFound
+	} else if status == http.StatusInternalServerError {
+		middleware.RequestLog(c).Error(err)
+		err = errors.New("issue with the webhooks")
+	}
+
+	c.Error(err)
+	c.JSON(status, gin.H{
+		"errors": c.Errors,
+	})
+}
+
+/*
+ * Get the webhooks, their secrets are not shown.
+ * Method: GET
+ * URL: `/webhook/get`
+ * Query Params: webhookId (optional)
+ */
+func GetWebhooksHandler(c *gin.Context) {
+	if c.Query("webhookId") != "" {
+		webhook, err := model.GetWebhook(c.Request.Context(), c.Query("webhookId"), DatabasePool)
+		if err != nil {
+			webhookError(c, err, http.StatusInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, webhook)
+		return
+	}
+
+	webhooks, err := model.GetWebhooks(c.Request.Context(), DatabasePool)
+	if err != nil {
+		webhookError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, webhooks)
+}
+
+/*
+ * Create a webhook, the response has the secret that deliveries are signed with. It is not
+ * shown again.
+ * Method: POST
+ * URL: `/webhook/create`
+ * Body Params: url, events (list of events), description (optional)
+ */
+func CreateWebhookHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body WebhookBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	webhook := model.Webhook{Url: body.Url, Events: body.Events, Description: body.Description}
+	err = model.CreateWebhook(c.Request.Context(), &webhook, claims.Uuid, DatabasePool)
+	if err != nil {
+		webhookError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusCreated, webhook)
+}
+
+/*
+ * Update a webhook, the events are replaced. Inactive webhooks keep their deliveries until they
+ * are active again.
+ * Method: PUT
+ * URL: `/webhook/update`
+ * Body Params: webhook-id, url, events (list of events), description (optional),
+ *	active (optional, true by default)
+ */
+func UpdateWebhookHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body WebhookBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	webhook := model.Webhook{Id: body.WebhookId, Url: body.Url, Events: body.Events, Description: body.Description, Active: true}
+	if body.Active != nil {
+		webhook.Active = *body.Active
+	}
+
+	err = model.UpdateWebhook(c.Request.Context(), &webhook, DatabasePool)
+	if err != nil {
+		webhookError(c, err, http.StatusBadRequest)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, webhook)
+}
+
+/*
+ * Delete a webhook and, its deliveries.
+ * Method: DELETE
+ * URL: `/webhook/delete`
+ * Body Params: webhook-id
+ */
+func DeleteWebhookHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body DeleteWebhookBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	err = model.DeleteWebhook(c.Request.Context(), body.WebhookId, DatabasePool)
+	if err != nil {
+		webhookError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+/*
+ * Get the latest deliveries for a webhook, newest first.
+ * Method: GET
+ * URL: `/webhook/deliveries`
+ * Query Params: webhookId
+ */
+func GetWebhookDeliveriesHandler(c *gin.Context) {
+	deliveries, err := model.GetWebhookDeliveries(c.Request.Context(), c.Query("webhookId"), DatabasePool)
+	if err != nil {
+		webhookError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+/*
+ * Get the attempts to send a delivery, oldest first.
+ * Method: GET
+ * URL: `/webhook/log`
+ * Query Params: deliveryId
+ */
+func GetWebhookDeliveryLogHandler(c *gin.Context) {
+	attempts, err := model.GetWebhookDeliveryLog(c.Request.Context(), c.Query("deliveryId"), DatabasePool)
+	if err != nil {
+		webhookError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, attempts)
+}
+
+/*
+ * Get the deliveries that ran out of attempts, newest first.
+ * Method: GET
+ * URL: `/webhook/dead-letters`
+ */
+func GetWebhookDeadLettersHandler(c *gin.Context) {
+	deadLetters, err := model.GetWebhookDeadLetters(c.Request.Context(), DatabasePool)
+	if err != nil {
+		webhookError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, deadLetters)
+}
+
+/*
+ * Send a dead letter again, it gets a new set of attempts.
+ * Method: POST
+ * URL: `/webhook/retry`
+ * Body Params: dead-letter-id
+ */
+func RetryWebhookDeadLetterHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(security.Claims)
+
+	var body RetryWebhookBody
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		middleware.RequestLog(c).Error(err)
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": c.Errors,
+		})
+		return
+	}
+
+	err = model.RetryWebhookDeadLetter(c.Request.Context(), body.DeadLetterId, DatabasePool)
+	if err != nil {
+		webhookError(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	utils.UpdateLogs(claims, c.Request, DatabasePool, GlobalConfig)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
@@ -0,0 +1,96 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+/*
+ * Check-in codes are TOTP style (RFC 6238) codes that rotate every period.
+ * Each code is derived from a HMAC of the session id and, the current time step
+ * with the server's check-in secret so that codes cannot be guessed or reused
+ * across sessions.
+ */
+
+const CHECKIN_CODE_DIGITS = 6
+
+// The amount of previous time steps that are still accepted, this stops students
+// who scanned a code just before it rotated from being rejected.
+const CHECKIN_CODE_DRIFT = 1
+
+const MIN_CHECKIN_CODE_PERIOD = 5 * time.Second
+
+/*
+ * Returns the time step that t falls in for a given code period.
+ */
+func CheckinCodeStep(t time.Time, period time.Duration) int64 {
+	seconds := int64(period / time.Second)
+	if seconds <= 0 {
+		seconds = 1
+	}
+
+	return t.Unix() / seconds
+}
+
+/*
+ * Returns the time at which the code for the time step that t falls in expires.
+ */
+func CheckinCodeExpiry(t time.Time, period time.Duration) time.Time {
+	seconds := int64(period / time.Second)
+	if seconds <= 0 {
+		seconds = 1
+	}
+
+	return time.Unix((CheckinCodeStep(t, period)+1)*seconds, 0)
+}
+
+/*
+ * Generates the code for a session at a time step.
+ *
+ * @param secret    the server check-in secret
+ * @param sessionId the check-in session the code is for
+ * @param step      the time step, see CheckinCodeStep
+ * @return string   the zero padded code
+ */
+func GenerateCheckinCode(secret []byte, sessionId string, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(sessionId))
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation as per RFC 4226
+	offset := sum[len(sum)-1] & 0x0F
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7FFFFFFF
+
+	mod := uint32(1)
+	for i := 0; i < CHECKIN_CODE_DIGITS; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", CHECKIN_CODE_DIGITS, bin%mod)
+}
+
+/*
+ * Checks that a code is valid for a session at the time now, allowing for
+ * CHECKIN_CODE_DRIFT previous codes.
+ */
+func CheckCheckinCode(secret []byte, sessionId string, period time.Duration, code string, now time.Time) bool {
+	step := CheckinCodeStep(now, period)
+	valid := false
+
+	// Do not short circuit so the time taken does not depend on which code matched
+	for i := int64(0); i <= CHECKIN_CODE_DRIFT; i++ {
+		expected := GenerateCheckinCode(secret, sessionId, step-i)
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			valid = true
+		}
+	}
+
+	return valid
+}
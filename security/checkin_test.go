@@ -0,0 +1,88 @@
+package security
+
+import (
+	"testing"
+	"time"
+)
+
+var checkinSecret = []byte("this is a test secret")
+
+func TestCheckinCodeFormat(t *testing.T) {
+	for i := int64(0); i < 1000; i++ {
+		code := GenerateCheckinCode(checkinSecret, "session", i)
+		if len(code) != CHECKIN_CODE_DIGITS {
+			t.Logf("Code %s has the wrong length", code)
+			t.Fail()
+		}
+	}
+}
+
+func TestCheckinCodeIsDeterministic(t *testing.T) {
+	a := GenerateCheckinCode(checkinSecret, "session", 123)
+	b := GenerateCheckinCode(checkinSecret, "session", 123)
+	if a != b {
+		t.Log("The same session and, step gave different codes")
+		t.Fail()
+	}
+}
+
+func TestCheckinCodeDependsOnSession(t *testing.T) {
+	same := 0
+	for i := int64(0); i < 100; i++ {
+		if GenerateCheckinCode(checkinSecret, "session a", i) == GenerateCheckinCode(checkinSecret, "session b", i) {
+			same++
+		}
+	}
+
+	if same > 1 {
+		t.Logf("%d codes were shared between sessions", same)
+		t.Fail()
+	}
+}
+
+func TestCheckCheckinCode(t *testing.T) {
+	period := 30 * time.Second
+	now := time.Unix(1000000020, 0)
+	code := GenerateCheckinCode(checkinSecret, "session", CheckinCodeStep(now, period))
+
+	if !CheckCheckinCode(checkinSecret, "session", period, code, now) {
+		t.Log("The current code was rejected")
+		t.Fail()
+	}
+
+	if !CheckCheckinCode(checkinSecret, "session", period, code, now.Add(period)) {
+		t.Log("The previous code was rejected within the drift")
+		t.Fail()
+	}
+
+	if CheckCheckinCode(checkinSecret, "session", period, code, now.Add(period*(CHECKIN_CODE_DRIFT+1))) {
+		t.Log("An expired code was accepted")
+		t.Fail()
+	}
+
+	if CheckCheckinCode([]byte("another secret"), "session", period, code, now) {
+		t.Log("A code from another secret was accepted")
+		t.Fail()
+	}
+
+	if CheckCheckinCode(checkinSecret, "session", period, "", now) {
+		t.Log("An empty code was accepted")
+		t.Fail()
+	}
+}
+
+func TestCheckinCodeExpiry(t *testing.T) {
+	period := 30 * time.Second
+	now := time.Unix(1000000020, 0)
+	expiry := CheckinCodeExpiry(now, period)
+
+	if !expiry.After(now) || expiry.Sub(now) > period {
+		t.Logf("Expiry %s is not in the current period", expiry)
+		t.Fail()
+	}
+
+	if CheckinCodeStep(expiry, period) != CheckinCodeStep(now, period)+1 {
+		t.Log("The expiry is not the start of the next step")
+		t.Fail()
+	}
+}
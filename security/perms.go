@@ -1,6 +1,7 @@
 package security
 
 import (
+	"errors"
 	"math/bits"
 )
 
@@ -22,6 +23,47 @@ func (l Layer) IsValid() bool {
 	return false
 }
 
+var layerNames = map[Layer]string{
+	Global:      "global",
+	Module:      "module",
+	ModuleGroup: "module-group",
+	Attendance:  "attendance",
+}
+
+func (l Layer) String() string {
+	name, found := layerNames[l]
+	if !found {
+		return "invalid"
+	}
+
+	return name
+}
+
+// Layers are shown by name in JSON
+func (l Layer) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *Layer) UnmarshalText(text []byte) error {
+	parsed, err := ParseLayer(string(text))
+	if err != nil {
+		return err
+	}
+
+	*l = parsed
+	return nil
+}
+
+func ParseLayer(name string) (Layer, error) {
+	for l, layerName := range layerNames {
+		if layerName == name {
+			return l, nil
+		}
+	}
+
+	return Global, errors.New("Invalid layer " + name)
+}
+
 type Overrides uint32
 
 // See https://docs.arcio.uk/attendance-system/perms for more detail
@@ -51,7 +93,63 @@ const PERMS_MANAGE_ROLES = 1 << 10
 // Attendance permissions
 const PERMS_ATTENDANCE_ALLOW_PAST_MARK = 1 << 11 // whether a user can change historical records
 
-const PERMS_VALID_MASK = 0b11111111111 // 11 ones I hope lmao
+const PERMS_VALID_MASK = 0b111111111111 // 12 ones, one for each flag above
+
+// Names of each flag for explaining permissions, see Overrides.Flags
+var permsFlagNames = []struct {
+	Flag Overrides
+	Name string
+}{
+	{PERMS_CREATE_SELF, "PERMS_CREATE_SELF"},
+	{PERMS_READ_SELF, "PERMS_READ_SELF"},
+	{PERMS_UPDATE_SELF, "PERMS_UPDATE_SELF"},
+	{PERMS_DELETE_SELF, "PERMS_DELETE_SELF"},
+	{PERMS_CREATE_CHILDREN, "PERMS_CREATE_CHILDREN"},
+	{PERMS_READ_CHILDREN, "PERMS_READ_CHILDREN"},
+	{PERMS_UPDATE_CHILDREN, "PERMS_UPDATE_CHILDREN"},
+	{PERMS_DELETE_CHILDREN, "PERMS_DELETE_CHILDREN"},
+	{PERMS_READ_ALL_SELF, "PERMS_READ_ALL_SELF"},
+	{PERMS_READ_ALL_CHILDREN, "PERMS_READ_ALL_CHILDREN"},
+	{PERMS_MANAGE_ROLES, "PERMS_MANAGE_ROLES"},
+	{PERMS_ATTENDANCE_ALLOW_PAST_MARK, "PERMS_ATTENDANCE_ALLOW_PAST_MARK"},
+}
+
+/*
+ * Returns the names of the flags that are set, bits outside of PERMS_VALID_MASK are ignored.
+ */
+func (o Overrides) Flags() []string {
+	ret := make([]string, 0)
+	for _, flag := range permsFlagNames {
+		if o&flag.Flag != 0 {
+			ret = append(ret, flag.Name)
+		}
+	}
+
+	return ret
+}
+
+/*
+ * Combines flags by name, i.e: PERMS_READ_SELF. See Overrides.Flags
+ */
+func ParseFlags(names []string) (Overrides, error) {
+	var ret Overrides
+	for _, name := range names {
+		found := false
+		for _, flag := range permsFlagNames {
+			if flag.Name == name {
+				ret |= flag.Flag
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return PERMS_NONE, errors.New("Invalid permission flag " + name)
+		}
+	}
+
+	return ret, nil
+}
 
 // This is a bit mask to ignore the self mask of previous layers when calculating permissions
 const PERMS_CHILDREN_MASK = 0xFFFFFFFF ^ PERMS_CREATE_SELF ^ PERMS_READ_SELF ^ PERMS_UPDATE_SELF ^ PERMS_DELETE_SELF ^ PERMS_READ_ALL_SELF
@@ -87,6 +185,7 @@ const PERMS_CAN_CREATE = PERMS_CREATE_SELF | PERMS_CREATE_CHILDREN
 const PERMS_CAN_READ_ALL = PERMS_READ_ALL_SELF | PERMS_READ_ALL_CHILDREN
 const PERMS_CAN_READ = PERMS_READ_SELF | PERMS_READ_CHILDREN | PERMS_CAN_READ_ALL
 const PERMS_CAN_UPDATE = PERMS_UPDATE_SELF | PERMS_UPDATE_CHILDREN
+const PERMS_CAN_DELETE = PERMS_DELETE_SELF | PERMS_DELETE_CHILDREN
 
 /*
  * Checks that user's permissions match the permission strings provided
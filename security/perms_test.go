@@ -98,3 +98,55 @@ func CalcPermsInnerTest(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestParseLayer(t *testing.T) {
+	for _, l := range []Layer{Global, Module, ModuleGroup, Attendance} {
+		parsed, err := ParseLayer(l.String())
+		if err != nil || parsed != l {
+			t.Logf("Layer %s did not parse", l)
+			t.Fail()
+		}
+	}
+
+	_, err := ParseLayer("beans")
+	if err == nil {
+		t.Log("An invalid layer was parsed")
+		t.Fail()
+	}
+}
+
+func TestOverridesFlags(t *testing.T) {
+	flags := Overrides(PERMS_READ_SELF | PERMS_MANAGE_ROLES | PERMS_ATTENDANCE_ALLOW_PAST_MARK).Flags()
+	expected := []string{"PERMS_READ_SELF", "PERMS_MANAGE_ROLES", "PERMS_ATTENDANCE_ALLOW_PAST_MARK"}
+
+	if len(flags) != len(expected) {
+		t.Logf("Expected %v got %v", expected, flags)
+		t.FailNow()
+	}
+
+	for i := range expected {
+		if flags[i] != expected[i] {
+			t.Logf("Expected %v got %v", expected, flags)
+			t.Fail()
+		}
+	}
+
+	if len(Overrides(PERMS_VALID_MASK).Flags()) != 12 {
+		t.Log("Not every flag is in the valid mask")
+		t.Fail()
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	expected := Overrides(PERMS_READ_SELF | PERMS_MANAGE_ROLES)
+	perms, err := ParseFlags(expected.Flags())
+	if err != nil || perms != expected {
+		t.Logf("Expected %d got %d %s", expected, perms, err)
+		t.Fail()
+	}
+
+	if _, err := ParseFlags([]string{"PERMS_FLY"}); err == nil {
+		t.Log("Expected invalid flags to be refused")
+		t.Fail()
+	}
+}
@@ -2,6 +2,7 @@ package security
 
 import (
 	"arcio/attendance-system/config"
+	"crypto/ecdsa"
 	"errors"
 	"log"
 	"strings"
@@ -100,3 +101,19 @@ func CheckJwt(JwtIn string, conf config.Config) (Claims, error) {
 	}
 	return ret, nil
 }
+
+/*
+ * Signs an access token for a user with the auth system's private key, this is for tests and,
+ * the mint-token command. Users get their tokens from the auth system.
+ */
+func SignAccessToken(key *ecdsa.PrivateKey, userId string, firstname string, surname string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := Claims{Type: "ACCESS",
+		Uuid:      userId,
+		Firstname: firstname,
+		Surname:   surname,
+		Iat:       now.Unix(),
+		Exp:       now.Add(ttl).Unix()}
+
+	return jwt.NewWithClaims(jwt.SigningMethodES512, claims).SignedString(key)
+}
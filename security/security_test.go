@@ -2,7 +2,12 @@ package security
 
 import (
 	"arcio/attendance-system/config"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/hex"
+	"encoding/pem"
 	"github.com/golang-jwt/jwt"
 	"io"
 	"log"
@@ -79,3 +84,36 @@ func TestInvalidJwt(t *testing.T) {
 	}
 
 }
+
+func TestSignAccessToken(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	conf := config.Config{JwtPublicKey: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})}
+
+	token, err := SignAccessToken(key, "user", "Dave", "Dave", time.Minute)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	claims, err := CheckJwt(token, conf)
+	if err != nil || claims.Uuid != "user" || claims.Type != "ACCESS" {
+		t.Log("Cannot check the signed token", claims, err)
+		t.Fail()
+	}
+
+	expired, _ := SignAccessToken(key, "user", "Dave", "Dave", -time.Minute)
+	if _, err := CheckJwt(expired, conf); err == nil {
+		t.Log("Expected expired tokens to be refused")
+		t.Fail()
+	}
+}
@@ -0,0 +1,41 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+/*
+ * Webhook payloads are signed with a HMAC-SHA256 of the delivery timestamp and, the body using
+ * the subscription's secret. Receivers should check the signature and, refuse old timestamps so
+ * that deliveries cannot be forged or, replayed.
+ */
+
+const WEBHOOK_SIGNATURE_PREFIX = "sha256="
+
+/*
+ * Signs a webhook payload.
+ *
+ * @param secret    the subscription's secret
+ * @param timestamp the unix time that the delivery was sent, this is sent in a header too
+ * @param payload   the request body
+ * @return string   the signature, WEBHOOK_SIGNATURE_PREFIX then the hex encoded HMAC
+ */
+func SignWebhookPayload(secret []byte, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return WEBHOOK_SIGNATURE_PREFIX + hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+ * Checks the signature of a webhook payload, see SignWebhookPayload.
+ */
+func CheckWebhookSignature(secret []byte, timestamp int64, payload []byte, signature string) bool {
+	expected := SignWebhookPayload(secret, timestamp, payload)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
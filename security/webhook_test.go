@@ -0,0 +1,38 @@
+package security
+
+import (
+	"strings"
+	"testing"
+)
+
+var webhookSecret = []byte("this is a test webhook secret")
+
+func TestWebhookSignature(t *testing.T) {
+	payload := []byte(`{"event":"lesson.created"}`)
+	signature := SignWebhookPayload(webhookSecret, 1650000000, payload)
+
+	if !strings.HasPrefix(signature, WEBHOOK_SIGNATURE_PREFIX) {
+		t.Logf("Signature %s does not have the prefix", signature)
+		t.Fail()
+	}
+
+	if !CheckWebhookSignature(webhookSecret, 1650000000, payload, signature) {
+		t.Log("The signature was not valid for the payload it signed")
+		t.Fail()
+	}
+
+	if CheckWebhookSignature(webhookSecret, 1650000001, payload, signature) {
+		t.Log("The signature was valid for a different timestamp")
+		t.Fail()
+	}
+
+	if CheckWebhookSignature(webhookSecret, 1650000000, []byte(`{"event":"lesson.cancelled"}`), signature) {
+		t.Log("The signature was valid for a different payload")
+		t.Fail()
+	}
+
+	if CheckWebhookSignature([]byte("another secret"), 1650000000, payload, signature) {
+		t.Log("The signature was valid for a different secret")
+		t.Fail()
+	}
+}
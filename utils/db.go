@@ -2,10 +2,10 @@ package utils
 
 import (
 	"arcio/attendance-system/config"
+	"arcio/attendance-system/logging"
 	"database/sql"
 	"fmt"
 	_ "github.com/lib/pq"
-	"log"
 	"sync"
 	"time"
 )
@@ -20,7 +20,7 @@ type DatabasePool struct {
 }
 
 func WriterThread(pool *DatabasePool) {
-	log.Println("Started writer thread.")
+	logging.Default().Info("Started writer thread.")
 
 	for true {
 		pool.WriteLock.Lock() // Lock the pending writes array then copy the contents to a cache then unlock
@@ -34,7 +34,7 @@ func WriterThread(pool *DatabasePool) {
 			// Perform the database write operation
 			i := 0
 			LocalWritesLen := len(LocalWrites)
-			log.Printf("Executing %d inserts.\n", LocalWritesLen)
+			logging.Default().Debugf("Executing %d inserts.", LocalWritesLen)
 
 			for i < LocalWritesLen {
 				LocalWrites[i](pool.Database)
@@ -48,29 +48,56 @@ func WriterThread(pool *DatabasePool) {
 }
 
 func WriteLater(pool *DatabasePool, runnable DatabaseRunnable) {
+	// Writes are dropped without a database, i.e: with the in-memory repositories
+	if pool == nil {
+		return
+	}
+
 	pool.WriteLock.Lock()
 	pool.PendingWrites = append(pool.PendingWrites, runnable)
 	pool.WriteLock.Unlock()
 }
 
+/*
+ * Runs any pending writes now, this is called on shutdown so that audit logs are not lost.
+ */
+func FlushPendingWrites(pool *DatabasePool) {
+	pool.WriteLock.Lock()
+	LocalWrites := pool.PendingWrites
+	pool.PendingWrites = make([]DatabaseRunnable, 0)
+	pool.WriteLock.Unlock()
+
+	if len(LocalWrites) > 0 {
+		logging.Default().Infof("Flushing %d inserts.", len(LocalWrites))
+	}
+
+	for _, write := range LocalWrites {
+		write(pool.Database)
+	}
+}
+
+// The connection string for the database, this is used for listeners too
+func DatabaseUrl(config config.Config) string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
+		config.DbUserName,
+		config.DbPassword,
+		config.DbUrl,
+		config.DbPort,
+		config.DbName,
+		config.SslMode)
+}
+
 func InitDatabasePool(config config.Config) (*DatabasePool, error) {
-	db, err := sql.Open("postgres",
-		fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
-			config.DbUserName,
-			config.DbPassword,
-			config.DbUrl,
-			config.DbPort,
-			config.DbName,
-			config.SslMode))
+	db, err := sql.Open("postgres", DatabaseUrl(config))
 	if err != nil {
-		log.Println(err)
+		logging.Default().Error(err)
 		return nil, err
 	}
 
 	db.SetMaxOpenConns(config.DbMaxConnections)
 	ret := DatabasePool{Database: db, PendingWrites: make([]DatabaseRunnable, 0)}
 
-	log.Println("Connected to the database successfully. Starting writer thread.")
+	logging.Default().Info("Connected to the database successfully. Starting writer thread.")
 	go WriterThread(&ret)
 	return &ret, nil
 }
@@ -2,11 +2,11 @@ package utils
 
 import (
 	"arcio/attendance-system/config"
+	"arcio/attendance-system/logging"
 	"arcio/attendance-system/security"
 	"database/sql"
 	"github.com/google/uuid"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strings"
 )
@@ -20,7 +20,7 @@ func UpdateLogs(claims security.Claims, req *http.Request, pool *DatabasePool, c
 			"VALUES ($1,$2,$3,$4,$5,$6);")
 
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(req.Context()).Error(err)
 			return
 		}
 		defer stmt.Close()
@@ -28,7 +28,7 @@ func UpdateLogs(claims security.Claims, req *http.Request, pool *DatabasePool, c
 		body, err := ioutil.ReadAll(req.Body)
 
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(req.Context()).Error(err)
 			return
 		}
 
@@ -47,7 +47,7 @@ func UpdateLogs(claims security.Claims, req *http.Request, pool *DatabasePool, c
 		_, err = stmt.Exec(uuid.New().String(), claims.Uuid, req.Method, req.RequestURI, body, ipAddr)
 
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(req.Context()).Error(err)
 		}
 	})
 }
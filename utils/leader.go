@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"arcio/attendance-system/logging"
+	"context"
+	"database/sql"
+	"time"
+)
+
+/*
+ * Leader election for background jobs so that only one replica of the backend runs each of them.
+ * The leader holds a Postgres session advisory lock on a connection that it keeps out of the pool,
+ * the lock is released when the connection closes so a crashed leader is replaced on the next poll.
+ */
+
+// Advisory lock keys, each job must have its own key
+const (
+	LESSON_SPAWNER_LOCK int64 = 0x61726369 + iota // "arci"
+	AT_RISK_LOCK
+	WEBHOOK_LOCK
+	MIGRATION_LOCK
+)
+
+// Returns a connection that holds the lock or, nil if another process holds it
+func tryAdvisoryLock(ctx context.Context, pool *DatabasePool, lockKey int64) (*sql.Conn, error) {
+	conn, err := pool.Database.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, "select pg_try_advisory_lock($1);", lockKey).Scan(&locked)
+	if err != nil || !locked {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func releaseAdvisoryLock(conn *sql.Conn, lockKey int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := conn.ExecContext(ctx, "select pg_advisory_unlock($1);", lockKey)
+	if err != nil {
+		logging.Default().Error(err)
+	}
+	conn.Close()
+}
+
+/*
+ * Runs a job every period while this process is the leader for the lock, returns once the
+ * context is done and, the lock has been released. Processes that are not the leader try to
+ * become it every period.
+ *
+ * @param ctx     cancel to stop
+ * @param pool    the database pool, one connection is held while leading
+ * @param lockKey the advisory lock key, see the keys above
+ * @param period  the time between runs of the job
+ * @param job     the job, it is not ran concurrently with itself
+ */
+func RunAsLeader(ctx context.Context, pool *DatabasePool, lockKey int64, period time.Duration, job func(ctx context.Context)) {
+	var conn *sql.Conn
+	defer func() {
+		if conn != nil {
+			releaseAdvisoryLock(conn, lockKey)
+			logging.FromContext(ctx).Infof("Released leader lock %d", lockKey)
+		}
+	}()
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		if conn == nil {
+			var err error
+			conn, err = tryAdvisoryLock(ctx, pool, lockKey)
+			if err != nil && ctx.Err() == nil {
+				logging.FromContext(ctx).Error(err)
+			} else if conn != nil {
+				logging.FromContext(ctx).Infof("Became the leader for lock %d", lockKey)
+			}
+		} else if err := conn.PingContext(ctx); err != nil && ctx.Err() == nil {
+			// The session, and so the lock, has been lost
+			logging.FromContext(ctx).Errorf("Lost leader lock %d - %s", lockKey, err)
+			conn.Close()
+			conn = nil
+		}
+
+		if conn != nil && ctx.Err() == nil {
+			job(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
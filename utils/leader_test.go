@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+)
+
+const TEST_LOCK int64 = 0x74657374 // "test"
+
+func TestRunAsLeader(t *testing.T) {
+	pool, err := InitDatabasePool(conf)
+	if err != nil {
+		t.Log("Failed to init database pool")
+		t.FailNow()
+	}
+
+	var lock sync.Mutex
+	runs := make([]int, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Only one of the two should become the leader
+	var wg sync.WaitGroup
+	for i := range runs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			RunAsLeader(ctx, pool, TEST_LOCK, 10*time.Millisecond, func(ctx context.Context) {
+				lock.Lock()
+				runs[i]++
+				lock.Unlock()
+			})
+		}(i)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	if (runs[0] == 0) == (runs[1] == 0) {
+		t.Logf("Expected exactly one leader but the jobs ran %v times\n", runs)
+		t.Fail()
+	}
+
+	// The lock should have been released
+	conn, err := tryAdvisoryLock(context.Background(), pool, TEST_LOCK)
+	if err != nil || conn == nil {
+		t.Log("The lock was not released", err)
+		t.FailNow()
+	}
+	releaseAdvisoryLock(conn, TEST_LOCK)
+}
+
+func TestFlushPendingWrites(t *testing.T) {
+	pool := &DatabasePool{PendingWrites: make([]DatabaseRunnable, 0)}
+
+	counter := 0
+	for i := 0; i < TEST_WRITES; i++ {
+		WriteLater(pool, func(database *sql.DB) {
+			counter++
+		})
+	}
+
+	FlushPendingWrites(pool)
+	if counter != TEST_WRITES || len(pool.PendingWrites) != 0 {
+		t.Logf("Expected %d writes to happen but only %d did\n", TEST_WRITES, counter)
+		t.Fail()
+	}
+}
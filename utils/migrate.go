@@ -0,0 +1,356 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * Versioned schema migrations, each migration has an up and, a down file named
+ * <version>_<name>.up.sql and, <version>_<name>.down.sql. Version 0 is the base schema which is
+ * never reverted, the others change it. Applied versions are stored in
+ * schema_migrations, each migration runs in its own transaction with the version row so a failed
+ * migration is not half applied. Migrations hold MIGRATION_LOCK so replicas do not run them twice.
+ */
+
+const MIGRATIONS_TABLE = "schema_migrations"
+
+var ErrSchemaBehind = errors.New("The database schema is behind")
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version     int        `json:"version"`
+	Name        string     `json:"name"`
+	Applied     bool       `json:"applied"`
+	AppliedTime *time.Time `json:"applied-time,omitempty"`
+}
+
+/*
+ * Reads the migrations in the top level of a file system in order of their versions, every
+ * version must have an up and, a down file.
+ */
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, name := range names {
+		var direction string
+		base := name
+		if strings.HasSuffix(name, ".up.sql") {
+			direction, base = "up", strings.TrimSuffix(name, ".up.sql")
+		} else if strings.HasSuffix(name, ".down.sql") {
+			direction, base = "down", strings.TrimSuffix(name, ".down.sql")
+		} else {
+			return nil, fmt.Errorf("Migration %s must end in .up.sql or, .down.sql", name)
+		}
+
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || version < 0 || len(parts) != 2 {
+			return nil, fmt.Errorf("Migration %s must be named <version>_<name>", name)
+		}
+
+		raw, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, found := byVersion[version]
+		if !found {
+			migration = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = migration
+		} else if migration.Name != parts[1] {
+			return nil, fmt.Errorf("Migration version %d is used by %s and, %s", version, migration.Name, parts[1])
+		}
+
+		if direction == "up" {
+			migration.Up = string(raw)
+		} else {
+			migration.Down = string(raw)
+		}
+	}
+
+	ret := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("Migration %d_%s needs an up and, a down file", migration.Version, migration.Name)
+		}
+		ret = append(ret, *migration)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Version < ret[j].Version
+	})
+	return ret, nil
+}
+
+// The version the code expects, 0 if there are no migrations after the base schema
+func LatestMigration(migrations []Migration) int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+func createMigrationsTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "create table if not exists "+MIGRATIONS_TABLE+" ("+
+		"version integer primary key, "+
+		"name varchar(255) not null, "+
+		"applied_time timestamp not null default now());")
+	return err
+}
+
+// Gets a connection that holds the migration lock, the table is created if it does not exist
+func lockMigrations(ctx context.Context, pool *DatabasePool) (*sql.Conn, error) {
+	conn, err := pool.Database.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.ExecContext(ctx, "select pg_advisory_lock($1);", MIGRATION_LOCK)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	err = createMigrationsTable(ctx, conn)
+	if err != nil {
+		releaseAdvisoryLock(conn, MIGRATION_LOCK)
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func getAppliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "select version, applied_time from "+MIGRATIONS_TABLE+";")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedTime time.Time
+		err = rows.Scan(&version, &appliedTime)
+		if err != nil {
+			return nil, err
+		}
+		ret[version] = appliedTime
+	}
+
+	return ret, rows.Err()
+}
+
+// Runs the migration's SQL and, adds or, removes its version in one transaction
+func runMigration(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	success := false
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if success {
+			tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}()
+
+	script, versionSql := migration.Down, "delete from "+MIGRATIONS_TABLE+" where version = $1;"
+	if up {
+		script, versionSql = migration.Up, "insert into "+MIGRATIONS_TABLE+" (version, name) values ($1, $2);"
+	}
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return fmt.Errorf("Migration %d_%s failed - %s", migration.Version, migration.Name, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, versionSql, migration.Version, migration.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, versionSql, migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	success = true
+	return nil
+}
+
+/*
+ * Applies the migrations that have not been applied up to and, including a version.
+ *
+ * @param target the last version to apply, 0 applies all of them
+ * @return the migrations that were applied
+ */
+func MigrateUp(ctx context.Context, pool *DatabasePool, migrations []Migration, target int) ([]Migration, error) {
+	conn, err := lockMigrations(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseAdvisoryLock(conn, MIGRATION_LOCK)
+
+	applied, err := getAppliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]Migration, 0)
+	for _, migration := range migrations {
+		if _, done := applied[migration.Version]; done || (target > 0 && migration.Version > target) {
+			continue
+		}
+
+		err = runMigration(ctx, conn, migration, true)
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, migration)
+	}
+
+	return ret, nil
+}
+
+/*
+ * Reverts the applied migrations after a version, newest first.
+ *
+ * @param target the version to go back to, 0 reverts all of them but, the base schema
+ * @return the migrations that were reverted
+ */
+func MigrateDown(ctx context.Context, pool *DatabasePool, migrations []Migration, target int) ([]Migration, error) {
+	conn, err := lockMigrations(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseAdvisoryLock(conn, MIGRATION_LOCK)
+
+	applied, err := getAppliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]Migration, 0)
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if _, done := applied[migration.Version]; !done || migration.Version <= target {
+			continue
+		}
+
+		err = runMigration(ctx, conn, migration, false)
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, migration)
+	}
+
+	return ret, nil
+}
+
+/*
+ * Marks the migrations up to and, including a version as applied without running them, this is
+ * for databases that had the migrations applied by hand.
+ */
+func BaselineMigrations(ctx context.Context, pool *DatabasePool, migrations []Migration, version int) error {
+	conn, err := lockMigrations(ctx, pool)
+	if err != nil {
+		return err
+	}
+	defer releaseAdvisoryLock(conn, MIGRATION_LOCK)
+
+	for _, migration := range migrations {
+		if migration.Version > version {
+			break
+		}
+
+		_, err = conn.ExecContext(ctx, "insert into "+MIGRATIONS_TABLE+" (version, name) values ($1, $2) "+
+			"on conflict (version) do nothing;", migration.Version, migration.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Gets whether each migration has been applied, migrations in the database that the code does
+ * not know about are listed too.
+ */
+func GetMigrationStatus(ctx context.Context, pool *DatabasePool, migrations []Migration) ([]MigrationStatus, error) {
+	conn, err := lockMigrations(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseAdvisoryLock(conn, MIGRATION_LOCK)
+
+	applied, err := getAppliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if appliedTime, done := applied[migration.Version]; done {
+			status.Applied = true
+			status.AppliedTime = &appliedTime
+			delete(applied, migration.Version)
+		}
+		ret = append(ret, status)
+	}
+
+	for version, appliedTime := range applied {
+		appliedTime := appliedTime
+		ret = append(ret, MigrationStatus{Version: version, Name: "unknown", Applied: true, AppliedTime: &appliedTime})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Version < ret[j].Version
+	})
+	return ret, nil
+}
+
+/*
+ * Checks that every migration has been applied, the server is not started otherwise. A schema
+ * that is ahead is allowed so that older replicas keep running during a deploy.
+ */
+func CheckSchemaVersion(ctx context.Context, pool *DatabasePool, migrations []Migration) error {
+	statuses, err := GetMigrationStatus(ctx, pool, migrations)
+	if err != nil {
+		return err
+	}
+
+	pending := make([]string, 0)
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, fmt.Sprintf("%d_%s", status.Version, status.Name))
+		}
+	}
+
+	if len(pending) != 0 {
+		return fmt.Errorf("%w, %s are not applied", ErrSchemaBehind, strings.Join(pending, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"arcio/attendance-system/migrations"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_second.up.sql":   {Data: []byte("create table b (id int);")},
+		"0002_second.down.sql": {Data: []byte("drop table b;")},
+		"0001_first.up.sql":    {Data: []byte("create table a (id int);")},
+		"0001_first.down.sql":  {Data: []byte("drop table a;")},
+	}
+
+	loaded, err := LoadMigrations(fsys)
+	if err != nil || len(loaded) != 2 {
+		t.Log("Cannot load migrations", loaded, err)
+		t.FailNow()
+	}
+
+	if loaded[0].Version != 1 || loaded[0].Name != "first" || loaded[1].Down != "drop table b;" {
+		t.Log("Bad migrations", loaded)
+		t.Fail()
+	}
+
+	if LatestMigration(loaded) != 2 || LatestMigration(nil) != 0 {
+		t.Log("Bad latest migration")
+		t.Fail()
+	}
+
+	// Every migration needs a down file
+	delete(fsys, "0002_second.down.sql")
+	if _, err := LoadMigrations(fsys); err == nil {
+		t.Log("Expected a migration without a down file to be refused")
+		t.Fail()
+	}
+
+	fsys["0002_second.down.sql"] = &fstest.MapFile{Data: []byte("drop table b;")}
+	fsys["0002_other.up.sql"] = &fstest.MapFile{Data: []byte("select 1;")}
+	if _, err := LoadMigrations(fsys); err == nil {
+		t.Log("Expected versions used twice to be refused")
+		t.Fail()
+	}
+
+	delete(fsys, "0002_other.up.sql")
+	fsys["-001_negative.up.sql"] = &fstest.MapFile{Data: []byte("select 1;")}
+	fsys["-001_negative.down.sql"] = &fstest.MapFile{Data: []byte("select 1;")}
+	if _, err := LoadMigrations(fsys); err == nil {
+		t.Log("Expected negative versions to be refused")
+		t.Fail()
+	}
+
+	delete(fsys, "-001_negative.up.sql")
+	delete(fsys, "-001_negative.down.sql")
+	fsys["first.up.sql"] = &fstest.MapFile{Data: []byte("select 1;")}
+	if _, err := LoadMigrations(fsys); err == nil {
+		t.Log("Expected migrations without a version to be refused")
+		t.Fail()
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	loaded, err := LoadMigrations(migrations.Files)
+	if err != nil || len(loaded) == 0 {
+		t.Log("Cannot load the embedded migrations", err)
+		t.FailNow()
+	}
+
+	if loaded[0].Version != 0 || loaded[0].Name != "base" {
+		t.Log("Expected the base schema first", loaded[0].Version, loaded[0].Name)
+		t.Fail()
+	}
+
+	for i, migration := range loaded {
+		if migration.Version != i {
+			t.Log("Expected the migrations to be numbered without gaps", migration.Version, migration.Name)
+			t.Fail()
+		}
+	}
+}
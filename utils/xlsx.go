@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+ * A minimal XLSX (Office Open XML) writer for reports, it writes a single sheet with inline
+ * strings and, numbers. Styles, formulas and, shared strings are not supported.
+ */
+
+const XLSX_CONTENT_TYPE = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+
+const xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+
+/*
+ * Converts a zero based column index to the column's letters, i.e: 0 -> A, 26 -> AA.
+ */
+func XlsxColumnName(i int) string {
+	name := ""
+	for i >= 0 {
+		name = string(rune('A'+i%26)) + name
+		i = i/26 - 1
+	}
+
+	return name
+}
+
+// Sheet names cannot contain []:*?/\ and, are at most 31 characters
+func xlsxSheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '-'
+		}
+		return r
+	}, name)
+
+	if len([]rune(name)) > 31 {
+		name = string([]rune(name)[:31])
+	}
+
+	if name == "" {
+		name = "Sheet1"
+	}
+
+	return name
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func writeXlsxCell(w io.Writer, ref string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		return
+	case int, int64, float64:
+		fmt.Fprintf(w, `<c r="%s"><v>%v</v></c>`, ref, v)
+	case float32:
+		fmt.Fprintf(w, `<c r="%s"><v>%v</v></c>`, ref, float64(v))
+	default:
+		str := fmt.Sprint(v)
+		if str == "" {
+			return
+		}
+		fmt.Fprintf(w, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, xmlEscape(str))
+	}
+}
+
+/*
+ * Writes rows as a single sheet workbook. Ints and, floats are written as numbers, everything
+ * else is written as a string.
+ *
+ * @param w         where to write the workbook
+ * @param sheetName the name of the sheet
+ * @param rows      the cells, rows can be different lengths
+ */
+func WriteXlsx(w io.Writer, sheetName string, rows [][]interface{}) error {
+	archive := zip.NewWriter(w)
+
+	files := []struct {
+		Name    string
+		Content string
+	}{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, xmlEscape(xlsxSheetName(sheetName)))},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+
+	for _, file := range files {
+		f, err := archive.Create(file.Name)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(f, file.Content)
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+
+	sheet := bufio.NewWriter(f)
+	io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	for i, row := range rows {
+		fmt.Fprintf(sheet, `<row r="%d">`, i+1)
+		for j, value := range row {
+			writeXlsxCell(sheet, fmt.Sprintf("%s%d", XlsxColumnName(j), i+1), value)
+		}
+		io.WriteString(sheet, `</row>`)
+	}
+
+	io.WriteString(sheet, `</sheetData></worksheet>`)
+	err = sheet.Flush()
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestXlsxColumnName(t *testing.T) {
+	cases := map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"}
+	for i, expected := range cases {
+		if XlsxColumnName(i) != expected {
+			t.Logf("Column %d should be %s got %s", i, expected, XlsxColumnName(i))
+			t.Fail()
+		}
+	}
+}
+
+func TestWriteXlsx(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteXlsx(&buf, "Report: CS1860/A", [][]interface{}{
+		{"Name", "Percentage"},
+		{"Beans & <toast>", 87.5},
+		{"Empty", nil, 3},
+	})
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Log("The workbook is not a valid zip")
+		t.FailNow()
+	}
+
+	contents := make(map[string]string)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		b, _ := io.ReadAll(r)
+		r.Close()
+		contents[f.Name] = string(b)
+	}
+
+	sheet, found := contents["xl/worksheets/sheet1.xml"]
+	if !found {
+		t.Log("The sheet is missing")
+		t.FailNow()
+	}
+
+	if !strings.Contains(sheet, "Beans &amp; &lt;toast&gt;") {
+		t.Log("The string was not escaped")
+		t.Fail()
+	}
+
+	if !strings.Contains(sheet, `<c r="B2"><v>87.5</v></c>`) || !strings.Contains(sheet, `<c r="C3"><v>3</v></c>`) {
+		t.Log("The numbers were not written as numbers")
+		t.Fail()
+	}
+
+	if strings.Contains(contents["xl/workbook.xml"], "/") && strings.Contains(contents["xl/workbook.xml"], "CS1860/A") {
+		t.Log("The sheet name was not cleaned")
+		t.Fail()
+	}
+}